@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MicroSOA-09/gateway-service/handler"
+)
+
+// serviceConfigFromEnv reads per-service options from variables named <PREFIX>_<OPTION>,
+// e.g. BLOG_STRIP_PREFIX=true
+func serviceConfigFromEnv(prefix string) handler.ServiceConfig {
+	return handler.ServiceConfig{
+		StripPrefix:   envBool(prefix+"_STRIP_PREFIX", false),
+		HeaderRenames: envMap(prefix + "_HEADER_RENAMES"),
+
+		ClaimHeaders:    envMap(prefix + "_CLAIM_HEADERS"),
+		RequestHeaders:  envHeaderRules(prefix + "_REQUEST_HEADERS"),
+		ResponseHeaders: envHeaderRules(prefix + "_RESPONSE_HEADERS"),
+
+		OverloadHeader:     os.Getenv(prefix + "_OVERLOAD_HEADER"),
+		OverloadThreshold:  envInt(prefix+"_OVERLOAD_THRESHOLD", 0),
+		ThrottleStatus:     envInt(prefix+"_THROTTLE_STATUS", 0),
+		ThrottleRetryAfter: envDuration(prefix+"_THROTTLE_RETRY_AFTER", 0),
+
+		CacheTTL:           envDuration(prefix+"_CACHE_TTL", 0),
+		CacheMaxEntries:    envInt(prefix+"_CACHE_MAX_ENTRIES", 0),
+		CacheMaxEntryBytes: envInt(prefix+"_CACHE_MAX_ENTRY_BYTES", 0),
+		CacheVaryHeaders:   envList(prefix + "_CACHE_VARY_HEADERS"),
+		CacheByRole:        envBool(prefix+"_CACHE_BY_ROLE", false),
+		Coalesce:           envBool(prefix+"_COALESCE", false),
+		CoalesceMaxBytes:   envInt(prefix+"_COALESCE_MAX_BYTES", 0),
+
+		EgressProxy:         os.Getenv(prefix + "_EGRESS_PROXY"),
+		EgressProxyUser:     os.Getenv(prefix + "_EGRESS_PROXY_USER"),
+		EgressProxyPassword: os.Getenv(prefix + "_EGRESS_PROXY_PASSWORD"),
+
+		TLSCertFile: os.Getenv(prefix + "_TLS_CERT_FILE"),
+		TLSKeyFile:  os.Getenv(prefix + "_TLS_KEY_FILE"),
+		TLSCAFile:   os.Getenv(prefix + "_TLS_CA_FILE"),
+
+		MaxConcurrent:  envInt(prefix+"_MAX_CONCURRENT", 0),
+		CircuitBreaker: envBreaker(prefix + "_CIRCUIT"),
+		Retry:          envRetry(prefix + "_RETRY"),
+
+		Versions: envVersions(prefix + "_VERSIONS"),
+
+		ShadowURL:     os.Getenv(prefix + "_SHADOW_URL"),
+		ShadowPercent: envFloat(prefix+"_SHADOW_PERCENT", 0),
+
+		StatusRemaps: envStatusRemaps(prefix + "_STATUS_REMAPS"),
+
+		WarmPoolSize:      envInt(prefix+"_WARM_POOL_SIZE", 0),
+		WarmPoolProbePath: os.Getenv(prefix + "_WARM_POOL_PROBE_PATH"),
+
+		WarmupPaths: envList(prefix + "_WARMUP_PATHS"),
+
+		HealthPath:    os.Getenv(prefix + "_HEALTH_PATH"),
+		LoadBalancing: os.Getenv(prefix + "_LOAD_BALANCING"),
+		ConsulService: os.Getenv(prefix + "_CONSUL_SERVICE"),
+
+		KubernetesService: os.Getenv(prefix + "_KUBERNETES_SERVICE"),
+		KubernetesPort:    os.Getenv(prefix + "_KUBERNETES_PORT"),
+
+		GRPC:        envBool(prefix+"_GRPC", false),
+		GRPCMethods: envMap(prefix + "_GRPC_METHODS"),
+
+		Credentials: handler.ServiceCredentials{
+			TokenURL:     os.Getenv(prefix + "_SERVICE_TOKEN_URL"),
+			ClientID:     os.Getenv(prefix + "_SERVICE_CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "_SERVICE_CLIENT_SECRET"),
+			Scopes:       envList(prefix + "_SERVICE_SCOPES"),
+			StaticKey:    os.Getenv(prefix + "_SERVICE_KEY"),
+			Header:       os.Getenv(prefix + "_SERVICE_CREDENTIALS_HEADER"),
+		},
+	}
+}
+
+// envBool parses a boolean env variable, returning def when unset or invalid
+func envBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// envInt parses an integer env variable, returning def when unset or invalid
+func envInt(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// envFloat parses a floating-point env variable, returning def when unset or invalid
+func envFloat(key string, def float64) float64 {
+	f, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// envDuration parses a duration env variable such as "3s", returning def when unset or invalid
+func envDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// envList parses a comma-separated list, skipping empty items
+func envList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envIntList parses a comma-separated list of integers, skipping invalid items
+func envIntList(key string) []int {
+	var list []int
+	for _, item := range envList(key) {
+		if n, err := strconv.Atoi(item); err == nil {
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+// envMap parses a comma-separated list of key=value pairs, e.g. "X-API-KEY=X-Api-Key,A=B"
+func envMap(key string) map[string]string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m
+}
+
+// envSizes parses key=bytes pairs, e.g. "/api/blog=1048576,/api/asp/upload=52428800";
+// invalid and non-positive sizes are skipped
+func envSizes(key string) map[string]int64 {
+	var sizes map[string]int64
+	for k, v := range envMap(key) {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			if sizes == nil {
+				sizes = make(map[string]int64)
+			}
+			sizes[k] = n
+		}
+	}
+	return sizes
+}
+
+// envDurations parses key=duration pairs, e.g. "/api/blog=5s,/api/blog/search=2s";
+// invalid durations are skipped
+func envDurations(key string) map[string]time.Duration {
+	var durations map[string]time.Duration
+	for k, v := range envMap(key) {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			if durations == nil {
+				durations = make(map[string]time.Duration)
+			}
+			durations[k] = d
+		}
+	}
+	return durations
+}
+
+// envRouteCORS reads per-route CORS policies from ROUTE_CORS_ORIGINS, prefix=origins pairs
+// with "|" separating origins, and ROUTE_CORS_CREDENTIALS, prefix=bool pairs, e.g.
+// ROUTE_CORS_ORIGINS=/api/blog=*,/api/user=https://app.example.com|https://admin.example.com
+func envRouteCORS() map[string]handler.CORSConfig {
+	var policies map[string]handler.CORSConfig
+	set := func(prefix string, update func(*handler.CORSConfig)) {
+		if policies == nil {
+			policies = make(map[string]handler.CORSConfig)
+		}
+		c := policies[prefix]
+		update(&c)
+		policies[prefix] = c
+	}
+	for prefix, origins := range envMap("ROUTE_CORS_ORIGINS") {
+		set(prefix, func(c *handler.CORSConfig) { c.Origins = strings.Split(origins, "|") })
+	}
+	for prefix, v := range envMap("ROUTE_CORS_CREDENTIALS") {
+		if allow, err := strconv.ParseBool(v); err == nil && !allow {
+			set(prefix, func(c *handler.CORSConfig) { c.NoCredentials = true })
+		}
+	}
+	return policies
+}
+
+// envAccessRules parses access rules of the form "[METHOD|METHOD ]pattern=role|role",
+// e.g. "/api/user/admin/**=admin,DELETE /api/blog/**=author|admin". A malformed rule is
+// an error rather than skipped, as dropping it would leave its paths open.
+func envAccessRules(key string) ([]handler.AccessRule, error) {
+	var rules []handler.AccessRule
+	for _, item := range envList(key) {
+		rule, roles, ok := strings.Cut(item, "=")
+		if !ok || strings.Trim(roles, "| ") == "" {
+			return nil, fmt.Errorf("invalid %s rule %q: want [METHODS ]pattern=roles", key, item)
+		}
+		var methods []string
+		if m, path, ok := strings.Cut(strings.TrimSpace(rule), " "); ok {
+			methods, rule = strings.Split(m, "|"), path
+		}
+		if rule = strings.TrimSpace(rule); !strings.HasPrefix(rule, "/") && !strings.HasPrefix(rule, "^") {
+			return nil, fmt.Errorf("invalid %s rule %q: missing path pattern", key, item)
+		}
+		rules = append(rules, handler.AccessRule{Path: rule, Methods: methods, Roles: strings.Split(roles, "|")})
+	}
+	return rules, nil
+}
+
+// envRateLimit parses a single rate limit such as "100/1m"; unset or invalid disables it
+func envRateLimit(key string) handler.RateLimit {
+	limit, _ := handler.ParseRateLimit(os.Getenv(key))
+	return limit
+}
+
+// envAPIKeys parses name=key|role[|limit] pairs, e.g. "acme=s3cr3t|partner|1000/1m"
+func envAPIKeys(key string) []handler.APIKey {
+	var keys []handler.APIKey
+	for name, v := range envMap(key) {
+		parts := strings.Split(v, "|")
+		if len(parts) < 2 {
+			continue
+		}
+		k := handler.APIKey{Name: name, Key: parts[0], Role: parts[1]}
+		if len(parts) > 2 {
+			k.Limit, _ = handler.ParseRateLimit(parts[2])
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// envRateLimits parses key=limit pairs, e.g. "premium=1000/1m,acme=500/1m"
+func envRateLimits(key string) map[string]handler.RateLimit {
+	var limits map[string]handler.RateLimit
+	for k, v := range envMap(key) {
+		if limit, ok := handler.ParseRateLimit(v); ok {
+			if limits == nil {
+				limits = make(map[string]handler.RateLimit)
+			}
+			limits[k] = limit
+		}
+	}
+	return limits
+}
+
+// envSLAs parses SLA definitions of the form "prefix:availability:p95[:window]",
+// e.g. "/api/blog:0.999:500ms:1h,/api/user:0.99:1s"
+func envSLAs(key string) []handler.SLAConfig {
+	var slas []handler.SLAConfig
+	for _, item := range envList(key) {
+		parts := strings.Split(item, ":")
+		if len(parts) < 3 {
+			continue
+		}
+		availability, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			continue
+		}
+		p95, err := time.ParseDuration(parts[2])
+		if err != nil {
+			continue
+		}
+		sla := handler.SLAConfig{Prefix: parts[0], Availability: availability, P95: p95}
+		if len(parts) > 3 {
+			sla.Window, _ = time.ParseDuration(parts[3])
+		}
+		slas = append(slas, sla)
+	}
+	return slas
+}
+
+// envVersions parses version=URL pairs, with "|" separating instances of one version,
+// e.g. "v2=http://blog-v2a:8080|http://blog-v2b:8080"
+func envVersions(key string) map[string]string {
+	versions := envMap(key)
+	for v, urls := range versions {
+		versions[v] = strings.ReplaceAll(urls, "|", ",")
+	}
+	return versions
+}
+
+// envHeaderRules reads header transformation rules from <PREFIX>_REMOVE (list) and
+// <PREFIX>_RENAME, <PREFIX>_SET and <PREFIX>_ADD (name=value pairs),
+// e.g. BLOG_RESPONSE_HEADERS_REMOVE=Server
+func envHeaderRules(prefix string) handler.HeaderRules {
+	return handler.HeaderRules{
+		Remove: envList(prefix + "_REMOVE"),
+		Rename: envMap(prefix + "_RENAME"),
+		Set:    envMap(prefix + "_SET"),
+		Add:    envMap(prefix + "_ADD"),
+	}
+}
+
+// envStatusRemaps parses "from:to[:body marker]" rules, e.g. "500:400:validation,502:503"
+func envStatusRemaps(key string) []handler.StatusRemap {
+	var remaps []handler.StatusRemap
+	for _, item := range envList(key) {
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		from, err1 := strconv.Atoi(parts[0])
+		to, err2 := strconv.Atoi(parts[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		rm := handler.StatusRemap{From: from, To: to}
+		if len(parts) == 3 {
+			rm.BodyContains = parts[2]
+		}
+		remaps = append(remaps, rm)
+	}
+	return remaps
+}
+
+// serverTimeouts are the http.Server timeouts, read from env with the old hard-coded values as defaults
+type serverTimeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	// Drain bounds how long shutdown waits for in-flight requests before closing them
+	Drain time.Duration
+}
+
+// serverTimeoutsFromEnv reads READ_TIMEOUT, READ_HEADER_TIMEOUT, WRITE_TIMEOUT and IDLE_TIMEOUT.
+// Set values must be valid positive durations.
+func serverTimeoutsFromEnv() (serverTimeouts, error) {
+	var t serverTimeouts
+	for _, opt := range []struct {
+		key string
+		def time.Duration
+		dst *time.Duration
+	}{
+		{"READ_TIMEOUT", 5 * time.Second, &t.Read},
+		{"READ_HEADER_TIMEOUT", 5 * time.Second, &t.ReadHeader},
+		{"WRITE_TIMEOUT", 10 * time.Second, &t.Write},
+		{"IDLE_TIMEOUT", 15 * time.Second, &t.Idle},
+		{"SHUTDOWN_DRAIN_TIMEOUT", 10 * time.Second, &t.Drain},
+	} {
+		*opt.dst = opt.def
+		raw := os.Getenv(opt.key)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return t, fmt.Errorf("%s must be a positive duration, got %q", opt.key, raw)
+		}
+		*opt.dst = d
+	}
+	return t, nil
+}
+
+// envSchemaRules parses "METHOD /prefix=schema-file" pairs,
+// e.g. "POST /api/blog/posts=/etc/gateway/post.json"
+func envSchemaRules(key string) []handler.SchemaRule {
+	var rules []handler.SchemaRule
+	for route, file := range envMap(key) {
+		method, prefix, ok := strings.Cut(route, " ")
+		if !ok {
+			continue
+		}
+		rules = append(rules, handler.SchemaRule{Method: method, Prefix: strings.TrimSpace(prefix), File: file})
+	}
+	return rules
+}
+
+// envPrefix turns a service name into its env variable prefix, e.g. "blog-v2" into "BLOG_V2"
+func envPrefix(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// envBreaker reads <prefix>_FAILURE_THRESHOLD, <prefix>_OPEN_TIMEOUT and <prefix>_HALF_OPEN_PROBES
+func envBreaker(prefix string) handler.BreakerConfig {
+	return handler.BreakerConfig{
+		FailureThreshold: envInt(prefix+"_FAILURE_THRESHOLD", 0),
+		OpenTimeout:      envDuration(prefix+"_OPEN_TIMEOUT", 0),
+		HalfOpenProbes:   envInt(prefix+"_HALF_OPEN_PROBES", 0),
+	}
+}
+
+// envRetry reads <prefix>_MAX_ATTEMPTS, <prefix>_METHODS, <prefix>_BASE_DELAY,
+// <prefix>_MAX_DELAY and <prefix>_BUDGET
+func envRetry(prefix string) handler.RetryConfig {
+	return handler.RetryConfig{
+		MaxAttempts: envInt(prefix+"_MAX_ATTEMPTS", 0),
+		Methods:     envList(prefix + "_METHODS"),
+		BaseDelay:   envDuration(prefix+"_BASE_DELAY", 0),
+		MaxDelay:    envDuration(prefix+"_MAX_DELAY", 0),
+		Budget:      envFloat(prefix+"_BUDGET", 0),
+	}
+}
+
+// durationsAsText logs durations as "1.5s" rather than nanoseconds
+func durationsAsText(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindDuration {
+		a.Value = slog.StringValue(a.Value.Duration().String())
+	}
+	return a
+}
+
+// envLogLevel parses debug, info, warn or error; unset or unknown values mean info
+func envLogLevel(key string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv(key))); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
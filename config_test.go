@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MicroSOA-09/gateway-service/handler"
+)
+
+func TestEnvAccessRules(t *testing.T) {
+	t.Setenv("ACCESS_RULES", "/api/user/admin/**=admin, DELETE|PUT /api/blog/**=author|admin")
+	rules, err := envAccessRules("ACCESS_RULES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []handler.AccessRule{
+		{Path: "/api/user/admin/**", Roles: []string{"admin"}},
+		{Path: "/api/blog/**", Methods: []string{"DELETE", "PUT"}, Roles: []string{"author", "admin"}},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %+v, want %+v", rules, want)
+	}
+
+	for _, malformed := range []string{"/api/user/admin/**", "/api/user/admin/**=", "=admin", "GET =admin"} {
+		t.Setenv("ACCESS_RULES", "/api/blog/**=author,"+malformed)
+		if rules, err := envAccessRules("ACCESS_RULES"); err == nil {
+			t.Errorf("%q: got rules %+v, want an error", malformed, rules)
+		}
+	}
+}
+
+func TestServerTimeoutsFromEnv(t *testing.T) {
+	for _, key := range []string{"READ_TIMEOUT", "READ_HEADER_TIMEOUT", "WRITE_TIMEOUT", "IDLE_TIMEOUT", "SHUTDOWN_DRAIN_TIMEOUT"} {
+		t.Setenv(key, "")
+	}
+	timeouts, err := serverTimeoutsFromEnv()
+	want := serverTimeouts{Read: 5 * time.Second, ReadHeader: 5 * time.Second, Write: 10 * time.Second, Idle: 15 * time.Second, Drain: 10 * time.Second}
+	if err != nil || timeouts != want {
+		t.Errorf("defaults %+v, %v; want %+v", timeouts, err, want)
+	}
+
+	t.Setenv("WRITE_TIMEOUT", "2m")
+	t.Setenv("READ_HEADER_TIMEOUT", "1500ms")
+	want.Write, want.ReadHeader = 2*time.Minute, 1500*time.Millisecond
+	if timeouts, err := serverTimeoutsFromEnv(); err != nil || timeouts != want {
+		t.Errorf("overrides %+v, %v; want %+v", timeouts, err, want)
+	}
+
+	for _, invalid := range []string{"10", "soon", "0s", "-5s"} {
+		t.Setenv("IDLE_TIMEOUT", invalid)
+		if _, err := serverTimeoutsFromEnv(); err == nil {
+			t.Errorf("IDLE_TIMEOUT=%q accepted", invalid)
+		}
+	}
+}
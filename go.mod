@@ -3,6 +3,7 @@ module github.com/MicroSOA-09/gateway-service
 go 1.24.2
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/gorilla/handlers v1.5.2
 	github.com/gorilla/mux v1.8.1
 	github.com/joho/godotenv v1.5.1
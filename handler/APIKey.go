@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DefaultAPIKeyHeader carries API keys when APIKeyConfig.Header is empty
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// APIKeyConfig lets machine clients authenticate with a static key instead of a JWT
+type APIKeyConfig struct {
+	// Header carries the key (default X-API-Key)
+	Header string
+	// Keys are the known keys, typically loaded from the environment or with LoadAPIKeys
+	Keys []APIKey
+	// ValidatePath is an AuthService endpoint that validates keys not in Keys; the key
+	// is POSTed in Header and the response is read like a JWT validation. Empty disables it.
+	ValidatePath string
+	// RateLimit applies to each key without its own limit; zero means none
+	RateLimit RateLimit
+}
+
+// enabled reports whether any key can be accepted
+func (c APIKeyConfig) enabled() bool {
+	return len(c.Keys) > 0 || c.ValidatePath != ""
+}
+
+func (c APIKeyConfig) header() string {
+	if c.Header == "" {
+		return DefaultAPIKeyHeader
+	}
+	return c.Header
+}
+
+// APIKey maps a key to the synthetic user it authenticates as
+type APIKey struct {
+	// Name identifies the key in logs and rate limits, and is the default user ID
+	Name     string    `json:"name"`
+	Key      string    `json:"key"`
+	UserID   string    `json:"userID,omitempty"`
+	Role     string    `json:"role"`
+	Username string    `json:"username,omitempty"`
+	TenantID string    `json:"tenantID,omitempty"`
+	Limit    RateLimit `json:"-"`
+}
+
+// identity is the user the key authenticates as
+func (k *APIKey) identity() *AuthValidateResponse {
+	id := &AuthValidateResponse{UserID: k.UserID, Role: k.Role, Username: k.Username, TenantID: k.TenantID}
+	if id.UserID == "" {
+		id.UserID = k.Name
+	}
+	if id.Username == "" {
+		id.Username = k.Name
+	}
+	return id
+}
+
+// LoadAPIKeys reads API keys from a JSON file: an array of objects with name, key, role
+// and optionally userID, username, tenantID and rateLimit ("<requests>/<window>")
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys file: %w", err)
+	}
+	var raw []struct {
+		APIKey
+		RateLimit string `json:"rateLimit,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid API keys file %s: %w", path, err)
+	}
+	keys := make([]APIKey, 0, len(raw))
+	for _, k := range raw {
+		key := k.APIKey
+		if k.RateLimit != "" {
+			limit, ok := ParseRateLimit(k.RateLimit)
+			if !ok {
+				return nil, fmt.Errorf("API key %s: invalid rate limit %q", key.Name, k.RateLimit)
+			}
+			key.Limit = limit
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// apiKeyIndex looks keys up by their hash, so lookups don't compare the keys themselves
+type apiKeyIndex map[[sha256.Size]byte]*APIKey
+
+// newAPIKeyIndex indexes keys, rejecting incomplete and duplicate ones
+func newAPIKeyIndex(keys []APIKey) (apiKeyIndex, error) {
+	index := make(apiKeyIndex, len(keys))
+	for i := range keys {
+		k := &keys[i]
+		switch {
+		case k.Name == "" || k.Key == "" || k.Role == "":
+			return nil, fmt.Errorf("API key %d: name, key and role are required", i+1)
+		case index[sha256.Sum256([]byte(k.Key))] != nil:
+			return nil, fmt.Errorf("API key %s: duplicate key", k.Name)
+		}
+		index[sha256.Sum256([]byte(k.Key))] = k
+	}
+	return index, nil
+}
+
+func (idx apiKeyIndex) lookup(key string) *APIKey {
+	return idx[sha256.Sum256([]byte(key))]
+}
+
+var errUnknownAPIKey = errors.New("unknown API key")
+
+// authenticateAPIKey resolves key against the configured keys, then AuthService. Keys
+// AuthService validated are named by their user ID and get the default rate limit.
+func (g *Gateway) authenticateAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	if k := g.routing.Load().apiKeys.lookup(key); k != nil {
+		return k, nil
+	}
+	cfg := g.Config.APIKeys
+	if cfg.ValidatePath == "" {
+		return nil, errUnknownAPIKey
+	}
+
+	cacheKey := "apikey:" + key
+	var id *AuthValidateResponse
+	var ok bool
+	if g.tokenCache != nil {
+		id, ok = g.tokenCache.get(cacheKey)
+	}
+	if !ok {
+		auth := g.upstreams()[ServiceAuth]
+		if auth.breaker != nil {
+			if ok, _ := auth.breaker.allow(); !ok {
+				return nil, &AuthUnavailableError{Err: errors.New("AuthService circuit is open")}
+			}
+		}
+		var err error
+		id, err = g.requestKeyValidation(ctx, auth.pick().url, key)
+		if auth.breaker != nil {
+			var unavailable *AuthUnavailableError
+			if errors.Is(ctx.Err(), context.Canceled) {
+				auth.breaker.release()
+			} else {
+				g.recordBreaker(auth, !errors.As(err, &unavailable))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if g.tokenCache != nil {
+			g.tokenCache.set(cacheKey, id)
+		}
+	}
+	return &APIKey{Name: id.UserID, UserID: id.UserID, Role: id.Role, Username: id.Username, TenantID: id.TenantID}, nil
+}
+
+// requestKeyValidation asks one AuthService instance to validate an API key
+func (g *Gateway) requestKeyValidation(ctx context.Context, authURL *url.URL, key string) (*AuthValidateResponse, error) {
+	cfg := g.Config.APIKeys
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(authURL.String(), "/")+cfg.ValidatePath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API key validation request: %w", err)
+	}
+	req.Header.Set(cfg.header(), key)
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+
+	resp, err := g.upstreams()[ServiceAuth].client.Do(req)
+	if err != nil {
+		return nil, &AuthUnavailableError{Err: fmt.Errorf("failed to contact AuthService: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return nil, &AuthUnavailableError{Err: fmt.Errorf("AuthService returned status: %d", resp.StatusCode)}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound:
+		return nil, errUnknownAPIKey
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("AuthService returned status: %d", resp.StatusCode)
+	}
+	var id AuthValidateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&id); err != nil {
+		return nil, &AuthUnavailableError{Err: fmt.Errorf("failed to decode AuthService response: %w", err)}
+	}
+	if id.UserID == "" || id.Role == "" {
+		return nil, &AuthUnavailableError{Err: fmt.Errorf("invalid AuthService response: missing userID or role")}
+	}
+	return &id, nil
+}
+
+// apiKeyAuth authenticates a request carrying an API key and applies the key's rate
+// limit. It returns the request to forward, or nil when it has already responded.
+func (g *Gateway) apiKeyAuth(w http.ResponseWriter, r *http.Request, key string) *http.Request {
+	k, err := g.authenticateAPIKey(r.Context(), key)
+	var unavailable *AuthUnavailableError
+	switch {
+	case err == nil:
+		g.metrics.observeAuth(authSuccess)
+	case errors.As(err, &unavailable):
+		g.Logger.ErrorContext(r.Context(), "API key validation failed", "error", err)
+		g.metrics.observeAuth(authUnavailable)
+		writeJSONError(w, http.StatusServiceUnavailable, "authentication service unavailable")
+		return nil
+	default:
+		g.Logger.InfoContext(r.Context(), "API key rejected", "error", err)
+		g.metrics.observeAuth(authInvalid)
+		writeJSONError(w, http.StatusUnauthorized, "invalid API key")
+		return nil
+	}
+
+	limit := k.Limit
+	if !limit.Enabled() {
+		limit = g.Config.APIKeys.RateLimit
+	}
+	if limit.Enabled() {
+		d := g.limiter.allow("apikey:"+k.Name, limit)
+		g.rateLimitCounts.observe("apikey:"+k.Name, d.allowed)
+		if !d.allowed {
+			g.Logger.WarnContext(r.Context(), "Rate limit exceeded", "api_key", k.Name, "limit", limit.Requests, "window", limit.Window)
+			g.writeRateLimited(w, d, "apikey")
+			return nil
+		}
+		setRateLimitHeaders(w, d)
+	}
+	return g.setIdentity(r, k.identity())
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	users := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		UserServiceURL: users.URL,
+		APIKeys: APIKeyConfig{
+			Header: "X-Partner-Key",
+			Keys: []APIKey{
+				{Name: "reporting", Key: "k-report", Role: "reader", TenantID: "acme"},
+				{Name: "billing", Key: "k-bill", UserID: "svc-billing", Role: "service", Limit: RateLimit{Requests: 2, Window: time.Minute}},
+			},
+		},
+	})
+	h := g.AuthMiddleware(g.ProxyHandler(ServiceUser))
+	get := func(key string) (*httptest.ResponseRecorder, testUpstreamResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/profile", nil)
+		req.Header.Set("X-Partner-Key", key)
+		var got testUpstreamResponse
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		return rec, got
+	}
+
+	rec, got := get("k-report")
+	if rec.Code != http.StatusOK || got.UserID != "reporting" || got.Header.Get("X-User-Role") != "reader" ||
+		got.Header.Get("X-Username") != "reporting" || got.Header.Get("X-Tenant-ID") != "acme" {
+		t.Errorf("valid key: status %d, identity %q/%q/%q/%q", rec.Code, got.UserID, got.Header.Get("X-User-Role"), got.Header.Get("X-Username"), got.Header.Get("X-Tenant-ID"))
+	}
+	// The key itself never reaches the service
+	if got.Header.Get("X-Partner-Key") != "" {
+		t.Error("API key forwarded to the service")
+	}
+	if rec, _ := get("k-unknown"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key: status %d, want 401", rec.Code)
+	}
+	// A prefix of a valid key isn't a valid key
+	if rec, _ := get("k-rep"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("key prefix: status %d, want 401", rec.Code)
+	}
+
+	// billing has its own limit; reporting has none
+	for i := range 2 {
+		if rec, got := get("k-bill"); rec.Code != http.StatusOK || got.UserID != "svc-billing" || rec.Header().Get("X-RateLimit-Limit") != "2" {
+			t.Fatalf("billing request %d: status %d, user %q, limit %q", i+1, rec.Code, got.UserID, rec.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+	if rec, _ := get("k-bill"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("billing over its limit: status %d, want 429", rec.Code)
+	}
+	if rec, _ := get("k-report"); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("reporting while billing is limited: status %d, limit %q", rec.Code, rec.Header().Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestAPIKeyValidationByAuthService(t *testing.T) {
+	var validations atomic.Int64
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		validations.Add(1)
+		if r.Method != http.MethodPost || r.URL.Path != "/api/auth/keys/validate" || r.Header.Get(DefaultAPIKeyHeader) != "k-remote" {
+			writeJSONError(w, http.StatusUnauthorized, "unknown key")
+			return
+		}
+		writeJSON(w, http.StatusOK, testUser{UserID: "partner-9", Role: "partner"})
+	}))
+	t.Cleanup(auth.Close)
+	users := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		AuthServiceURL: auth.URL,
+		UserServiceURL: users.URL,
+		TokenCacheTTL:  time.Minute,
+		APIKeys: APIKeyConfig{
+			ValidatePath: "/api/auth/keys/validate",
+			RateLimit:    RateLimit{Requests: 1, Window: time.Minute},
+		},
+	})
+	h := g.AuthMiddleware(g.ProxyHandler(ServiceUser))
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/profile", nil)
+		req.Header.Set(DefaultAPIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var got testUpstreamResponse
+	rec := get("k-remote")
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK || got.UserID != "partner-9" {
+		t.Fatalf("remote key: status %d, user %q", rec.Code, got.UserID)
+	}
+	// Keys AuthService validated get the default limit, and their validation is cached
+	if rec := get("k-remote"); rec.Code != http.StatusTooManyRequests || validations.Load() != 1 {
+		t.Errorf("second request: status %d after %d validations, want 429 after 1", rec.Code, validations.Load())
+	}
+	if rec := get("k-other"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("key AuthService rejects: status %d, want 401", rec.Code)
+	}
+}
+
+func TestAPIKeyIndexRefusesBadKeys(t *testing.T) {
+	for name, keys := range map[string][]APIKey{
+		"no name":   {{Key: "k1", Role: "user"}},
+		"no key":    {{Name: "a", Role: "user"}},
+		"no role":   {{Name: "a", Key: "k1"}},
+		"duplicate": {{Name: "a", Key: "k1", Role: "user"}, {Name: "b", Key: "k1", Role: "admin"}},
+	} {
+		if _, err := newAPIKeyIndex(keys); err == nil {
+			t.Errorf("%s: newAPIKeyIndex accepted %+v", name, keys)
+		}
+	}
+	idx, err := newAPIKeyIndex([]APIKey{{Name: "a", Key: "k1", Role: "user"}})
+	if err != nil || idx.lookup("k1") == nil || idx.lookup("k1").Name != "a" || idx.lookup("k2") != nil {
+		t.Errorf("index %v (%v)", idx, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(`[{"name":"ci","key":"k-ci","role":"deployer","rateLimit":"10/1m"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadAPIKeys(path)
+	if err != nil || len(keys) != 1 || keys[0].Limit != (RateLimit{Requests: 10, Window: time.Minute}) {
+		t.Errorf("LoadAPIKeys: %+v (%v)", keys, err)
+	}
+	if err := os.WriteFile(path, []byte(`[{"name":"ci","key":"k-ci","role":"deployer","rateLimit":"lots"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAPIKeys(path); err == nil {
+		t.Error("LoadAPIKeys accepted an invalid rate limit")
+	}
+}
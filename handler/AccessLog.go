@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Access log formats for Config.AccessLogFormat
+const (
+	AccessLogCommon   = "common"   // NCSA Common Log Format
+	AccessLogCombined = "combined" // Common plus referer and user agent
+	AccessLogJSON     = "json"     // one JSON object per line, including request headers
+)
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// defaultAccessLogRedact are the request headers whose values JSON access lines withhold,
+// along with the API key header; Sec-WebSocket-Protocol can carry a bearer token
+var defaultAccessLogRedact = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Admin-Token", "Sec-WebSocket-Protocol"}
+
+// accessLogDroppedParams are query parameters carrying credentials, left out of logged
+// URIs: the WebSocket token and what an OIDC callback could carry
+var accessLogDroppedParams = []string{websocketTokenParam, "id_token", "refresh_token", "code"}
+
+// accessLogURI is the request URI without the accessLogDroppedParams; other parameters
+// are kept as sent
+func accessLogURI(r *http.Request) string {
+	path, query, ok := strings.Cut(r.RequestURI, "?")
+	if r.RequestURI == "" {
+		path, query, ok = r.URL.EscapedPath(), r.URL.RawQuery, r.URL.RawQuery != ""
+	}
+	if !ok {
+		return path
+	}
+	kept := make([]string, 0, strings.Count(query, "&")+1)
+	for _, pair := range strings.Split(query, "&") {
+		key, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(key); err == nil && slices.Contains(accessLogDroppedParams, name) {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	if len(kept) == 0 {
+		return path
+	}
+	return path + "?" + strings.Join(kept, "&")
+}
+
+// accessRecord is one line of the JSON access log
+type accessRecord struct {
+	Time      time.Time           `json:"time"`
+	RequestID string              `json:"requestId,omitempty"`
+	ClientIP  string              `json:"clientIP,omitempty"`
+	User      string              `json:"user,omitempty"`
+	Method    string              `json:"method"`
+	URI       string              `json:"uri"`
+	Proto     string              `json:"proto"`
+	Status    int                 `json:"status"`
+	Bytes     int64               `json:"bytes"`
+	LatencyMS int64               `json:"latencyMs"`
+	Referer   string              `json:"referer,omitempty"`
+	UserAgent string              `json:"userAgent,omitempty"`
+	Headers   map[string][]string `json:"headers,omitempty"`
+}
+
+// accessEntry collects what the access log needs from inner handlers
+type accessEntry struct {
+	user string
+}
+
+type accessEntryKey struct{}
+
+func accessEntryFrom(ctx context.Context) *accessEntry {
+	e, _ := ctx.Value(accessEntryKey{}).(*accessEntry)
+	return e
+}
+
+// newAccessLog returns the access log sink, or nil when access logging is off. Lines
+// go to stdout, or are appended to the file at path.
+func newAccessLog(format, path string) (*log.Logger, error) {
+	switch format {
+	case "":
+		return nil, nil
+	case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", format)
+	}
+	if path == "" {
+		return log.New(os.Stdout, "", 0), nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+	return log.New(f, "", 0), nil
+}
+
+// AccessLogMiddleware writes one line per request when Config.AccessLogFormat is set,
+// skipping the paths in Config.AccessLogExcludePaths. It is separate from the debug log,
+// which keeps its own messages.
+func (g *Gateway) AccessLogMiddleware(next http.Handler) http.Handler {
+	if g.accessLog == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(g.Config.AccessLogExcludePaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		entry := &accessEntry{}
+		var header http.Header
+		if g.Config.AccessLogFormat == AccessLogJSON {
+			header = r.Header.Clone() // as the client sent it, before auth adds identity headers
+		}
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+		if header != nil {
+			g.accessLog.Print(g.formatAccessJSON(r, header, entry, rec, start))
+			return
+		}
+		g.accessLog.Print(g.formatAccessLine(r, entry, rec, start))
+	})
+}
+
+// formatAccessJSON renders the request as an accessRecord, with the values of
+// Config.AccessLogRedactHeaders (default defaultAccessLogRedact) replaced
+func (g *Gateway) formatAccessJSON(r *http.Request, header http.Header, entry *accessEntry, rec *statusRecorder, start time.Time) string {
+	redact := g.Config.AccessLogRedactHeaders
+	if len(redact) == 0 {
+		redact = append(defaultAccessLogRedact[:len(defaultAccessLogRedact):len(defaultAccessLogRedact)], g.Config.APIKeys.header())
+	}
+	for _, name := range redact {
+		if header.Get(name) != "" {
+			header.Set(name, "[REDACTED]")
+		}
+	}
+	ar := accessRecord{
+		Time:      start.UTC(),
+		RequestID: requestIDFrom(r.Context()),
+		User:      entry.user,
+		Method:    r.Method,
+		URI:       accessLogURI(r),
+		Proto:     r.Proto,
+		Status:    rec.status,
+		Bytes:     rec.bytes,
+		LatencyMS: time.Since(start).Milliseconds(),
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+		Headers:   header,
+	}
+	if ip, ok := g.clientIP(r); ok {
+		ar.ClientIP = ip.String()
+	}
+	line, _ := json.Marshal(ar)
+	return string(line)
+}
+
+// formatAccessLine renders host ident authuser [date] "request" status bytes, plus
+// "referer" "user-agent" in combined format
+func (g *Gateway) formatAccessLine(r *http.Request, entry *accessEntry, rec *statusRecorder, start time.Time) string {
+	host := "-"
+	if ip, ok := g.clientIP(r); ok {
+		host = ip.String()
+	}
+	user := "-"
+	if entry.user != "" {
+		user = entry.user
+	}
+	size := "-"
+	if rec.bytes > 0 {
+		size = strconv.FormatInt(rec.bytes, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s", host, user, start.Format(clfTimeFormat),
+		r.Method+" "+accessLogURI(r)+" "+r.Proto, rec.status, size)
+	if g.Config.AccessLogFormat == AccessLogCombined {
+		line += fmt.Sprintf(" %q %q", orDash(r.Referer()), orDash(r.UserAgent()))
+	}
+	return line
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
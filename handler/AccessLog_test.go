@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// accessLogLine serves req through the access log of a gateway built from config and
+// returns the line it wrote
+func accessLogLine(t *testing.T, config *Config, req *http.Request) string {
+	t.Helper()
+	config.AccessLogPath = filepath.Join(t.TempDir(), "access.log")
+	g := newTestGateway(t, config)
+	serve(t, g.AccessLogMiddleware(http.NotFoundHandler()), req, nil)
+	line, err := os.ReadFile(config.AccessLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(line))
+}
+
+func TestAccessLogRedactsCredentialHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/user/profile", nil)
+	secrets := map[string]string{
+		"Authorization":          "Bearer jwt-secret",
+		"Cookie":                 "access_token=cookie-secret",
+		"X-Admin-Token":          "admin-secret",
+		"X-Partner-Key":          "api-key-secret",
+		"Sec-WebSocket-Protocol": "bearer, ws-secret",
+	}
+	for k, v := range secrets {
+		req.Header.Set(k, v)
+	}
+	line := accessLogLine(t, &Config{AccessLogFormat: AccessLogJSON, APIKeys: APIKeyConfig{Header: "X-Partner-Key"}}, req)
+
+	var rec accessRecord
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		t.Fatalf("invalid access line %q: %v", line, err)
+	}
+	for k, v := range secrets {
+		if strings.Contains(line, v) {
+			t.Errorf("access line leaks %s: %s", k, line)
+		}
+		if got := http.Header(rec.Headers).Get(k); got != "[REDACTED]" {
+			t.Errorf("%s = %q, want it redacted", k, got)
+		}
+	}
+}
+
+func TestAccessLogDropsCredentialQueryParams(t *testing.T) {
+	for _, format := range []string{AccessLogCommon, AccessLogJSON} {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/live?room=7&access_token=ws-secret&code=oidc-secret&x=1", nil)
+		line := accessLogLine(t, &Config{AccessLogFormat: format}, req)
+		if strings.Contains(line, "secret") {
+			t.Errorf("%s line leaks a credential: %s", format, line)
+		}
+		var rec accessRecord
+		if format == AccessLogJSON && json.Unmarshal([]byte(line), &rec) == nil {
+			line = rec.URI
+		}
+		if !strings.Contains(line, "/api/blog/live?room=7&x=1") {
+			t.Errorf("%s line lost the other parameters: %s", format, line)
+		}
+	}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	const common = `^192\.0\.2\.7 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /api/blog/posts\?page=2 HTTP/1\.1" 404 19`
+	for _, tt := range []struct {
+		format, pattern string
+	}{
+		{AccessLogCommon, common + `$`},
+		{AccessLogCombined, common + ` "https://app\.example/feed" "Mozilla/5\.0 \(X11\)"$`},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts?page=2", nil)
+		req.RemoteAddr = "192.0.2.7:5000"
+		req.Header.Set("Referer", "https://app.example/feed")
+		req.Header.Set("User-Agent", "Mozilla/5.0 (X11)")
+		if line := accessLogLine(t, &Config{AccessLogFormat: tt.format}, req); !regexp.MustCompile(tt.pattern).MatchString(line) {
+			t.Errorf("%s line %q doesn't match %s", tt.format, line, tt.pattern)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	if line := accessLogLine(t, &Config{AccessLogFormat: AccessLogCombined, AccessLogExcludePaths: []string{"/healthz"}}, req); line != "" {
+		t.Errorf("excluded path logged: %s", line)
+	}
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// AdminMiddleware guards admin endpoints with the X-Admin-Token header. Without a
+// configured Config.AdminToken every admin request is refused, unless the admin API is
+// served on its own Config.AdminAddr listener, where the token is optional.
+func (g *Gateway) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.adminAuthorized(r) {
+			g.Logger.WarnContext(r.Context(), "Admin request denied", "method", r.Method, "path", r.URL.Path, "remote", g.clientAddr(r))
+			writeJSONError(w, http.StatusForbidden, "admin token required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (g *Gateway) adminAuthorized(r *http.Request) bool {
+	if g.Config.AdminToken == "" {
+		return g.Config.AdminAddr != ""
+	}
+	token := r.Header.Get("X-Admin-Token")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(g.Config.AdminToken)) == 1
+}
+
+type adminRoute struct {
+	Service   string   `json:"service"`
+	Prefix    string   `json:"prefix"`
+	Declared  bool     `json:"declared"` // from the routes file rather than built in
+	Methods   []string `json:"methods,omitempty"`
+	Public    bool     `json:"public,omitempty"`
+	Optional  bool     `json:"optionalAuth,omitempty"`
+	Stream    bool     `json:"stream,omitempty"`
+	Instances []string `json:"instances"`
+}
+
+type routesResponse struct {
+	Routes       []adminRoute `json:"routes"`
+	PublicPaths  []string     `json:"publicPaths"`
+	OptionalAuth []string     `json:"optionalAuthPaths"`
+	StreamRoutes []string     `json:"streamRoutes"`
+}
+
+// RoutesHandler lists the services the gateway currently routes to (GET /admin/routes)
+func (g *Gateway) RoutesHandler(w http.ResponseWriter, r *http.Request) {
+	live := g.routing.Load()
+	declared := make(map[string]RouteConfig, len(live.routes))
+	for _, rt := range g.Routes() {
+		declared[rt.Name] = rt
+	}
+	resp := routesResponse{PublicPaths: live.publicPatterns, OptionalAuth: live.optionalAuthPatterns, StreamRoutes: live.streamRoutes}
+	for _, name := range sortedKeys(live.upstreams) {
+		route := adminRoute{
+			Service:   name,
+			Prefix:    ServicePrefixes[name],
+			Instances: instanceURLs(live.upstreams[name].instances()),
+		}
+		if rt, ok := declared[name]; ok {
+			route.Prefix, route.Declared, route.Methods = rt.Prefix, true, rt.Methods
+			route.Public, route.Optional, route.Stream = rt.Public, rt.OptionalAuth, rt.Stream
+		}
+		resp.Routes = append(resp.Routes, route)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type instanceStatus struct {
+	URL          string    `json:"url"`
+	Score        float64   `json:"score"`
+	Active       int64     `json:"active"`
+	ErrorRate    float64   `json:"errorRate"`
+	LatencyMs    float64   `json:"latencyMs"`
+	Failures     int       `json:"failures"`
+	EjectedUntil time.Time `json:"ejectedUntil,omitzero"`
+	Drained      bool      `json:"drained,omitempty"`
+}
+
+type upstreamStatus struct {
+	Health      serviceHealth    `json:"health"`
+	Strategy    string           `json:"strategy"`
+	Circuit     string           `json:"circuit,omitempty"`
+	Maintenance bool             `json:"maintenance,omitempty"`
+	Draining    bool             `json:"draining,omitempty"`
+	InFlight    int64            `json:"inFlight"`
+	Instances   []instanceStatus `json:"instances"`
+}
+
+// UpstreamsHandler reports the readiness, circuit breaker and per-instance health of
+// every service (GET /admin/upstreams)
+func (g *Gateway) UpstreamsHandler(w http.ResponseWriter, r *http.Request) {
+	upstreams := g.upstreams()
+	health, _ := g.readiness.snapshot()
+	now := time.Now()
+	resp := make(map[string]upstreamStatus, len(upstreams))
+	for name, up := range upstreams {
+		s := upstreamStatus{
+			Health:      health[name],
+			Strategy:    up.strategy,
+			Maintenance: up.maintenance.Load() != nil,
+			Draining:    up.draining.Load() != nil,
+			InFlight:    up.stats.inFlight.Load(),
+		}
+		if up.breaker != nil {
+			s.Circuit = up.breaker.current()
+		}
+		for _, in := range up.instances() {
+			s.Instances = append(s.Instances, in.status(up.health, now))
+		}
+		resp[name] = s
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"services": resp})
+}
+
+func (in *instance) status(cfg HealthScoreConfig, now time.Time) instanceStatus {
+	s := instanceStatus{
+		URL:     in.url.String(),
+		Score:   in.score(cfg),
+		Active:  in.active.Load(),
+		Drained: in.drained.Load(),
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	s.ErrorRate, s.LatencyMs, s.Failures = in.errorRate, in.latency*1000, in.failures
+	if now.Before(in.ejectedUntil) {
+		s.EjectedUntil = in.ejectedUntil
+	}
+	return s
+}
+
+// instanceDrainRequest is the body of POST /admin/instances/drain
+type instanceDrainRequest struct {
+	Service  string `json:"service"`
+	Instance string `json:"instance"` // URL as listed by /admin/upstreams
+	Enabled  bool   `json:"enabled"`
+}
+
+// InstanceDrainHandler takes one instance of a service out of rotation or puts it back
+// (POST /admin/instances/drain). Requests in flight on it finish; new ones go to the other
+// instances. The last instance in rotation can't be drained; drain the service instead.
+func (g *Gateway) InstanceDrainHandler(w http.ResponseWriter, r *http.Request) {
+	var req instanceDrainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid drain request")
+		return
+	}
+	up, ok := g.upstreams()[req.Service]
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown service "+req.Service)
+		return
+	}
+	var target *instance
+	inRotation := 0
+	for _, in := range up.instances() {
+		if in.url.String() == req.Instance {
+			target = in
+		}
+		if !in.drained.Load() {
+			inRotation++
+		}
+	}
+	if target == nil {
+		writeJSONError(w, http.StatusNotFound, "unknown instance "+req.Instance)
+		return
+	}
+	if req.Enabled && !target.drained.Load() && inRotation < 2 {
+		writeJSONError(w, http.StatusConflict, "cannot drain the last instance in rotation, use /admin/drain")
+		return
+	}
+
+	target.drained.Store(req.Enabled)
+	if req.Enabled {
+		g.Logger.Info("Instance drained", "service", req.Service, "instance", req.Instance)
+	} else {
+		g.Logger.Info("Instance back in rotation", "service", req.Service, "instance", req.Instance)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"service":  req.Service,
+		"instance": req.Instance,
+		"drained":  req.Enabled,
+		"active":   target.active.Load(),
+	})
+}
+
+type rateLimitStatus struct {
+	Requests int    `json:"requests"`
+	Window   string `json:"window"`
+	Burst    int    `json:"burst"`
+	rateLimitCount
+}
+
+type rateLimitsResponse struct {
+	Backend       string                     `json:"backend"`
+	Tenants       map[string]rateLimitStatus `json:"tenants"`
+	DefaultTenant *rateLimitStatus           `json:"defaultTenant,omitempty"`
+	Routes        map[string]rateLimitStatus `json:"routes"`
+}
+
+// RateLimitsHandler reports the configured rate limits with the number of requests each
+// allowed and rejected since startup (GET /admin/rate-limits)
+func (g *Gateway) RateLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	live := g.routing.Load()
+	counts := g.rateLimitCounts.snapshot()
+	status := func(l RateLimit, key string) rateLimitStatus {
+		return rateLimitStatus{Requests: l.Requests, Window: l.Window.String(), Burst: int(l.burst()), rateLimitCount: counts[key]}
+	}
+	resp := rateLimitsResponse{
+		Backend: "memory",
+		Tenants: make(map[string]rateLimitStatus, len(live.tenantRateLimits)),
+		Routes:  make(map[string]rateLimitStatus, len(live.routeRateLimits)),
+	}
+	if g.Config.RateLimitRedisURL != "" {
+		resp.Backend = "redis"
+	}
+	for tenant, l := range live.tenantRateLimits {
+		resp.Tenants[tenant] = status(l, tenantLimitKey(tenant))
+	}
+	if l := live.defaultTenantRateLimit; l.Enabled() {
+		s := status(l, defaultTenantLimitKey)
+		resp.DefaultTenant = &s
+	}
+	for prefix, l := range live.routeRateLimits {
+		resp.Routes[prefix] = status(l, routeLimitKey(prefix))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func instanceURLs(instances []*instance) []string {
+	urls := make([]string, len(instances))
+	for i, in := range instances {
+		urls[i] = in.url.String()
+	}
+	return urls
+}
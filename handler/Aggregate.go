@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultAggregateTimeout = 3 * time.Second
+
+// AggregateSection describes one upstream call whose JSON becomes a section of the merged response
+type AggregateSection struct {
+	Name    string `json:"name"`    // key in the merged response; empty merges the fields of an object into the top level
+	Service string `json:"service"` // upstream service name
+	Path    string `json:"path"`    // gateway path, rewritten the same way ProxyHandler would
+	// Fields keeps only these fields of an object response
+	Fields []string `json:"fields,omitempty"`
+	// Required sections fail the whole response with 502; others are reported under "errors"
+	Required bool `json:"required,omitempty"`
+	// Timeout overrides Config.AggregateTimeout for the section
+	Timeout time.Duration `json:"-"`
+}
+
+// Composition declares an aggregation endpoint, e.g. GET /api/composite/profile
+// combining the user's profile with their posts
+type Composition struct {
+	Path     string             `json:"path"`
+	Sections []AggregateSection `json:"sections"`
+}
+
+// DashboardSections is the fan-out list for GET /api/aggregate/dashboard
+var DashboardSections = []AggregateSection{
+	{Name: "profile", Service: ServiceUser, Path: "/api/user/profile"},
+	{Name: "posts", Service: ServiceBlog, Path: "/api/blog/posts"},
+}
+
+// LoadCompositions reads a JSON compositions file, whose section timeouts are duration
+// strings, e.g. [{"path":"/api/composite/profile","sections":[{"name":"user","service":"user",
+// "path":"/api/user/profile","required":true},{"name":"posts","service":"blog","path":"/api/blog/posts","timeout":"1s"}]}]
+func LoadCompositions(path string) ([]Composition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compositions file: %w", err)
+	}
+	var raw []struct {
+		Path     string `json:"path"`
+		Sections []struct {
+			AggregateSection
+			Timeout string `json:"timeout,omitempty"`
+		} `json:"sections"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid compositions file %s: %w", path, err)
+	}
+	compositions := make([]Composition, 0, len(raw))
+	for _, c := range raw {
+		comp := Composition{Path: c.Path}
+		for _, s := range c.Sections {
+			sec := s.AggregateSection
+			if s.Timeout != "" {
+				if sec.Timeout, err = time.ParseDuration(s.Timeout); err != nil {
+					return nil, fmt.Errorf("composition %s: section %s: invalid timeout: %w", c.Path, sec.Name, err)
+				}
+			}
+			comp.Sections = append(comp.Sections, sec)
+		}
+		compositions = append(compositions, comp)
+	}
+	return compositions, nil
+}
+
+// validateCompositions checks compositions against each other and the known services
+func validateCompositions(compositions []Composition, routes []RouteConfig) error {
+	services := make(map[string]bool)
+	for name := range ServicePrefixes {
+		services[name] = true
+	}
+	for _, rt := range routes {
+		services[rt.Name] = true
+	}
+	paths := make(map[string]bool)
+	for _, c := range compositions {
+		switch {
+		case !strings.HasPrefix(c.Path, "/"):
+			return fmt.Errorf("composition %q: path must start with /", c.Path)
+		case paths[c.Path]:
+			return fmt.Errorf("composition %s: duplicate path", c.Path)
+		case len(c.Sections) == 0:
+			return fmt.Errorf("composition %s: no sections", c.Path)
+		}
+		paths[c.Path] = true
+		names := make(map[string]bool)
+		for _, sec := range c.Sections {
+			switch {
+			case !services[sec.Service]:
+				return fmt.Errorf("composition %s: unknown service %q", c.Path, sec.Service)
+			case sec.Name != "" && names[sec.Name]:
+				return fmt.Errorf("composition %s: duplicate section %s", c.Path, sec.Name)
+			}
+			names[sec.Name] = true
+		}
+	}
+	return nil
+}
+
+// AggregateHandler concurrently calls every section and merges the results into one JSON object
+// in section order. Failed sections are reported under "errors"; the response is a 502
+// problem if a required section or all sections fail.
+func (g *Gateway) AggregateHandler(sections []AggregateSection) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := g.Config.AggregateTimeout
+		if timeout <= 0 {
+			timeout = defaultAggregateTimeout
+		}
+
+		var wg sync.WaitGroup
+		data := make([]json.RawMessage, len(sections))
+		errs := make([]error, len(sections))
+		for i, sec := range sections {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(r.Context(), cmp.Or(sec.Timeout, timeout))
+				defer cancel()
+
+				data[i], errs[i] = g.fetchSection(ctx, r, sec)
+				if errs[i] == nil && (sec.Name == "" || len(sec.Fields) > 0) {
+					data[i], errs[i] = selectFields(data[i], sec.Fields)
+				}
+				if errs[i] != nil {
+					g.Logger.WarnContext(r.Context(), "Aggregate section failed", "section", sec.Name, "service", sec.Service, "error", errs[i])
+				}
+			}()
+		}
+		wg.Wait()
+
+		status := http.StatusOK
+		result := make(map[string]any, len(sections)+1)
+		failed := make(map[string]string)
+		for i, sec := range sections {
+			if errs[i] != nil {
+				// The error, logged above, may name internal addresses
+				msg := "section unavailable"
+				if errors.Is(errs[i], context.DeadlineExceeded) {
+					msg = "section timed out"
+				}
+				failed[cmp.Or(sec.Name, sec.Service)] = msg
+				if sec.Required {
+					status = http.StatusBadGateway
+				}
+				continue
+			}
+			if sec.Name != "" {
+				result[sec.Name] = data[i]
+				continue
+			}
+			var fields map[string]json.RawMessage
+			json.Unmarshal(data[i], &fields) // an object, checked by selectFields
+			for k, v := range fields {
+				result[k] = v
+			}
+		}
+		if len(failed) == len(sections) {
+			status = http.StatusBadGateway
+		}
+		if status != http.StatusOK {
+			writeProblem(w, status, "aggregate sections failed", map[string]any{"errors": failed})
+			return
+		}
+		if len(failed) > 0 {
+			result["errors"] = failed
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// selectFields keeps only fields of a JSON object, or all of them when fields is empty
+func selectFields(data json.RawMessage, fields []string) (json.RawMessage, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil || obj == nil {
+		return nil, fmt.Errorf("upstream returned no JSON object")
+	}
+	if len(fields) == 0 {
+		return data, nil
+	}
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		if v, ok := obj[f]; ok {
+			selected[f] = v
+		}
+	}
+	return json.Marshal(selected)
+}
+
+// fetchSection performs the upstream GET for a section, forwarding the caller's identity
+func (g *Gateway) fetchSection(ctx context.Context, r *http.Request, sec AggregateSection) (json.RawMessage, error) {
+	return g.fetchJSON(ctx, r, sec.Service, http.MethodGet, sec.Path, nil)
+}
+
+// fetchJSON calls a service on behalf of r's caller, forwarding their token and identity,
+// and returns the JSON of a 2xx response. A body is sent as JSON.
+func (g *Gateway) fetchJSON(ctx context.Context, r *http.Request, service, method, path string, body []byte) (json.RawMessage, error) {
+	up, ok := g.upstreams()[service]
+	if !ok {
+		return nil, fmt.Errorf("unknown service %q", service)
+	}
+	target, err := g.upstreamURL(up, up.pick(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	for _, h := range identityHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+
+	resp, err := up.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upstream unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("upstream returned status: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upstream response: %w", err)
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("upstream returned invalid JSON")
+	}
+	return data, nil
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboardAggregatesUserAndBlog(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"alice-token": {UserID: "alice", Role: "user"}})
+	users, blog := newTestUpstream(t), newTestUpstream(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	dashboard := func(usersURL, blogURL string) (int, map[string]json.RawMessage) {
+		g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, UserServiceURL: usersURL, BlogServiceURL: blogURL})
+		req := httptest.NewRequest(http.MethodGet, "/api/aggregate/dashboard", nil)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		var resp map[string]json.RawMessage
+		rec := serve(t, g.AuthMiddleware(g.AggregateHandler(DashboardSections)), req, &resp)
+		return rec.Code, resp
+	}
+
+	status, resp := dashboard(users.URL, blog.URL)
+	var profile, posts testUpstreamResponse
+	json.Unmarshal(resp["profile"], &profile)
+	json.Unmarshal(resp["posts"], &posts)
+	if status != http.StatusOK || profile.Path != "/api/user/profile" || posts.Path != "/api/blog/posts" || resp["errors"] != nil {
+		t.Errorf("both up: status %d, response %s", status, resp)
+	}
+	if profile.UserID != "alice" || posts.UserID != "alice" {
+		t.Errorf("sections called as %q and %q, want alice", profile.UserID, posts.UserID)
+	}
+
+	status, resp = dashboard(users.URL, down.URL)
+	if status != http.StatusOK || resp["profile"] == nil || string(resp["errors"]) != `{"posts":"section unavailable"}` {
+		t.Errorf("blog down: status %d, response %s; want the profile and a posts error", status, resp)
+	}
+
+	if status, resp = dashboard(down.URL, down.URL); status != http.StatusBadGateway || resp["type"] == nil || strings.Contains(string(resp["errors"]), "127.0.0.1") {
+		t.Errorf("both down: status %d, response %s; want a 502 problem without upstream addresses", status, resp)
+	}
+}
+
+func TestCompositionsMergeSelectedFields(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"alice-token": {UserID: "alice", Role: "user"}})
+	users, blog := newTestUpstream(t), newTestUpstream(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	profile := func(usersURL, blogURL string, sections []AggregateSection) (int, map[string]json.RawMessage) {
+		g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, UserServiceURL: usersURL, BlogServiceURL: blogURL,
+			Compositions: []Composition{{Path: "/api/composite/profile", Sections: sections}}})
+		req := httptest.NewRequest(http.MethodGet, "/api/composite/profile", nil)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		var resp map[string]json.RawMessage
+		rec := serve(t, g.AuthMiddleware(g.AggregateHandler(g.Config.Compositions[0].Sections)), req, &resp)
+		return rec.Code, resp
+	}
+	sections := []AggregateSection{
+		{Service: ServiceUser, Path: "/api/user/profile", Fields: []string{"userID", "path"}, Required: true},
+		{Name: "posts", Service: ServiceBlog, Path: "/api/blog/posts", Fields: []string{"path"}},
+	}
+
+	status, resp := profile(users.URL, blog.URL, sections)
+	if status != http.StatusOK || string(resp["userID"]) != `"alice"` || string(resp["path"]) != `"/api/user/profile"` ||
+		string(resp["posts"]) != `{"path":"/api/blog/posts"}` || resp["method"] != nil || resp["errors"] != nil {
+		t.Errorf("both up: status %d, response %s", status, resp)
+	}
+
+	status, resp = profile(users.URL, down.URL, sections)
+	if status != http.StatusOK || resp["userID"] == nil || !strings.Contains(string(resp["errors"]), "posts") {
+		t.Errorf("optional section down: status %d, response %s; want the profile and a posts error", status, resp)
+	}
+	status, resp = profile(down.URL, blog.URL, sections)
+	if status != http.StatusBadGateway || resp["posts"] != nil || !strings.Contains(string(resp["errors"]), "user") {
+		t.Errorf("required section down: status %d, response %s; want 502 with only errors", status, resp)
+	}
+
+	config := &Config{AuthServiceURL: auth.URL, UserServiceURL: users.URL, BlogServiceURL: blog.URL, AspServiceURL: blog.URL,
+		Compositions: []Composition{{Path: "/api/composite/x", Sections: []AggregateSection{{Name: "x", Service: "billing", Path: "/x"}}}}}
+	if _, err := NewGateway(config, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("NewGateway accepted a composition of an unknown service")
+	}
+}
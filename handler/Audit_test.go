@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogRecordsAuthenticatedRequests(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"alice-token": {UserID: "alice", Role: "author", Username: "Alice"}})
+	blog := newTestUpstream(t)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	g := newTestGateway(t, &Config{
+		AuthServiceURL: auth.URL,
+		BlogServiceURL: blog.URL,
+		PublicPaths:    []string{"/api/blog/public/"},
+		AuditEnabled:   true,
+		AuditLogPath:   path,
+	})
+	log := captureLog(g)
+	h := g.AuthMiddleware(g.ProxyHandler(ServiceBlog))
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/blog/posts/7", nil)
+	req.Header.Set("Authorization", "Bearer alice-token")
+	serve(t, h, req, nil)
+	serve(t, h, httptest.NewRequest(http.MethodGet, "/api/blog/public/posts", nil), nil)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []AuditRecord
+	for lines := bufio.NewScanner(f); lines.Scan(); {
+		var rec AuditRecord
+		if err := json.Unmarshal(lines.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid audit line %q: %v", lines.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 1 {
+		t.Fatalf("audit records %+v, want one for the authenticated request", records)
+	}
+	rec := records[0]
+	if rec.Event != "request" || rec.UserID != "alice" || rec.Role != "author" || rec.Username != "Alice" ||
+		rec.Method != http.MethodDelete || rec.Path != "/api/blog/posts/7" || rec.Service != ServiceBlog ||
+		rec.Status != http.StatusOK || rec.Timestamp.IsZero() {
+		t.Errorf("audit record %+v", rec)
+	}
+	if strings.Contains(log.String(), `"event":"request"`) {
+		t.Error("audit record written to the operational log")
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// AccessRule restricts requests matching a path pattern, and optionally methods, to roles
+type AccessRule struct {
+	// Path is a path pattern (see compilePathPatterns), e.g. "/api/user/admin/**"
+	Path string
+	// Methods the rule applies to; empty means all methods
+	Methods []string
+	// Roles allowed through; callers with any other role get 403
+	Roles []string
+}
+
+// accessRule is an AccessRule with its path compiled
+type accessRule struct {
+	AccessRule
+	path *regexp.Regexp
+}
+
+// compileAccessRules compiles the path patterns of rules
+func compileAccessRules(rules []AccessRule) ([]accessRule, error) {
+	compiled := make([]accessRule, 0, len(rules))
+	for _, rule := range rules {
+		patterns, err := compilePathPatterns([]string{rule.Path})
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, accessRule{AccessRule: rule, path: patterns[0]})
+	}
+	return compiled, nil
+}
+
+func (rule *accessRule) matches(r *http.Request) bool {
+	if len(rule.Methods) > 0 && !slices.ContainsFunc(rule.Methods, func(m string) bool { return strings.EqualFold(m, r.Method) }) {
+		return false
+	}
+	return rule.path.MatchString(r.URL.Path)
+}
+
+// AuthorizationMiddleware enforces Config.AccessRules on authenticated requests. The
+// first rule matching the method and path decides; requests no rule matches are
+// allowed. It must run after AuthMiddleware.
+func (g *Gateway) AuthorizationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules := g.routing.Load().accessRules
+		i := slices.IndexFunc(rules, func(rule accessRule) bool { return rule.matches(r) })
+		if i < 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rule := rules[i]
+		id := identityFrom(r)
+		if id == nil {
+			// A public path can't be authorized by role
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if !slices.Contains(rule.Roles, id.Role) {
+			g.Logger.InfoContext(r.Context(), "Request denied by access rule", "path", r.URL.Path, "method", r.Method,
+				"rule", rule.Path, "role", id.Role, "user_id", id.UserID)
+			message := "role " + id.Role + " may not " + r.Method + " " + r.URL.Path
+			writeProblem(w, http.StatusForbidden, message, map[string]any{
+				"error":         "forbidden",
+				"message":       message,
+				"requiredRoles": rule.Roles,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
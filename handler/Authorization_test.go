@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorizationMiddleware(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{
+		"admin-token":  {UserID: "1", Role: "admin"},
+		"author-token": {UserID: "2", Role: "author"},
+		"user-token":   {UserID: "3", Role: "user"},
+	})
+	users := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		AuthServiceURL: auth.URL,
+		UserServiceURL: users.URL,
+		PublicPaths:    []string{"/api/auth/", "/api/user/public/"},
+		AccessRules: []AccessRule{
+			{Path: "/api/user/admin/**", Roles: []string{"admin"}},
+			{Path: "/api/user/posts/*", Methods: []string{"delete", "PUT"}, Roles: []string{"author", "admin"}},
+			{Path: "/api/user/public/**", Roles: []string{"admin"}},
+		},
+	})
+	h := g.AuthMiddleware(g.AuthorizationMiddleware(g.ProxyHandler(ServiceUser)))
+	send := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serve(t, h, req, nil)
+	}
+
+	for _, tt := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, "/api/user/admin", "admin-token", http.StatusOK},
+		{http.MethodGet, "/api/user/admin", "user-token", http.StatusForbidden},
+		{http.MethodGet, "/api/user/admin/users/7", "author-token", http.StatusForbidden},
+		{http.MethodGet, "/api/user/administrator", "user-token", http.StatusOK},
+		{http.MethodDelete, "/api/user/posts/7", "author-token", http.StatusOK},
+		{http.MethodDelete, "/api/user/posts/7", "user-token", http.StatusForbidden},
+		{http.MethodPut, "/api/user/posts/7", "user-token", http.StatusForbidden},
+		// Methods the rule doesn't name, and paths below its single-segment glob, are open
+		{http.MethodGet, "/api/user/posts/7", "user-token", http.StatusOK},
+		{http.MethodDelete, "/api/user/posts/7/comments/1", "user-token", http.StatusOK},
+		{http.MethodGet, "/api/user/profile", "user-token", http.StatusOK},
+		// Public paths skip authentication, so a rule over one has no role to check
+		{http.MethodGet, "/api/user/public/info", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/user/public/info", "admin-token", http.StatusUnauthorized},
+	} {
+		if rec := send(tt.method, tt.path, tt.token); rec.Code != tt.want {
+			t.Errorf("%s %s as %s: status %d, want %d", tt.method, tt.path, tt.token, rec.Code, tt.want)
+		}
+	}
+
+	var denied struct {
+		Error         string   `json:"error"`
+		RequiredRoles []string `json:"requiredRoles"`
+	}
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/posts/7", nil)
+	req.Header.Set("Authorization", "Bearer user-token")
+	if rec := serve(t, h, req, &denied); rec.Code != http.StatusForbidden || denied.Error != "forbidden" || len(denied.RequiredRoles) != 2 {
+		t.Errorf("403 body: %s", rec.Body)
+	}
+	if calls := users.calls.Load(); calls != 6 {
+		t.Errorf("service got %d requests, want only the 6 allowed ones", calls)
+	}
+}
+
+func TestAuthorizationFirstMatchingRuleDecides(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"user-token": {UserID: "3", Role: "user"}})
+	users := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		AuthServiceURL: auth.URL,
+		UserServiceURL: users.URL,
+		AccessRules: []AccessRule{
+			{Path: "/api/user/admin/help", Roles: []string{"user", "admin"}},
+			{Path: "/api/user/admin/**", Roles: []string{"admin"}},
+		},
+	})
+	h := g.AuthMiddleware(g.AuthorizationMiddleware(g.ProxyHandler(ServiceUser)))
+	for path, want := range map[string]int{"/api/user/admin/help": http.StatusOK, "/api/user/admin/users": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer user-token")
+		if rec := serve(t, h, req, nil); rec.Code != want {
+			t.Errorf("%s: status %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestAuthorizationRefusesInvalidPatterns(t *testing.T) {
+	missing := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(missing.Close)
+	config := &Config{AuthServiceURL: missing.URL, BlogServiceURL: missing.URL, UserServiceURL: missing.URL, AspServiceURL: missing.URL,
+		AccessRules: []AccessRule{{Path: "^/api/user/(admin", Roles: []string{"admin"}}}}
+	if _, err := NewGateway(config, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("NewGateway accepted an access rule with an invalid pattern")
+	}
+}
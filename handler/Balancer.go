@@ -0,0 +1,369 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health score defaults
+const (
+	defaultErrorWeight      = 0.5
+	defaultLatencyWeight    = 0.3
+	defaultConnectionWeight = 0.2
+	defaultLatencyTarget    = 200 * time.Millisecond
+	defaultConnectionTarget = 10
+	defaultEjectDuration    = 30 * time.Second
+
+	healthAlpha   = 0.2  // EWMA smoothing for error rate and latency
+	minPickWeight = 0.01 // so a low-scoring instance still gets an occasional request
+)
+
+// Load balancing strategies for Config.LoadBalancing and ServiceConfig.LoadBalancing
+const (
+	LoadBalanceHealth           = "health"            // weighted by health score (default)
+	LoadBalanceRoundRobin       = "round-robin"       // each instance in turn
+	LoadBalanceLeastConnections = "least-connections" // fewest requests in flight
+)
+
+// HealthScoreConfig weights the signals combined into an instance's health score.
+// Each signal is mapped to a 0..1 "badness" and the score is 1 minus their weighted average.
+type HealthScoreConfig struct {
+	ErrorWeight      float64
+	LatencyWeight    float64
+	ConnectionWeight float64
+	// LatencyTarget is the latency at which the latency signal reaches 0.5
+	LatencyTarget time.Duration
+	// ConnectionTarget is the active-connection count at which the connection signal reaches 0.5
+	ConnectionTarget int
+	// EjectBelow ejects instances whose score drops under it (0 disables ejection)
+	EjectBelow float64
+	// EjectAfterFailures ejects an instance after this many consecutive 5xx or connection
+	// errors (0 disables), independently of its score
+	EjectAfterFailures int
+	// EjectDuration is how long an ejected instance stays out of rotation
+	EjectDuration time.Duration
+}
+
+func (c HealthScoreConfig) withDefaults() HealthScoreConfig {
+	if c.ErrorWeight == 0 && c.LatencyWeight == 0 && c.ConnectionWeight == 0 {
+		c.ErrorWeight, c.LatencyWeight, c.ConnectionWeight = defaultErrorWeight, defaultLatencyWeight, defaultConnectionWeight
+	}
+	if c.LatencyTarget <= 0 {
+		c.LatencyTarget = defaultLatencyTarget
+	}
+	if c.ConnectionTarget <= 0 {
+		c.ConnectionTarget = defaultConnectionTarget
+	}
+	if c.EjectDuration <= 0 {
+		c.EjectDuration = defaultEjectDuration
+	}
+	return c
+}
+
+// loadBalancing resolves a service's strategy; ServiceConfig.LoadBalancing overrides Config.LoadBalancing
+func (g *Gateway) loadBalancing(svc ServiceConfig) (string, error) {
+	strategy := svc.LoadBalancing
+	if strategy == "" {
+		strategy = g.Config.LoadBalancing
+	}
+	switch strategy {
+	case "":
+		return LoadBalanceHealth, nil
+	case LoadBalanceHealth, LoadBalanceRoundRobin, LoadBalanceLeastConnections:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown load balancing strategy %q", strategy)
+	}
+}
+
+// instance is one backend address of a service
+type instance struct {
+	url      *url.URL
+	director func(*http.Request)
+	active   atomic.Int64
+	drained  atomic.Bool // taken out of rotation through the admin API
+
+	mu           sync.Mutex
+	errorRate    float64 // EWMA of 5xx/transport failures
+	latency      float64 // EWMA of response latency in seconds
+	failures     int     // consecutive failures
+	ejectedUntil time.Time
+}
+
+func newInstance(u *url.URL) *instance {
+	return &instance{url: u, director: httputil.NewSingleHostReverseProxy(u).Director}
+}
+
+// score combines the instance's signals into a 0..1 health score, 1 being perfectly healthy
+func (in *instance) score(cfg HealthScoreConfig) float64 {
+	in.mu.Lock()
+	errRate, latency := in.errorRate, in.latency
+	in.mu.Unlock()
+
+	latTarget := cfg.LatencyTarget.Seconds()
+	latBad := latency / (latency + latTarget)
+	conns := float64(in.active.Load())
+	connBad := conns / (conns + float64(cfg.ConnectionTarget))
+
+	total := cfg.ErrorWeight + cfg.LatencyWeight + cfg.ConnectionWeight
+	bad := (cfg.ErrorWeight*errRate + cfg.LatencyWeight*latBad + cfg.ConnectionWeight*connBad) / total
+	return 1 - bad
+}
+
+// observe folds the outcome of one request into the instance's signals and returns
+// the number of consecutive failures
+func (in *instance) observe(failed bool, latency time.Duration) int {
+	var f float64
+	if failed {
+		f = 1
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.errorRate += healthAlpha * (f - in.errorRate)
+	in.latency += healthAlpha * (latency.Seconds() - in.latency)
+	if failed {
+		in.failures++
+	} else {
+		in.failures = 0
+	}
+	return in.failures
+}
+
+// ejected reports whether the instance is out of rotation, re-admitting it with
+// fresh signals once its ejection has expired
+func (in *instance) ejected(now time.Time) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.ejectedUntil.IsZero() {
+		return false
+	}
+	if now.Before(in.ejectedUntil) {
+		return true
+	}
+	in.ejectedUntil = time.Time{}
+	in.errorRate, in.latency, in.failures = 0, 0, 0
+	return false
+}
+
+func (in *instance) eject(until time.Time) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.ejectedUntil = until
+}
+
+// instances returns the service's current instances; the slice must not be modified
+func (up *upstream) instances() []*instance {
+	return *up.pool.Load()
+}
+
+// setInstances replaces the service's instances with targets, keeping the state of
+// instances whose URL is unchanged so their health signals and ejections carry over
+func (up *upstream) setInstances(targets []*url.URL) {
+	current := make(map[string]*instance)
+	if old := up.pool.Load(); old != nil {
+		for _, in := range *old {
+			current[in.url.String()] = in
+		}
+	}
+	instances := make([]*instance, 0, len(targets))
+	for _, t := range targets {
+		in, ok := current[t.String()]
+		if !ok {
+			in = newInstance(t)
+		}
+		instances = append(instances, in)
+	}
+	up.pool.Store(&instances)
+}
+
+// pick chooses an instance with probability proportional to its health score,
+// skipping ejected instances unless all of them are ejected
+func (up *upstream) pick() *instance {
+	return up.pickAvoiding(nil)
+}
+
+// pickAvoiding is pick, also skipping the instances in avoid (such as ones a retried
+// request already failed on) unless no other instance is left. The service's load
+// balancing strategy chooses among the remaining instances. Drained instances are never
+// picked while any other is left.
+func (up *upstream) pickAvoiding(avoid map[*instance]bool) *instance {
+	instances := up.rotation()
+	if len(instances) == 1 {
+		return instances[0]
+	}
+	now := time.Now()
+	candidates := make([]bool, len(instances))
+	var left int
+	for i, in := range instances {
+		if !in.ejected(now) && !avoid[in] {
+			candidates[i] = true
+			left++
+		}
+	}
+	if left == 0 {
+		if len(avoid) > 0 {
+			return up.pickAvoiding(nil)
+		}
+		return instances[rand.IntN(len(instances))]
+	}
+
+	switch up.strategy {
+	case LoadBalanceRoundRobin:
+		start := int(up.next.Add(1) % uint64(len(instances)))
+		for i := range instances {
+			if j := (start + i) % len(instances); candidates[j] {
+				return instances[j]
+			}
+		}
+	case LoadBalanceLeastConnections:
+		// Ties go to a random instance so idle services don't pile onto the first one
+		var best *instance
+		var bestActive int64
+		ties := 0
+		for i, in := range instances {
+			if !candidates[i] {
+				continue
+			}
+			switch active := in.active.Load(); {
+			case best == nil || active < bestActive:
+				best, bestActive, ties = in, active, 1
+			case active == bestActive:
+				if ties++; rand.IntN(ties) == 0 {
+					best = in
+				}
+			}
+		}
+		return best
+	}
+
+	weights := make([]float64, len(instances))
+	var total float64
+	for i, in := range instances {
+		if candidates[i] {
+			weights[i] = max(in.score(up.health), minPickWeight)
+			total += weights[i]
+		}
+	}
+	n := rand.Float64() * total
+	for i, w := range weights {
+		if n < w {
+			return instances[i]
+		}
+		n -= w
+	}
+	return instances[len(instances)-1]
+}
+
+// rotation returns the instances that aren't drained, or all of them if every one is
+func (up *upstream) rotation() []*instance {
+	instances := up.instances()
+	if !slices.ContainsFunc(instances, func(in *instance) bool { return in.drained.Load() }) {
+		return instances
+	}
+	active := slices.DeleteFunc(slices.Clone(instances), func(in *instance) bool { return in.drained.Load() })
+	if len(active) == 0 {
+		return instances
+	}
+	return active
+}
+
+// resolve maps a backend path onto the instance URL, keeping any base path in it the
+// same way the proxy director does: http://blog-svc/blog-api + /posts is /blog-api/posts
+func (in *instance) resolve(ref *url.URL) *url.URL {
+	u := *in.url
+	u.Path = joinPath(in.url.Path, ref.Path)
+	u.RawPath = ""
+	u.RawQuery = ref.RawQuery
+	u.Fragment = ""
+	return &u
+}
+
+// joinPath joins a base path and a request path with exactly one slash between them
+func joinPath(base, p string) string {
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(p, "/")
+}
+
+type (
+	instanceKey      struct{}
+	triedInstanceKey struct{}
+)
+
+// balanceHandler picks an instance for each request and records its outcome. A response
+// the proxy aborted midway (it panics with http.ErrAbortHandler) is a failure.
+func (g *Gateway) balanceHandler(up *upstream, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tried, _ := r.Context().Value(triedInstanceKey{}).(map[*instance]bool)
+		in := up.pickAvoiding(tried)
+		if tried != nil {
+			tried[in] = true
+		}
+		g.Logger.DebugContext(r.Context(), "Forwarding", "method", r.Method, "path", r.URL.Path, "upstream", in.url.String())
+
+		in.active.Add(1)
+		defer in.active.Add(-1)
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		defer func() {
+			p := recover()
+			g.recordInstance(up, in, p != nil || rec.status >= 500, time.Since(start))
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), instanceKey{}, in)))
+	})
+}
+
+// recordInstance records an outcome of in, ejecting it when its failures or score call
+// for it and the service has other instances
+func (g *Gateway) recordInstance(up *upstream, in *instance, failed bool, latency time.Duration) {
+	failures := in.observe(failed, latency)
+	if len(up.instances()) < 2 {
+		return
+	}
+	if n := up.health.EjectAfterFailures; n > 0 && failures >= n {
+		g.Logger.Warn("Ejecting instance", "service", up.name, "instance", in.url.String(), "for", up.health.EjectDuration, "failures", failures)
+		in.eject(time.Now().Add(up.health.EjectDuration))
+	} else if up.health.EjectBelow > 0 {
+		if score := in.score(up.health); score < up.health.EjectBelow {
+			g.Logger.Warn("Ejecting instance", "service", up.name, "instance", in.url.String(), "for", up.health.EjectDuration, "score", score)
+			in.eject(time.Now().Add(up.health.EjectDuration))
+		}
+	}
+}
+
+// instanceFrom returns the instance chosen for the request, falling back to the first one
+func (up *upstream) instanceFrom(r *http.Request) *instance {
+	if in, ok := r.Context().Value(instanceKey{}).(*instance); ok {
+		return in
+	}
+	return up.instances()[0]
+}
+
+// parseTargets parses a comma-separated list of service URLs
+func parseTargets(raw string) ([]*url.URL, error) {
+	var targets []*url.URL
+	for _, s := range strings.Split(raw, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, u)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no URL configured")
+	}
+	return targets, nil
+}
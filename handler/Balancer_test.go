@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthScoreWeighsSignals(t *testing.T) {
+	cfg := HealthScoreConfig{ErrorWeight: 2, LatencyWeight: 1, ConnectionWeight: 1}.withDefaults()
+	in := newInstance(&url.URL{Scheme: "http", Host: "blog-1"})
+	if score := in.score(cfg); score != 1 {
+		t.Fatalf("fresh instance scores %v, want 1", score)
+	}
+
+	in.observe(true, 0)
+	if score, want := in.score(cfg), 1-2*healthAlpha/4; math.Abs(score-want) > 1e-9 {
+		t.Errorf("score after a failure %v, want %v", score, want)
+	}
+	in.observe(false, 0)
+	in.active.Add(int64(cfg.ConnectionTarget))
+	if score, want := in.score(cfg), 1-(2*healthAlpha*(1-healthAlpha)+0.5)/4; math.Abs(score-want) > 1e-9 {
+		t.Errorf("score with ConnectionTarget requests in flight %v, want %v", score, want)
+	}
+}
+
+func TestLowScoringInstancesAreEjected(t *testing.T) {
+	var failed atomic.Int64
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failed.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	healthy := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: failing.URL + "," + healthy.URL,
+		HealthScore:    HealthScoreConfig{ErrorWeight: 1, EjectBelow: 0.7, EjectDuration: time.Minute},
+	})
+	h := g.ProxyHandler(ServiceBlog)
+
+	// The error rate passes 0.3 after two failures, so the failing instance sees at most two requests
+	for i := range 20 {
+		serve(t, h, httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil)
+		if n := failed.Load(); n > 2 {
+			t.Fatalf("failing instance served %d requests after %d, want it ejected after 2", n, i+1)
+		}
+	}
+	if failed.Load()+healthy.calls.Load() != 20 {
+		t.Errorf("%d failed and %d healthy calls, want 20 in total", failed.Load(), healthy.calls.Load())
+	}
+}
+
+func TestFailingInstancesAreEjectedForACoolDown(t *testing.T) {
+	var broken atomic.Bool
+	broken.Store(true)
+	var flaky atomic.Int64
+	flakySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flaky.Add(1)
+		if broken.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer flakySrv.Close()
+	healthy := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: flakySrv.URL + "," + healthy.URL,
+		LoadBalancing:  LoadBalanceRoundRobin,
+		HealthScore:    HealthScoreConfig{EjectAfterFailures: 2, EjectDuration: 200 * time.Millisecond},
+		Retry:          RetryConfig{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+	h := g.ProxyHandler(ServiceBlog)
+	get := func() int {
+		return serve(t, h, httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil).Code
+	}
+
+	// Retries move to the healthy instance, so clients never see the failures
+	for i := range 10 {
+		if code := get(); code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want the retry to succeed", i+1, code)
+		}
+	}
+	if n := flaky.Load(); n != 2 {
+		t.Fatalf("broken instance got %d requests, want it ejected after 2", n)
+	}
+
+	broken.Store(false)
+	time.Sleep(250 * time.Millisecond)
+	for range 4 {
+		get()
+	}
+	if n := flaky.Load(); n != 4 {
+		t.Errorf("re-admitted instance got %d more requests out of 4, want 2", n-2)
+	}
+}
+
+func TestAbortedResponsesAreInstanceFailures(t *testing.T) {
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cutOffResponse(w)
+	}))
+	defer blog.Close()
+	g := newTestGateway(t, &Config{BlogServiceURL: blog.URL})
+	if !serveAborting(g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)) {
+		t.Fatal("response cut off by the service wasn't aborted")
+	}
+	in := g.upstreams()[ServiceBlog].instances()[0]
+	in.mu.Lock()
+	errorRate := in.errorRate
+	in.mu.Unlock()
+	if in.active.Load() != 0 || errorRate == 0 {
+		t.Errorf("after an aborted response: %d active, error rate %v, want none active and a failure", in.active.Load(), errorRate)
+	}
+}
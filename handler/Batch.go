@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+)
+
+// Batch defaults
+const (
+	BatchPath                  = "/api/batch"
+	defaultBatchMaxRequests    = 20
+	defaultBatchMaxConcurrency = 8
+	defaultBatchMaxResponse    = 1 << 20
+)
+
+// BatchConfig lets clients send several API requests in one POST /api/batch, saving
+// round trips on mobile networks
+type BatchConfig struct {
+	Enabled bool
+
+	// MaxRequests bounds the sub-requests of one batch (default 20)
+	MaxRequests int
+
+	// MaxConcurrency bounds the sub-requests of one batch running at once (default 8)
+	MaxConcurrency int
+
+	// MaxResponseBytes bounds the buffered body of each sub-response (default 1 MiB)
+	MaxResponseBytes int64
+}
+
+func (c BatchConfig) withDefaults() BatchConfig {
+	if c.MaxRequests <= 0 {
+		c.MaxRequests = defaultBatchMaxRequests
+	}
+	if c.MaxConcurrency <= 0 {
+		c.MaxConcurrency = defaultBatchMaxConcurrency
+	}
+	if c.MaxResponseBytes <= 0 {
+		c.MaxResponseBytes = defaultBatchMaxResponse
+	}
+	return c
+}
+
+// BatchRequest is one sub-request of a batch
+type BatchRequest struct {
+	Method  string            `json:"method,omitempty"` // default GET
+	Path    string            `json:"path"`             // gateway path with query, e.g. /api/blog/posts?page=2
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"` // sent as application/json
+}
+
+// BatchResponse is the response to one sub-request. JSON bodies are embedded as is,
+// others as a string.
+type BatchResponse struct {
+	Status  int             `json:"status"`
+	Headers http.Header     `json:"headers,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+}
+
+// BatchHandler runs the sub-requests of a batch concurrently through routes, normally
+// the gateway's router, so each one is authenticated, rate limited and proxied like a
+// request of its own with the batch request's headers. Responses come back in request
+// order with their own statuses; the batch itself gets 200. Streams and upgrades can't
+// be batched.
+func (g *Gateway) BatchHandler(routes http.Handler) http.HandlerFunc {
+	config := g.Config.Batch.withDefaults()
+	return func(w http.ResponseWriter, r *http.Request) {
+		var batch []BatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "batch must be a JSON array of requests")
+			return
+		}
+		if len(batch) > config.MaxRequests {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("batch has more than %d requests", config.MaxRequests))
+			return
+		}
+
+		responses := make([]BatchResponse, len(batch))
+		sem := make(chan struct{}, config.MaxConcurrency)
+		var wg sync.WaitGroup
+		for i, sub := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				responses[i] = g.batchRequest(routes, r, sub, config.MaxResponseBytes)
+			}()
+		}
+		wg.Wait()
+		writeJSON(w, http.StatusOK, responses)
+	}
+}
+
+// batchRequest serves one sub-request, derived from the batch request r
+func (g *Gateway) batchRequest(routes http.Handler, r *http.Request, sub BatchRequest, limit int64) BatchResponse {
+	target, err := url.ParseRequestURI(sub.Path)
+	switch {
+	case err != nil || target.IsAbs():
+		return batchError(http.StatusBadRequest, "invalid path "+sub.Path)
+	case path.Clean(target.Path) == BatchPath:
+		return batchError(http.StatusBadRequest, "batches can't be nested")
+	}
+
+	req := subRequest(r, cmp.Or(sub.Method, http.MethodGet), target, sub.Body)
+	for k, v := range sub.Headers {
+		req.Header.Set(k, v)
+	}
+	if g.longLived(req) || req.Header.Get("Upgrade") != "" {
+		return batchError(http.StatusBadRequest, "streams and upgrades can't be batched")
+	}
+
+	bw, err := serveBuffered(routes, req, limit)
+	if err != nil {
+		return batchError(http.StatusBadGateway, err.Error())
+	}
+	resp := BatchResponse{Status: bw.status, Headers: bw.header}
+	if body := bw.body.Bytes(); json.Valid(body) {
+		resp.Body = body
+	} else if len(body) > 0 {
+		resp.Body, _ = json.Marshal(string(body))
+	}
+	return resp
+}
+
+func batchError(status int, message string) BatchResponse {
+	body, _ := json.Marshal(problem(status, message, "", nil))
+	return BatchResponse{Status: status, Headers: http.Header{"Content-Type": {ProblemContentType}}, Body: body}
+}
+
+// subRequest derives a request the gateway makes to itself from the client's request r,
+// keeping its credentials. The response is read in full by the gateway, so it isn't
+// compressed.
+func subRequest(r *http.Request, method string, target *url.URL, body []byte) *http.Request {
+	req := r.Clone(r.Context())
+	req.Method, req.URL, req.RequestURI = method, target, target.RequestURI()
+	req.Header.Del("Content-Type")
+	req.Header.Del("Content-Length")
+	req.Header.Del("Accept-Encoding")
+	req.Body, req.ContentLength = http.NoBody, 0
+	if len(body) > 0 {
+		req.Body, req.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+// serveBuffered runs req through routes and returns the response, buffered up to limit
+// bytes. The proxy aborts a response whose writes fail by panicking with
+// http.ErrAbortHandler, which is recovered here.
+func serveBuffered(routes http.Handler, req *http.Request, limit int64) (bw *batchWriter, err error) {
+	bw = &batchWriter{header: make(http.Header), limit: limit}
+	defer func() {
+		if p := recover(); p != nil {
+			if p != http.ErrAbortHandler {
+				panic(p)
+			}
+			err = cmp.Or(bw.err, errors.New("upstream response aborted"))
+		}
+	}()
+	routes.ServeHTTP(bw, req)
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	return bw, bw.err
+}
+
+// batchWriter buffers a response the gateway made to itself, refusing event streams and
+// bodies over limit
+type batchWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	limit  int64
+	err    error
+}
+
+func (bw *batchWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *batchWriter) WriteHeader(code int) {
+	if bw.status == 0 && code >= 200 {
+		bw.status = code
+		if isEventStream(bw.header) {
+			bw.err = errors.New("event streams can't be buffered")
+		}
+	}
+}
+
+func (bw *batchWriter) Write(b []byte) (int, error) {
+	bw.WriteHeader(http.StatusOK)
+	if bw.err == nil && int64(bw.body.Len()+len(b)) > bw.limit {
+		bw.err = fmt.Errorf("response exceeds %d bytes", bw.limit)
+	}
+	if bw.err != nil {
+		return 0, bw.err
+	}
+	return bw.body.Write(b)
+}
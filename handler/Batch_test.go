@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatchRunsSubRequestsInOrder(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"alice-token": {UserID: "alice", Role: "user"}})
+	users, blog := newTestUpstream(t), newTestUpstream(t)
+	g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, UserServiceURL: users.URL, BlogServiceURL: blog.URL,
+		Batch: BatchConfig{Enabled: true, MaxRequests: 4}})
+	routes := http.NewServeMux()
+	routes.Handle("/api/user/", g.AuthMiddleware(g.ProxyHandler(ServiceUser)))
+	routes.Handle("/api/blog/", g.AuthMiddleware(g.ProxyHandler(ServiceBlog)))
+	routes.Handle(BatchPath, g.BatchHandler(routes))
+
+	batch := func(token, body string) (int, []BatchResponse) {
+		req := httptest.NewRequest(http.MethodPost, BatchPath, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := serve(t, routes, req, nil)
+		var resp []BatchResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	status, resp := batch("alice-token", `[
+		{"path": "/api/user/profile"},
+		{"method": "POST", "path": "/api/blog/posts?draft=true", "body": {"title": "hi"}},
+		{"path": "/api/batch"},
+		{"path": "/api/nowhere"}
+	]`)
+	if status != http.StatusOK || len(resp) != 4 {
+		t.Fatalf("batch got %d with %d responses, want 200 with 4", status, len(resp))
+	}
+	if resp[0].Status != http.StatusOK || !strings.Contains(string(resp[0].Body), `"path":"/api/user/profile"`) ||
+		!strings.Contains(string(resp[0].Body), `"userID":"alice"`) {
+		t.Errorf("profile got %d %s", resp[0].Status, resp[0].Body)
+	}
+	if resp[1].Status != http.StatusOK || !strings.Contains(string(resp[1].Body), `"method":"POST"`) ||
+		!strings.Contains(string(resp[1].Body), `"query":"draft=true"`) {
+		t.Errorf("post got %d %s", resp[1].Status, resp[1].Body)
+	}
+	if resp[2].Status != http.StatusBadRequest || resp[3].Status != http.StatusNotFound {
+		t.Errorf("nested batch and unknown path got %d and %d, want 400 and 404", resp[2].Status, resp[3].Status)
+	}
+
+	if _, resp = batch("bad-token", `[{"path": "/api/user/profile"}]`); len(resp) != 1 || resp[0].Status != http.StatusUnauthorized {
+		t.Errorf("batch with a bad token got %+v, want a 401 sub-response", resp)
+	}
+	if status, _ = batch("alice-token", strings.Repeat(`{"path": "/api/user/profile"},`, 5)); status != http.StatusBadRequest {
+		t.Errorf("malformed batch got %d, want 400", status)
+	}
+	if status, _ = batch("alice-token", "["+strings.Repeat(`{"path": "/api/user/profile"},`, 4)+`{"path": "/api/user/profile"}]`); status != http.StatusBadRequest {
+		t.Errorf("oversized batch got %d, want 400", status)
+	}
+}
+
+func TestBatchBuffersPlainBoundedResponses(t *testing.T) {
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/blog/big" {
+			w.Write([]byte(strings.Repeat("x", 4096)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			defer zw.Close()
+			zw.Write([]byte(`{"posts":[]}`))
+			return
+		}
+		w.Write([]byte(`{"posts":[]}`))
+	}))
+	defer blog.Close()
+	g := newTestGateway(t, &Config{BlogServiceURL: blog.URL, PublicPaths: []string{"/api/blog/"}, StreamRoutes: []string{"/api/blog/events"},
+		Batch: BatchConfig{Enabled: true, MaxResponseBytes: 1024}})
+	routes := http.NewServeMux()
+	routes.Handle("/api/blog/", g.AuthMiddleware(g.ProxyHandler(ServiceBlog)))
+
+	req := httptest.NewRequest(http.MethodPost, BatchPath, strings.NewReader(`[
+		{"path": "/api/blog/posts"},
+		{"path": "/api/blog/big"},
+		{"path": "/api/blog/events"},
+		{"path": "/api/blog/posts", "headers": {"Upgrade": "websocket", "Connection": "Upgrade"}}
+	]`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	var resp []BatchResponse
+	serve(t, g.BatchHandler(routes), req, &resp)
+	if len(resp) != 4 {
+		t.Fatalf("got %d responses, want 4", len(resp))
+	}
+	if resp[0].Status != http.StatusOK || string(resp[0].Body) != `{"posts":[]}` {
+		t.Errorf("posts got %d %s, want the uncompressed JSON", resp[0].Status, resp[0].Body)
+	}
+	if resp[1].Status != http.StatusBadGateway || resp[2].Status != http.StatusBadRequest || resp[3].Status != http.StatusBadRequest {
+		t.Errorf("oversized response, stream and upgrade got %d, %d and %d, want 502, 400 and 400", resp[1].Status, resp[2].Status, resp[3].Status)
+	}
+}
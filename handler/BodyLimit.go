@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// BodyLimitMiddleware caps request bodies at the longest Config.RouteBodyLimits prefix
+// matching the path, or else at Config.MaxBodyBytes. Bodies declared larger are refused
+// with 413 before reaching the service; chunked bodies are cut off once they exceed
+// the limit, and the upstream call fails with 413 as well.
+func (g *Gateway) BodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := g.Config.MaxBodyBytes
+		if _, l, ok := longestPrefixMatch(g.Config.RouteBodyLimits, r.URL.Path); ok {
+			limit = l
+		}
+		if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			g.Logger.WarnContext(r.Context(), "Request body too large", "method", r.Method, "path", r.URL.Path, "content_length", r.ContentLength, "limit", limit)
+			writeBodyTooLarge(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyTooLarge reports whether err comes from reading past the body limit
+func bodyTooLarge(err error) (int64, bool) {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return mbe.Limit, true
+	}
+	return 0, false
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	writeJSONError(w, http.StatusRequestEntityTooLarge, "request body exceeds "+strconv.FormatInt(limit, 10)+" bytes")
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const defaultBodyLogMaxBytes = 4096
+
+// BodyLogConfig enables request/response body logging for failed requests on selected routes
+type BodyLogConfig struct {
+	// Prefixes are the route prefixes whose bodies are captured
+	Prefixes []string
+	// MaxBytes caps how much of each body is kept
+	MaxBytes int
+	// RedactFields are dotted JSON paths (e.g. "password", "card.number") replaced before logging
+	RedactFields []string
+	// Statuses trigger logging; when empty, any status >= 400 does
+	Statuses []int
+}
+
+// limitedBuffer keeps the first max bytes written to it and drops the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// bodyLogWriter tees the response body into a limited buffer
+type bodyLogWriter struct {
+	*statusRecorder
+	buf *limitedBuffer
+}
+
+func (w *bodyLogWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	return w.statusRecorder.Write(b)
+}
+
+// BodyLogMiddleware buffers request and response bodies on configured routes and
+// logs them only when the response status is an error; on success they are discarded
+func (g *Gateway) BodyLogMiddleware(next http.Handler) http.Handler {
+	cfg := g.Config.BodyLog
+	maxBytes := cfg.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultBodyLogMaxBytes
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasAnyPrefix(r.URL.Path, cfg.Prefixes) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reqBuf := &limitedBuffer{max: maxBytes}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, reqBuf), r.Body}
+		}
+		rw := &bodyLogWriter{statusRecorder: newStatusRecorder(w), buf: &limitedBuffer{max: maxBytes}}
+
+		next.ServeHTTP(rw, r)
+
+		if !cfg.triggers(rw.status) {
+			return
+		}
+		g.Logger.InfoContext(r.Context(), "Body log", "method", r.Method, "path", r.URL.Path, "status", rw.status,
+			"request", cfg.render(reqBuf), "response", cfg.render(rw.buf))
+	})
+}
+
+func (c BodyLogConfig) triggers(status int) bool {
+	if len(c.Statuses) == 0 {
+		return status >= 400
+	}
+	return slices.Contains(c.Statuses, status)
+}
+
+// render redacts JSON bodies and marks truncated ones. When redaction is configured,
+// bodies that cannot be parsed as JSON are withheld rather than logged unredacted.
+func (c BodyLogConfig) render(b *limitedBuffer) string {
+	body := b.Bytes()
+	if len(body) == 0 {
+		return "(empty)"
+	}
+	if len(c.RedactFields) > 0 {
+		var doc any
+		if b.truncated || json.Unmarshal(body, &doc) != nil {
+			return "(withheld: body cannot be redacted)"
+		}
+		for _, field := range c.RedactFields {
+			redactPath(doc, strings.Split(field, "."))
+		}
+		body, _ = json.Marshal(doc)
+	}
+	s := string(body)
+	if b.truncated {
+		s += "...(truncated)"
+	}
+	return s
+}
+
+// redactPath replaces the value at path, descending through objects and arrays
+func redactPath(doc any, path []string) {
+	switch v := doc.(type) {
+	case map[string]any:
+		child, ok := v[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			v[path[0]] = "[REDACTED]"
+			return
+		}
+		redactPath(child, path[1:])
+	case []any:
+		for _, item := range v {
+			redactPath(item, path)
+		}
+	}
+}
+
+// hasAnyPrefix reports whether path starts with any of prefixes
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
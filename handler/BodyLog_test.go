@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLogRecordsFailedRequests(t *testing.T) {
+	g := newTestGateway(t, &Config{BodyLog: BodyLogConfig{
+		Prefixes:     []string{"/api/user/"},
+		RedactFields: []string{"password", "card.number"},
+	}})
+	logs := captureLog(g)
+	h := g.BodyLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["username"] == "taken" {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "username taken"})
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"id": "7"})
+	}))
+	post := func(path, body string) {
+		serve(t, h, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)), nil)
+	}
+
+	post("/api/user/register", `{"username":"free","password":"hunter2"}`)
+	post("/api/blog/posts", `{"username":"taken"}`)
+	if logs.Len() != 0 {
+		t.Fatalf("logged a success or an unconfigured route: %s", logs)
+	}
+
+	post("/api/user/register", `{"username":"taken","password":"hunter2","card":{"number":"4111"}}`)
+	var entry struct {
+		Msg      string `json:"msg"`
+		Status   int    `json:"status"`
+		Request  string `json:"request"`
+		Response string `json:"response"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("want one log entry, got %q: %v", logs, err)
+	}
+	if entry.Msg != "Body log" || entry.Status != http.StatusConflict || !strings.Contains(entry.Response, "username taken") {
+		t.Errorf("entry %+v", entry)
+	}
+	if !strings.Contains(entry.Request, `"taken"`) || strings.Contains(entry.Request, "hunter2") || strings.Contains(entry.Request, "4111") {
+		t.Errorf("request body %s, want it with the password and card number redacted", entry.Request)
+	}
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// bufferResponse reads responses of up to limit bytes into memory and closes the upstream
+// body, so the backend connection goes back to the pool before a slow client has read
+// anything. Larger bodies and event streams are left to stream through.
+func bufferResponse(resp *http.Response, limit int) error {
+	if resp.Body == nil || resp.Body == http.NoBody || isEventStream(resp.Header) || resp.StatusCode == http.StatusSwitchingProtocols {
+		return nil
+	}
+	if resp.ContentLength > int64(limit) {
+		return nil
+	}
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(resp.Body, int64(limit)+1))
+	if err != nil {
+		return err
+	}
+	if n > int64(limit) {
+		// Unknown length and too big after all: stream what's left behind what we read
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(&buf, resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(&buf)
+	resp.ContentLength = n
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Length", strconv.FormatInt(n, 10))
+	return nil
+}
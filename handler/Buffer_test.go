@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// trackedBody records whether the upstream body was closed
+type trackedBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestBufferResponse(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		body          string
+		contentLength int64
+		contentType   string
+		buffered      bool
+	}{
+		{"small chunked", "hello", -1, "text/plain", true},
+		{"small with a length", "hello", 5, "text/plain", true},
+		{"exactly the limit", strings.Repeat("x", 16), -1, "text/plain", true},
+		{"large chunked", strings.Repeat("x", 17), -1, "text/plain", false},
+		{"large with a length", strings.Repeat("x", 100), 100, "text/plain", false},
+		{"event stream", "data: 1\n\n", -1, "text/event-stream", false},
+	} {
+		upstream := &trackedBody{Reader: strings.NewReader(tt.body)}
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": {tt.contentType}},
+			Body:          upstream,
+			ContentLength: tt.contentLength,
+		}
+		if err := bufferResponse(resp, 16); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if upstream.closed != tt.buffered {
+			t.Errorf("%s: upstream body closed %v, want %v", tt.name, upstream.closed, tt.buffered)
+		}
+		if tt.buffered && (resp.ContentLength != int64(len(tt.body)) || resp.Header.Get("Content-Length") == "") {
+			t.Errorf("%s: buffered response has length %d, header %q", tt.name, resp.ContentLength, resp.Header.Get("Content-Length"))
+		}
+		if got, _ := io.ReadAll(resp.Body); string(got) != tt.body {
+			t.Errorf("%s: client reads %q, want %q", tt.name, got, tt.body)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+)
+
+const defaultBulkheadQueue = 10
+
+// bulkhead caps concurrent in-flight requests to a service, letting a bounded
+// number of extra requests wait for a free slot
+type bulkhead struct {
+	slots chan struct{}
+	queue chan struct{}
+}
+
+func newBulkhead(limit, queue int) *bulkhead {
+	return &bulkhead{slots: make(chan struct{}, limit), queue: make(chan struct{}, queue)}
+}
+
+// acquire takes a slot, waiting in the queue if there is room; it fails when the
+// queue is full or ctx is done first
+func (b *bulkhead) acquire(ctx context.Context) bool {
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	select {
+	case b.queue <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-b.queue }()
+
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (b *bulkhead) release() {
+	<-b.slots
+}
+
+// bulkheadHandler returns 503 when the service's concurrency limit and queue are both full
+func (g *Gateway) bulkheadHandler(up *upstream, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.bulkhead.acquire(r.Context()) {
+			g.Logger.WarnContext(r.Context(), "Bulkhead full, rejecting request", "service", up.name, "method", r.Method, "path", r.URL.Path)
+			w.Header().Set("Retry-After", retryAfter(0))
+			writeJSONError(w, http.StatusServiceUnavailable, "too many concurrent requests")
+			return
+		}
+		defer up.bulkhead.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bulkheadLimits resolves a service's concurrency limit and queue size, 0 meaning unlimited
+func (g *Gateway) bulkheadLimits(svc ServiceConfig) (limit, queue int) {
+	limit = g.Config.MaxConcurrentPerService
+	if svc.MaxConcurrent > 0 {
+		limit = svc.MaxConcurrent
+	}
+	switch queue = g.Config.BulkheadQueueSize; {
+	case queue == 0:
+		queue = defaultBulkheadQueue
+	case queue < 0:
+		queue = 0
+	}
+	return limit, queue
+}
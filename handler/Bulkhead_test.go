@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestBulkheadRejectsRequestsOverTheLimit(t *testing.T) {
+	const limit = 2
+	arrived, release := make(chan struct{}), make(chan struct{})
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		writeJSON(w, http.StatusOK, map[string]string{"ok": "true"})
+	}))
+	defer blog.Close()
+	g := newTestGateway(t, &Config{
+		BlogServiceURL:    blog.URL,
+		BulkheadQueueSize: -1,
+		Services:          map[string]ServiceConfig{ServiceBlog: {MaxConcurrent: limit}},
+	})
+	h := g.ProxyHandler(ServiceBlog)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, limit)
+	for range limit {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil))
+			codes <- rec.Code
+		}()
+		<-arrived
+	}
+
+	var resp map[string]any
+	rec := serve(t, h, httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), &resp)
+	close(release)
+	wg.Wait()
+	close(codes)
+	if rec.Code != http.StatusServiceUnavailable || resp["error"] == nil || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("request %d: status %d, body %v, Retry-After %q; want a JSON 503", limit+1, rec.Code, resp, rec.Header().Get("Retry-After"))
+	}
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("admitted request got %d", code)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/handlers"
+)
+
+// Defaults for the CORSConfig fields left nil
+var (
+	DefaultCORSOrigins        = []string{"http://localhost:4200"}
+	DefaultCORSMethods        = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	DefaultCORSHeaders        = []string{"Content-Type", "Authorization", "X-Grpc-Web", "X-User-Agent", "Traceparent", "Tracestate", "X-Request-ID"}
+	DefaultCORSExposedHeaders = []string{"Grpc-Status", "Grpc-Message", "X-Token-Expires-In", "X-Token-Refresh-Suggested",
+		"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "X-Request-ID"}
+)
+
+// CORSConfig is the policy CORSMiddleware applies to browser requests
+type CORSConfig struct {
+	// Origins are the allowed origins; nil means DefaultCORSOrigins. "*" allows any
+	// origin, though browsers then refuse credentialed requests, and a "*" label allows
+	// any subdomain, e.g. "https://*.example.com".
+	Origins []string
+	// Methods are the methods allowed in preflight requests; nil means DefaultCORSMethods
+	Methods []string
+	// Headers are the request headers allowed; nil means DefaultCORSHeaders
+	Headers []string
+	// ExposedHeaders are the response headers scripts may read; nil means DefaultCORSExposedHeaders
+	ExposedHeaders []string
+	// MaxAge lets browsers cache preflight responses, at most 10 minutes; 0 sends no max age
+	MaxAge time.Duration
+	// NoCredentials stops allowing cookies and Authorization headers on cross-origin requests
+	NoCredentials bool
+}
+
+// withDefaults fills in the nil fields
+func (c CORSConfig) withDefaults() CORSConfig {
+	return c.inherit(CORSConfig{
+		Origins:        DefaultCORSOrigins,
+		Methods:        DefaultCORSMethods,
+		Headers:        DefaultCORSHeaders,
+		ExposedHeaders: DefaultCORSExposedHeaders,
+	})
+}
+
+// inherit fills in the fields c leaves unset from parent. Credentials can only be
+// turned off, not back on.
+func (c CORSConfig) inherit(parent CORSConfig) CORSConfig {
+	if c.Origins == nil {
+		c.Origins = parent.Origins
+	}
+	if c.Methods == nil {
+		c.Methods = parent.Methods
+	}
+	if c.Headers == nil {
+		c.Headers = parent.Headers
+	}
+	if c.ExposedHeaders == nil {
+		c.ExposedHeaders = parent.ExposedHeaders
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = parent.MaxAge
+	}
+	c.NoCredentials = c.NoCredentials || parent.NoCredentials
+	return c
+}
+
+// routeCORS resolves Config.RouteCORS against the global policy
+func routeCORS(config *Config, global CORSConfig) map[string]CORSConfig {
+	if len(config.RouteCORS) == 0 {
+		return nil
+	}
+	policies := make(map[string]CORSConfig, len(config.RouteCORS))
+	for prefix, c := range config.RouteCORS {
+		policies[prefix] = c.inherit(global)
+	}
+	return policies
+}
+
+// corsHandlers are the CORS handlers built for one routing state
+type corsHandlers struct {
+	state  *routing
+	global http.Handler
+	routes map[string]http.Handler
+}
+
+// CORSMiddleware answers preflight requests and adds CORS headers for the allowed origins.
+// Requests under a prefix of Config.RouteCORS get that route's policy (the longest
+// matching prefix wins), others Config.CORS. The handlers are rebuilt after a Reload.
+func (g *Gateway) CORSMiddleware(next http.Handler) http.Handler {
+	build := func(cfg CORSConfig) http.Handler {
+		opts := []handlers.CORSOption{
+			handlers.AllowedOrigins(cfg.Origins),
+			handlers.AllowedMethods(cfg.Methods),
+			handlers.AllowedHeaders(cfg.Headers),
+			handlers.ExposedHeaders(cfg.ExposedHeaders),
+			handlers.MaxAge(int(cfg.MaxAge / time.Second)),
+		}
+		if !cfg.NoCredentials {
+			opts = append(opts, handlers.AllowCredentials())
+		}
+		if hasOriginPattern(cfg.Origins) {
+			opts = append(opts, handlers.AllowedOriginValidator(func(origin string) bool {
+				return corsOriginAllowed(origin, cfg.Origins)
+			}))
+		}
+		h := handlers.CORS(opts...)(next)
+		if !hasOriginPattern(cfg.Origins) || len(cfg.Origins) > 1 {
+			return h
+		}
+		// The handler only varies on Origin by itself when there's more than one origin
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
+			h.ServeHTTP(w, r)
+		})
+	}
+	buildAll := func(state *routing) *corsHandlers {
+		ch := &corsHandlers{state: state, global: build(state.cors), routes: make(map[string]http.Handler, len(state.routeCORS))}
+		for prefix, cfg := range state.routeCORS {
+			ch.routes[prefix] = build(cfg)
+		}
+		return ch
+	}
+	var current atomic.Pointer[corsHandlers]
+	current.Store(buildAll(g.routing.Load()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ch := current.Load()
+		if state := g.routing.Load(); state != ch.state {
+			ch = buildAll(state)
+			current.Store(ch)
+		}
+		if _, h, ok := longestPrefixMatch(ch.routes, r.URL.Path); ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ch.global.ServeHTTP(w, r)
+	})
+}
+
+// hasOriginPattern reports whether any origin other than "*" contains a wildcard
+func hasOriginPattern(origins []string) bool {
+	for _, o := range origins {
+		if o != "*" && strings.Contains(o, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+// corsOriginAllowed matches origin against exact origins and subdomain patterns. The
+// "*" of a pattern stands for one or more whole labels, so "https://*.example.com"
+// matches "https://api.example.com" but neither "https://example.com" nor
+// "https://evil-example.com".
+func corsOriginAllowed(origin string, allowed []string) bool {
+	origin = strings.ToLower(origin)
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == "*" || a == origin {
+			return true
+		}
+		prefix, suffix, ok := strings.Cut(a, "*")
+		if !ok || !strings.HasPrefix(suffix, ".") || len(origin) <= len(prefix)+len(suffix) ||
+			!strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+			continue
+		}
+		labels := origin[len(prefix) : len(origin)-len(suffix)]
+		if !strings.ContainsAny(labels, "/:@") && !strings.HasPrefix(labels, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+package handler
+
+import (
+	"container/list"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheMaxEntries    = 1000
+	defaultCacheMaxEntryBytes = 1 << 20
+)
+
+// defaultCacheVaryHeaders keep representations apart, and anonymous callers' tokens;
+// authenticated callers are told apart by their identity (see requestKey)
+var defaultCacheVaryHeaders = []string{"Accept", "Accept-Encoding", "Authorization"}
+
+// roleCacheVaryHeaders replace them with ServiceConfig.CacheByRole, which keys on the role
+var roleCacheVaryHeaders = []string{"Accept", "Accept-Encoding"}
+
+type cacheEntry struct {
+	key     string
+	path    string // request path, for purges
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// cacheStore keeps cached responses, in memory or shared through Redis
+type cacheStore interface {
+	get(path, key string) *cacheEntry
+	set(e *cacheEntry)
+	// purge drops the entries for path, or all of them when path is empty, and
+	// returns how many it dropped
+	purge(path string) (int, error)
+}
+
+// responseCache serves GET and HEAD responses from a cacheStore, with a fixed TTL
+// unless the response's Cache-Control sets one
+type responseCache struct {
+	store         cacheStore
+	ttl           time.Duration
+	maxEntryBytes int
+	vary          []string
+	byRole        bool
+}
+
+// newResponseCache keeps the service's responses in memory, or in Redis when
+// Config.CacheRedisURL is set
+func (g *Gateway) newResponseCache(name string, svc ServiceConfig) *responseCache {
+	c := &responseCache{
+		ttl:           svc.CacheTTL,
+		maxEntryBytes: svc.CacheMaxEntryBytes,
+		vary:          cacheVary(svc),
+		byRole:        svc.CacheByRole,
+	}
+	if g.cacheRedis != nil {
+		prefix := g.Config.CacheRedisPrefix
+		if prefix == "" {
+			prefix = defaultCacheRedisPrefix
+		}
+		c.store = &redisCache{client: g.cacheRedis, prefix: prefix + name + ":", logger: g.Logger}
+	} else {
+		c.store = newMemoryCache(svc.CacheMaxEntries)
+	}
+	if c.maxEntryBytes <= 0 {
+		c.maxEntryBytes = defaultCacheMaxEntryBytes
+	}
+	return c
+}
+
+// handler serves cache hits directly and stores cacheable responses from next
+func (c *responseCache) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		reqCC := r.Header.Get("Cache-Control")
+		key := c.key(r)
+
+		if !hasDirective(reqCC, "no-cache") && !hasDirective(reqCC, "no-store") {
+			if e := c.store.get(r.URL.Path, key); e != nil {
+				for k, v := range e.header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("X-Cache", "HIT")
+				w.WriteHeader(e.status)
+				w.Write(e.body)
+				return
+			}
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		// Only what the upstream call adds to the headers is stored, not the request ID,
+		// CORS and other headers set for this client
+		before := w.Header().Clone()
+		rw := &bodyLogWriter{statusRecorder: newStatusRecorder(w), buf: &limitedBuffer{max: c.maxEntryBytes}}
+		next.ServeHTTP(rw, r)
+
+		if rw.status != http.StatusOK || rw.buf.truncated || hasDirective(reqCC, "no-store") || isEventStream(w.Header()) {
+			return
+		}
+		ttl, ok := c.responseTTL(w.Header().Get("Cache-Control"))
+		if !ok {
+			return
+		}
+		header := w.Header().Clone()
+		for k, v := range before {
+			if slices.Equal(header[k], v) {
+				delete(header, k)
+			}
+		}
+		header.Del("Set-Cookie")
+		c.store.set(&cacheEntry{
+			key:     key,
+			path:    r.URL.Path,
+			status:  rw.status,
+			header:  header,
+			body:    append([]byte(nil), rw.buf.Bytes()...),
+			expires: time.Now().Add(ttl),
+		})
+	})
+}
+
+// responseTTL applies shared-cache semantics to a response's Cache-Control:
+// private, no-store, no-cache and a zero max age are never stored, and
+// s-maxage takes precedence over max-age, which takes precedence over the configured TTL
+func (c *responseCache) responseTTL(cacheControl string) (time.Duration, bool) {
+	for _, d := range []string{"private", "no-store", "no-cache"} {
+		if hasDirective(cacheControl, d) {
+			return 0, false
+		}
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := directiveValue(cacheControl, d); ok {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs <= 0 {
+				return 0, false
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	return c.ttl, true
+}
+
+func (c *responseCache) key(r *http.Request) string {
+	return requestKey(r, c.vary, c.byRole)
+}
+
+// cacheVary returns the request headers that tell the service's responses apart
+func cacheVary(svc ServiceConfig) []string {
+	switch {
+	case len(svc.CacheVaryHeaders) > 0:
+		return svc.CacheVaryHeaders
+	case svc.CacheByRole:
+		return roleCacheVaryHeaders
+	default:
+		return defaultCacheVaryHeaders
+	}
+}
+
+// requestKey identifies the requests that get the same response: the method, URI, the
+// vary headers, the API version and the caller, by role with byRole and otherwise by
+// user. The caller is the identity AuthMiddleware validated, whichever credential it
+// came from (a cookie or API key never reaches the Authorization header), not the
+// client's headers; public paths have none and share the anonymous entry.
+func requestKey(r *http.Request, vary []string, byRole bool) string {
+	id := identityFrom(r)
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(r.URL.RequestURI())
+	for _, h := range vary {
+		// The identity stands in for the token, so a refreshed token keeps its entries
+		if id != nil && strings.EqualFold(h, "Authorization") {
+			continue
+		}
+		b.WriteByte('\n')
+		b.WriteString(h)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	// Versions of a service share a Redis prefix
+	if v := requestedVersion(r); v != "" {
+		b.WriteString("\nversion:")
+		b.WriteString(v)
+	}
+	switch {
+	case byRole:
+		b.WriteString("\nrole:")
+		if id != nil {
+			b.WriteString(id.Role)
+		}
+	case id != nil:
+		b.WriteString("\nuser:")
+		b.WriteString(id.UserID)
+		b.WriteString("\ntenant:")
+		b.WriteString(id.TenantID)
+	}
+	return b.String()
+}
+
+// CachePurgeHandler drops cached responses (DELETE /admin/cache), across all replicas when
+// they share Redis. ?service=<name> limits the purge to one service and ?path=<path> to
+// the variants of one gateway path, such as /api/blog/posts with any query.
+func (g *Gateway) CachePurgeHandler(w http.ResponseWriter, r *http.Request) {
+	service, path := r.URL.Query().Get("service"), r.URL.Query().Get("path")
+	upstreams := g.upstreams()
+	if _, ok := upstreams[service]; service != "" && !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown service "+service)
+		return
+	}
+	purged := 0
+	for _, up := range g.allUpstreams() {
+		if up.cache == nil || service != "" && up.name != service {
+			continue
+		}
+		n, err := up.cache.store.purge(path)
+		purged += n
+		if err != nil {
+			g.Logger.ErrorContext(r.Context(), "Failed to purge response cache", "service", up.name, "path", path, "error", err)
+			writeJSONError(w, http.StatusBadGateway, "cache purge failed")
+			return
+		}
+	}
+	g.Logger.InfoContext(r.Context(), "Purged response cache", "entries", purged, "service", service, "path", path)
+	writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+// memoryCache is an LRU cache local to the gateway
+type memoryCache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List
+	maxEntries int
+}
+
+func newMemoryCache(maxEntries int) *memoryCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	return &memoryCache{entries: make(map[string]*list.Element), lru: list.New(), maxEntries: maxEntries}
+}
+
+func (c *memoryCache) get(_, key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return e
+}
+
+func (c *memoryCache) set(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *memoryCache) purge(path string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key, el := range c.entries {
+		if path == "" || el.Value.(*cacheEntry).path == path {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// hasDirective reports whether a Cache-Control header value contains directive
+func hasDirective(cacheControl, directive string) bool {
+	_, ok := directiveValue(cacheControl, directive)
+	return ok
+}
+
+// directiveValue returns the (unquoted) argument of a Cache-Control directive
+func directiveValue(cacheControl, directive string) (string, bool) {
+	for _, d := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if strings.EqualFold(strings.TrimSpace(name), directive) {
+			return strings.Trim(strings.TrimSpace(value), `"`), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheServesGETsUntilTheTTL(t *testing.T) {
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		Services:       map[string]ServiceConfig{ServiceBlog: {CacheTTL: 100 * time.Millisecond}},
+	})
+	h := g.ProxyHandler(ServiceBlog)
+	request := func(method string, header ...string) (string, int64) {
+		req := httptest.NewRequest(method, "/api/blog/posts", nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		var resp testUpstreamResponse
+		rec := serve(t, h, req, &resp)
+		return rec.Header().Get("X-Cache"), resp.Call
+	}
+
+	for _, tt := range []struct {
+		name, method string
+		header       []string
+		cache        string
+		call         int64
+	}{
+		{"first GET", http.MethodGet, nil, "MISS", 1},
+		{"repeat GET", http.MethodGet, nil, "HIT", 1},
+		{"POST", http.MethodPost, nil, "", 2},
+		{"no-cache GET", http.MethodGet, []string{"Cache-Control", "no-cache"}, "MISS", 3},
+		{"GET after no-cache", http.MethodGet, nil, "HIT", 3},
+	} {
+		if cache, call := request(tt.method, tt.header...); cache != tt.cache || call != tt.call {
+			t.Errorf("%s: X-Cache %q from call %d, want %q from call %d", tt.name, cache, call, tt.cache, tt.call)
+		}
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if cache, call := request(http.MethodGet); cache != "MISS" || call != 4 {
+		t.Errorf("GET after the TTL: X-Cache %q from call %d, want a fresh response", cache, call)
+	}
+}
+
+func TestCacheHonorsResponseCacheControl(t *testing.T) {
+	c := &responseCache{ttl: time.Minute}
+	for _, tt := range []struct {
+		cacheControl string
+		ttl          time.Duration
+		stored       bool
+	}{
+		{"", time.Minute, true},
+		{"public", time.Minute, true},
+		{"private", 0, false},
+		{"private, max-age=600", 0, false},
+		{"no-store", 0, false},
+		{"no-cache", 0, false},
+		{"max-age=0", 0, false},
+		{"max-age=30", 30 * time.Second, true},
+		{"max-age=30, s-maxage=5", 5 * time.Second, true},
+		{`s-maxage="0", max-age=30`, 0, false},
+		{"max-age=soon", 0, false},
+	} {
+		if ttl, stored := c.responseTTL(tt.cacheControl); ttl != tt.ttl || stored != tt.stored {
+			t.Errorf("Cache-Control %q: TTL %v stored %v, want %v %v", tt.cacheControl, ttl, stored, tt.ttl, tt.stored)
+		}
+	}
+}
+
+func TestCacheNeverStoresPrivateResponses(t *testing.T) {
+	var calls atomic.Int64
+	user := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "private, max-age=600")
+		writeJSON(w, http.StatusOK, testUpstreamResponse{Call: calls.Add(1)})
+	}))
+	defer user.Close()
+	g := newTestGateway(t, &Config{
+		UserServiceURL: user.URL,
+		Services:       map[string]ServiceConfig{ServiceUser: {CacheTTL: time.Minute}},
+	})
+
+	for want := int64(1); want <= 2; want++ {
+		var resp testUpstreamResponse
+		rec := serve(t, g.ProxyHandler(ServiceUser), httptest.NewRequest(http.MethodGet, "/api/user/profile", nil), &resp)
+		if rec.Header().Get("X-Cache") != "MISS" || resp.Call != want {
+			t.Fatalf("X-Cache %q from call %d, want MISS from call %d", rec.Header().Get("X-Cache"), resp.Call, want)
+		}
+	}
+}
+
+func TestCacheStoresOnlyUpstreamHeaders(t *testing.T) {
+	var calls atomic.Int64
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("X-Upstream", "blog")
+		w.Header().Set("Set-Cookie", "session=first-caller")
+		w.Write([]byte(`{"posts":[]}`))
+	}))
+	t.Cleanup(blog.Close)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		CORS:           CORSConfig{Origins: []string{"https://first.example", "https://second.example"}},
+		Services:       map[string]ServiceConfig{ServiceBlog: {CacheTTL: time.Minute}},
+	})
+	h := g.RequestIDMiddleware(g.CORSMiddleware(g.ProxyHandler(ServiceBlog)))
+	get := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Origin", origin)
+		return serve(t, h, req, nil)
+	}
+
+	first := get("https://first.example")
+	second := get("https://second.example")
+	if second.Header().Get("X-Cache") != "HIT" || calls.Load() != 1 {
+		t.Fatalf("second caller: X-Cache %q after %d upstream calls, want a hit", second.Header().Get("X-Cache"), calls.Load())
+	}
+	if id := second.Header().Get(RequestIDHeader); id == "" || id == first.Header().Get(RequestIDHeader) {
+		t.Errorf("second caller got request ID %q, first had %q", id, first.Header().Get(RequestIDHeader))
+	}
+	if got := second.Header().Values("Access-Control-Allow-Origin"); len(got) != 1 || got[0] != "https://second.example" {
+		t.Errorf("second caller got Access-Control-Allow-Origin %q, want its own origin", got)
+	}
+	if second.Header().Get("X-Upstream") != "blog" || second.Body.String() != `{"posts":[]}` {
+		t.Errorf("second caller got X-Upstream %q, body %q, want the cached response", second.Header().Get("X-Upstream"), second.Body)
+	}
+	if c := second.Header().Get("Set-Cookie"); c != "" {
+		t.Errorf("cache hit replayed the first caller's Set-Cookie %q", c)
+	}
+}
@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const captureMaxBody = 64 << 10
+
+// capturedHeadersExcluded are never stored, so captures don't hold credentials
+var capturedHeadersExcluded = []string{"Authorization", "Cookie", "X-Admin-Token"}
+
+// CapturedRequest is a proxied request kept for debugging and replay
+type CapturedRequest struct {
+	ID        string      `json:"id"`
+	Time      time.Time   `json:"time"`
+	Service   string      `json:"service"`
+	Method    string      `json:"method"`
+	Path      string      `json:"path"`
+	Query     string      `json:"query,omitempty"`
+	Header    http.Header `json:"header"`
+	Body      []byte      `json:"body,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+	Status    int         `json:"status"`
+}
+
+// captureStore is a ring buffer of the most recent captured requests
+type captureStore struct {
+	mu    sync.Mutex
+	items []*CapturedRequest
+	next  int
+}
+
+func newCaptureStore(size int) *captureStore {
+	if size <= 0 {
+		return nil
+	}
+	return &captureStore{items: make([]*CapturedRequest, size)}
+}
+
+func (s *captureStore) add(c *CapturedRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[s.next] = c
+	s.next = (s.next + 1) % len(s.items)
+}
+
+func (s *captureStore) get(id string) *CapturedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.items {
+		if c != nil && c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// list returns captures newest first
+func (s *captureStore) list() []*CapturedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*CapturedRequest, 0, len(s.items))
+	for i := range s.items {
+		if c := s.items[(s.next-1-i+2*len(s.items))%len(s.items)]; c != nil {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// captureHandler records requests to a service when Config.CaptureSize is set
+func (g *Gateway) captureHandler(up *upstream, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := &CapturedRequest{
+			ID:      newID(),
+			Time:    time.Now().UTC(),
+			Service: up.name,
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Query:   r.URL.RawQuery,
+			Header:  r.Header.Clone(),
+		}
+		for _, h := range capturedHeadersExcluded {
+			c.Header.Del(h)
+		}
+
+		body := &limitedBuffer{max: captureMaxBody}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, body), r.Body}
+		}
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		c.Body, c.Truncated, c.Status = body.Bytes(), body.truncated, rec.status
+		g.captures.add(c)
+	})
+}
+
+// CapturesHandler lists the captured requests (GET /admin/captures)
+func (g *Gateway) CapturesHandler(w http.ResponseWriter, r *http.Request) {
+	if g.captures == nil {
+		writeJSONError(w, http.StatusNotFound, "request capture is disabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, g.captures.list())
+}
+
+// replayRequest is the body of POST /admin/replay
+type replayRequest struct {
+	ID string `json:"id"`
+	// Target optionally replays against another backend; it must be in Config.ReplayTargets
+	Target string `json:"target,omitempty"`
+	// Confirm must be set to replay non-idempotent methods
+	Confirm bool `json:"confirm,omitempty"`
+}
+
+type replayResponse struct {
+	ID      string      `json:"id"`
+	Target  string      `json:"target"`
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Body    string      `json:"body"`
+	Elapsed string      `json:"elapsed"`
+}
+
+// ReplayHandler re-sends a captured request to its service, or to a configured canary
+// target, and returns the backend's response (POST /admin/replay). Replays go through the
+// service's client, and carry an X-Gateway-Replay header so backends can tell them apart
+// from real traffic.
+func (g *Gateway) ReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if g.captures == nil {
+		writeJSONError(w, http.StatusNotFound, "request capture is disabled")
+		return
+	}
+	var req replayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid replay request")
+		return
+	}
+	c := g.captures.get(req.ID)
+	if c == nil {
+		writeJSONError(w, http.StatusNotFound, "capture not found")
+		return
+	}
+	if !isIdempotent(c.Method) && !req.Confirm {
+		writeJSONError(w, http.StatusConflict, "replaying "+c.Method+" may cause side effects; set confirm to true")
+		return
+	}
+	if c.Truncated {
+		writeJSONError(w, http.StatusConflict, "captured body was truncated and cannot be replayed")
+		return
+	}
+
+	up, ok := g.upstreams()[c.Service]
+	if !ok {
+		writeJSONError(w, http.StatusConflict, "captured service "+c.Service+" no longer exists")
+		return
+	}
+	base := up.pick()
+	if req.Target != "" {
+		if !slices.Contains(g.Config.ReplayTargets, req.Target) {
+			writeJSONError(w, http.StatusBadRequest, "target is not an allowed replay target")
+			return
+		}
+		targets, err := parseTargets(req.Target)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid target")
+			return
+		}
+		base = newInstance(targets[0])
+	}
+	target, err := g.upstreamURL(up, base, c.Path)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	target.RawQuery = c.Query
+
+	out, err := http.NewRequestWithContext(r.Context(), c.Method, target.String(), bytes.NewReader(c.Body))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	out.Header = c.Header.Clone()
+	out.Header.Set("X-Gateway-Replay", c.ID)
+
+	g.Logger.InfoContext(r.Context(), "Replaying capture", "id", c.ID, "method", c.Method, "target", target)
+	start := time.Now()
+	resp, err := up.client.Do(out)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, "replay failed: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, captureMaxBody))
+
+	writeJSON(w, http.StatusOK, replayResponse{
+		ID:      c.ID,
+		Target:  target.String(),
+		Status:  resp.StatusCode,
+		Header:  resp.Header,
+		Body:    string(body),
+		Elapsed: time.Since(start).String(),
+	})
+}
+
+// isIdempotent reports whether replaying method is free of side effects
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// newID returns a random 128-bit hex identifier
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
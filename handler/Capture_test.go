@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReplayCapturedRequests(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Backend", name)
+			w.Header().Set("X-Replay", r.Header.Get("X-Gateway-Replay"))
+			w.Write([]byte(r.Method + " " + r.URL.RequestURI()))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	blog, canary := backend("blog"), backend("canary")
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		CaptureSize:    10,
+		ReplayTargets:  []string{canary.URL},
+	})
+	serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts?page=2", nil), nil)
+	serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodPost, "/api/blog/posts", strings.NewReader(`{"title":"hi"}`)), nil)
+	var captures []CapturedRequest
+	serve(t, http.HandlerFunc(g.CapturesHandler), httptest.NewRequest(http.MethodGet, "/admin/captures", nil), &captures)
+	if len(captures) != 2 || captures[0].Method != http.MethodPost || captures[1].Query != "page=2" {
+		t.Fatalf("captures %+v, want the POST and then the GET", captures)
+	}
+	post, get := captures[0].ID, captures[1].ID
+
+	for _, tt := range []struct {
+		name, body     string
+		status         int
+		backend, reply string
+	}{
+		{"GET", `{"id":"` + get + `"}`, http.StatusOK, "blog", "GET /api/blog/posts?page=2"},
+		{"GET against the canary", `{"id":"` + get + `","target":"` + canary.URL + `"}`, http.StatusOK, "canary", "GET /api/blog/posts?page=2"},
+		{"unlisted target", `{"id":"` + get + `","target":"http://evil.example"}`, http.StatusBadRequest, "", ""},
+		{"unconfirmed POST", `{"id":"` + post + `"}`, http.StatusConflict, "", ""},
+		{"confirmed POST", `{"id":"` + post + `","confirm":true}`, http.StatusOK, "blog", "POST /api/blog/posts"},
+		{"unknown capture", `{"id":"nope"}`, http.StatusNotFound, "", ""},
+	} {
+		var resp replayResponse
+		rec := serve(t, http.HandlerFunc(g.ReplayHandler), httptest.NewRequest(http.MethodPost, "/admin/replay", strings.NewReader(tt.body)), &resp)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if resp.Status != http.StatusOK || resp.Header.Get("X-Backend") != tt.backend || resp.Header.Get("X-Replay") != resp.ID || resp.Body != tt.reply {
+			t.Errorf("%s: backend answered %d from %q with replay header %q: %q, want %q from %s",
+				tt.name, resp.Status, resp.Header.Get("X-Backend"), resp.Header.Get("X-Replay"), resp.Body, tt.reply, tt.backend)
+		}
+	}
+}
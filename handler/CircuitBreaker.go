@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Circuit breaker defaults
+const (
+	defaultBreakerOpenTimeout    = 30 * time.Second
+	defaultBreakerHalfOpenProbes = 1
+)
+
+// BreakerConfig opens a service's circuit after FailureThreshold consecutive failures
+// (transport errors and 5xx responses), answering 503 without contacting the service for
+// OpenTimeout. After that up to HalfOpenProbes requests are let through: a success closes
+// the circuit, a failure opens it again. A zero FailureThreshold disables the breaker.
+type BreakerConfig struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	HalfOpenProbes   int
+}
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+type circuitBreaker struct {
+	mu       sync.Mutex
+	config   BreakerConfig
+	state    string
+	failures int // consecutive, while closed
+	openedAt time.Time
+	probes   int // in flight, while half-open
+}
+
+func newCircuitBreaker(config BreakerConfig) *circuitBreaker {
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if config.HalfOpenProbes <= 0 {
+		config.HalfOpenProbes = defaultBreakerHalfOpenProbes
+	}
+	return &circuitBreaker{config: config, state: circuitClosed}
+}
+
+// allow reports whether a request may go to the service, and otherwise how long
+// until the circuit is half-open
+func (b *circuitBreaker) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitOpen {
+		if wait := b.config.OpenTimeout - time.Since(b.openedAt); wait > 0 {
+			return false, wait
+		}
+		b.state, b.probes = circuitHalfOpen, 0
+	}
+	if b.state == circuitHalfOpen {
+		if b.probes >= b.config.HalfOpenProbes {
+			return false, 0
+		}
+		b.probes++
+	}
+	return true, 0
+}
+
+// record reports the outcome of an allowed request and returns the state it moved
+// to, or "" when it didn't change
+func (b *circuitBreaker) record(success bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitHalfOpen:
+		if b.probes > 0 {
+			b.probes--
+		}
+		if success {
+			b.state, b.failures = circuitClosed, 0
+		} else {
+			b.state, b.openedAt = circuitOpen, time.Now()
+		}
+		return b.state
+	case circuitClosed:
+		if success {
+			b.failures = 0
+			return ""
+		}
+		if b.failures++; b.failures >= b.config.FailureThreshold {
+			b.state, b.openedAt = circuitOpen, time.Now()
+			return b.state
+		}
+	}
+	return ""
+}
+
+// release gives back an allowed request that ended without an outcome, such as one the
+// client abandoned
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *circuitBreaker) current() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// breakerConfig resolves a service's breaker settings; non-zero service fields override Config.CircuitBreaker
+func (g *Gateway) breakerConfig(svc ServiceConfig) BreakerConfig {
+	c := g.Config.CircuitBreaker
+	if svc.CircuitBreaker.FailureThreshold != 0 {
+		c.FailureThreshold = svc.CircuitBreaker.FailureThreshold
+	}
+	if svc.CircuitBreaker.OpenTimeout != 0 {
+		c.OpenTimeout = svc.CircuitBreaker.OpenTimeout
+	}
+	if svc.CircuitBreaker.HalfOpenProbes != 0 {
+		c.HalfOpenProbes = svc.CircuitBreaker.HalfOpenProbes
+	}
+	return c
+}
+
+// breakerHandler fails fast with 503 while the service's circuit is open. Requests the
+// client abandoned count as neither success nor failure; timeouts and responses the
+// proxy aborted midway (it panics with http.ErrAbortHandler) are failures.
+func (g *Gateway) breakerHandler(up *upstream, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := up.breaker.allow()
+		if !ok {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			writeJSONError(w, http.StatusServiceUnavailable, "service unavailable")
+			return
+		}
+		rec := newStatusRecorder(w)
+		defer func() {
+			p := recover()
+			if errors.Is(r.Context().Err(), context.Canceled) {
+				up.breaker.release()
+			} else {
+				g.recordBreaker(up, p == nil && rec.status < 500)
+			}
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// recordBreaker records an outcome, logging state changes
+func (g *Gateway) recordBreaker(up *upstream, success bool) {
+	if state := up.breaker.record(success); state != "" {
+		g.Logger.Warn("Circuit state changed", "service", up.name, "state", state)
+	}
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	b := newCircuitBreaker(BreakerConfig{FailureThreshold: 3, OpenTimeout: 20 * time.Millisecond, HalfOpenProbes: 2})
+	fail := func(n int) {
+		for range n {
+			if ok, _ := b.allow(); !ok {
+				t.Fatalf("request refused in state %s", b.current())
+			}
+			b.record(false)
+		}
+	}
+
+	// A success resets the count of consecutive failures
+	fail(2)
+	b.allow()
+	b.record(true)
+	fail(2)
+	if b.current() != circuitClosed {
+		t.Fatalf("state %s after non-consecutive failures, want closed", b.current())
+	}
+	fail(1)
+	if b.current() != circuitOpen {
+		t.Fatalf("state %s after 3 consecutive failures, want open", b.current())
+	}
+	if ok, wait := b.allow(); ok || wait <= 0 || wait > 20*time.Millisecond {
+		t.Errorf("open circuit: allowed %t, wait %s", ok, wait)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	for i := range 2 {
+		if ok, _ := b.allow(); !ok {
+			t.Fatalf("half-open probe %d refused", i+1)
+		}
+	}
+	if ok, _ := b.allow(); ok || b.current() != circuitHalfOpen {
+		t.Errorf("third request while half-open: allowed %t in state %s, want only 2 probes", ok, b.current())
+	}
+	// A probe that failed opens the circuit again
+	if state := b.record(false); state != circuitOpen {
+		t.Fatalf("failed probe moved to %q, want open", state)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	b.allow()
+	if state := b.record(true); state != circuitClosed {
+		t.Fatalf("successful probe moved to %q, want closed", state)
+	}
+	if ok, _ := b.allow(); !ok {
+		t.Error("closed circuit refused a request")
+	}
+}
+
+func TestCircuitBreakerReleasesAbandonedProbes(t *testing.T) {
+	b := newCircuitBreaker(BreakerConfig{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond})
+	b.allow()
+	b.record(false)
+	time.Sleep(15 * time.Millisecond)
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("probe refused")
+	}
+	if ok, _ := b.allow(); ok {
+		t.Fatal("second probe allowed, want the default of 1")
+	}
+	b.release()
+	if ok, _ := b.allow(); !ok || b.current() != circuitHalfOpen {
+		t.Errorf("after release: allowed %t in state %s, want another probe", ok, b.current())
+	}
+}
+
+func TestCircuitBreakerCountsAbortedProbesAsFailures(t *testing.T) {
+	var calls atomic.Int64
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			http.Error(w, "down", http.StatusInternalServerError)
+		case 2:
+			cutOffResponse(w)
+		default:
+			writeJSON(w, http.StatusOK, map[string]string{"ok": "yes"})
+		}
+	}))
+	defer blog.Close()
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		CircuitBreaker: BreakerConfig{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond},
+	})
+	h := g.ProxyHandler(ServiceBlog)
+	get := func() *http.Request { return httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil) }
+
+	serve(t, h, get(), nil)
+	time.Sleep(30 * time.Millisecond)
+	if !serveAborting(h, get()) {
+		t.Fatal("probe cut off by the service wasn't aborted")
+	}
+	if rec := serve(t, h, get(), nil); rec.Code != http.StatusServiceUnavailable || calls.Load() != 2 {
+		t.Fatalf("after an aborted probe: status %d, %d calls, want the circuit open again", rec.Code, calls.Load())
+	}
+	time.Sleep(30 * time.Millisecond)
+	if rec := serve(t, h, get(), nil); rec.Code != http.StatusOK {
+		t.Errorf("next probe: status %d, want 200", rec.Code)
+	}
+}
+
+func TestCircuitBreakerOnTheProxy(t *testing.T) {
+	var healthy atomic.Bool
+	var calls atomic.Int64
+	backend := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"ok": "yes"})
+	}
+	blog := httptest.NewServer(http.HandlerFunc(backend))
+	t.Cleanup(blog.Close)
+	users := httptest.NewServer(http.HandlerFunc(backend))
+	t.Cleanup(users.Close)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		UserServiceURL: users.URL,
+		CircuitBreaker: BreakerConfig{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond},
+		Services: map[string]ServiceConfig{ServiceUser: {
+			CircuitBreaker: BreakerConfig{FailureThreshold: 4},
+		}},
+	})
+	get := func(service, path string) *httptest.ResponseRecorder {
+		return serve(t, g.ProxyHandler(service), httptest.NewRequest(http.MethodGet, path, nil), nil)
+	}
+
+	for range 2 {
+		get(ServiceBlog, "/api/blog/posts")
+	}
+	before := calls.Load()
+	rec := get(ServiceBlog, "/api/blog/posts")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" || calls.Load() != before {
+		t.Errorf("open circuit: status %d, Retry-After %q, service called %t", rec.Code, rec.Header().Get("Retry-After"), calls.Load() != before)
+	}
+	// The user service's own threshold keeps its circuit closed for longer
+	for i := range 3 {
+		if rec := get(ServiceUser, "/api/user/profile"); rec.Code != http.StatusInternalServerError {
+			t.Fatalf("user request %d: status %d, want the service's 500", i+1, rec.Code)
+		}
+	}
+	get(ServiceUser, "/api/user/profile")
+	if rec := get(ServiceUser, "/api/user/profile"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("user service after 4 failures: status %d, want 503", rec.Code)
+	}
+
+	// A probe the client abandons doesn't use up the half-open circuit
+	time.Sleep(60 * time.Millisecond)
+	healthy.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil).WithContext(ctx), nil)
+	if rec := get(ServiceBlog, "/api/blog/posts"); rec.Code != http.StatusOK {
+		t.Errorf("probe after an abandoned one: status %d, want 200", rec.Code)
+	}
+	if rec := get(ServiceBlog, "/api/blog/posts"); rec.Code != http.StatusOK {
+		t.Errorf("after a successful probe: status %d, want the circuit closed", rec.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MicroSOA-09/gateway-service/identity"
+)
+
+// stripClaimHeaders drops client-sent values of every header used for claims by any
+// service, so only the gateway can set them
+func (g *Gateway) stripClaimHeaders(r *http.Request) {
+	for _, svc := range g.Config.Services {
+		for _, header := range svc.ClaimHeaders {
+			r.Header.Del(header)
+		}
+	}
+}
+
+// setClaimHeaders forwards the allowlisted claims of the authenticated user as headers
+// (claim name to header name); claims not in the allowlist are never forwarded
+func setClaimHeaders(r *http.Request, claimHeaders map[string]string) {
+	identity := identityFrom(r)
+	if identity == nil {
+		return
+	}
+	for claim, header := range claimHeaders {
+		if v, ok := claimValue(identity.Claims[claim]); ok {
+			r.Header.Set(header, v)
+		}
+	}
+}
+
+// claimValue renders a scalar claim, or a list of scalars joined by commas
+func claimValue(v any) (string, bool) {
+	switch v := v.(type) {
+	case nil, map[string]any:
+		return "", false
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := claimValue(item)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, ","), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// signIdentity adds X-Gateway-Signature over the identity headers when
+// Config.IdentitySecret is set
+func (g *Gateway) signIdentity(r *http.Request, id *AuthValidateResponse) {
+	if g.Config.IdentitySecret == "" {
+		return
+	}
+	r.Header.Set(identity.SignatureHeader, identity.Sign([]byte(g.Config.IdentitySecret), identity.Identity{
+		UserID:   id.UserID,
+		Role:     id.Role,
+		Username: id.Username,
+		TenantID: id.TenantID,
+	}, time.Now()))
+}
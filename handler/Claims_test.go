@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClaimHeadersAreForwardedOnlyWhereAllowed(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"alice-token": {
+		UserID: "1",
+		Role:   "author",
+		Claims: map[string]any{"email": "alice@example.com", "groups": []any{"a", "b"}, "ssn": "123-45-6789"},
+	}})
+	blog, users := newTestUpstream(t), newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		AuthServiceURL: auth.URL,
+		BlogServiceURL: blog.URL,
+		UserServiceURL: users.URL,
+		Services: map[string]ServiceConfig{ServiceBlog: {ClaimHeaders: map[string]string{
+			"email":  "X-User-Email",
+			"groups": "X-User-Groups",
+			"tenant": "X-Tenant",
+		}}},
+	})
+	get := func(service, path string) testUpstreamResponse {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		req.Header.Set("X-User-Email", "mallory@example.com")
+		var got testUpstreamResponse
+		if rec := serve(t, g.AuthMiddleware(g.ProxyHandler(service)), req, &got); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", path, rec.Code)
+		}
+		return got
+	}
+
+	blogGot := get(ServiceBlog, "/api/blog/posts")
+	if email := blogGot.Header.Get("X-User-Email"); email != "alice@example.com" {
+		t.Errorf("blog X-User-Email = %q, want the token's claim", email)
+	}
+	if groups := blogGot.Header.Get("X-User-Groups"); groups != "a,b" {
+		t.Errorf("blog X-User-Groups = %q, want a,b", groups)
+	}
+	if _, ok := blogGot.Header["X-Tenant"]; ok {
+		t.Error("blog got X-Tenant for a claim the token does not carry")
+	}
+	for name, values := range blogGot.Header {
+		for _, v := range values {
+			if v == "123-45-6789" {
+				t.Errorf("blog got the unlisted ssn claim in %s", name)
+			}
+		}
+	}
+
+	userGot := get(ServiceUser, "/api/user/profile")
+	for _, h := range []string{"X-User-Email", "X-User-Groups"} {
+		if v, ok := userGot.Header[h]; ok {
+			t.Errorf("user service got %s %q without an allowlist", h, v)
+		}
+	}
+	if userGot.UserID != "1" {
+		t.Errorf("user service X-User-ID = %q, want 1", userGot.UserID)
+	}
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// peerTrusted reports whether the immediate peer may set forwarding headers.
+// With Config.TrustedProxyCIDRs set the peer must be inside one of them;
+// otherwise a non-zero Config.TrustedProxyHops trusts the peer unconditionally.
+func (g *Gateway) peerTrusted(r *http.Request) bool {
+	if len(g.trustedProxies) > 0 {
+		ip, ok := remoteIP(r)
+		return ok && containsAddr(g.trustedProxies, ip)
+	}
+	return g.Config.TrustedProxyHops > 0
+}
+
+// clientIP returns the real client address, used by IP filtering, rate limiting and logging.
+// Forwarding headers are only consulted when the peer is trusted: with trusted-proxy CIDRs
+// the X-Forwarded-For chain (or, without one, the for= chain of Forwarded) is walked from
+// the right past trusted proxies, otherwise the TrustedProxyHops-th entry from the right
+// is used. Entries further left can be spoofed.
+func (g *Gateway) clientIP(r *http.Request) (netip.Addr, bool) {
+	if !g.peerTrusted(r) {
+		return remoteIP(r)
+	}
+	chain := forwardedFor(r)
+	if len(chain) == 0 {
+		return remoteIP(r)
+	}
+
+	if len(g.trustedProxies) > 0 {
+		for i := len(chain) - 1; i >= 0; i-- {
+			ip, err := netip.ParseAddr(chain[i])
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			if ip = ip.Unmap(); i == 0 || !containsAddr(g.trustedProxies, ip) {
+				return ip, true
+			}
+		}
+	}
+
+	i := max(len(chain)-g.Config.TrustedProxyHops, 0)
+	ip, err := netip.ParseAddr(chain[i])
+	return ip.Unmap(), err == nil
+}
+
+// setForwardedHeaders prepares X-Forwarded-* and X-Real-IP for the upstream request.
+// Inbound values from untrusted peers are dropped so the chain restarts at RemoteAddr;
+// the reverse proxy then appends the peer address to X-Forwarded-For.
+func (g *Gateway) setForwardedHeaders(r *http.Request) {
+	trusted := g.peerTrusted(r)
+	if !trusted {
+		for _, h := range []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Real-IP", "Forwarded"} {
+			r.Header.Del(h)
+		}
+	}
+
+	if ip, ok := g.clientIP(r); ok {
+		r.Header.Set("X-Real-IP", ip.String())
+	} else {
+		r.Header.Del("X-Real-IP")
+	}
+	// The first proxy saw the client's own request, so its Forwarded element has the
+	// original proto and host
+	var first map[string]string
+	if elements := forwardedElements(r); trusted && len(elements) > 0 {
+		first = elements[0]
+	}
+	if !trusted || r.Header.Get("X-Forwarded-Proto") == "" {
+		proto := first["proto"]
+		if proto == "" {
+			proto = "http"
+			if r.TLS != nil {
+				proto = "https"
+			}
+		}
+		r.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if !trusted || r.Header.Get("X-Forwarded-Host") == "" {
+		host := first["host"]
+		if host == "" {
+			host = r.Host
+		}
+		r.Header.Set("X-Forwarded-Host", host)
+	}
+}
+
+// clientAddr is the client IP for log lines, or the peer address when it can't be told
+func (g *Gateway) clientAddr(r *http.Request) string {
+	if ip, ok := g.clientIP(r); ok {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+// forwardedFor flattens all X-Forwarded-For headers into one chain, leftmost first.
+// Without X-Forwarded-For the for= parameters of Forwarded make up the chain.
+func forwardedFor(r *http.Request) []string {
+	var chain []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(v, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				chain = append(chain, ip)
+			}
+		}
+	}
+	if len(chain) > 0 {
+		return chain
+	}
+	for _, e := range forwardedElements(r) {
+		if node, ok := e["for"]; ok {
+			chain = append(chain, forwardedNode(node))
+		}
+	}
+	return chain
+}
+
+// forwardedElements parses RFC 7239 Forwarded headers into one parameter map per
+// element, leftmost first; parameter names are lower-cased and values unquoted
+func forwardedElements(r *http.Request) []map[string]string {
+	var elements []map[string]string
+	for _, v := range r.Header.Values("Forwarded") {
+		for _, element := range splitQuoted(v, ',') {
+			params := make(map[string]string)
+			for _, pair := range splitQuoted(element, ';') {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				val = strings.TrimSpace(val)
+				if unquoted, err := strconv.Unquote(val); err == nil && strings.HasPrefix(val, `"`) {
+					val = unquoted
+				}
+				params[strings.ToLower(strings.TrimSpace(k))] = val
+			}
+			if len(params) > 0 {
+				elements = append(elements, params)
+			}
+		}
+	}
+	return elements
+}
+
+// forwardedNode strips the port and IPv6 brackets from a Forwarded for= node, e.g.
+// "[2001:db8::17]:4711" becomes "2001:db8::17". Obfuscated nodes and "unknown" are
+// returned as is and fail to parse as addresses.
+func forwardedNode(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.IndexByte(node, ']'); end > 0 {
+			return node[1:end]
+		}
+		return node
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
+
+// splitQuoted splits s at sep outside of double-quoted strings
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// remoteIP parses the immediate peer address of the request. Peers on a Unix socket
+// (LISTEN_SOCKET) have no address and count as local, 127.0.0.1.
+func remoteIP(r *http.Request) (netip.Addr, bool) {
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		return netip.AddrFrom4([4]byte{127, 0, 0, 1}), true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	return ip.Unmap(), err == nil
+}
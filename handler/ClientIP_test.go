@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedHeadersTrustOnlyConfiguredProxies(t *testing.T) {
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{BlogServiceURL: blog.URL, TrustedProxyCIDRs: []string{"10.0.0.0/8"}})
+
+	for _, tt := range []struct {
+		name, remote                  string
+		wantXFF, wantRealIP, wantHost string
+		wantProto                     string
+	}{
+		{"trusted ingress", "10.0.0.1:4000", "203.0.113.7, 10.0.0.1", "203.0.113.7", "shop.example", "https"},
+		{"spoofing client", "198.51.100.2:4000", "198.51.100.2", "198.51.100.2", "gateway.internal", "http"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway.internal/api/blog/posts", nil)
+		req.RemoteAddr = tt.remote
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "shop.example")
+		req.Header.Set("X-Real-IP", "203.0.113.7")
+		var resp testUpstreamResponse
+		serve(t, g.ProxyHandler(ServiceBlog), req, &resp)
+
+		h := resp.Header
+		if h.Get("X-Forwarded-For") != tt.wantXFF || h.Get("X-Real-IP") != tt.wantRealIP ||
+			h.Get("X-Forwarded-Host") != tt.wantHost || h.Get("X-Forwarded-Proto") != tt.wantProto {
+			t.Errorf("%s: upstream saw X-Forwarded-For %q, X-Real-IP %q, X-Forwarded-Host %q, X-Forwarded-Proto %q; want %q, %q, %q, %q",
+				tt.name, h.Get("X-Forwarded-For"), h.Get("X-Real-IP"), h.Get("X-Forwarded-Host"), h.Get("X-Forwarded-Proto"),
+				tt.wantXFF, tt.wantRealIP, tt.wantHost, tt.wantProto)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+)
+
+const defaultCoalesceMaxBytes = 1 << 20
+
+// coalescedCall is an upstream call that identical requests wait on
+type coalescedCall struct {
+	done   chan struct{}
+	shared bool // false when the response can't be handed out, e.g. it was too large
+	status int
+	header http.Header
+	body   []byte
+}
+
+// coalescer lets concurrent identical GET requests share one upstream call. Requests
+// are identical when their cache key is (see requestKey), so by default different users
+// never share a response, however they authenticated.
+type coalescer struct {
+	mu       sync.Mutex
+	calls    map[string]*coalescedCall
+	vary     []string
+	byRole   bool
+	maxBytes int
+}
+
+func newCoalescer(svc ServiceConfig) *coalescer {
+	c := &coalescer{
+		calls:    make(map[string]*coalescedCall),
+		vary:     cacheVary(svc),
+		byRole:   svc.CacheByRole,
+		maxBytes: svc.CoalesceMaxBytes,
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = defaultCoalesceMaxBytes
+	}
+	return c
+}
+
+// coalesceHandler forwards the first of a set of identical GET requests and answers the
+// others with its response once it completes. Waiters forward their own request when the
+// response can't be shared: it streamed, exceeded ServiceConfig.CoalesceMaxBytes, or the
+// first client went away before it finished.
+func (g *Gateway) coalesceHandler(up *upstream, c *coalescer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || g.longLived(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := requestKey(r, c.vary, c.byRole)
+
+		c.mu.Lock()
+		call, waiting := c.calls[key]
+		if !waiting {
+			call = &coalescedCall{done: make(chan struct{})}
+			c.calls[key] = call
+		}
+		c.mu.Unlock()
+
+		if waiting {
+			select {
+			case <-call.done:
+			case <-r.Context().Done():
+				return
+			}
+			if !call.shared {
+				next.ServeHTTP(w, r)
+				return
+			}
+			g.Logger.DebugContext(r.Context(), "Request coalesced", "service", up.name, "path", r.URL.Path)
+			for k, v := range call.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(call.status)
+			w.Write(call.body)
+			return
+		}
+
+		// Only what the upstream call adds to the headers is shared, not the request ID
+		// and other headers set for this client
+		before := w.Header().Clone()
+		rw := &bodyLogWriter{statusRecorder: newStatusRecorder(w), buf: &limitedBuffer{max: c.maxBytes}}
+		defer func() {
+			c.mu.Lock()
+			delete(c.calls, key)
+			c.mu.Unlock()
+			close(call.done)
+		}()
+		next.ServeHTTP(rw, r)
+
+		if rw.buf.truncated || r.Context().Err() != nil || isEventStream(w.Header()) {
+			return
+		}
+		header := w.Header().Clone()
+		for k, v := range before {
+			if slices.Equal(header[k], v) {
+				delete(header, k)
+			}
+		}
+		call.status, call.header, call.body, call.shared = rw.status, header, rw.buf.Bytes(), true
+	})
+}
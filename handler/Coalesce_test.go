@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCoalesceKeepsCookieUsersApart(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{
+		"alice-token": {UserID: "alice", Role: "user"},
+		"bob-token":   {UserID: "bob", Role: "user"},
+	})
+	arrived, release := make(chan string, 2), make(chan struct{})
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- r.Header.Get("X-User-ID")
+		<-release
+		writeJSON(w, http.StatusOK, testUpstreamResponse{UserID: r.Header.Get("X-User-ID")})
+	}))
+	defer users.Close()
+	defer close(release)
+	g := newTestGateway(t, &Config{
+		AuthServiceURL: auth.URL,
+		UserServiceURL: users.URL,
+		CookieAuth:     CookieAuthConfig{Enabled: true},
+		Services:       map[string]ServiceConfig{ServiceUser: {Coalesce: true}},
+	})
+	h := g.AuthMiddleware(g.ProxyHandler(ServiceUser))
+
+	type result struct{ user, body string }
+	results := make(chan result, 2)
+	get := func(user string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/profile", nil)
+		req.AddCookie(&http.Cookie{Name: "access_token", Value: user + "-token"})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		results <- result{user, rec.Body.String()}
+	}
+	go get("alice")
+	<-arrived
+	go get("bob")
+	select {
+	case <-arrived:
+	case <-time.After(2 * time.Second):
+		t.Fatal("bob's request was coalesced into alice's")
+	}
+	release <- struct{}{}
+	release <- struct{}{}
+	for range 2 {
+		res := <-results
+		var resp testUpstreamResponse
+		if err := json.Unmarshal([]byte(res.body), &resp); err != nil || resp.UserID != res.user {
+			t.Fatalf("%s got %q", res.user, res.body)
+		}
+	}
+}
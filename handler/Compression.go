@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const defaultCompressionMinSize = 1024
+
+// DefaultCompressionTypes are compressed when CompressionConfig.ContentTypes is empty
+var DefaultCompressionTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/problem+json",
+	"image/svg+xml",
+}
+
+// CompressionConfig compresses responses the upstream sent uncompressed, with brotli,
+// gzip or deflate
+type CompressionConfig struct {
+	Enabled bool
+	// MinSize is the smallest body worth compressing (default 1KB)
+	MinSize int
+	// ContentTypes are the media types compressed; "type/*" matches a whole type
+	ContentTypes []string
+	// Level is the gzip/flate compression level (default flate.DefaultCompression);
+	// brotli always uses brotli.DefaultCompression
+	Level int
+}
+
+// CompressionMiddleware compresses responses with the best encoding the client accepts.
+// Responses that already have a Content-Encoding, are under MinSize, aren't of an
+// allowed type or have no body pass through unchanged. Server-Sent Events and upgraded
+// connections are never compressed.
+func (g *Gateway) CompressionMiddleware(next http.Handler) http.Handler {
+	cfg := g.Config.Compression
+	if !cfg.Enabled {
+		return next
+	}
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = defaultCompressionMinSize
+	}
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = DefaultCompressionTypes
+	}
+	if cfg.Level == 0 {
+		cfg.Level = flate.DefaultCompression
+	}
+	if cfg.Level < flate.HuffmanOnly || cfg.Level > flate.BestCompression {
+		g.Logger.Warn("Invalid compression level, using the default", "level", cfg.Level)
+		cfg.Level = flate.DefaultCompression
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, cfg: &cfg, encoding: acceptedEncoding(r.Header.Get("Accept-Encoding"))}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the start of the body until it knows whether compressing it
+// is worthwhile, then either compresses or passes everything through
+type compressWriter struct {
+	http.ResponseWriter
+	cfg      *CompressionConfig
+	encoding string // negotiated encoding, "" if the client accepts none
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser // nil when passing through
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.status != 0 || cw.decided {
+		return
+	}
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code) // informational, e.g. 103 Early Hints
+		return
+	}
+	cw.status = code
+	if !cw.eligible() {
+		cw.passThrough()
+		return
+	}
+	// A known length settles it without buffering
+	if n, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil && n < cw.cfg.MinSize {
+		cw.passThrough()
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.cfg.MinSize {
+		if err := cw.compress(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush sends what's buffered so far. A body flushed before reaching MinSize is still
+// compressed, as streamed bodies tend to grow.
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.compress()
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// eligible reports whether the response may be compressed at all; the Vary header is
+// added for every response whose representation depends on Accept-Encoding
+func (cw *compressWriter) eligible() bool {
+	h := cw.Header()
+	switch {
+	case cw.status == http.StatusNoContent, cw.status == http.StatusNotModified,
+		cw.status == http.StatusPartialContent, cw.status == http.StatusSwitchingProtocols:
+		return false
+	case h.Get("Content-Encoding") != "", h.Get("Content-Range") != "", isEventStream(h):
+		return false
+	case hasDirective(h.Get("Cache-Control"), "no-transform"):
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || !matchesMediaType(mediaType, cw.cfg.ContentTypes) {
+		return false
+	}
+	h.Add("Vary", "Accept-Encoding")
+	return cw.encoding != ""
+}
+
+// compress switches to the negotiated encoding and writes out the buffered body
+func (cw *compressWriter) compress() error {
+	h := cw.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", cw.encoding)
+	// The compressed body is a different representation than a strong ETag names
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	switch cw.encoding {
+	case "br":
+		cw.enc = brotli.NewWriterLevel(cw.ResponseWriter, brotli.DefaultCompression)
+	case "gzip":
+		cw.enc, _ = gzip.NewWriterLevel(cw.ResponseWriter, cw.cfg.Level)
+	default:
+		cw.enc, _ = zlib.NewWriterLevel(cw.ResponseWriter, cw.cfg.Level) // HTTP's deflate is zlib
+	}
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.enc.Write(buf)
+	return err
+}
+
+// passThrough sends the response as is, including anything buffered
+func (cw *compressWriter) passThrough() {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) > 0 {
+		cw.ResponseWriter.Write(cw.buf)
+		cw.buf = nil
+	}
+}
+
+// close ends the response: bodies that stayed under MinSize go out uncompressed
+func (cw *compressWriter) close() {
+	switch {
+	case cw.status == 0:
+		// Nothing was written; the server sends its default response
+	case !cw.decided:
+		cw.passThrough()
+	case cw.enc != nil:
+		cw.enc.Close()
+	}
+}
+
+// acceptedEncoding picks br, gzip or deflate from an Accept-Encoding header, preferring
+// them in that order on equal quality and honoring q=0 exclusions, including through "*"
+func acceptedEncoding(header string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[name] = weight
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range []string{"br", "gzip", "deflate"} {
+		weight, ok := q[enc]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = enc, weight
+		}
+	}
+	return best
+}
+
+// matchesMediaType reports whether mediaType is in types, where "type/*" matches any subtype
+func matchesMediaType(mediaType string, types []string) bool {
+	for _, t := range types {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                            "",
+		"identity":                    "",
+		"gzip, deflate, br":           "br",
+		"gzip, deflate":               "gzip",
+		"deflate":                     "deflate",
+		"br;q=0.5, gzip;q=0.8":        "gzip",
+		"GZIP;q=0.1, Deflate;q=0.2":   "deflate",
+		"br;q=0, gzip;q=0, deflate":   "deflate",
+		"*":                           "br",
+		"br;q=0, *":                   "gzip",
+		"*;q=0":                       "",
+		"gzip;q=0, *;q=0.5":           "br",
+		"gzip;q=1.0, br;q=0.9, *;q=0": "gzip",
+	} {
+		if got := acceptedEncoding(header); got != want {
+			t.Errorf("acceptedEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	body := strings.Repeat(`{"id":7,"title":"Hello world"},`, 100)
+	g := newTestGateway(t, &Config{Compression: CompressionConfig{Enabled: true, MinSize: 512}})
+	serveWith := func(acceptEncoding string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		g.CompressionMiddleware(h).ServeHTTP(rec, req)
+		return rec
+	}
+	// chunked writes the body in small pieces, as a proxied response arrives
+	chunked := func(body string, header map[string]string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			for k, v := range header {
+				w.Header().Set(k, v)
+			}
+			for chunk := range slices.Chunk([]byte(body), 100) {
+				w.Write(chunk)
+			}
+		}
+	}
+
+	for encoding, decode := range map[string]func([]byte) ([]byte, error){
+		"br": func(b []byte) ([]byte, error) {
+			return io.ReadAll(brotli.NewReader(bytes.NewReader(b)))
+		},
+		"gzip": func(b []byte) ([]byte, error) {
+			zr, err := gzip.NewReader(bytes.NewReader(b))
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(zr)
+		},
+	} {
+		rec := serveWith(encoding, chunked(body, map[string]string{"ETag": `"v7"`}))
+		got, err := decode(rec.Body.Bytes())
+		if rec.Header().Get("Content-Encoding") != encoding || err != nil || string(got) != body {
+			t.Errorf("%s: Content-Encoding %q, decoded %d bytes (%v)", encoding, rec.Header().Get("Content-Encoding"), len(got), err)
+		}
+		if rec.Body.Len() >= len(body) || rec.Header().Get("Content-Length") != "" || rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: %d bytes, Content-Length %q, Vary %q", encoding, rec.Body.Len(), rec.Header().Get("Content-Length"), rec.Header().Get("Vary"))
+		}
+		// The compressed body isn't byte-for-byte what the strong ETag named
+		if etag := rec.Header().Get("ETag"); etag != `W/"v7"` {
+			t.Errorf("%s: ETag %q, want it weakened", encoding, etag)
+		}
+	}
+
+	// Bodies under MinSize are buffered, then sent as they are
+	small := body[:400]
+	rec := serveWith("br, gzip", chunked(small, nil))
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != small || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("small body: Content-Encoding %q, body %d bytes, Vary %q", rec.Header().Get("Content-Encoding"), rec.Body.Len(), rec.Header().Get("Vary"))
+	}
+	// A small Content-Length settles it without buffering
+	rec = serveWith("gzip", chunked(small, map[string]string{"Content-Length": strconv.Itoa(len(small))}))
+	if rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("Content-Length") != strconv.Itoa(len(small)) || rec.Body.String() != small {
+		t.Errorf("small Content-Length: Content-Encoding %q, Content-Length %q", rec.Header().Get("Content-Encoding"), rec.Header().Get("Content-Length"))
+	}
+
+	for name, tt := range map[string]struct {
+		acceptEncoding string
+		header         map[string]string
+	}{
+		"already encoded":       {"gzip", map[string]string{"Content-Encoding": "br"}},
+		"client accepts none":   {"identity", nil},
+		"all encodings refused": {"gzip;q=0, *;q=0", nil},
+		"type not in the list":  {"gzip", map[string]string{"Content-Type": "image/png"}},
+		"no-transform":          {"gzip", map[string]string{"Cache-Control": "public, no-transform"}},
+		"server-sent events":    {"gzip", map[string]string{"Content-Type": "text/event-stream"}},
+	} {
+		rec := serveWith(tt.acceptEncoding, chunked(body, tt.header))
+		if rec.Body.String() != body || rec.Header().Get("Content-Encoding") != tt.header["Content-Encoding"] {
+			t.Errorf("%s: Content-Encoding %q, body %d bytes, want it passed through", name, rec.Header().Get("Content-Encoding"), rec.Body.Len())
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// consulWait is how long a blocking query waits for a change
+const consulWait = 5 * time.Minute
+
+// ConsulConfig resolves service instances from the Consul catalog; services opt in with
+// ServiceConfig.ConsulService (see DiscoverInstances)
+type ConsulConfig struct {
+	// Address is the Consul HTTP API, e.g. http://consul:8500; empty disables discovery
+	Address string
+	// Token is sent as X-Consul-Token when set
+	Token string
+	// Datacenter queries another datacenter than the agent's own
+	Datacenter string
+}
+
+// consulEntry is the part of a /v1/health/service result the gateway uses
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+// watchConsul follows the passing instances of service with blocking queries, so changes
+// apply as soon as Consul sees them
+func (g *Gateway) watchConsul(ctx context.Context, up *upstream, service string) {
+	// Discovered instances take the scheme and base path of the configured URL
+	base := *up.instances()[0].url
+	var index uint64
+	retry := discoveryRetryMin
+	for {
+		entries, next, err := g.consulHealth(ctx, service, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			g.Logger.Warn("Consul query failed, keeping current instances", "service", up.name, "consul_service", service, "retry_in", retry, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+			retry = min(retry*2, discoveryRetryMax)
+			continue
+		}
+		retry = discoveryRetryMin
+
+		// The index only moves forward; start over if Consul reset it
+		changed := next != index
+		if next < index {
+			next = 0
+		}
+		index = next
+		if !changed {
+			continue
+		}
+
+		g.updateInstances(up, "Consul", consulTargets(&base, entries))
+	}
+}
+
+// consulHealth runs a blocking query for the passing instances of service, returning
+// once the result differs from index or consulWait elapses
+func (g *Gateway) consulHealth(ctx context.Context, service string, index uint64) ([]consulEntry, uint64, error) {
+	cfg := g.Config.Consul
+	q := url.Values{"passing": {"true"}, "wait": {consulWait.String()}}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+	}
+	if cfg.Datacenter != "" {
+		q.Set("dc", cfg.Datacenter)
+	}
+	target := fmt.Sprintf("%s/v1/health/service/%s?%s", cfg.Address, url.PathEscape(service), q.Encode())
+
+	// Consul adds up to wait/16 of jitter to the wait time
+	ctx, cancel := context.WithTimeout(ctx, consulWait+consulWait/16+10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create Consul request: %w", err)
+	}
+	if cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", cfg.Token)
+	}
+	// g.Client's timeout is far shorter than a blocking query
+	client := &http.Client{Transport: g.Client.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("Consul returned status: %d", resp.StatusCode)
+	}
+	next, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid X-Consul-Index: %w", err)
+	}
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode Consul response: %w", err)
+	}
+	return entries, next, nil
+}
+
+// consulTargets turns Consul entries into instance URLs based on base. Services
+// registered without an address are reached at their node's address.
+func consulTargets(base *url.URL, entries []consulEntry) []*url.URL {
+	targets := make([]*url.URL, 0, len(entries))
+	for _, e := range entries {
+		addr := e.Service.Address
+		if addr == "" {
+			addr = e.Node.Address
+		}
+		if addr == "" || e.Service.Port == 0 {
+			continue
+		}
+		u := *base
+		u.Host = net.JoinHostPort(addr, strconv.Itoa(e.Service.Port))
+		targets = append(targets, &u)
+	}
+	return targets
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForInstances waits for up's instances to become want, in any order
+func waitForInstances(t *testing.T, up *upstream, want ...string) {
+	t.Helper()
+	slices.Sort(want)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		have := instanceURLs(up.instances())
+		slices.Sort(have)
+		if slices.Equal(have, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("instances %v, want %v", have, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type consulReply struct {
+	index   uint64
+	entries string
+}
+
+func TestConsulDiscovery(t *testing.T) {
+	queries, replies := make(chan url.Values), make(chan consulReply)
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/blog-api" || r.Header.Get("X-Consul-Token") != "consul-token" {
+			http.Error(w, "unexpected request", http.StatusForbidden)
+			return
+		}
+		// Blocking queries wait here until the test answers them
+		select {
+		case queries <- r.URL.Query():
+		case <-r.Context().Done():
+			return
+		}
+		select {
+		case reply := <-replies:
+			w.Header().Set("X-Consul-Index", strconv.FormatUint(reply.index, 10))
+			w.Write([]byte(reply.entries))
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(consul.Close)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: "http://blog.internal:8080/v2",
+		Consul:         ConsulConfig{Address: consul.URL, Token: "consul-token", Datacenter: "eu-west"},
+		Services:       map[string]ServiceConfig{ServiceBlog: {ConsulService: "blog-api"}},
+	})
+	var logs lockedBuffer
+	g.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	g.DiscoverInstances(ctx)
+	up := g.upstreams()[ServiceBlog]
+
+	query := func(index string) {
+		t.Helper()
+		select {
+		case q := <-queries:
+			if q.Get("index") != index || q.Get("passing") != "true" || q.Get("dc") != "eu-west" || q.Get("wait") == "" {
+				t.Errorf("query %v, want index %q of the passing instances in eu-west", q, index)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Consul never queried")
+		}
+	}
+
+	query("")
+	if instances := instanceURLs(up.instances()); len(instances) != 1 || instances[0] != "http://blog.internal:8080/v2" {
+		t.Errorf("instances %v before Consul answered, want the configured URL", instances)
+	}
+	// Entries without a service address use the node's; those without a port are skipped
+	replies <- consulReply{10, `[
+		{"Node": {"Address": "10.0.0.9"}, "Service": {"Address": "10.0.0.1", "Port": 9000}},
+		{"Node": {"Address": "10.0.0.2"}, "Service": {"Address": "", "Port": 9001}},
+		{"Node": {"Address": "10.0.0.3"}, "Service": {"Address": "10.0.0.3", "Port": 0}}
+	]`}
+	query("10")
+	waitForInstances(t, up, "http://10.0.0.1:9000/v2", "http://10.0.0.2:9001/v2")
+
+	// No passing instance leaves the last ones in place
+	replies <- consulReply{12, `[]`}
+	query("12")
+	waitForInstances(t, up, "http://10.0.0.1:9000/v2", "http://10.0.0.2:9001/v2")
+	if !strings.Contains(logs.String(), "No ready instances in Consul") {
+		t.Errorf("empty result not logged: %s", logs.String())
+	}
+
+	// An index that went backwards means Consul reset it, so the next query starts over
+	replies <- consulReply{3, `[{"Node": {"Address": "10.0.0.4"}, "Service": {"Port": 9000}}]`}
+	query("")
+	waitForInstances(t, up, "http://10.0.0.4:9000/v2")
+}
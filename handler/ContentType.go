@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Content-type enforcement defaults
+var (
+	defaultEnforcedMethods      = []string{"POST", "PUT", "PATCH"}
+	defaultEnforcedContentTypes = []string{"application/json"}
+)
+
+// ContentTypeConfig rejects write requests whose body isn't an allowed media type
+type ContentTypeConfig struct {
+	// Prefixes are the routes checked; empty disables enforcement
+	Prefixes []string
+	// Methods default to POST, PUT and PATCH
+	Methods []string
+	// Types are the allowed media types, parameters such as charset ignored (default application/json)
+	Types []string
+}
+
+// ContentTypeMiddleware answers 415 for configured write requests carrying a body of
+// another media type. Requests without a body pass without a Content-Type.
+func (g *Gateway) ContentTypeMiddleware(next http.Handler) http.Handler {
+	cfg := g.Config.ContentType
+	if len(cfg.Prefixes) == 0 {
+		return next
+	}
+	methods := cfg.Methods
+	if len(methods) == 0 {
+		methods = defaultEnforcedMethods
+	}
+	types := cfg.Types
+	if len(types) == 0 {
+		types = defaultEnforcedContentTypes
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength == 0 || !slices.Contains(methods, r.Method) || !hasAnyPrefix(r.URL.Path, cfg.Prefixes) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || !slices.ContainsFunc(types, func(t string) bool { return strings.EqualFold(t, mediaType) }) {
+			writeJSONError(w, http.StatusUnsupportedMediaType, "Content-Type must be one of "+strings.Join(types, ", "))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
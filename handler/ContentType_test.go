@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContentTypeEnforcement(t *testing.T) {
+	blog := newTestUpstream(t)
+	for _, tt := range []struct {
+		name        string
+		config      ContentTypeConfig
+		method      string
+		path        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"json", ContentTypeConfig{Prefixes: []string{"/api/blog"}}, http.MethodPost, "/api/blog/posts", "application/json", `{}`, http.StatusOK},
+		{"json with charset", ContentTypeConfig{Prefixes: []string{"/api/blog"}}, http.MethodPut, "/api/blog/posts/1", "Application/JSON; charset=utf-8", `{}`, http.StatusOK},
+		{"wrong type", ContentTypeConfig{Prefixes: []string{"/api/blog"}}, http.MethodPatch, "/api/blog/posts/1", "text/plain", "hi", http.StatusUnsupportedMediaType},
+		{"missing type", ContentTypeConfig{Prefixes: []string{"/api/blog"}}, http.MethodPost, "/api/blog/posts", "", `{}`, http.StatusUnsupportedMediaType},
+		{"malformed type", ContentTypeConfig{Prefixes: []string{"/api/blog"}}, http.MethodPost, "/api/blog/posts", "application/", `{}`, http.StatusUnsupportedMediaType},
+		{"empty body", ContentTypeConfig{Prefixes: []string{"/api/blog"}}, http.MethodPost, "/api/blog/posts/1/like", "", "", http.StatusOK},
+		{"unenforced method", ContentTypeConfig{Prefixes: []string{"/api/blog"}}, http.MethodDelete, "/api/blog/posts/1", "text/plain", "hi", http.StatusOK},
+		{"unenforced prefix", ContentTypeConfig{Prefixes: []string{"/api/user"}}, http.MethodPost, "/api/blog/posts", "text/plain", "hi", http.StatusOK},
+		{"disabled", ContentTypeConfig{}, http.MethodPost, "/api/blog/posts", "text/plain", "hi", http.StatusOK},
+		{"configured methods", ContentTypeConfig{Prefixes: []string{"/api/blog"}, Methods: []string{"DELETE"}}, http.MethodDelete, "/api/blog/posts/1", "text/plain", "hi", http.StatusUnsupportedMediaType},
+		{"configured types", ContentTypeConfig{Prefixes: []string{"/api/blog"}, Types: []string{"application/merge-patch+json"}}, http.MethodPatch, "/api/blog/posts/1", "application/merge-patch+json", `{}`, http.StatusOK},
+		{"configured types exclude json", ContentTypeConfig{Prefixes: []string{"/api/blog"}, Types: []string{"application/merge-patch+json"}}, http.MethodPatch, "/api/blog/posts/1", "application/json", `{}`, http.StatusUnsupportedMediaType},
+	} {
+		g := newTestGateway(t, &Config{BlogServiceURL: blog.URL, ContentType: tt.config})
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		calls := blog.calls.Load()
+		rec := serve(t, g.ContentTypeMiddleware(g.ProxyHandler(ServiceBlog)), req, nil)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusUnsupportedMediaType {
+			if ct := rec.Header().Get("Content-Type"); ct != ProblemContentType {
+				t.Errorf("%s: 415 answered with Content-Type %q, want problem details", tt.name, ct)
+			}
+			if blog.calls.Load() != calls {
+				t.Errorf("%s: rejected request reached the blog service", tt.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Cookie auth defaults
+var (
+	defaultLoginPaths   = []string{"/api/auth/login"}
+	defaultRefreshPaths = []string{"/api/auth/refresh"}
+)
+
+const (
+	defaultAccessTokenField   = "accessToken"
+	defaultRefreshTokenField  = "refreshToken"
+	defaultAccessTokenCookie  = "access_token"
+	defaultRefreshTokenCookie = "refresh_token"
+
+	// cookieAuthMaxBody bounds the login/refresh response read to find the tokens
+	cookieAuthMaxBody = 64 << 10
+)
+
+// CookieAuthConfig moves tokens into HttpOnly cookies: login and refresh responses from
+// the auth service set them, refresh requests send the refresh cookie upstream as the
+// bearer token, and AuthMiddleware accepts the access cookie when there's no
+// Authorization header. Zero values mean the defaults above.
+type CookieAuthConfig struct {
+	Enabled bool
+
+	LoginPaths   []string // gateway paths, default /api/auth/login
+	RefreshPaths []string // default /api/auth/refresh
+
+	// JSON fields of the login/refresh response holding the tokens
+	AccessTokenField  string
+	RefreshTokenField string
+
+	// Cookie names, default access_token and refresh_token
+	AccessTokenCookie  string
+	RefreshTokenCookie string
+
+	// Secure limits the cookies to HTTPS; only turn it off for local development
+	Secure bool
+	// SameSite controls cross-site sending: "strict" never sends the cookies cross-site,
+	// "lax" (what browsers assume when unset) only on top-level navigations, and "none"
+	// also with cross-site fetches. A frontend on another site needs "none", which
+	// requires Secure, and a CORS policy allowing credentials for its exact origin.
+	SameSite string
+	Domain   string
+	// RefreshCookiePath limits where the browser sends the refresh cookie (default the first refresh path)
+	RefreshCookiePath string
+}
+
+func (c CookieAuthConfig) withDefaults() CookieAuthConfig {
+	if len(c.LoginPaths) == 0 {
+		c.LoginPaths = defaultLoginPaths
+	}
+	if len(c.RefreshPaths) == 0 {
+		c.RefreshPaths = defaultRefreshPaths
+	}
+	if c.AccessTokenField == "" {
+		c.AccessTokenField = defaultAccessTokenField
+	}
+	if c.RefreshTokenField == "" {
+		c.RefreshTokenField = defaultRefreshTokenField
+	}
+	if c.AccessTokenCookie == "" {
+		c.AccessTokenCookie = defaultAccessTokenCookie
+	}
+	if c.RefreshTokenCookie == "" {
+		c.RefreshTokenCookie = defaultRefreshTokenCookie
+	}
+	if c.RefreshCookiePath == "" {
+		c.RefreshCookiePath = c.RefreshPaths[0]
+	}
+	return c
+}
+
+// validate rejects settings browsers would refuse the cookies for
+func (c CookieAuthConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	switch strings.ToLower(c.SameSite) {
+	case "", "lax", "strict":
+	case "none":
+		if !c.Secure {
+			return errors.New("cookie auth with SameSite=None requires Secure cookies")
+		}
+	default:
+		return fmt.Errorf("invalid cookie SameSite %q", c.SameSite)
+	}
+	return nil
+}
+
+// checkCookieCORS warns about CORS policies under which browsers won't send the token
+// cookies cross-origin: without credentials, or with any origin allowed
+func (g *Gateway) checkCookieCORS() {
+	if !g.Config.CookieAuth.Enabled {
+		return
+	}
+	live := g.routing.Load()
+	policies := map[string]CORSConfig{"/": live.cors}
+	maps.Copy(policies, live.routeCORS)
+	for prefix, cfg := range policies {
+		if cfg.NoCredentials || slices.Contains(cfg.Origins, "*") {
+			g.Logger.Warn("CORS policy doesn't allow credentials, cross-origin requests won't carry auth cookies", "route", prefix)
+		}
+	}
+}
+
+// cookieFlow marks a request to the auth service as a login or refresh call
+type cookieFlow int
+
+const (
+	cookieFlowNone cookieFlow = iota
+	cookieFlowLogin
+	cookieFlowRefresh
+)
+
+type cookieFlowKey struct{}
+
+// cookieAuthHandler tags login and refresh requests by their gateway path, which the
+// director and ModifyResponse can no longer see once the path is rewritten
+func (g *Gateway) cookieAuthHandler(next http.Handler) http.Handler {
+	cfg := g.Config.CookieAuth.withDefaults()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flow := cookieFlowNone
+		switch {
+		case slices.Contains(cfg.LoginPaths, r.URL.Path):
+			flow = cookieFlowLogin
+		case slices.Contains(cfg.RefreshPaths, r.URL.Path):
+			flow = cookieFlowRefresh
+		}
+		if flow != cookieFlowNone {
+			r = r.WithContext(context.WithValue(r.Context(), cookieFlowKey{}, flow))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func cookieFlowFrom(r *http.Request) cookieFlow {
+	flow, _ := r.Context().Value(cookieFlowKey{}).(cookieFlow)
+	return flow
+}
+
+// injectRefreshCookie sends the refresh cookie upstream as the bearer token on refresh calls
+func (c CookieAuthConfig) injectRefreshCookie(r *http.Request) {
+	if cookieFlowFrom(r) != cookieFlowRefresh || r.Header.Get("Authorization") != "" {
+		return
+	}
+	if cookie, err := r.Cookie(c.RefreshTokenCookie); err == nil && cookie.Value != "" {
+		r.Header.Set("Authorization", "Bearer "+cookie.Value)
+	}
+}
+
+// setTokenCookies turns the tokens of a successful login/refresh response into cookies.
+// The body is passed on unchanged.
+func (c CookieAuthConfig) setTokenCookies(resp *http.Response) error {
+	if cookieFlowFrom(resp.Request) == cookieFlowNone || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, cookieAuthMaxBody+1))
+	if err != nil {
+		return err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if len(body) > cookieAuthMaxBody {
+		return nil
+	}
+
+	var tokens map[string]any
+	if json.Unmarshal(body, &tokens) != nil {
+		return nil
+	}
+	if access, ok := tokens[c.AccessTokenField].(string); ok && access != "" {
+		resp.Header.Add("Set-Cookie", c.cookie(c.AccessTokenCookie, access, "/", tokens).String())
+	}
+	if refresh, ok := tokens[c.RefreshTokenField].(string); ok && refresh != "" {
+		resp.Header.Add("Set-Cookie", c.cookie(c.RefreshTokenCookie, refresh, c.RefreshCookiePath, nil).String())
+	}
+	return nil
+}
+
+// cookie builds an HttpOnly token cookie; an expiresIn (seconds) in the response sets Max-Age
+func (c CookieAuthConfig) cookie(name, value, path string, tokens map[string]any) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   c.Domain,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: parseSameSite(c.SameSite),
+	}
+	if expiresIn, ok := tokens["expiresIn"].(float64); ok && expiresIn > 0 {
+		cookie.MaxAge = int(expiresIn)
+	}
+	return cookie
+}
+
+func parseSameSite(s string) http.SameSite {
+	switch strings.ToLower(s) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	case "lax":
+		return http.SameSiteLaxMode
+	default:
+		return http.SameSiteDefaultMode
+	}
+}
+
+// cookieToken returns the access token cookie when cookie auth is enabled
+func (g *Gateway) cookieToken(r *http.Request) string {
+	if !g.Config.CookieAuth.Enabled {
+		return ""
+	}
+	cookie, err := r.Cookie(g.Config.CookieAuth.withDefaults().AccessTokenCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCookieAuthFlows(t *testing.T) {
+	validate := testAuthService(t, map[string]testUser{"access-2": {UserID: "1", Role: "author"}})
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/auth/login":
+			if r.URL.Query().Get("password") != "secret" {
+				writeJSONError(w, http.StatusUnauthorized, "bad credentials")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"accessToken": "access-1", "refreshToken": "refresh-1", "expiresIn": 900})
+		case "/api/auth/refresh":
+			if r.Header.Get("Authorization") != "Bearer refresh-1" {
+				writeJSONError(w, http.StatusUnauthorized, "no refresh token")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"accessToken": "access-2", "refreshToken": "refresh-2"})
+		default:
+			validate.Config.Handler.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(auth.Close)
+	blog := newTestUpstream(t)
+	newGateway := func(cfg CookieAuthConfig) *Gateway {
+		return newTestGateway(t, &Config{AuthServiceURL: auth.URL, BlogServiceURL: blog.URL, CookieAuth: cfg})
+	}
+	cookies := func(rec *httptest.ResponseRecorder) map[string]*http.Cookie {
+		m := make(map[string]*http.Cookie)
+		for _, c := range rec.Result().Cookies() {
+			m[c.Name] = c
+		}
+		return m
+	}
+	g := newGateway(CookieAuthConfig{Enabled: true, Secure: true, SameSite: "strict", Domain: "example.com"})
+
+	var body map[string]any
+	rec := serve(t, g.ProxyHandler(ServiceAuth), httptest.NewRequest(http.MethodPost, "/api/auth/login?password=secret", nil), &body)
+	if rec.Code != http.StatusOK || body["accessToken"] != "access-1" {
+		t.Fatalf("login: status %d, body %v", rec.Code, body)
+	}
+	set := cookies(rec)
+	access, refresh := set["access_token"], set["refresh_token"]
+	if access == nil || access.Value != "access-1" || access.Path != "/" || access.MaxAge != 900 {
+		t.Errorf("login access cookie: %+v", access)
+	}
+	if refresh == nil || refresh.Value != "refresh-1" || refresh.Path != "/api/auth/refresh" {
+		t.Errorf("login refresh cookie: %+v", refresh)
+	}
+	for _, c := range set {
+		if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteStrictMode || c.Domain != "example.com" {
+			t.Errorf("cookie %s flags: HttpOnly %t, Secure %t, SameSite %v, Domain %q", c.Name, c.HttpOnly, c.Secure, c.SameSite, c.Domain)
+		}
+	}
+
+	if rec := serve(t, g.ProxyHandler(ServiceAuth), httptest.NewRequest(http.MethodPost, "/api/auth/login?password=wrong", nil), nil); rec.Code != http.StatusUnauthorized || len(cookies(rec)) != 0 {
+		t.Errorf("failed login: status %d, cookies %v", rec.Code, cookies(rec))
+	}
+
+	// The refresh token comes from its cookie, with no Authorization header
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "refresh-1"})
+	rec = serve(t, g.ProxyHandler(ServiceAuth), req, nil)
+	if set := cookies(rec); rec.Code != http.StatusOK || set["access_token"] == nil || set["access_token"].Value != "access-2" || set["refresh_token"] == nil || set["refresh_token"].Value != "refresh-2" {
+		t.Errorf("refresh from cookie: status %d, cookies %v", rec.Code, set)
+	}
+
+	// The access cookie authenticates API calls
+	req = httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: "access-2"})
+	var got testUpstreamResponse
+	if rec := serve(t, g.AuthMiddleware(g.ProxyHandler(ServiceBlog)), req, &got); rec.Code != http.StatusOK || got.UserID != "1" {
+		t.Errorf("access cookie: status %d, user %q", rec.Code, got.UserID)
+	}
+
+	// Without CookieAuth the auth service is proxied as is
+	g = newGateway(CookieAuthConfig{})
+	if rec := serve(t, g.ProxyHandler(ServiceAuth), httptest.NewRequest(http.MethodPost, "/api/auth/login?password=secret", nil), nil); rec.Code != http.StatusOK || len(cookies(rec)) != 0 {
+		t.Errorf("login without cookie auth: status %d, cookies %v", rec.Code, cookies(rec))
+	}
+	req = httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "refresh-1"})
+	if rec := serve(t, g.ProxyHandler(ServiceAuth), req, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh cookie without cookie auth: status %d, want 401", rec.Code)
+	}
+}
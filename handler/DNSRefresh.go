@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDNSRefreshInterval = 30 * time.Second
+	dnsLookupTimeout          = 5 * time.Second
+)
+
+// RecycleConnections retires pooled upstream connections that may point at stale
+// addresses until ctx is done. Every Config.DNSRefreshInterval the hostnames of all
+// instances are resolved again; when a service's addresses change, its idle connections
+// are closed on that round and the next one, so connections busy during the first round
+// are retired too and new requests dial the new addresses. Every Config.MaxConnAge,
+// idle connections are closed regardless, which also covers DNS that rotates addresses
+// without the gateway seeing a change.
+func (g *Gateway) RecycleConnections(ctx context.Context) {
+	interval := g.Config.DNSRefreshInterval
+	if interval == 0 {
+		interval = defaultDNSRefreshInterval
+	}
+	var refresh, recycle <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+	if g.Config.MaxConnAge > 0 {
+		ticker := time.NewTicker(g.Config.MaxConnAge)
+		defer ticker.Stop()
+		recycle = ticker.C
+	}
+	if refresh == nil && recycle == nil {
+		return
+	}
+
+	resolved := make(map[string][]string) // host to sorted addresses
+	pending := make(map[*upstream]bool)   // changed last round, recycled once more
+	g.resolveUpstreams(ctx, g.allUpstreams(), resolved)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-recycle:
+			for _, up := range g.allUpstreams() {
+				up.transport.CloseIdleConnections()
+			}
+		case <-refresh:
+			upstreams := g.allUpstreams()
+			changed := g.resolveUpstreams(ctx, upstreams, resolved)
+			for _, up := range upstreams {
+				if changed[up] || pending[up] {
+					up.transport.CloseIdleConnections()
+				}
+			}
+			pending = changed
+		}
+	}
+}
+
+// resolveUpstreams looks up the hostnames of every instance, updating resolved, and
+// returns the upstreams with a host whose addresses changed since the previous lookup
+func (g *Gateway) resolveUpstreams(ctx context.Context, upstreams []*upstream, resolved map[string][]string) map[*upstream]bool {
+	changed := make(map[*upstream]bool)
+	hostChanged := make(map[string]bool) // hosts looked up this round
+	for _, up := range upstreams {
+		for _, in := range up.instances() {
+			host := in.url.Hostname()
+			if _, err := netip.ParseAddr(host); err == nil || host == "" {
+				continue
+			}
+			if _, looked := hostChanged[host]; !looked {
+				hostChanged[host] = false
+				addrs, err := g.lookupHost(ctx, host)
+				if err != nil {
+					// Keep the previous addresses; a failed lookup isn't a change
+					g.Logger.Warn("Upstream DNS lookup failed", "service", up.name, "host", host, "error", err)
+					continue
+				}
+				prev, seen := resolved[host]
+				resolved[host] = addrs
+				if seen && !slices.Equal(prev, addrs) {
+					hostChanged[host] = true
+					g.Logger.Info("Upstream addresses changed, recycling connections", "host", host,
+						"addresses", strings.Join(addrs, ","), "previous", strings.Join(prev, ","))
+				}
+			}
+			if hostChanged[host] {
+				changed[up] = true
+			}
+		}
+	}
+	return changed
+}
+
+// allUpstreams returns every current service along with its extra API versions
+func (g *Gateway) allUpstreams() []*upstream {
+	var upstreams []*upstream
+	current := g.upstreams()
+	for _, name := range sortedKeys(current) {
+		up := current[name]
+		upstreams = append(upstreams, up)
+		for _, v := range sortedKeys(up.versions) {
+			upstreams = append(upstreams, up.versions[v])
+		}
+	}
+	return upstreams
+}
+
+func (g *Gateway) lookupHost(ctx context.Context, host string) ([]string, error) {
+	resolver := g.options.resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+	addrs, err := resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(addrs)
+	return addrs, nil
+}
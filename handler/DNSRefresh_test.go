@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDNS answers A queries over UDP from a table it can change; names missing from it
+// get NXDOMAIN and AAAA queries get no answers
+type fakeDNS struct {
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	hosts   map[string][]string // name without the trailing dot to IPv4 addresses
+	queried []string
+}
+
+func newFakeDNS(t *testing.T) *fakeDNS {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	d := &fakeDNS{conn: conn, hosts: map[string][]string{}}
+	go d.serve()
+	return d
+}
+
+// resolver sends every query to d
+func (d *fakeDNS) resolver() *net.Resolver {
+	return &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "udp", d.conn.LocalAddr().String())
+	}}
+}
+
+func (d *fakeDNS) set(name string, addrs ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hosts[name] = addrs
+}
+
+func (d *fakeDNS) remove(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.hosts, name)
+}
+
+func (d *fakeDNS) names() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queried...)
+}
+
+func (d *fakeDNS) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if reply := d.answer(buf[:n]); reply != nil {
+			d.conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+// answer builds the reply to one query, echoing its question
+func (d *fakeDNS) answer(query []byte) []byte {
+	if len(query) < 12 || binary.BigEndian.Uint16(query[4:]) != 1 {
+		return nil
+	}
+	var labels []string
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		size := int(query[end])
+		if end+1+size > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[end+1:end+1+size]))
+		end += 1 + size
+	}
+	end += 5 // the root label, QTYPE and QCLASS
+	if end > len(query) {
+		return nil
+	}
+	name, qtype := strings.Join(labels, "."), binary.BigEndian.Uint16(query[end-4:])
+
+	d.mu.Lock()
+	d.queried = append(d.queried, name)
+	addrs, ok := d.hosts[name]
+	d.mu.Unlock()
+
+	reply := append([]byte(nil), query[:end]...)
+	binary.BigEndian.PutUint16(reply[2:], 0x8180) // a response, recursion available
+	binary.BigEndian.PutUint16(reply[8:], 0)      // no authority records
+	binary.BigEndian.PutUint16(reply[10:], 0)     // no additional records
+	switch {
+	case !ok:
+		reply[3] |= 3 // NXDOMAIN
+		addrs = nil
+	case qtype != 1: // only A records
+		addrs = nil
+	}
+	binary.BigEndian.PutUint16(reply[6:], uint16(len(addrs)))
+	for _, a := range addrs {
+		ip := netip.MustParseAddr(a).As4()
+		// A pointer to the name in the question, type A, class IN, TTL 0 and the address
+		reply = append(reply, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 0, 0, 4)
+		reply = append(reply, ip[:]...)
+	}
+	return reply
+}
+
+func TestRecycleConnections(t *testing.T) {
+	dns := newFakeDNS(t)
+	dns.set("blog.test", "10.0.0.1")
+	var conns atomic.Int64
+	blog := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	blog.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	blog.Start()
+	t.Cleanup(blog.Close)
+
+	const interval = 20 * time.Millisecond
+	g := newTestGateway(t, &Config{BlogServiceURL: "http://blog.test", DNSRefreshInterval: interval}, WithResolver(dns.resolver()))
+	var logs lockedBuffer
+	g.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	// Whatever blog.test resolves to, connections reach the test server
+	g.upstreams()[ServiceBlog].transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, blog.Listener.Addr().String())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go g.RecycleConnections(ctx)
+	get := func() int64 {
+		t.Helper()
+		if rec := serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil); rec.Code != http.StatusOK {
+			t.Fatalf("status %d", rec.Code)
+		}
+		return conns.Load()
+	}
+
+	get()
+	time.Sleep(3 * interval)
+	if n := get(); n != 1 {
+		t.Errorf("%d connections while the addresses stayed the same, want 1 kept alive", n)
+	}
+
+	// A failed lookup isn't a change
+	dns.remove("blog.test")
+	time.Sleep(3 * interval)
+	dns.set("blog.test", "10.0.0.1")
+	time.Sleep(3 * interval)
+	if n := get(); n != 1 || !strings.Contains(logs.String(), "Upstream DNS lookup failed") {
+		t.Errorf("%d connections after a failed lookup, want 1 kept alive", n)
+	}
+
+	dns.set("blog.test", "10.0.0.1", "10.0.0.2")
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "recycling connections") {
+		if time.Now().After(deadline) {
+			t.Fatalf("address change never noticed: %s", logs.String())
+		}
+		time.Sleep(interval / 4)
+	}
+	// Two rounds retire the connection whether or not it was busy during the first
+	time.Sleep(3 * interval)
+	if n := get(); n != 2 {
+		t.Errorf("%d connections after the addresses changed, want a new one", n)
+	}
+	if n := get(); n != 2 {
+		t.Errorf("%d connections, want the new one kept alive", n)
+	}
+
+	// Only hostnames are looked up, not the IP addresses of the other services
+	for _, name := range dns.names() {
+		if name != "blog.test" {
+			t.Errorf("looked up %q", name)
+		}
+	}
+}
+
+func TestRecycleConnectionsByAge(t *testing.T) {
+	var conns atomic.Int64
+	blog := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	blog.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	blog.Start()
+	t.Cleanup(blog.Close)
+	g := newTestGateway(t, &Config{BlogServiceURL: blog.URL, DNSRefreshInterval: -1, MaxConnAge: 20 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go g.RecycleConnections(ctx)
+
+	for range 2 {
+		serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil)
+	}
+	time.Sleep(50 * time.Millisecond)
+	serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil)
+	if n := conns.Load(); n != 2 {
+		t.Errorf("%d connections, want a new one once MaxConnAge closed the idle one", n)
+	}
+}
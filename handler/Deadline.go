@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DeadlineMiddleware gives each request one time budget, Config.RequestDeadline, shared by
+// JWT validation and the upstream call, so their timeouts can't add up. Stream routes
+// are exempt since their responses are open-ended.
+func (g *Gateway) DeadlineMiddleware(next http.Handler) http.Handler {
+	if g.Config.RequestDeadline <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.longLived(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), g.Config.RequestDeadline)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// deadlineExceeded reports whether the request's time budget has run out
+func deadlineExceeded(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.DeadlineExceeded)
+}
+
+// writeDeadlineExceeded answers 504, naming the upstream timeout when that is what ran out
+func writeDeadlineExceeded(w http.ResponseWriter, r *http.Request) {
+	if t, ok := r.Context().Value(upstreamTimeoutKey{}).(upstreamTimeout); ok && time.Since(t.start) >= t.timeout {
+		writeProblem(w, http.StatusGatewayTimeout, "upstream timeout", map[string]any{
+			"service": t.service,
+			"timeout": t.timeout.String(),
+		})
+		return
+	}
+	writeJSONError(w, http.StatusGatewayTimeout, "request deadline exceeded")
+}
+
+type upstreamTimeoutKey struct{}
+
+type upstreamTimeout struct {
+	service string
+	timeout time.Duration
+	start   time.Time
+}
+
+// timeoutHandler bounds each request to a service by its route's entry in
+// Config.RouteTimeouts, or else the service's Timeout; the proxied request is cancelled
+// and the client gets 504 when it runs out. Stream routes are exempt.
+func (g *Gateway) timeoutHandler(up *upstream, svc ServiceConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := svc.Timeout
+		if t, ok := g.routeTimeout(r.URL.Path); ok {
+			timeout = t
+		}
+		if timeout <= 0 || g.streamRoute(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		ctx = context.WithValue(ctx, upstreamTimeoutKey{}, upstreamTimeout{service: up.name, timeout: timeout, start: time.Now()})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (g *Gateway) routeTimeout(path string) (time.Duration, bool) {
+	_, timeout, ok := longestPrefixMatch(g.Config.RouteTimeouts, path)
+	return timeout, ok
+}
+
+// setTimeoutHeader tells the backend how many milliseconds are left of the request's
+// deadline, so it can give up on work the gateway will no longer wait for
+func setTimeoutHeader(r *http.Request) {
+	r.Header.Del("X-Request-Timeout")
+	if deadline, ok := r.Context().Deadline(); ok {
+		r.Header.Set("X-Request-Timeout", strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 1), 10))
+	}
+}
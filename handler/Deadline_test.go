@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestDeadlineIsSharedByAuthAndUpstream(t *testing.T) {
+	const budget = 300 * time.Millisecond
+	users := testAuthService(t, map[string]testUser{"alice-token": {UserID: "1", Role: "author"}})
+	var authDelay atomic.Int64
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Duration(authDelay.Load())):
+			users.Config.Handler.ServeHTTP(w, r)
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(auth.Close)
+	var upstreamBudget atomic.Int64
+	var upstreamCalls atomic.Int64
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		ms, _ := strconv.ParseInt(r.Header.Get("X-Request-Timeout"), 10, 64)
+		upstreamBudget.Store(ms)
+		select {
+		case <-time.After(budget):
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(blog.Close)
+	g := newTestGateway(t, &Config{
+		AuthServiceURL:  auth.URL,
+		BlogServiceURL:  blog.URL,
+		RequestDeadline: budget,
+		Services: map[string]ServiceConfig{ServiceBlog: {
+			Retry: RetryConfig{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		}},
+	})
+	h := g.DeadlineMiddleware(g.AuthMiddleware(g.ProxyHandler(ServiceBlog)))
+	get := func() (*httptest.ResponseRecorder, time.Duration) {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		start := time.Now()
+		rec := serve(t, h, req, nil)
+		return rec, time.Since(start)
+	}
+
+	// Auth takes most of the budget; the upstream call gets only what is left
+	authDelay.Store(int64(budget * 2 / 3))
+	rec, elapsed := get()
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("slow auth then slow upstream: status %d, want 504", rec.Code)
+	}
+	if elapsed > budget+150*time.Millisecond {
+		t.Errorf("slow auth then slow upstream took %s, want about the %s budget", elapsed, budget)
+	}
+	if ms := upstreamBudget.Load(); upstreamCalls.Load() == 0 || ms <= 0 || ms > int64((budget/3+20*time.Millisecond)/time.Millisecond) {
+		t.Errorf("upstream was told %dms were left after %d calls, want at most the third of the budget auth left", ms, upstreamCalls.Load())
+	}
+	if calls := upstreamCalls.Load(); calls != 1 {
+		t.Errorf("upstream got %d calls, want no retries once the budget ran out", calls)
+	}
+
+	// Auth takes the whole budget; the upstream is never called
+	upstreamCalls.Store(0)
+	authDelay.Store(int64(2 * budget))
+	rec, elapsed = get()
+	if rec.Code != http.StatusGatewayTimeout || upstreamCalls.Load() != 0 {
+		t.Errorf("auth past the budget: status %d after %d upstream calls, want 504 and none", rec.Code, upstreamCalls.Load())
+	}
+	if elapsed > budget+150*time.Millisecond {
+		t.Errorf("auth past the budget took %s, want about the %s budget", elapsed, budget)
+	}
+}
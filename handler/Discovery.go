@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Backoff between failed registry queries
+const (
+	discoveryRetryMin = time.Second
+	discoveryRetryMax = 30 * time.Second
+)
+
+// DiscoverInstances keeps the instances of services with a ServiceConfig.ConsulService or
+// KubernetesService in sync with the registry until ctx is done. The configured URLs are
+// used until the registry first answers, and stay in place while it is unreachable or
+// reports no ready instance; discovered instances take their scheme and base path.
+func (g *Gateway) DiscoverInstances(ctx context.Context) {
+	var kube *kubeClient
+	if g.Config.Kubernetes.Enabled {
+		var err error
+		if kube, err = newKubeClient(g.Config.Kubernetes); err != nil {
+			g.Logger.Error("Kubernetes discovery disabled", "error", err)
+		}
+	}
+	for _, up := range g.upstreams() {
+		svc := up.svc
+		switch {
+		case svc.ConsulService != "" && g.Config.Consul.Address != "":
+			go g.watchConsul(ctx, up, svc.ConsulService)
+		case svc.KubernetesService != "" && kube != nil:
+			go g.watchKubernetes(ctx, kube, up, svc.KubernetesService, svc.KubernetesPort)
+		}
+	}
+}
+
+// updateInstances swaps in the targets a registry reported for up, ignoring empty results
+func (g *Gateway) updateInstances(up *upstream, source string, targets []*url.URL) {
+	if len(targets) == 0 {
+		g.Logger.Warn("No ready instances in "+source+", keeping current instances", "service", up.name)
+		return
+	}
+	if sameTargets(up.instances(), targets) {
+		return
+	}
+	up.setInstances(targets)
+	g.Logger.Info("Service instances updated from "+source, "service", up.name, "instances", len(targets))
+}
+
+// sameTargets reports whether instances already point at exactly targets, in any order
+func sameTargets(instances []*instance, targets []*url.URL) bool {
+	if len(instances) != len(targets) {
+		return false
+	}
+	have := make([]string, len(instances))
+	for i, in := range instances {
+		have[i] = in.url.String()
+	}
+	want := make([]string, len(targets))
+	for i, t := range targets {
+		want[i] = t.String()
+	}
+	slices.Sort(have)
+	slices.Sort(want)
+	return slices.Equal(have, want)
+}
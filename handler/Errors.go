@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// ProblemContentType is the media type of the gateway's error responses (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// problemDrainMax bounds the upstream error body read before it's replaced, so the
+// connection can be reused without reading a huge body
+const problemDrainMax = 64 << 10
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError writes a problem details body with message as the detail
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeProblem(w, status, message, nil)
+}
+
+// writeProblem writes an RFC 7807 problem details body with the given status, plus the
+// request ID once RequestIDMiddleware has set it. ext adds extension members.
+func writeProblem(w http.ResponseWriter, status int, detail string, ext map[string]any) {
+	body, _ := json.Marshal(problem(status, detail, w.Header().Get(RequestIDHeader), ext))
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// problem builds a problem details body. The detail is repeated as "error" for clients
+// of the gateway's earlier {"error": message} bodies, unless ext sets it.
+func problem(status int, detail, requestID string, ext map[string]any) map[string]any {
+	body := map[string]any{
+		"type":   "about:blank",
+		"title":  http.StatusText(status),
+		"status": status,
+		"detail": detail,
+		"error":  detail,
+	}
+	if requestID != "" {
+		body["requestId"] = requestID
+	}
+	maps.Copy(body, ext)
+	return body
+}
+
+// problemResponse replaces the body of an upstream 5xx response with problem details,
+// so clients see one error format whichever service failed. The upstream's own body is
+// dropped, as it may expose internals; bodies already in the format are kept.
+func problemResponse(resp *http.Response) error {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode < 500 || mediaType == ProblemContentType {
+		return nil
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, problemDrainMax))
+	resp.Body.Close()
+
+	detail := "upstream service error"
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		detail = "upstream service unavailable"
+	}
+	body, _ := json.Marshal(problem(resp.StatusCode, detail, requestIDFrom(resp.Request.Context()), nil))
+	body = append(body, '\n')
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Set("Content-Type", ProblemContentType)
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("ETag")
+	return nil
+}
+
+// NotFoundHandler answers requests that match no route, redirecting non-API paths
+// listed in Config.Redirects
+func (g *Gateway) NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target, ok := g.redirectFor(r.URL.Path); ok {
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+		writeJSONError(w, http.StatusNotFound, "no route for "+r.URL.Path)
+	})
+}
+
+// MethodNotAllowedHandler answers requests whose path matches a route but whose method doesn't
+func (g *Gateway) MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method "+r.Method+" not allowed")
+	})
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorsAreProblemDetails(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"alice-token": {UserID: "alice", Role: "user"}})
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "NullPointerException at PostRepository.java:42", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	for _, rewrite := range []bool{true, false} {
+		g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, BlogServiceURL: failing.URL, ProblemUpstreamErrors: rewrite})
+		h := g.RequestIDMiddleware(g.AuthMiddleware(g.ProxyHandler(ServiceBlog)))
+
+		var problem map[string]any
+		rec := serve(t, h, httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), &problem)
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("Content-Type") != ProblemContentType {
+			t.Fatalf("missing token got %d with Content-Type %q, want 401 problem details", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if problem["type"] != "about:blank" || problem["title"] != "Unauthorized" || problem["status"] != 401.0 ||
+			problem["detail"] != "missing Authorization header" || problem["requestId"] != rec.Header().Get(RequestIDHeader) {
+			t.Errorf("401 problem %v", problem)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		rec = serve(t, h, req, nil)
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("rewrite %v: upstream failure got %d, want 500", rewrite, rec.Code)
+		}
+		rewritten := rec.Header().Get("Content-Type") == ProblemContentType && !strings.Contains(rec.Body.String(), "NullPointerException") &&
+			strings.Contains(rec.Body.String(), `"requestId":"`+rec.Header().Get(RequestIDHeader)+`"`)
+		if rewritten != rewrite {
+			t.Errorf("rewrite %v: upstream failure answered with %q %s", rewrite, rec.Header().Get("Content-Type"), rec.Body)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcMaxMessage bounds transcoded request and response messages
+const grpcMaxMessage = 4 << 20
+
+// grpcHTTPStatus maps gRPC status codes to the HTTP status of a transcoded response,
+// following grpc-gateway
+var grpcHTTPStatus = map[int]int{
+	0:  http.StatusOK,
+	1:  499, // CANCELLED; the client closed the request
+	2:  http.StatusInternalServerError,
+	3:  http.StatusBadRequest,
+	4:  http.StatusGatewayTimeout,
+	5:  http.StatusNotFound,
+	6:  http.StatusConflict,
+	7:  http.StatusForbidden,
+	8:  http.StatusTooManyRequests,
+	9:  http.StatusBadRequest,
+	10: http.StatusConflict,
+	11: http.StatusBadRequest,
+	12: http.StatusNotImplemented,
+	13: http.StatusInternalServerError,
+	14: http.StatusServiceUnavailable,
+	15: http.StatusInternalServerError,
+	16: http.StatusUnauthorized,
+}
+
+// grpcRoute maps a REST call below a service prefix to a gRPC method
+type grpcRoute struct {
+	verb     string
+	segments []string // "{name}" segments capture a message field
+	method   string   // e.g. /recs.Recommendations/ForUser
+}
+
+// parseGRPCRoutes parses ServiceConfig.GRPCMethods keys like "GET /users/{userId}"
+func parseGRPCRoutes(methods map[string]string) ([]grpcRoute, error) {
+	routes := make([]grpcRoute, 0, len(methods))
+	for _, key := range sortedKeys(methods) {
+		verb, path, ok := strings.Cut(strings.TrimSpace(key), " ")
+		method := methods[key]
+		if !ok || !strings.HasPrefix(strings.TrimSpace(path), "/") || strings.Count(method, "/") != 2 || !strings.HasPrefix(method, "/") {
+			return nil, fmt.Errorf("invalid gRPC method mapping %q: %q", key, method)
+		}
+		routes = append(routes, grpcRoute{
+			verb:     strings.ToUpper(verb),
+			segments: strings.Split(strings.Trim(strings.TrimSpace(path), "/"), "/"),
+			method:   method,
+		})
+	}
+	return routes, nil
+}
+
+// match returns the route's captured fields when it matches verb and path
+func (rt grpcRoute) match(verb, path string) (map[string]string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if verb != rt.verb || len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	fields := make(map[string]string)
+	for i, s := range rt.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			fields[s[1:len(s)-1]] = segments[i]
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+	return fields, true
+}
+
+// grpcMethodFor resolves the gRPC method of a request path below the service prefix:
+// a ServiceConfig.GRPCMethods mapping, or POST /<package.Service>/<Method> as is
+func grpcMethodFor(routes []grpcRoute, verb, path string) (string, map[string]string, bool) {
+	for _, rt := range routes {
+		if fields, ok := rt.match(verb, path); ok {
+			return rt.method, fields, true
+		}
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if verb == http.MethodPost && len(parts) == 2 && strings.Contains(parts[0], ".") && parts[1] != "" {
+		return "/" + parts[0] + "/" + parts[1], nil, true
+	}
+	return "", nil, false
+}
+
+// grpcTranscoder turns JSON requests into unary gRPC calls with the JSON codec and the
+// responses back into JSON. It replaces the reverse proxy of a ServiceConfig.GRPC
+// service, so the service's other layers and AuthMiddleware still apply; identity
+// headers such as X-User-ID travel as gRPC metadata.
+func (g *Gateway) grpcTranscoder(up *upstream, transport http.RoundTripper, routes []grpcRoute) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, fields, ok := grpcMethodFor(routes, r.Method, strings.TrimPrefix(r.URL.Path, g.servicePrefix(up.name)))
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "no gRPC method for "+r.Method+" "+r.URL.Path)
+			return
+		}
+		msg, err := grpcRequestMessage(r, fields)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		target := up.instanceFrom(r).resolve(&url.URL{Path: method})
+		frame := make([]byte, 5, 5+len(msg))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, target.String(), bytes.NewReader(append(frame, msg...)))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid gRPC request")
+			return
+		}
+		for k, vv := range r.Header {
+			switch http.CanonicalHeaderKey(k) {
+			case "Content-Type", "Content-Length", "Connection", "Te", "Upgrade", "Keep-Alive",
+				"Transfer-Encoding", "Accept", "Accept-Encoding", "Origin", "Cookie":
+				continue
+			}
+			req.Header[k] = vv
+		}
+		g.setForwardedHeaders(r)
+		for _, k := range []string{"X-Forwarded-Proto", "X-Forwarded-Host", "X-Real-IP"} {
+			req.Header.Set(k, r.Header.Get(k))
+		}
+		req.Header.Set("Content-Type", "application/grpc+json")
+		req.Header.Set("Te", "trailers")
+		if deadline, ok := r.Context().Deadline(); ok {
+			req.Header.Set("Grpc-Timeout", strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 1), 10)+"m")
+		}
+
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			g.proxyErrorHandler(up.name)(w, r, err)
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, grpcMaxMessage+5+1))
+		if err != nil {
+			g.proxyErrorHandler(up.name)(w, r, err)
+			return
+		}
+
+		// Trailers-only responses carry the status in the headers
+		status := resp.Trailer.Get("Grpc-Status")
+		message := resp.Trailer.Get("Grpc-Message")
+		if status == "" {
+			status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+		}
+		code, err := strconv.Atoi(status)
+		if resp.StatusCode != http.StatusOK || err != nil {
+			g.Logger.ErrorContext(r.Context(), "gRPC call failed", "service", up.name, "method", method, "status", resp.StatusCode)
+			writeJSONError(w, http.StatusBadGateway, "upstream unavailable")
+			return
+		}
+		if code != 0 {
+			httpStatus, ok := grpcHTTPStatus[code]
+			if !ok {
+				httpStatus = http.StatusInternalServerError
+			}
+			writeJSON(w, httpStatus, map[string]any{"error": message, "code": code, "requestId": requestIDFrom(r.Context())})
+			return
+		}
+
+		out, err := grpcResponseMessage(body)
+		if err != nil {
+			g.Logger.ErrorContext(r.Context(), "Invalid gRPC response", "service", up.name, "method", method, "error", err)
+			writeJSONError(w, http.StatusBadGateway, "invalid upstream response")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+	})
+}
+
+// grpcRequestMessage builds the JSON request message from the body, the path fields and,
+// for GET and DELETE, the query parameters
+func grpcRequestMessage(r *http.Request, fields map[string]string) ([]byte, error) {
+	msg := make(map[string]any)
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(r.Body, grpcMaxMessage+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		if len(body) > grpcMaxMessage {
+			return nil, errors.New("request body too large")
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber() // keeps 64-bit integers exact
+			if err := dec.Decode(&msg); err != nil {
+				return nil, errors.New("request body must be a JSON object")
+			}
+		}
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodDelete {
+		for k, vv := range r.URL.Query() {
+			if len(vv) == 1 {
+				msg[k] = vv[0]
+			} else {
+				msg[k] = vv
+			}
+		}
+	}
+	for k, v := range fields {
+		msg[k] = v
+	}
+	return json.Marshal(msg)
+}
+
+// grpcResponseMessage extracts the single message of a unary response body
+func grpcResponseMessage(body []byte) ([]byte, error) {
+	if len(body) == 0 {
+		return []byte("{}"), nil
+	}
+	if len(body) < 5 {
+		return nil, errors.New("truncated message frame")
+	}
+	if body[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(body[1:5])
+	if n > grpcMaxMessage || int(n) > len(body)-5 {
+		return nil, errors.New("message frame too large or truncated")
+	}
+	return body[5 : 5+n], nil
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestGRPCService serves the JSON codec over cleartext HTTP/2 like a gRPC backend,
+// answering each method as the test needs
+func newTestGRPCService(t *testing.T) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.ProtoMajor != 2 || r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/grpc+json" || r.Header.Get("Te") != "trailers" {
+			w.Header().Set("Grpc-Status", "3")
+			w.Header().Set("Grpc-Message", "not a gRPC call")
+			return
+		}
+		reply := func(msg string) {
+			w.Header().Set("Content-Type", "application/grpc+json")
+			w.Header().Set("Trailer", "Grpc-Status")
+			w.Write(grpcFrame(msg))
+			w.Header().Set("Grpc-Status", "0")
+		}
+		switch r.URL.Path {
+		case "/recs.Recommendations/ForUser", "/recs.Recommendations/Echo":
+			// The message and the metadata the call arrived with
+			meta, _ := json.Marshal(map[string]any{"message": json.RawMessage(body[5:]), "user": r.Header.Get("X-User-ID"),
+				"timeout": r.Header.Get("Grpc-Timeout"), "cookie": r.Header.Get("Cookie")})
+			reply(string(meta))
+		case "/recs.Recommendations/Missing":
+			// Trailers-only
+			w.Header().Set("Content-Type", "application/grpc+json")
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "user not found")
+		case "/recs.Recommendations/Compressed":
+			w.Header().Set("Content-Type", "application/grpc+json")
+			w.Header().Set("Trailer", "Grpc-Status")
+			frame := grpcFrame("{}")
+			frame[0] = 1
+			w.Write(frame)
+			w.Header().Set("Grpc-Status", "0")
+		default:
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		}
+	}))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGRPCTranscoding(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"alice-token": {UserID: "alice", Role: "user"}})
+	recs := newTestGRPCService(t)
+	g := newTestGateway(t, &Config{
+		AuthServiceURL: auth.URL,
+		BlogServiceURL: recs.URL,
+		Services: map[string]ServiceConfig{ServiceBlog: {
+			GRPC:        true,
+			GRPCMethods: map[string]string{"GET /users/{userId}/recommendations": "/recs.Recommendations/ForUser"},
+		}},
+	})
+	h := g.AuthMiddleware(g.ProxyHandler(ServiceBlog))
+	call := func(ctx context.Context, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		req.Header.Set("Cookie", "session=secret")
+		return serve(t, h, req, nil)
+	}
+	type echo struct {
+		Message map[string]any
+		User    string
+		Timeout string
+		Cookie  string
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rec := call(ctx, http.MethodGet, "/api/blog/users/42/recommendations?limit=5&tag=go&tag=grpc", "")
+	var got echo
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("mapped GET: status %d, body %s", rec.Code, rec.Body)
+	}
+	// Path fields and query parameters fill the message; identity travels as metadata
+	if b, _ := json.Marshal(got.Message); string(b) != `{"limit":"5","tag":["go","grpc"],"userId":"42"}` || got.User != "alice" || got.Cookie != "" {
+		t.Errorf("mapped GET: backend got %s as %q with cookie %q", b, got.User, got.Cookie)
+	}
+	if !strings.HasSuffix(got.Timeout, "m") || got.Timeout == "0m" {
+		t.Errorf("Grpc-Timeout %q, want the request deadline in milliseconds", got.Timeout)
+	}
+
+	// Any method can be called by name with POST; large integers stay exact
+	rec = call(context.Background(), http.MethodPost, "/api/blog/recs.Recommendations/Echo", `{"id": 12345678901234567890}`)
+	if !strings.Contains(rec.Body.String(), `"message":{"id":12345678901234567890}`) || !strings.Contains(rec.Body.String(), `"timeout":""`) {
+		t.Errorf("POST by name: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	for _, tt := range []struct {
+		name, method, target, body string
+		status                     int
+		contains                   string
+	}{
+		{"gRPC error", http.MethodPost, "/api/blog/recs.Recommendations/Missing", "{}", http.StatusNotFound, `"error":"user not found"`},
+		{"unmapped path", http.MethodGet, "/api/blog/users", "", http.StatusNotFound, "no gRPC method"},
+		{"GET by name", http.MethodGet, "/api/blog/recs.Recommendations/Echo", "", http.StatusNotFound, "no gRPC method"},
+		{"body not an object", http.MethodPost, "/api/blog/recs.Recommendations/Echo", "[1]", http.StatusBadRequest, "JSON object"},
+		{"HTTP error", http.MethodPost, "/api/blog/recs.Recommendations/Overloaded", "{}", http.StatusBadGateway, "upstream unavailable"},
+		{"compressed reply", http.MethodPost, "/api/blog/recs.Recommendations/Compressed", "{}", http.StatusBadGateway, "invalid upstream response"},
+	} {
+		rec := call(context.Background(), tt.method, tt.target, tt.body)
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.contains) {
+			t.Errorf("%s: status %d, body %s; want %d with %q", tt.name, rec.Code, rec.Body, tt.status, tt.contains)
+		}
+	}
+}
+
+func TestGRPCResponseMessage(t *testing.T) {
+	frame := grpcFrame(`{"ok":true}`)
+	for name, tt := range map[string]struct {
+		body []byte
+		want string
+	}{
+		"one message":  {frame, `{"ok":true}`},
+		"empty body":   {nil, "{}"},
+		"short header": {frame[:3], ""},
+		"truncated":    {frame[:len(frame)-1], ""},
+		"compressed":   {append([]byte{1}, frame[1:]...), ""},
+	} {
+		got, err := grpcResponseMessage(tt.body)
+		if string(got) != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("%s: %q (%v), want %q", name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GRPCWebPrefix is the gateway path for gRPC-Web calls to the ASP service;
+// /grpc/asp.Echo/Say is forwarded as the gRPC method /asp.Echo/Say
+const GRPCWebPrefix = "/grpc"
+
+// grpcTrailerFlag marks a gRPC-Web frame that carries trailers instead of a message
+const grpcTrailerFlag = 0x80
+
+// grpcStatusUnavailable is the gRPC UNAVAILABLE status code
+const grpcStatusUnavailable = "14"
+
+// newGRPCWebHandler translates gRPC-Web requests from browsers into gRPC over HTTP/2
+// to the upstream's instances, and the gRPC response back into gRPC-Web framing with
+// trailers in a final body frame. Plain http:// backends are reached with h2c.
+func (g *Gateway) newGRPCWebHandler(up *upstream) (http.Handler, error) {
+	transport, err := g.newTransport(up.svc)
+	if err != nil {
+		return nil, fmt.Errorf("%s gRPC transport: %w", up.name, err)
+	}
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetHTTP2(true)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType := r.Header.Get("Content-Type")
+		if r.Method != http.MethodPost || !strings.HasPrefix(contentType, "application/grpc-web") {
+			writeJSONError(w, http.StatusUnsupportedMediaType, "expected a gRPC-Web request")
+			return
+		}
+		text := strings.HasPrefix(contentType, "application/grpc-web-text")
+		subtype := grpcSubtype(contentType)
+
+		var body io.Reader = r.Body
+		if text {
+			body = base64.NewDecoder(base64.StdEncoding, r.Body)
+		}
+		target := up.instanceFrom(r).resolve(&url.URL{Path: strings.TrimPrefix(r.URL.Path, GRPCWebPrefix)})
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, target.String(), body)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid gRPC-Web request")
+			return
+		}
+
+		// Headers become gRPC metadata, including X-User-ID and the other identity headers
+		for k, vv := range r.Header {
+			switch http.CanonicalHeaderKey(k) {
+			case "Content-Type", "Content-Length", "Connection", "Te", "Upgrade", "Keep-Alive",
+				"Transfer-Encoding", "Accept", "Accept-Encoding", "X-Grpc-Web", "X-User-Agent", "Origin":
+				continue
+			}
+			req.Header[k] = vv
+		}
+		g.setForwardedHeaders(r)
+		for _, k := range []string{"X-Forwarded-Proto", "X-Forwarded-Host", "X-Real-IP"} {
+			req.Header.Set(k, r.Header.Get(k))
+		}
+		req.Header.Set("Content-Type", "application/grpc"+subtype)
+		req.Header.Set("Te", "trailers")
+
+		resp, err := up.withCredentials(transport).RoundTrip(req)
+		if err != nil {
+			g.Logger.ErrorContext(r.Context(), "gRPC-Web call failed", "target", target.String(), "error", err)
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Grpc-Status", grpcStatusUnavailable)
+			w.Header().Set("Grpc-Message", "upstream unavailable")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		defer resp.Body.Close()
+
+		// grpc-status and grpc-message may arrive in the headers (trailers-only responses);
+		// either way they're sent to the browser in the trailer frame
+		trailers := make(http.Header)
+		for k, vv := range resp.Header {
+			switch k {
+			case "Content-Type", "Content-Length", "Trailer":
+			case "Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin":
+				trailers[k] = vv
+			default:
+				w.Header()[k] = vv
+			}
+		}
+		outType := "application/grpc-web" + subtype
+		if text {
+			outType = "application/grpc-web-text" + subtype
+		}
+		w.Header().Set("Content-Type", outType)
+		w.WriteHeader(resp.StatusCode)
+
+		out := &flushWriter{w: w, rc: http.NewResponseController(w)}
+		if err := copyGRPCBody(out, resp.Body, text); err != nil {
+			g.Logger.WarnContext(r.Context(), "gRPC-Web response interrupted", "target", target.String(), "error", err)
+			return
+		}
+		for k, vv := range resp.Trailer {
+			trailers[k] = vv
+		}
+		writeGRPCChunk(out, grpcTrailerFrame(trailers), text)
+	})
+	return g.balanceHandler(up, h), nil
+}
+
+// GRPCWebHandler serves gRPC-Web calls to the ASP service; 404 unless Config.AspGRPC is set
+func (g *Gateway) GRPCWebHandler() http.Handler {
+	if g.grpcWeb == nil {
+		return g.NotFoundHandler()
+	}
+	return g.grpcWeb
+}
+
+// grpcSubtype returns the codec suffix of a gRPC content type, e.g. "+proto"
+func grpcSubtype(contentType string) string {
+	if i := strings.IndexByte(contentType, '+'); i >= 0 {
+		return strings.TrimSpace(strings.SplitN(contentType[i:], ";", 2)[0])
+	}
+	return "+proto"
+}
+
+// grpcTrailerFrame encodes trailers as a gRPC-Web trailer frame
+func grpcTrailerFrame(trailers http.Header) []byte {
+	var block bytes.Buffer
+	for k, vv := range trailers {
+		for _, v := range vv {
+			fmt.Fprintf(&block, "%s: %s\r\n", strings.ToLower(k), v)
+		}
+	}
+	frame := make([]byte, 5, 5+block.Len())
+	frame[0] = grpcTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(block.Len()))
+	return append(frame, block.Bytes()...)
+}
+
+// copyGRPCBody relays the gRPC message frames unchanged, base64-encoding each chunk
+// for grpc-web-text
+func copyGRPCBody(w io.Writer, body io.Reader, text bool) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if werr := writeGRPCChunk(w, buf[:n], text); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeGRPCChunk writes p, as a self-contained padded base64 chunk in text mode
+func writeGRPCChunk(w io.Writer, p []byte, text bool) error {
+	if text {
+		p = []byte(base64.StdEncoding.EncodeToString(p))
+	}
+	_, err := w.Write(p)
+	return err
+}
+
+// flushWriter flushes after every write so streamed gRPC messages reach the browser promptly
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		f.rc.Flush()
+	}
+	return n, err
+}
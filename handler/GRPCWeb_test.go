@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// grpcFrame encodes msg as a length-prefixed gRPC message frame
+func grpcFrame(msg string) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+func TestGRPCWebTranslation(t *testing.T) {
+	asp := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in, _ := io.ReadAll(r.Body)
+		if r.ProtoMajor != 2 || r.URL.Path != "/asp.Echo/Say" || r.Header.Get("Content-Type") != "application/grpc+proto" ||
+			r.Header.Get("Te") != "trailers" || !bytes.Equal(in, grpcFrame("hello")) {
+			w.Header().Set("Grpc-Status", "3")
+			w.Header().Set("Grpc-Message", "unexpected call")
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write(grpcFrame("hello, " + r.Header.Get("X-User-ID")))
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "ok")
+	}))
+	asp.Config.Protocols = new(http.Protocols)
+	asp.Config.Protocols.SetUnencryptedHTTP2(true)
+	asp.Start()
+	defer asp.Close()
+	g := newTestGateway(t, &Config{
+		AspServiceURL: asp.URL,
+		AspGRPC:       true,
+	})
+
+	for _, text := range []bool{false, true} {
+		contentType, body, reply := "application/grpc-web+proto", string(grpcFrame("hello")), string(grpcFrame("hello, alice"))
+		if text {
+			contentType = "application/grpc-web-text+proto"
+			body, reply = base64.StdEncoding.EncodeToString([]byte(body)), base64.StdEncoding.EncodeToString([]byte(reply))
+		}
+		req := httptest.NewRequest(http.MethodPost, GRPCWebPrefix+"/asp.Echo/Say", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-User-ID", "alice")
+		rec := serve(t, g.GRPCWebHandler(), req, nil)
+
+		message, trailers, ok := strings.Cut(rec.Body.String(), reply)
+		if text {
+			// Each chunk is padded base64 on its own: the message, then the trailers
+			b, err := base64.StdEncoding.DecodeString(trailers)
+			trailers = string(b)
+			ok = ok && err == nil
+		}
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != contentType || !ok || message != "" {
+			t.Fatalf("%s: status %d, Content-Type %q, body %q", contentType, rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+		}
+		if len(trailers) < 5 || trailers[0] != grpcTrailerFlag || !strings.Contains(trailers, "grpc-status: 0\r\n") || !strings.Contains(trailers, "grpc-message: ok\r\n") {
+			t.Errorf("%s: trailer frame %q, want grpc-status 0", contentType, trailers)
+		}
+	}
+}
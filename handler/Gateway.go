@@ -1,149 +1,876 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/MicroSOA-09/gateway-service/identity"
+)
+
+const defaultTokenRefreshThreshold = 5 * time.Minute
+
+// Service names used as keys in Config.Services
+const (
+	ServiceAuth = "auth"
+	ServiceBlog = "blog"
+	ServiceUser = "user"
+	ServiceAsp  = "asp"
 )
 
+// ProxyMethods are the HTTP methods proxied routes accept; others get 405
+var ProxyMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// ServicePrefixes maps each service to the path prefix it is mounted under
+var ServicePrefixes = map[string]string{
+	ServiceAuth: "/api/auth",
+	ServiceBlog: "/api/blog",
+	ServiceUser: "/api/user",
+	ServiceAsp:  "/api",
+}
+
+// Service URLs may list several comma-separated instances to balance across. A path in
+// a URL is a base path that forwarded request paths are appended to.
 type Config struct {
 	AuthServiceURL string
 	BlogServiceURL string
 	UserServiceURL string
 	AspServiceURL  string
+
+	// Routes declares additional services, typically loaded from a file with LoadRoutes.
+	// Their StripPrefix and Timeout are merged into Services.
+	Routes []RouteConfig
+
+	// AspPrefixes limits the ASP service to these path prefixes; empty means all of /api/
+	AspPrefixes []string
+
+	// PublicPaths are path patterns that skip JWT validation (see compilePathPatterns);
+	// nil means DefaultPublicPaths
+	PublicPaths []string
+	// OptionalAuthPaths are path patterns where requests without credentials are
+	// proxied anonymously, while credentials sent are still validated
+	OptionalAuthPaths []string
+
+	// AccessRules limit paths to roles, checked in order by AuthorizationMiddleware
+	AccessRules []AccessRule
+
+	// CORS is the cross-origin policy of CORSMiddleware
+	CORS CORSConfig
+	// RouteCORS replaces CORS for path prefixes, e.g. to open a public API to any origin.
+	// Fields a route leaves unset are taken from CORS.
+	RouteCORS map[string]CORSConfig
+
+	// Per-service options, keyed by service name
+	Services map[string]ServiceConfig
+
+	// Upstream connection pool tuning, shared by all requests to a service.
+	// Zero values fall back to defaults; MaxConnsPerHost 0 means unlimited.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	// DNSRefreshInterval re-resolves upstream hostnames to retire connections to old
+	// addresses (default 30s, negative disables); MaxConnAge closes idle connections that
+	// often regardless (0 disables). See RecycleConnections.
+	DNSRefreshInterval time.Duration
+	MaxConnAge         time.Duration
+
+	// BodyLog captures request/response bodies of failed requests on selected routes
+	BodyLog BodyLogConfig
+
+	// Compression compresses uncompressed responses for clients that accept br, gzip or deflate
+	Compression CompressionConfig
+
+	// APIKeys authenticates machine clients with a key header instead of a JWT
+	APIKeys APIKeyConfig
+
+	// CookieAuth keeps tokens in HttpOnly cookies set on login/refresh through the auth service
+	CookieAuth CookieAuthConfig
+
+	// LoadShed rejects part of the API traffic while the gateway is overloaded
+	LoadShed LoadShedConfig
+
+	// SchemaRules validate request bodies against JSON Schemas loaded at startup
+	SchemaRules []SchemaRule
+
+	// ContentType enforces request media types for write methods on selected routes
+	ContentType ContentTypeConfig
+
+	// HealthScore weights the signals used to rank instances of multi-URL services
+	HealthScore HealthScoreConfig
+	// LoadBalancing is the default strategy across the instances of multi-URL services:
+	// LoadBalanceHealth, LoadBalanceRoundRobin or LoadBalanceLeastConnections. Every strategy
+	// skips instances ejected by HealthScore or failing readiness probes.
+	LoadBalancing string
+
+	// Consul resolves the instances of services with a ServiceConfig.ConsulService
+	Consul ConsulConfig
+	// Kubernetes resolves the instances of services with a ServiceConfig.KubernetesService
+	Kubernetes KubernetesConfig
+
+	// IP filtering for subrouters using IPFilterMiddleware; the denylist wins over the allowlist
+	AllowedCIDRs []string
+	DeniedCIDRs  []string
+	// TrustedProxyCIDRs are the proxies allowed to set X-Forwarded-* headers.
+	// Without them, TrustedProxyHops is the number of proxies in front of the gateway
+	// that append to X-Forwarded-For.
+	TrustedProxyCIDRs []string
+	TrustedProxyHops  int
+
+	// MaxConcurrentPerService caps in-flight proxied requests per service (0 = unlimited);
+	// up to BulkheadQueueSize further requests (negative disables queueing) wait for a slot before getting 503
+	MaxConcurrentPerService int
+	BulkheadQueueSize       int
+
+	// CircuitBreaker applies to every service, including token validation against
+	// AuthService; ServiceConfig.CircuitBreaker overrides it per service
+	CircuitBreaker BreakerConfig
+
+	// Retry retries idempotent requests that fail with connection errors, 502 or 503;
+	// ServiceConfig.Retry overrides it per service
+	Retry RetryConfig
+
+	// Per-tenant rate limits keyed by tenant ID; unlisted tenants get DefaultTenantRateLimit
+	TenantRateLimits       map[string]RateLimit
+	DefaultTenantRateLimit RateLimit
+
+	// RouteRateLimits limits each client per path prefix (the longest matching prefix
+	// wins); clients are keyed by X-User-ID once authenticated, otherwise by IP
+	RouteRateLimits map[string]RateLimit
+
+	// RateLimitRedisURL shares rate limit buckets between gateway replicas through Redis,
+	// e.g. redis://:password@redis:6379/0; while Redis is unreachable each replica
+	// enforces the limits locally
+	RateLimitRedisURL string
+
+	// RateLimitDetails adds the limit, window, reset time and limited key to 429 bodies
+	RateLimitDetails bool
+
+	// CacheRedisURL shares the response caches of all services (ServiceConfig.CacheTTL)
+	// between gateway replicas through Redis; CacheRedisPrefix starts their keys
+	// (default "gateway:cache:")
+	CacheRedisURL    string
+	CacheRedisPrefix string
+
+	// MaxHeaderBytes is the server's limit on the request line and headers (main.go wires it);
+	// MaxHeaderCount and MaxHeaderValueBytes are enforced by HeaderLimitGuard (defaults 100 and 8KB)
+	MaxHeaderBytes      int
+	MaxHeaderCount      int
+	MaxHeaderValueBytes int
+
+	// RequestDeadline bounds the whole of a request's auth check and upstream call (0 disables)
+	RequestDeadline time.Duration
+
+	// MaxBodyBytes caps request bodies (0 disables); RouteBodyLimits overrides it per path
+	// prefix, the longest matching prefix winning. Larger bodies get 413.
+	MaxBodyBytes    int64
+	RouteBodyLimits map[string]int64
+
+	// RouteTimeouts bound upstream calls per path prefix (the longest matching prefix wins),
+	// taking precedence over ServiceConfig.Timeout; 504 names the timeout that ran out
+	RouteTimeouts map[string]time.Duration
+
+	// StreamRoutes are path prefixes whose responses are flushed as written and exempt
+	// from server timeouts; text/event-stream responses are treated this way everywhere
+	StreamRoutes []string
+	// ResponseBufferLimit, when > 0, buffers upstream responses up to this many bytes so the
+	// backend connection is released before slow clients finish reading; larger ones stream
+	ResponseBufferLimit int
+
+	// FlushInterval is how often the proxies flush other response bodies (0 = buffered)
+	FlushInterval time.Duration
+
+	// SmugglingProtection rejects requests with ambiguous Transfer-Encoding/Content-Length framing
+	SmugglingProtection bool
+
+	// SLAs are evaluated every SLAEvalInterval; breaches and recoveries are POSTed to
+	// SLAWebhook (or the SLA's own webhook), at most once per SLANotifyInterval per route
+	SLAs              []SLAConfig
+	SLAWebhook        string
+	SLAEvalInterval   time.Duration
+	SLANotifyInterval time.Duration
+
+	// IdentitySecret signs the forwarded identity headers with X-Gateway-Signature so
+	// services can verify them with the identity package (empty disables)
+	IdentitySecret string
+
+	// AdminToken guards the /admin endpoints (X-Admin-Token header); empty disables them
+	// unless AdminAddr is set
+	AdminToken string
+	// AdminAddr serves the admin API, /metrics and pprof on a separate listener such as
+	// 127.0.0.1:9090 instead of the main port; the admin token is then optional
+	AdminAddr string
+
+	// CaptureSize keeps the last N proxied requests for inspection and replay (0 disables)
+	CaptureSize int
+	// ReplayTargets are the extra backends (e.g. canaries) captures may be replayed against
+	ReplayTargets []string
+
+	// AuditEnabled writes an audit record for every authenticated proxied request to
+	// AuditLogPath, or stdout when it is empty
+	AuditEnabled bool
+	AuditLogPath string
+
+	// WarmPoolInterval is how often warm pools are refilled
+	WarmPoolInterval time.Duration
+
+	// DebugTimelineRoles may request a processing timeline with X-Debug-Timeline: true
+	// (empty disables the feature)
+	DebugTimelineRoles []string
+
+	// WarmupTimeout bounds the startup warm-up, including waiting for backends to come up
+	WarmupTimeout time.Duration
+
+	// Redirects maps exact non-API paths to URLs they redirect to (302) instead of 404
+	Redirects map[string]string
+
+	// AllowStaleAuthOnOutage lets a token's last successful validation stand in while
+	// AuthService is unreachable, for up to StaleAuthMaxAge (default 5m) after it was
+	// validated and never past the token's own expiry
+	AllowStaleAuthOnOutage bool
+	StaleAuthMaxAge        time.Duration
+
+	// LocalJWT verifies tokens in the gateway instead of calling AuthService per request
+	LocalJWT LocalJWTConfig
+
+	// OIDC logs browsers in with an external identity provider and keeps a session cookie
+	OIDC OIDCConfig
+
+	// Tracing exports request spans to an OpenTelemetry collector
+	Tracing TracingConfig
+
+	// TokenCacheTTL caches successful token validations for this long, never past the
+	// token's expiry (0 disables); TokenCacheMaxEntries bounds the cache (default 10000)
+	TokenCacheTTL        time.Duration
+	TokenCacheMaxEntries int
+
+	// Revocation rejects tokens revoked through the admin API or AuthService's list
+	Revocation RevocationConfig
+
+	// TokenRefreshThreshold is the remaining token lifetime below which responses carry
+	// X-Token-Refresh-Suggested (default 5m)
+	TokenRefreshThreshold time.Duration
+
+	// AccessLogFormat enables an access log in AccessLogCommon, AccessLogCombined or
+	// AccessLogJSON format, written to AccessLogPath or stdout when it is empty
+	AccessLogFormat string
+	AccessLogPath   string
+	// AccessLogExcludePaths are request paths left out of the access log, e.g. health checks
+	AccessLogExcludePaths []string
+	// AccessLogRedactHeaders are request headers whose values JSON access lines withhold
+	// (default Authorization, Proxy-Authorization, Cookie, X-Admin-Token,
+	// Sec-WebSocket-Protocol and the API key header)
+	AccessLogRedactHeaders []string
+
+	// ReadinessInterval is how often services are probed for /readyz (default 10s);
+	// CriticalServices are those that must be up for the gateway to be ready
+	// (default DefaultCriticalServices)
+	ReadinessInterval time.Duration
+	CriticalServices  []string
+
+	// EnablePprof exposes /debug/pprof/ to admin-token holders
+	EnablePprof bool
+
+	// AspGRPC enables the gRPC-Web endpoint under GRPCWebPrefix, which forwards to the ASP
+	// service as gRPC over HTTP/2; the JSON routes keep working alongside it
+	AspGRPC bool
+
+	// GatewayVersion, when set, is sent as X-Gateway-Version on every proxied response
+	GatewayVersion string
+
+	// ProblemUpstreamErrors replaces the bodies of upstream 5xx responses with problem
+	// details like the gateway's own errors
+	ProblemUpstreamErrors bool
+
+	// AggregateTimeout bounds each upstream call made by aggregation endpoints
+	AggregateTimeout time.Duration
+
+	// Compositions are declared aggregation endpoints, registered by the router
+	Compositions []Composition
+
+	// GraphQL serves /graphql, resolving fields through the services
+	GraphQL GraphQLConfig
+
+	// Batch serves POST /api/batch, running several API requests in one
+	Batch BatchConfig
+}
+
+// ServiceConfig holds options that apply to a single upstream service
+type ServiceConfig struct {
+	// OverloadHeader is a response header the backend uses to signal saturation
+	// (e.g. X-Overloaded or X-Queue-Depth). When set, the gateway throttles forwarding
+	// to the service after seeing overload signals.
+	OverloadHeader string
+	// OverloadThreshold, when > 0, treats OverloadHeader as a number and signals overload at or above it
+	OverloadThreshold int
+	// ThrottleStatus and ThrottleRetryAfter shape the response to throttled requests
+	ThrottleStatus     int
+	ThrottleRetryAfter time.Duration
+
+	// CacheTTL enables caching of 200 GET/HEAD responses for this long (0 disables)
+	CacheTTL time.Duration
+	// CacheMaxEntries and CacheMaxEntryBytes bound the cache; zero means defaults
+	CacheMaxEntries    int
+	CacheMaxEntryBytes int
+	// CacheVaryHeaders are request headers included in the cache key
+	CacheVaryHeaders []string
+	// CacheByRole keys cached responses on the caller's validated role instead of their
+	// Authorization header, so users with the same role share entries. Responses that
+	// differ per user must then be marked Cache-Control: private by the service.
+	CacheByRole bool
+	// Coalesce lets concurrent identical GET requests share one upstream call, keyed like
+	// the cache; responses over CoalesceMaxBytes (default 1MB) aren't shared
+	Coalesce         bool
+	CoalesceMaxBytes int
+
+	// EgressProxy routes this service's traffic through an http(s) or socks5 proxy URL;
+	// credentials may be embedded in the URL or given separately
+	EgressProxy         string
+	EgressProxyUser     string
+	EgressProxyPassword string
+
+	// TLSCertFile and TLSKeyFile are a client certificate presented to the service (mTLS);
+	// TLSCAFile verifies the service's certificate instead of the system roots
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSCAFile   string
+
+	// MaxConcurrent overrides Config.MaxConcurrentPerService for this service
+	MaxConcurrent int
+
+	// CircuitBreaker overrides the non-zero fields of Config.CircuitBreaker for this service
+	CircuitBreaker BreakerConfig
+
+	// Retry overrides the non-zero fields of Config.Retry for this service
+	Retry RetryConfig
+
+	// ShadowURL mirrors ShadowPercent (0-100) of idempotent requests to a second backend,
+	// comparing its status and latency with the primary in the log
+	ShadowURL     string
+	ShadowPercent float64
+
+	// Versions maps extra API versions (e.g. "v2") to their URLs; the service URL serves v1.
+	// Requests pick a version with X-API-Version or an application/vnd.<api>.v2+json Accept header.
+	Versions map[string]string
+
+	// WarmPoolSize keeps this many idle connections open to each instance by probing
+	// WarmPoolProbePath (a backend path, default "/") with HEAD requests
+	WarmPoolSize      int
+	WarmPoolProbePath string
+
+	// WarmupPaths are gateway paths fetched from every instance on startup to warm backend caches
+	WarmupPaths []string
+
+	// HealthPath is the backend path probed for readiness (default "/")
+	HealthPath string
+
+	// LoadBalancing overrides Config.LoadBalancing for the service
+	LoadBalancing string
+
+	// ConsulService is the service's name in Consul; its passing instances replace the
+	// configured URLs, which still give the scheme and base path (see DiscoverInstances)
+	ConsulService string
+
+	// KubernetesService is the name of the service's Kubernetes Service; the ready endpoints
+	// of its EndpointSlices replace the configured URLs like ConsulService does, using the
+	// port named KubernetesPort (default the first port)
+	KubernetesService string
+	KubernetesPort    string
+
+	// GRPC makes the service a gRPC backend: JSON requests are transcoded into unary gRPC
+	// calls over HTTP/2 (h2c for http:// URLs) using the JSON codec, content type
+	// application/grpc+json, which the backend must register. GRPCMethods maps
+	// "VERB /path/{field}" below the service prefix to a method like "/recs.Recs/ForUser";
+	// path fields, and query parameters of GET and DELETE, are merged into the JSON body.
+	// Unmapped calls may name the method directly: POST <prefix>/<package.Service>/<Method>.
+	GRPC        bool
+	GRPCMethods map[string]string
+
+	// Timeout bounds each request to the service, answering 504 when exceeded (0 disables)
+	Timeout time.Duration
+
+	// StripPrefix removes the service prefix (e.g. /api/blog) before forwarding
+	StripPrefix bool
+
+	// HeaderRenames renames request headers before forwarding (from-name to to-name).
+	// The to-name is sent exactly as written, for backends that are picky about case.
+	HeaderRenames map[string]string
+
+	// Credentials identify the gateway to the service on its own calls and proxied requests
+	Credentials ServiceCredentials
+
+	// ClaimHeaders forwards these claims of the authenticated user (claim name to header
+	// name, e.g. email=X-User-Email); other claims are never sent to the service
+	ClaimHeaders map[string]string
+
+	// RequestHeaders transforms request headers before forwarding, after HeaderRenames;
+	// ResponseHeaders transforms the backend's response headers before returning them
+	RequestHeaders  HeaderRules
+	ResponseHeaders HeaderRules
+
+	// StatusRemaps normalize upstream status codes, e.g. a 500 whose body marks a validation
+	// error becomes 400. The first matching rule wins.
+	StatusRemaps []StatusRemap
 }
 
 // Gateway struct
 type Gateway struct {
 	Config    *Config
-	Logger    *log.Logger
+	Logger    *slog.Logger
 	AuthProxy *httputil.ReverseProxy
 	BlogProxy *httputil.ReverseProxy
 	UserProxy *httputil.ReverseProxy
 	AspProxy  *httputil.ReverseProxy
 	Client    *http.Client
+
+	routing  atomic.Pointer[routing] // swapped by Reload
+	reloadMu sync.Mutex
+	options  gatewayOptions
+	ipFilter *ipFilter
+	limiter  rateLimitBackend
+
+	rateLimitCounts *rateLimitCounters
+	cacheRedis      *redisClient // nil unless CacheRedisURL is set
+
+	trustedProxies []netip.Prefix
+	slaRoutes      []*slaRoute
+	captures       *captureStore
+	auditLog       *auditLogger
+	grpcWeb        http.Handler
+	proxies        map[string]http.Handler // replacement reverse proxies from WithProxy
+	accessLog      *log.Logger
+	started        time.Time
+	requests       atomic.Int64 // proxied requests, for /admin/stats
+	inFlight       atomic.Int64
+	shedder        loadShedder
+	schemaRoutes   []schemaRoute
+	staleAuth      *staleAuthCache // nil unless AllowStaleAuthOnOutage
+	jwt            *jwtVerifier    // nil unless LocalJWT is enabled
+	tokenCache     *tokenCache     // nil unless TokenCacheTTL is set
+	oidc           *oidcProvider   // nil unless OIDC.Issuer is set
+	revocations    *revocationList
+	metrics        *metrics
+	tracer         *tracer // nil unless Tracing.OTLPEndpoint is set
+	readiness      *readiness
+	shuttingDown   atomic.Bool
 }
 
 type AuthValidateResponse struct {
 	UserID   string `json:"userID"`
 	Role     string `json:"role"`
 	Username string `json:"username"`
-	Error    string `json:"error,omitempty"`
+	TenantID string `json:"tenantID,omitempty"`
+	// ExpiresAt is the token's expiry as Unix seconds; older AuthService versions omit it
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+	// IssuedAt is when the token was issued as Unix seconds, for user revocations
+	IssuedAt int64 `json:"issuedAt,omitempty"`
+	// Claims carries extra token claims; only those in ServiceConfig.ClaimHeaders are forwarded
+	Claims map[string]any `json:"claims,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+type identityKey struct{}
+
+// identityFrom returns the identity AuthMiddleware validated for r, or nil
+func identityFrom(r *http.Request) *AuthValidateResponse {
+	id, _ := r.Context().Value(identityKey{}).(*AuthValidateResponse)
+	return id
 }
 
+// AuthUnavailableError means the token could not be validated because AuthService
+// was unreachable or misbehaving, as opposed to AuthService rejecting the token
+type AuthUnavailableError struct {
+	Err error
+}
+
+func (e *AuthUnavailableError) Error() string { return e.Err.Error() }
+func (e *AuthUnavailableError) Unwrap() error { return e.Err }
+
 // NewGateway initializes the gateway
-func NewGateway(config *Config, logger *log.Logger) (*Gateway, error) {
-	authURL, err := url.Parse(config.AuthServiceURL)
-	if err != nil {
-		return nil, fmt.Errorf("invalid auth service URL: %w", err)
+func NewGateway(config *Config, logger *slog.Logger, options ...Option) (*Gateway, error) {
+	var opts gatewayOptions
+	for _, o := range options {
+		o(&opts)
 	}
-	blogURL, err := url.Parse(config.BlogServiceURL)
+
+	targets, err := configTargets(config, &opts)
 	if err != nil {
-		return nil, fmt.Errorf("invalid blog service URL: %w", err)
+		return nil, err
 	}
-	userURL, err := url.Parse(config.UserServiceURL)
+
+	ipFilter, err := newIPFilter(config.AllowedCIDRs, config.DeniedCIDRs)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user service URL: %w", err)
+		return nil, err
+	}
+
+	var limiter rateLimitBackend = newRateLimiter()
+	if config.RateLimitRedisURL != "" {
+		if limiter, err = newRedisRateLimiter(config.RateLimitRedisURL, logger); err != nil {
+			return nil, err
+		}
 	}
-	aspURL, err := url.Parse(config.AspServiceURL)
+
+	trustedProxies, err := parsePrefixes(config.TrustedProxyCIDRs)
 	if err != nil {
-		return nil, fmt.Errorf("invalid asp service URL: %w", err)
+		return nil, fmt.Errorf("invalid trusted proxy CIDR: %w", err)
+	}
+	if err := config.CookieAuth.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.OIDC.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateCompositions(config.Compositions, config.Routes); err != nil {
+		return nil, err
+	}
+	if err := config.GraphQL.validate(); err != nil {
+		return nil, err
 	}
 
-	return &Gateway{
-		Config:    config,
-		Logger:    logger,
-		AuthProxy: httputil.NewSingleHostReverseProxy(authURL),
-		BlogProxy: httputil.NewSingleHostReverseProxy(blogURL),
-		UserProxy: httputil.NewSingleHostReverseProxy(userURL),
-		AspProxy:  httputil.NewSingleHostReverseProxy(aspURL),
+	g := &Gateway{
+		Config: config,
+		Logger: slog.New(requestIDLogHandler{logger.Handler()}),
 		Client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
-	}, nil
+		ipFilter: ipFilter,
+		limiter:  limiter,
+		options:  opts,
+
+		trustedProxies: trustedProxies,
+		slaRoutes:      newSLARoutes(config.SLAs),
+		captures:       newCaptureStore(config.CaptureSize),
+		proxies:        opts.proxies,
+		started:        time.Now(),
+		metrics:        newMetrics(),
+		revocations:    newRevocationList(config.Revocation.MaxTokenAge),
+
+		rateLimitCounts: newRateLimitCounters(),
+	}
+	if config.AllowStaleAuthOnOutage {
+		g.staleAuth = newStaleAuthCache(config.StaleAuthMaxAge)
+	}
+	if config.TokenCacheTTL > 0 {
+		g.tokenCache = newTokenCache(config.TokenCacheTTL, config.TokenCacheMaxEntries)
+	}
+	if config.LocalJWT.Enabled {
+		g.jwt = newJWTVerifier(config.LocalJWT)
+	}
+	if config.OIDC.Issuer != "" {
+		g.oidc = newOIDCProvider(config.OIDC)
+	}
+	if opts.client != nil {
+		g.Client = opts.client
+	}
+	if config.Tracing.OTLPEndpoint != "" {
+		g.tracer = newTracer(config.Tracing, g.Client)
+	}
+	if config.CacheRedisURL != "" {
+		if g.cacheRedis, err = newRedisClient(config.CacheRedisURL); err != nil {
+			return nil, err
+		}
+	}
+
+	if g.schemaRoutes, err = loadSchemaRoutes(config.SchemaRules); err != nil {
+		return nil, err
+	}
+	if g.accessLog, err = newAccessLog(config.AccessLogFormat, config.AccessLogPath); err != nil {
+		return nil, err
+	}
+
+	// The audit logger must exist before the upstreams so their handlers include it
+	if config.AuditEnabled {
+		if g.auditLog, err = newAuditLogger(config.AuditLogPath); err != nil {
+			return nil, err
+		}
+	}
+
+	live, _, _, err := g.newRouting(config, targets, nil)
+	if err != nil {
+		return nil, err
+	}
+	g.routing.Store(live)
+	g.checkCookieCORS()
+	critical := config.CriticalServices
+	if critical == nil {
+		critical = DefaultCriticalServices
+	}
+	g.readiness = newReadiness(sortedKeys(live.upstreams), critical)
+	g.AuthProxy = live.upstreams[ServiceAuth].proxy
+	g.BlogProxy = live.upstreams[ServiceBlog].proxy
+	g.UserProxy = live.upstreams[ServiceUser].proxy
+	g.AspProxy = live.upstreams[ServiceAsp].proxy
+
+	if config.AspGRPC {
+		if g.grpcWeb, err = g.newGRPCWebHandler(live.upstreams[ServiceAsp]); err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// setTokenExpiryHeaders tells the client how long its token stays valid, and suggests
+// a refresh once that drops under Config.TokenRefreshThreshold
+func (g *Gateway) setTokenExpiryHeaders(w http.ResponseWriter, identity *AuthValidateResponse) {
+	if identity.ExpiresAt == 0 {
+		return
+	}
+	remaining := max(time.Until(time.Unix(identity.ExpiresAt, 0)), 0)
+	w.Header().Set("X-Token-Expires-In", strconv.Itoa(int(remaining.Seconds())))
+
+	threshold := g.Config.TokenRefreshThreshold
+	if threshold <= 0 {
+		threshold = defaultTokenRefreshThreshold
+	}
+	if remaining < threshold {
+		w.Header().Set("X-Token-Refresh-Suggested", "true")
+	}
+}
+
+// bearerToken extracts the token from an Authorization header. The scheme is matched
+// case-insensitively and any amount of whitespace may surround the token.
+func bearerToken(header string) (string, error) {
+	fields := strings.Fields(header)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "Bearer") {
+		return "", errors.New("invalid Authorization format")
+	}
+	switch len(fields) {
+	case 1:
+		return "", errors.New("missing bearer token")
+	case 2:
+		return fields[1], nil
+	default:
+		return "", errors.New("invalid Authorization format")
+	}
 }
 
+// identityHeaders are set by AuthMiddleware and forwarded on internal calls
+var identityHeaders = []string{"X-User-ID", "X-User-Role", "X-Username", "X-Tenant-ID", identity.SignatureHeader}
+
 // authMiddleware validates JWT for protected routes
 func (g *Gateway) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip auth for /api/auth/*
-		if strings.HasPrefix(r.URL.Path, "/api/auth/") {
+		// Identity headers are only ever set by the gateway, never trusted from the client,
+		// public paths included
+		for _, h := range identityHeaders {
+			r.Header.Del(h)
+		}
+		g.stripClaimHeaders(r)
+		// API keys are for the gateway only
+		var apiKey string
+		if cfg := g.Config.APIKeys; cfg.enabled() {
+			apiKey = r.Header.Get(cfg.header())
+			r.Header.Del(cfg.header())
+		}
+
+		// Skip auth for public paths (/api/auth/* by default)
+		live := g.routing.Load()
+		if live.publicPaths.match(r.URL.Path) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		if apiKey != "" {
+			if r = g.apiKeyAuth(w, r, apiKey); r != nil {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
 		authHeader := r.Header.Get("Authorization")
+		if token := g.cookieToken(r); authHeader == "" && token != "" {
+			authHeader = "Bearer " + token
+		}
+		if token := websocketToken(r); authHeader == "" && token != "" {
+			authHeader = "Bearer " + token
+		}
+		if authHeader == "" && g.oidc != nil {
+			if identity, session := g.oidc.session(r); identity != nil && !g.revocations.revoked(session, identity) {
+				g.metrics.observeAuth(authSuccess)
+				r = g.setIdentity(r, identity)
+				g.setTokenExpiryHeaders(w, identity)
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if authHeader == "" && live.optionalAuthPaths.match(r.URL.Path) {
+			g.metrics.observeAuth(authAnonymous)
+			next.ServeHTTP(w, r)
+			return
+		}
 		if authHeader == "" {
-			http.Error(w, "missing Authorization header", http.StatusUnauthorized)
+			g.metrics.observeAuth(authMissing)
+			writeJSONError(w, http.StatusUnauthorized, "missing Authorization header")
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "invalid Authorization format", http.StatusUnauthorized)
+		token, err := bearerToken(authHeader)
+		if err != nil {
+			g.metrics.observeAuth(authInvalid)
+			writeJSONError(w, http.StatusUnauthorized, err.Error())
 			return
 		}
-		userID, role, username, err := g.validateJWT(parts[1])
-		if err != nil {
-			g.Logger.Printf("JWT validation failed: %v", err)
-			http.Error(w, "invalid JWT", http.StatusUnauthorized)
+		tl := timelineFrom(r.Context())
+		if tl != nil {
+			tl.mark("auth_start")
+		}
+		identity, err := g.authenticate(r.Context(), token)
+		if tl != nil {
+			tl.mark("auth_end")
+		}
+		var unavailable *AuthUnavailableError
+		switch {
+		case err != nil && deadlineExceeded(r):
+			g.Logger.WarnContext(r.Context(), "JWT validation ran out of request budget", "error", err)
+			g.metrics.observeAuth(authDeadline)
+			writeDeadlineExceeded(w, r)
+			return
+		case err == nil:
+			g.metrics.observeAuth(authSuccess)
+			if g.staleAuth != nil {
+				g.staleAuth.store(token, identity)
+			}
+		case errors.As(err, &unavailable) && g.staleAuth != nil:
+			stale, age, ok := g.staleAuth.lookup(token)
+			if !ok || g.revocations.revoked(token, stale) {
+				g.Logger.ErrorContext(r.Context(), "JWT validation failed", "error", err)
+				g.metrics.observeAuth(authUnavailable)
+				writeJSONError(w, http.StatusServiceUnavailable, "authentication service unavailable")
+				return
+			}
+			g.metrics.observeAuth(authStale)
+			g.Logger.WarnContext(r.Context(), "JWT validation failed, using stale result", "error", err, "user_id", stale.UserID, "age", age.Truncate(time.Second))
+			identity = stale
+		default:
+			g.Logger.InfoContext(r.Context(), "JWT validation failed", "error", err)
+			if errors.As(err, &unavailable) {
+				g.metrics.observeAuth(authUnavailable)
+				writeJSONError(w, http.StatusServiceUnavailable, "authentication service unavailable")
+				return
+			}
+			if errors.Is(err, errTokenRevoked) {
+				g.metrics.observeAuth(authRevoked)
+			} else {
+				g.metrics.observeAuth(authInvalid)
+			}
+			writeJSONError(w, http.StatusUnauthorized, "invalid JWT")
 			return
 		}
 
-		// Add userID, role, and username to request headers
-		r.Header.Set("X-User-ID", userID)
-		r.Header.Set("X-User-Role", role)
-		r.Header.Set("X-Username", username)
+		r = g.setIdentity(r, identity)
+		g.setTokenExpiryHeaders(w, identity)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-// proxyHandler forwards requests to the target proxy
-func (g *Gateway) ProxyHandler(proxy *httputil.ReverseProxy, targetURL string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		g.Logger.Printf("Forwarding %s %s to %s", r.Method, r.URL.Path, targetURL)
-		proxy.ServeHTTP(w, r)
+// setIdentity attaches the authenticated identity to the request and its headers
+func (g *Gateway) setIdentity(r *http.Request, identity *AuthValidateResponse) *http.Request {
+	r = r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+	g.trustTimeline(r, identity.Role)
+	if e := accessEntryFrom(r.Context()); e != nil {
+		e.user = identity.Username
+	}
+
+	// Add userID, role, and username to request headers
+	r.Header.Set("X-User-ID", identity.UserID)
+	r.Header.Set("X-User-Role", identity.Role)
+	r.Header.Set("X-Username", identity.Username)
+	if identity.TenantID != "" {
+		r.Header.Set("X-Tenant-ID", identity.TenantID)
 	}
+	g.signIdentity(r, identity)
+	return r
 }
 
 // validateJWT sends a request to AuthService to validate the JWT
-func (g *Gateway) validateJWT(token string) (string, string, string, error) {
-	g.Logger.Printf("Authorizing... Forwarding requet")
+// The request is bound to ctx so a client that goes away also cancels validation.
+// While AuthService's circuit is open it fails fast with AuthUnavailableError.
+func (g *Gateway) validateJWT(ctx context.Context, token string) (*AuthValidateResponse, error) {
+	g.Logger.DebugContext(ctx, "Validating token with AuthService")
+
+	auth := g.upstreams()[ServiceAuth]
+	if auth.breaker != nil {
+		if ok, _ := auth.breaker.allow(); !ok {
+			return nil, &AuthUnavailableError{Err: errors.New("AuthService circuit is open")}
+		}
+	}
+	var s *span
+	if g.tracer != nil {
+		ctx, s = g.tracer.startSpan(ctx, "POST auth validate", spanKindClient)
+		s.attrs["peer.service"] = ServiceAuth
+	}
+	identity, err := g.requestValidation(ctx, auth.pick().url, token)
+	if s != nil {
+		if err != nil {
+			s.attrs["error.type"] = err.Error()
+		}
+		s.failed = errors.As(err, new(*AuthUnavailableError))
+		g.tracer.finish(s)
+	}
+	if auth.breaker != nil {
+		var unavailable *AuthUnavailableError
+		if errors.Is(ctx.Err(), context.Canceled) {
+			auth.breaker.release()
+		} else {
+			g.recordBreaker(auth, !errors.As(err, &unavailable))
+		}
+	}
+	return identity, err
+}
 
-	req, err := http.NewRequest("POST", g.Config.AuthServiceURL+"/api/auth/jwt", nil)
+// requestValidation asks one AuthService instance to validate the JWT
+func (g *Gateway) requestValidation(ctx context.Context, authURL *url.URL, token string) (*AuthValidateResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(authURL.String(), "/")+"/api/auth/jwt", nil)
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to create validation request: %w", err)
+		return nil, fmt.Errorf("failed to create validation request: %w", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+token)
+	if s := spanFrom(ctx); s != nil {
+		req.Header.Set("traceparent", s.traceparent())
+	}
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
 
-	resp, err := g.Client.Do(req)
+	resp, err := g.upstreams()[ServiceAuth].client.Do(req)
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to contact AuthService: %w", err)
+		return nil, &AuthUnavailableError{Err: fmt.Errorf("failed to contact AuthService: %w", err)}
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 500 {
+		return nil, &AuthUnavailableError{Err: fmt.Errorf("AuthService returned status: %d", resp.StatusCode)}
+	}
 	if resp.StatusCode != http.StatusOK {
 		var authResp AuthValidateResponse
 		if err := json.NewDecoder(resp.Body).Decode(&authResp); err == nil && authResp.Error != "" {
-			return "", "", "", fmt.Errorf("AuthService error: %s", authResp.Error)
+			return nil, fmt.Errorf("AuthService error: %s", authResp.Error)
 		}
-		return "", "", "", fmt.Errorf("AuthService returned status: %d", resp.StatusCode)
+		return nil, fmt.Errorf("AuthService returned status: %d", resp.StatusCode)
 	}
 
 	var authResp AuthValidateResponse
 	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
-		return "", "", "", fmt.Errorf("failed to decode AuthService response: %w", err)
+		return nil, &AuthUnavailableError{Err: fmt.Errorf("failed to decode AuthService response: %w", err)}
 	}
 
 	if authResp.UserID == "" || authResp.Role == "" {
-		return "", "", "", fmt.Errorf("invalid AuthService response: missing userID or role")
+		return nil, &AuthUnavailableError{Err: fmt.Errorf("invalid AuthService response: missing userID or role")}
 	}
 
-	return authResp.UserID, authResp.Role, authResp.Username, nil
+	return &authResp, nil
 }
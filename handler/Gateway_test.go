@@ -0,0 +1,316 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testUser is the identity testAuthService returns for a token
+type testUser = AuthValidateResponse
+
+// testAuthService validates the bearer tokens in users
+func testAuthService(t *testing.T, users map[string]testUser) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := users[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		if r.URL.Path != "/api/auth/jwt" || !ok {
+			writeJSON(w, http.StatusUnauthorized, AuthValidateResponse{Error: "invalid token"})
+			return
+		}
+		writeJSON(w, http.StatusOK, user)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testUpstream is a service answering with the user the gateway forwarded and how many
+// calls it has served
+type testUpstream struct {
+	*httptest.Server
+	calls atomic.Int64
+}
+
+type testUpstreamResponse struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Query  string      `json:"query,omitempty"`
+	UserID string      `json:"userID"`
+	Header http.Header `json:"header"`
+	Call   int64       `json:"call"`
+}
+
+func newTestUpstream(t *testing.T) *testUpstream {
+	t.Helper()
+	up := &testUpstream{}
+	up.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, testUpstreamResponse{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.RawQuery,
+			UserID: r.Header.Get("X-User-ID"),
+			Header: r.Header,
+			Call:   up.calls.Add(1),
+		})
+	}))
+	t.Cleanup(up.Close)
+	return up
+}
+
+// newTestGateway builds a gateway from config, pointing services without a URL at a
+// server that answers 404
+func newTestGateway(t *testing.T, config *Config, options ...Option) *Gateway {
+	t.Helper()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(missing.Close)
+	for _, u := range []*string{&config.AuthServiceURL, &config.BlogServiceURL, &config.UserServiceURL, &config.AspServiceURL} {
+		if *u == "" {
+			*u = missing.URL
+		}
+	}
+	g, err := NewGateway(config, slog.New(slog.NewTextHandler(io.Discard, nil)), options...)
+	if err != nil {
+		t.Fatalf("NewGateway: %v", err)
+	}
+	return g
+}
+
+// serve runs req through h and decodes the JSON response into v, when given
+func serve(t *testing.T, h http.Handler, req *http.Request, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: invalid JSON response %q: %v", req.Method, req.URL, rec.Body.String(), err)
+		}
+	}
+	return rec
+}
+
+// serveAborting serves req like http.Server, where the proxy aborts a response it
+// can't finish by panicking with http.ErrAbortHandler; it reports whether it did
+func serveAborting(h http.Handler, req *http.Request) (aborted bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			if p != http.ErrAbortHandler {
+				panic(p)
+			}
+			aborted = true
+		}
+	}()
+	req = req.WithContext(context.WithValue(req.Context(), http.ServerContextKey, &http.Server{}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	return false
+}
+
+// cutOffResponse sends a 200 promising more body than it writes, then closes the
+// connection
+func cutOffResponse(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"partial\":")
+}
+
+func TestAuthValidationUsesServiceTransport(t *testing.T) {
+	// auth.invalid only resolves through the auth service's egress proxy
+	var proxied atomic.Int64
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "auth.invalid" || r.URL.Path != "/api/auth/jwt" {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadGateway)
+			return
+		}
+		proxied.Add(1)
+		writeJSON(w, http.StatusOK, testUser{UserID: "alice", Role: "user"})
+	}))
+	defer proxy.Close()
+	users := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		AuthServiceURL: "http://auth.invalid",
+		UserServiceURL: users.URL,
+		Services:       map[string]ServiceConfig{ServiceAuth: {EgressProxy: proxy.URL}},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/profile", nil)
+	req.Header.Set("Authorization", "Bearer alice-token")
+	var resp testUpstreamResponse
+	if rec := serve(t, g.AuthMiddleware(g.ProxyHandler(ServiceUser)), req, &resp); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if resp.UserID != "alice" || proxied.Load() != 1 {
+		t.Fatalf("user %q after %d proxied validations, want alice after 1", resp.UserID, proxied.Load())
+	}
+}
+
+// captureLog sends g's log, debug level included, to the returned buffer as JSON lines
+func captureLog(g *Gateway) *bytes.Buffer {
+	var buf bytes.Buffer
+	g.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return &buf
+}
+
+func TestPublicPathsSkipAuthentication(t *testing.T) {
+	auth := testAuthService(t, nil)
+	blog := newTestUpstream(t)
+	for _, tt := range []struct {
+		name        string
+		publicPaths []string
+		public      []string
+		private     []string
+	}{
+		{"default", nil, []string{"/api/auth/login"}, []string{"/api/blog/posts", "/api/auth"}},
+		{"configured", []string{"/api/auth/", "/api/blog/posts/**", "^/healthz$"},
+			[]string{"/api/auth/login", "/api/blog/posts", "/api/blog/posts/7", "/healthz"},
+			[]string{"/api/blog/drafts", "/api/blog/postsx", "/healthz/deep"}},
+	} {
+		g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, BlogServiceURL: blog.URL, PublicPaths: tt.publicPaths})
+		h := g.AuthMiddleware(g.ProxyHandler(ServiceBlog))
+		for _, path := range tt.public {
+			if rec := serve(t, h, httptest.NewRequest(http.MethodGet, path, nil), nil); rec.Code != http.StatusOK {
+				t.Errorf("%s: anonymous %s got %d, want it public", tt.name, path, rec.Code)
+			}
+		}
+		for _, path := range tt.private {
+			if rec := serve(t, h, httptest.NewRequest(http.MethodGet, path, nil), nil); rec.Code != http.StatusUnauthorized {
+				t.Errorf("%s: anonymous %s got %d, want 401", tt.name, path, rec.Code)
+			}
+		}
+	}
+
+	config := &Config{AuthServiceURL: auth.URL, BlogServiceURL: blog.URL, UserServiceURL: blog.URL, AspServiceURL: blog.URL, PublicPaths: []string{"^/api/(blog"}}
+	if _, err := NewGateway(config, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("NewGateway accepted an invalid public path pattern")
+	}
+}
+
+func TestOptionalAuthPaths(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"alice-token": {UserID: "alice", Role: "user"}})
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, BlogServiceURL: blog.URL, OptionalAuthPaths: []string{"/api/blog/posts/**"}})
+	h := g.AuthMiddleware(g.ProxyHandler(ServiceBlog))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+	req.Header.Set("X-User-ID", "mallory")
+	var resp testUpstreamResponse
+	if rec := serve(t, h, req, &resp); rec.Code != http.StatusOK || resp.UserID != "" {
+		t.Fatalf("anonymous request got %d as user %q, want 200 without a user", rec.Code, resp.UserID)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/blog/posts/7", nil)
+	req.Header.Set("Authorization", "Bearer alice-token")
+	if rec := serve(t, h, req, &resp); rec.Code != http.StatusOK || resp.UserID != "alice" {
+		t.Fatalf("authenticated request got %d as user %q, want 200 as alice", rec.Code, resp.UserID)
+	}
+
+	// A token that is sent must still be valid
+	req = httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+	req.Header.Set("Authorization", "Bearer forged")
+	if rec := serve(t, h, req, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("invalid token got %d, want 401", rec.Code)
+	}
+	if rec := serve(t, h, httptest.NewRequest(http.MethodGet, "/api/blog/drafts", nil), nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous request outside the optional paths got %d, want 401", rec.Code)
+	}
+}
+
+func TestAuthServiceOutagesAreNotInvalidTokens(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	garbled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>maintenance</html>"))
+	}))
+	defer garbled.Close()
+	blog := newTestUpstream(t)
+
+	for _, tt := range []struct {
+		name, authURL string
+		want          int
+	}{
+		{"rejected token", testAuthService(t, nil).URL, http.StatusUnauthorized},
+		{"unreachable AuthService", down.URL, http.StatusServiceUnavailable},
+		{"AuthService 5xx", failing.URL, http.StatusServiceUnavailable},
+		{"undecodable response", garbled.URL, http.StatusServiceUnavailable},
+	} {
+		g := newTestGateway(t, &Config{AuthServiceURL: tt.authURL, BlogServiceURL: blog.URL})
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		if rec := serve(t, g.AuthMiddleware(g.ProxyHandler(ServiceBlog)), req, nil); rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+	if blog.calls.Load() != 0 {
+		t.Errorf("blog service got %d unauthenticated calls", blog.calls.Load())
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	for _, tt := range []struct {
+		header, token string
+		ok            bool
+	}{
+		{"Bearer abc.def", "abc.def", true},
+		{"bearer abc.def", "abc.def", true},
+		{"BEARER   abc.def", "abc.def", true},
+		{"  Bearer\tabc.def  ", "abc.def", true},
+		{"", "", false},
+		{"Bearer", "", false},
+		{"Bearer   ", "", false},
+		{"Basic dXNlcjpwYXNz", "", false},
+		{"Bearerabc.def", "", false},
+		{"Bearer abc def", "", false},
+	} {
+		token, err := bearerToken(tt.header)
+		if token != tt.token || (err == nil) != tt.ok {
+			t.Errorf("bearerToken(%q) = %q, %v; want %q, ok %v", tt.header, token, err, tt.token, tt.ok)
+		}
+	}
+}
+
+func TestTokenExpiryHeaders(t *testing.T) {
+	now := time.Now()
+	auth := testAuthService(t, map[string]testUser{
+		"long-token":   {UserID: "alice", Role: "user", ExpiresAt: now.Add(time.Hour).Unix()},
+		"short-token":  {UserID: "bob", Role: "user", ExpiresAt: now.Add(2 * time.Minute).Unix()},
+		"legacy-token": {UserID: "carol", Role: "user"},
+	})
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, BlogServiceURL: blog.URL})
+
+	for _, tt := range []struct {
+		token            string
+		minIn, maxIn     int
+		refresh, present bool
+	}{
+		{"long-token", 3590, 3600, false, true},
+		{"short-token", 110, 120, true, true},
+		{"legacy-token", 0, 0, false, false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := serve(t, g.AuthMiddleware(g.ProxyHandler(ServiceBlog)), req, nil)
+		raw := rec.Header().Get("X-Token-Expires-In")
+		in, err := strconv.Atoi(raw)
+		if tt.present && (err != nil || in < tt.minIn || in > tt.maxIn) || !tt.present && raw != "" {
+			t.Errorf("%s: X-Token-Expires-In %q, want %d..%d", tt.token, raw, tt.minIn, tt.maxIn)
+		}
+		if refresh := rec.Header().Get("X-Token-Refresh-Suggested") == "true"; refresh != tt.refresh {
+			t.Errorf("%s: refresh suggested %v, want %v", tt.token, refresh, tt.refresh)
+		}
+	}
+}
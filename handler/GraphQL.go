@@ -0,0 +1,555 @@
+package handler
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GraphQL defaults
+const (
+	GraphQLPath                  = "/graphql"
+	defaultGraphQLMaxDepth       = 10
+	defaultGraphQLMaxConcurrency = 8
+	graphQLMaxResponse           = 1 << 20 // per resolver call
+)
+
+// GraphQLConfig exposes the REST services as one GraphQL endpoint, so clients can fetch
+// nested data (a user, their posts and the posts' comments) in one round trip. Resolver
+// calls go through the gateway's routes with the caller's credentials, so each one is
+// authenticated, authorized and rate limited like a request of its own.
+type GraphQLConfig struct {
+	Enabled bool
+
+	// Schema declares the resolvers; nil means DefaultGraphQLSchema
+	Schema GraphQLSchema
+
+	// MaxDepth bounds how deeply queries nest (default 10)
+	MaxDepth int
+
+	// MaxConcurrency bounds the upstream calls one request makes at once (default 8)
+	MaxConcurrency int
+}
+
+// GraphQLSchema maps type names, starting from Query and Mutation, to resolvers for
+// their fields. Fields without a resolver are read from the parent object as returned by
+// its service, so only fields that need another call are declared.
+type GraphQLSchema map[string]map[string]GraphQLResolver
+
+// GraphQLResolver resolves a field with one API call, each Config.AggregateTimeout
+// at most
+type GraphQLResolver struct {
+	Method string `json:"method,omitempty"` // default GET
+
+	// Path is a gateway path such as /api/blog/posts/{id}?tag={tag}: {name} is replaced by
+	// the field's argument and {parent.name} by a field of the parent object. Path
+	// placeholders are required; query parameters with no value are left out.
+	Path string `json:"path"`
+
+	// Body sends this argument as the JSON request body
+	Body string `json:"body,omitempty"`
+
+	// Type names the result's type, whose resolvers apply to its fields (for a list,
+	// to each item's)
+	Type string `json:"type,omitempty"`
+}
+
+// DefaultGraphQLSchema covers the auth, user and blog services
+var DefaultGraphQLSchema = GraphQLSchema{
+	"Query": {
+		"me":    {Path: "/api/user/profile", Type: "User"},
+		"user":  {Path: "/api/user/users/{id}", Type: "User"},
+		"posts": {Path: "/api/blog/posts?authorId={authorId}", Type: "Post"},
+		"post":  {Path: "/api/blog/posts/{id}", Type: "Post"},
+	},
+	"Mutation": {
+		"login":      {Method: http.MethodPost, Path: "/api/auth/login", Body: "input"},
+		"createPost": {Method: http.MethodPost, Path: "/api/blog/posts", Body: "input", Type: "Post"},
+		"addComment": {Method: http.MethodPost, Path: "/api/blog/posts/{postId}/comments", Body: "input", Type: "Comment"},
+	},
+	"User": {
+		"posts": {Path: "/api/blog/posts?authorId={parent.id}", Type: "Post"},
+	},
+	"Post": {
+		"author":   {Path: "/api/user/users/{parent.authorId}", Type: "User"},
+		"comments": {Path: "/api/blog/posts/{parent.id}/comments", Type: "Comment"},
+	},
+	"Comment": {
+		"author": {Path: "/api/user/users/{parent.authorId}", Type: "User"},
+	},
+}
+
+// LoadGraphQLSchema reads a JSON schema file in the shape of GraphQLSchema, e.g.
+// {"Query":{"me":{"path":"/api/user/profile","type":"User"}}}
+func LoadGraphQLSchema(path string) (GraphQLSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GraphQL schema file: %w", err)
+	}
+	var schema GraphQLSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid GraphQL schema file %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+func (c GraphQLConfig) withDefaults() GraphQLConfig {
+	if c.Schema == nil {
+		c.Schema = DefaultGraphQLSchema
+	}
+	if c.MaxDepth <= 0 {
+		c.MaxDepth = defaultGraphQLMaxDepth
+	}
+	if c.MaxConcurrency <= 0 {
+		c.MaxConcurrency = defaultGraphQLMaxConcurrency
+	}
+	return c
+}
+
+// validate checks the paths of the schema's resolvers
+func (c GraphQLConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	for typ, fields := range c.withDefaults().Schema {
+		for name, res := range fields {
+			pathPart, _, _ := strings.Cut(res.Path, "?")
+			switch {
+			case !strings.HasPrefix(res.Path, "/"):
+				return fmt.Errorf("GraphQL field %s.%s: path must start with /", typ, name)
+			case path.Clean(pathPart) == GraphQLPath || path.Clean(pathPart) == BatchPath:
+				return fmt.Errorf("GraphQL field %s.%s: path can't be %s", typ, name, pathPart)
+			}
+		}
+	}
+	return nil
+}
+
+// graphQLRequest is a GraphQL-over-HTTP request, as a JSON body or GET query parameters
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// gqlError is an entry of the response's errors
+type gqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// GraphQLHandler executes GraphQL queries and mutations (GET or POST /graphql), resolving
+// fields through routes, normally the gateway's router. Requests that don't parse get
+// 400; errors of individual fields are reported next to the data.
+func (g *Gateway) GraphQLHandler(routes http.Handler) http.HandlerFunc {
+	config := g.Config.GraphQL.withDefaults()
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		if r.Method == http.MethodGet {
+			q := r.URL.Query()
+			req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+			if v := q.Get("variables"); v != "" {
+				dec := json.NewDecoder(strings.NewReader(v))
+				dec.UseNumber()
+				if err := dec.Decode(&req.Variables); err != nil {
+					writeGraphQLError(w, http.StatusBadRequest, "invalid variables")
+					return
+				}
+			}
+		} else {
+			dec := json.NewDecoder(r.Body)
+			dec.UseNumber()
+			if err := dec.Decode(&req); err != nil {
+				writeGraphQLError(w, http.StatusBadRequest, "invalid GraphQL request")
+				return
+			}
+		}
+
+		doc, err := parseGraphQL(req.Query)
+		if err != nil {
+			writeGraphQLError(w, http.StatusBadRequest, "syntax error: "+err.Error())
+			return
+		}
+		var op *gqlOperation
+		for _, o := range doc.operations {
+			if o.name == req.OperationName || req.OperationName == "" && len(doc.operations) == 1 {
+				op = o
+			}
+		}
+		switch {
+		case op == nil:
+			writeGraphQLError(w, http.StatusBadRequest, "unknown or ambiguous operation")
+			return
+		case op.kind == "mutation" && r.Method == http.MethodGet:
+			w.Header().Set("Allow", http.MethodPost)
+			writeGraphQLError(w, http.StatusMethodNotAllowed, "mutations must be sent with POST")
+			return
+		}
+
+		e := &gqlExecution{
+			g:         g,
+			r:         r,
+			routes:    routes,
+			config:    config,
+			fragments: doc.fragments,
+			variables: make(map[string]any, len(op.variables)),
+			calls:     make(chan struct{}, config.MaxConcurrency),
+		}
+		for name, def := range op.variables {
+			e.variables[name] = def
+			if v, ok := req.Variables[name]; ok {
+				e.variables[name] = v
+			}
+		}
+		root := "Query"
+		if op.kind == "mutation" {
+			root = "Mutation"
+		}
+		// Mutations run one after another, as they may depend on each other
+		data := e.object(r.Context(), root, nil, op.selections, nil, 1, op.kind == "mutation")
+
+		resp := map[string]any{"data": data}
+		if len(e.errors) > 0 {
+			resp["errors"] = e.errors
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func writeGraphQLError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{"errors": []gqlError{{Message: message}}})
+}
+
+// gqlExecution is the state of one request's execution
+type gqlExecution struct {
+	g         *Gateway
+	r         *http.Request
+	routes    http.Handler
+	config    GraphQLConfig
+	fragments map[string][]*gqlSelection
+	variables map[string]any
+	calls     chan struct{} // semaphore of upstream calls in flight
+
+	mu     sync.Mutex
+	errors []gqlError
+}
+
+func (e *gqlExecution) fail(path []any, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errors = append(e.errors, gqlError{Message: err.Error(), Path: path})
+}
+
+// object resolves the selections on a parent object of type typ, concurrently unless serial
+func (e *gqlExecution) object(ctx context.Context, typ string, parent map[string]any, selections []*gqlSelection, path []any, depth int, serial bool) *gqlObject {
+	fields, err := e.collect(selections, nil)
+	if err != nil {
+		e.fail(path, err)
+		return nil
+	}
+	obj := &gqlObject{keys: make([]string, len(fields)), values: make([]any, len(fields))}
+	var wg sync.WaitGroup
+	for i, f := range fields {
+		obj.keys[i] = f.key()
+		if serial {
+			obj.values[i] = e.field(ctx, typ, parent, f, path, depth)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			obj.values[i] = e.field(ctx, typ, parent, f, path, depth)
+		}()
+	}
+	wg.Wait()
+	return obj
+}
+
+// collect flattens fragments into the fields to resolve, dropping skipped ones and
+// repeated response keys
+func (e *gqlExecution) collect(selections []*gqlSelection, visiting []string) ([]*gqlField, error) {
+	var fields []*gqlField
+	seen := make(map[string]bool)
+	for _, sel := range selections {
+		include, err := e.included(sel.directives)
+		if err != nil {
+			return nil, err
+		}
+		if !include {
+			continue
+		}
+		var nested []*gqlField
+		switch {
+		case sel.field != nil:
+			nested = []*gqlField{sel.field}
+		case sel.fragment != "":
+			frag, ok := e.fragments[sel.fragment]
+			if !ok {
+				return nil, fmt.Errorf("unknown fragment %s", sel.fragment)
+			}
+			if slices.Contains(visiting, sel.fragment) {
+				return nil, fmt.Errorf("fragment %s spreads itself", sel.fragment)
+			}
+			if nested, err = e.collect(frag, append(slices.Clip(visiting), sel.fragment)); err != nil {
+				return nil, err
+			}
+		default:
+			if nested, err = e.collect(sel.inline, visiting); err != nil {
+				return nil, err
+			}
+		}
+		for _, f := range nested {
+			if !seen[f.key()] {
+				seen[f.key()] = true
+				fields = append(fields, f)
+			}
+		}
+	}
+	return fields, nil
+}
+
+// included applies @skip and @include
+func (e *gqlExecution) included(directives []gqlDirective) (bool, error) {
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			return false, fmt.Errorf("unknown directive @%s", d.name)
+		}
+		v, err := e.value(d.args["if"])
+		if err != nil {
+			return false, err
+		}
+		cond, ok := v.(bool)
+		if !ok {
+			return false, fmt.Errorf("@%s needs a boolean if argument", d.name)
+		}
+		if cond == (d.name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// field resolves one field of a parent object of type typ
+func (e *gqlExecution) field(ctx context.Context, typ string, parent map[string]any, f *gqlField, path []any, depth int) any {
+	path = append(slices.Clip(path), f.key())
+	if f.name == "__typename" {
+		return typ
+	}
+	if depth > e.config.MaxDepth {
+		e.fail(path, fmt.Errorf("query is nested more than %d levels deep", e.config.MaxDepth))
+		return nil
+	}
+
+	var value any
+	res, ok := e.config.Schema[typ][f.name]
+	switch {
+	case ok:
+		args, err := e.value(f.args)
+		if err == nil {
+			value, err = e.resolve(ctx, res, args.(map[string]any), parent)
+		}
+		if err != nil {
+			e.fail(path, err)
+			return nil
+		}
+		typ = res.Type
+	case parent != nil:
+		value, typ = parent[f.name], ""
+	default:
+		e.fail(path, fmt.Errorf("unknown field %s on %s", f.name, typ))
+		return nil
+	}
+	return e.complete(ctx, typ, value, f, path, depth)
+}
+
+// complete applies a field's selections to its value
+func (e *gqlExecution) complete(ctx context.Context, typ string, value any, f *gqlField, path []any, depth int) any {
+	if len(f.selections) == 0 || value == nil {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		return e.object(ctx, typ, v, f.selections, path, depth+1, false)
+	case []any:
+		items := make([]any, len(v))
+		var wg sync.WaitGroup
+		for i, item := range v {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				items[i] = e.complete(ctx, typ, item, f, append(slices.Clip(path), i), depth)
+			}()
+		}
+		wg.Wait()
+		return items
+	default:
+		e.fail(path, fmt.Errorf("field %s has no subfields to select", f.name))
+		return nil
+	}
+}
+
+// value substitutes variables in an argument value
+func (e *gqlExecution) value(v any) (any, error) {
+	switch v := v.(type) {
+	case gqlVariable:
+		val, ok := e.variables[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s is not declared", v)
+		}
+		return val, nil
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = e.value(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case map[string]any:
+		obj := make(map[string]any, len(v))
+		for k, item := range v {
+			var err error
+			if obj[k], err = e.value(item); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	default:
+		return v, nil
+	}
+}
+
+// resolve makes a resolver's API call
+func (e *gqlExecution) resolve(ctx context.Context, res GraphQLResolver, args, parent map[string]any) (any, error) {
+	target, err := resolverPath(res.Path, args, parent)
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if res.Body != "" {
+		if body, err = json.Marshal(args[res.Body]); err != nil {
+			return nil, err
+		}
+	}
+
+	e.calls <- struct{}{}
+	defer func() { <-e.calls }()
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(e.g.Config.AggregateTimeout, defaultAggregateTimeout))
+	defer cancel()
+	req := subRequest(e.r.WithContext(ctx), cmp.Or(res.Method, http.MethodGet), target, body)
+	req.Header.Set("Accept", "application/json")
+	resp, err := serveBuffered(e.routes, req, graphQLMaxResponse)
+	if err != nil {
+		return nil, err
+	}
+	if resp.status < 200 || resp.status >= 300 {
+		return nil, fmt.Errorf("%s %s returned status %d", req.Method, target.Path, resp.status)
+	}
+	dec := json.NewDecoder(&resp.body)
+	dec.UseNumber()
+	var value any
+	return value, dec.Decode(&value)
+}
+
+var resolverPlaceholder = regexp.MustCompile(`\{(parent\.)?(\w+)\}`)
+
+// resolverPath fills a resolver's path template from the field's arguments and parent.
+// Values can't make up a dot segment, which would climb out of the template's path.
+func resolverPath(template string, args, parent map[string]any) (*url.URL, error) {
+	lookup := func(placeholder string) (string, bool) {
+		m := resolverPlaceholder.FindStringSubmatch(placeholder)
+		source := args
+		if m[1] != "" {
+			source = parent
+		}
+		switch v := source[m[2]].(type) {
+		case nil:
+			return "", false
+		case string:
+			return v, true
+		case json.Number:
+			return v.String(), true
+		case bool:
+			return strconv.FormatBool(v), true
+		default:
+			data, _ := json.Marshal(v)
+			return string(data), true
+		}
+	}
+
+	pathPart, query, _ := strings.Cut(template, "?")
+	var invalid error
+	pathPart = resolverPlaceholder.ReplaceAllStringFunc(pathPart, func(placeholder string) string {
+		v, ok := lookup(placeholder)
+		switch {
+		case invalid != nil:
+		case !ok:
+			invalid = fmt.Errorf("missing value for %s", placeholder)
+		case v == "" || v == "." || v == "..":
+			invalid = fmt.Errorf("invalid value %q for %s", v, placeholder)
+		}
+		return url.PathEscape(v)
+	})
+	if invalid != nil {
+		return nil, invalid
+	}
+
+	var params []string
+	for _, param := range strings.Split(query, "&") {
+		name, value, _ := strings.Cut(param, "=")
+		if name == "" {
+			continue
+		}
+		present := true
+		value = resolverPlaceholder.ReplaceAllStringFunc(value, func(placeholder string) string {
+			v, ok := lookup(placeholder)
+			present = present && ok
+			return v
+		})
+		if present {
+			params = append(params, name+"="+url.QueryEscape(value))
+		}
+	}
+	if len(params) > 0 {
+		pathPart += "?" + strings.Join(params, "&")
+	}
+	return url.ParseRequestURI(pathPart)
+}
+
+// gqlObject is a response object keeping its fields in selection order
+type gqlObject struct {
+	keys   []string
+	values []any
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
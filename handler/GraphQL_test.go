@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGraphQLResolvesNestedFields(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"alice-token": {UserID: "alice", Role: "user"}})
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if id == "profile" {
+			id = r.Header.Get("X-User-ID")
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "name": strings.ToUpper(id)})
+	}))
+	defer users.Close()
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("X-User-ID") != "alice":
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "no identity"})
+		case strings.HasSuffix(r.URL.Path, "/comments"):
+			writeJSON(w, http.StatusOK, []map[string]any{{"id": 7, "text": "nice", "authorId": "bob"}})
+		default:
+			author := r.URL.Query().Get("authorId")
+			writeJSON(w, http.StatusOK, []map[string]any{{"id": 1, "title": "hello", "authorId": author}, {"id": 2, "title": "again", "authorId": author}})
+		}
+	}))
+	defer blog.Close()
+	g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, UserServiceURL: users.URL, BlogServiceURL: blog.URL,
+		GraphQL:     GraphQLConfig{Enabled: true},
+		AccessRules: []AccessRule{{Path: "/api/blog/**", Methods: []string{http.MethodPost}, Roles: []string{"admin"}}}})
+	h := http.NewServeMux()
+	h.Handle("/api/user/", g.AuthMiddleware(g.AuthorizationMiddleware(g.ProxyHandler(ServiceUser))))
+	h.Handle("/api/blog/", g.AuthMiddleware(g.AuthorizationMiddleware(g.ProxyHandler(ServiceBlog))))
+	h.Handle(GraphQLPath, g.GraphQLHandler(h))
+
+	query := func(method string, body graphQLRequest) (int, string) {
+		var req *http.Request
+		if method == http.MethodGet {
+			req = httptest.NewRequest(method, GraphQLPath+"?query="+url.QueryEscape(body.Query), nil)
+		} else {
+			data, _ := json.Marshal(body)
+			req = httptest.NewRequest(method, GraphQLPath, strings.NewReader(string(data)))
+		}
+		req.Header.Set("Authorization", "Bearer alice-token")
+		rec := serve(t, h, req, nil)
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+
+	status, body := query(http.MethodPost, graphQLRequest{
+		Query: `query Feed($who: ID!) {
+			me { name posts { title } }
+			other: user(id: $who) { ...names posts { id comments { text author { name } } } }
+		}
+		fragment names on User { __typename name }`,
+		Variables: map[string]any{"who": "carol"},
+	})
+	want := `{"data":{"me":{"name":"ALICE","posts":[{"title":"hello"},{"title":"again"}]},` +
+		`"other":{"__typename":"User","name":"CAROL","posts":[` +
+		`{"id":1,"comments":[{"text":"nice","author":{"name":"BOB"}}]},` +
+		`{"id":2,"comments":[{"text":"nice","author":{"name":"BOB"}}]}]}}}`
+	if status != http.StatusOK || body != want {
+		t.Errorf("nested query got %d %s\nwant %s", status, body, want)
+	}
+
+	status, body = query(http.MethodGet, graphQLRequest{Query: `{ me { name } nope }`})
+	if status != http.StatusOK || !strings.Contains(body, `"me":{"name":"ALICE"}`) || !strings.Contains(body, `"path":["nope"]`) {
+		t.Errorf("unknown field got %d %s, want me with an error at nope", status, body)
+	}
+
+	status, body = query(http.MethodPost, graphQLRequest{Query: `mutation { createPost(input: {title: "hi"}) { id } }`})
+	if status != http.StatusOK || !strings.Contains(body, `"createPost":null`) || !strings.Contains(body, "status 403") {
+		t.Errorf("mutation denied by an access rule got %d %s, want a 403 error at createPost", status, body)
+	}
+	status, body = query(http.MethodPost, graphQLRequest{Query: `{ up: post(id: "..") { id } here: post(id: ".") { id } }`})
+	if status != http.StatusOK || strings.Count(body, "invalid value") != 2 {
+		t.Errorf("dot segment ids got %d %s, want both rejected", status, body)
+	}
+
+	if status, body = query(http.MethodGet, graphQLRequest{Query: `mutation { login(input: {}) { token } }`}); status != http.StatusMethodNotAllowed {
+		t.Errorf("mutation over GET got %d %s, want 405", status, body)
+	}
+	if status, body = query(http.MethodPost, graphQLRequest{Query: `{ me { name }`}); status != http.StatusBadRequest || !strings.Contains(body, "syntax error") {
+		t.Errorf("unterminated query got %d %s, want a 400 syntax error", status, body)
+	}
+}
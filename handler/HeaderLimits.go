@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Header limit defaults
+const (
+	DefaultMaxHeaderBytes      = 64 << 10 // for http.Server.MaxHeaderBytes
+	defaultMaxHeaderCount      = 100
+	defaultMaxHeaderValueBytes = 8 << 10
+)
+
+// HeaderLimitGuard answers 431 for requests carrying more than Config.MaxHeaderCount
+// header values or a single value longer than Config.MaxHeaderValueBytes, before they
+// reach any handler or upstream. The server's MaxHeaderBytes bounds the total size.
+func (g *Gateway) HeaderLimitGuard(next http.Handler) http.Handler {
+	maxCount := g.Config.MaxHeaderCount
+	if maxCount <= 0 {
+		maxCount = defaultMaxHeaderCount
+	}
+	maxValue := g.Config.MaxHeaderValueBytes
+	if maxValue <= 0 {
+		maxValue = defaultMaxHeaderValueBytes
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := 0
+		for name, values := range r.Header {
+			count += len(values)
+			for _, v := range values {
+				if len(v) > maxValue {
+					g.Logger.WarnContext(r.Context(), "Rejecting oversized header", "method", r.Method, "path", r.URL.Path, "remote", g.clientAddr(r), "header", name, "bytes", len(v))
+					writeJSONError(w, http.StatusRequestHeaderFieldsTooLarge, "header "+name+" exceeds "+strconv.Itoa(maxValue)+" bytes")
+					return
+				}
+			}
+		}
+		if count > maxCount {
+			g.Logger.WarnContext(r.Context(), "Rejecting request with too many headers", "method", r.Method, "path", r.URL.Path, "remote", g.clientAddr(r), "headers", count)
+			writeJSONError(w, http.StatusRequestHeaderFieldsTooLarge, "too many headers (max "+strconv.Itoa(maxCount)+")")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
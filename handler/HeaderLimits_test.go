@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHeaderLimitGuard(t *testing.T) {
+	withHeaders := func(count, valueBytes int) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		for i := range count {
+			req.Header.Set("X-Test-"+strconv.Itoa(i), "v")
+		}
+		if valueBytes > 0 {
+			req.Header.Set("X-Test-0", strings.Repeat("a", valueBytes))
+		}
+		return req
+	}
+	for _, tt := range []struct {
+		name   string
+		config Config
+		req    *http.Request
+		want   int
+	}{
+		{"within the defaults", Config{}, withHeaders(100, 8<<10), http.StatusOK},
+		{"over the default count", Config{}, withHeaders(101, 0), http.StatusRequestHeaderFieldsTooLarge},
+		{"over the default value size", Config{}, withHeaders(1, 8<<10+1), http.StatusRequestHeaderFieldsTooLarge},
+		{"within configured limits", Config{MaxHeaderCount: 5, MaxHeaderValueBytes: 10}, withHeaders(5, 10), http.StatusOK},
+		{"over the configured count", Config{MaxHeaderCount: 5}, withHeaders(6, 0), http.StatusRequestHeaderFieldsTooLarge},
+		{"over the configured value size", Config{MaxHeaderValueBytes: 10}, withHeaders(1, 11), http.StatusRequestHeaderFieldsTooLarge},
+	} {
+		var reached bool
+		g := newTestGateway(t, &tt.config)
+		h := g.HeaderLimitGuard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }))
+		rec := serve(t, h, tt.req, nil)
+		if rec.Code != tt.want || reached != (tt.want == http.StatusOK) {
+			t.Errorf("%s: status %d, next reached %t, want %d", tt.name, rec.Code, reached, tt.want)
+		}
+		if tt.want == http.StatusRequestHeaderFieldsTooLarge {
+			var body map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == nil {
+				t.Errorf("%s: 431 body %v, want a JSON error", tt.name, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package handler
+
+import "net/http"
+
+// HeaderRules transforms headers passing through the gateway. Rules are applied in
+// field order: Remove, Rename, Set, then Add.
+type HeaderRules struct {
+	// Remove drops every value of these headers
+	Remove []string
+	// Rename moves all values of a header to a new name, replacing any values already there
+	Rename map[string]string
+	// Set replaces all values of a header with a single value
+	Set map[string]string
+	// Add appends a value, keeping any values already present
+	Add map[string]string
+}
+
+func (hr HeaderRules) empty() bool {
+	return len(hr.Remove) == 0 && len(hr.Rename) == 0 && len(hr.Set) == 0 && len(hr.Add) == 0
+}
+
+// apply transforms h in place
+func (hr HeaderRules) apply(h http.Header) {
+	for _, name := range hr.Remove {
+		h.Del(name)
+	}
+	renameHeaders(h, hr.Rename)
+	for name, value := range hr.Set {
+		h.Set(name, value)
+	}
+	for name, value := range hr.Add {
+		h.Add(name, value)
+	}
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReadinessInterval = 10 * time.Second
+	readinessProbeTimeout    = 3 * time.Second
+)
+
+// DefaultCriticalServices are the services whose outage makes the gateway not ready
+// when Config.CriticalServices is nil
+var DefaultCriticalServices = []string{ServiceAuth, ServiceBlog, ServiceUser, ServiceAsp}
+
+// Service readiness states reported by /readyz
+const (
+	serviceUp      = "up"
+	serviceDown    = "down"
+	serviceUnknown = "unknown" // not probed yet
+)
+
+type serviceHealth struct {
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	CheckedAt time.Time `json:"checkedAt,omitzero"`
+	Latency   string    `json:"latency,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// readiness holds the latest probe result of every service
+type readiness struct {
+	mu       sync.RWMutex
+	services map[string]serviceHealth
+}
+
+func newReadiness(services []string, critical []string) *readiness {
+	rd := &readiness{services: make(map[string]serviceHealth, len(services))}
+	rd.track(services, critical)
+	return rd
+}
+
+// track makes the tracked services exactly services, keeping the results of those
+// already tracked; new ones start out unknown
+func (rd *readiness) track(services []string, critical []string) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	for name := range rd.services {
+		if !slices.Contains(services, name) {
+			delete(rd.services, name)
+		}
+	}
+	for _, name := range services {
+		h, ok := rd.services[name]
+		if !ok {
+			h.Status = serviceUnknown
+		}
+		h.Critical = slices.Contains(critical, name)
+		rd.services[name] = h
+	}
+}
+
+func (rd *readiness) set(name string, h serviceHealth) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	prev, ok := rd.services[name]
+	if !ok {
+		return // removed by a reload while being probed
+	}
+	h.Critical = prev.Critical
+	rd.services[name] = h
+}
+
+// snapshot returns a copy of the per-service results and whether every critical service is up
+func (rd *readiness) snapshot() (map[string]serviceHealth, bool) {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	services := make(map[string]serviceHealth, len(rd.services))
+	ready := true
+	for name, h := range rd.services {
+		services[name] = h
+		if h.Critical && h.Status != serviceUp {
+			ready = false
+		}
+	}
+	return services, ready
+}
+
+// ProbeUpstreams checks every service's ServiceConfig.HealthPath (default "/") every
+// Config.ReadinessInterval until ctx is done. A service is up while any of its instances
+// answers with a status below 500; instances that don't are ejected from load balancing
+// for HealthScoreConfig.EjectDuration.
+func (g *Gateway) ProbeUpstreams(ctx context.Context) {
+	interval := g.Config.ReadinessInterval
+	if interval <= 0 {
+		interval = defaultReadinessInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for name, up := range g.upstreams() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				g.probeUpstream(ctx, name, up)
+			}()
+		}
+		wg.Wait()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *Gateway) probeUpstream(ctx context.Context, name string, up *upstream) {
+	path := up.svc.HealthPath
+	if path == "" {
+		path = "/"
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		g.readiness.set(name, serviceHealth{Status: serviceDown, CheckedAt: time.Now(), Error: err.Error()})
+		return
+	}
+
+	// Every instance is probed; failing ones are taken out of rotation while others remain
+	start := time.Now()
+	var anyUp bool
+	var latency time.Duration
+	instances := up.instances()
+	for _, in := range instances {
+		perr := probeInstance(ctx, up.transport, in.resolve(ref).String())
+		if perr == nil {
+			if !anyUp {
+				anyUp, latency = true, time.Since(start)
+			}
+			continue
+		}
+		err = perr
+		if len(instances) > 1 && !in.ejected(time.Now()) {
+			g.Logger.Warn("Ejecting instance", "service", name, "instance", in.url.String(), "for", up.health.EjectDuration, "error", perr)
+			in.eject(time.Now().Add(up.health.EjectDuration))
+		}
+	}
+	if anyUp {
+		g.readiness.set(name, serviceHealth{Status: serviceUp, CheckedAt: start, Latency: latency.String()})
+		return
+	}
+	if prev, _ := g.readiness.snapshot(); prev[name].Status == serviceUp {
+		g.Logger.Warn("Service failed readiness probe", "service", name, "error", err)
+	}
+	g.readiness.set(name, serviceHealth{Status: serviceDown, CheckedAt: start, Error: err.Error()})
+}
+
+func probeInstance(ctx context.Context, transport http.RoundTripper, target string) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Gateway-Probe", "readiness")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// HealthzHandler answers liveness checks (GET /healthz): the process is up and serving
+func (g *Gateway) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler answers readiness checks (GET /readyz) with the latest probe result of
+// each service, and 503 while any critical service is down or not probed yet, or once
+// the gateway is shutting down
+func (g *Gateway) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	services, ready := g.readiness.snapshot()
+	status, code := "ready", http.StatusOK
+	switch {
+	case g.shuttingDown.Load():
+		status, code = "draining", http.StatusServiceUnavailable
+	case !ready:
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]any{"status": status, "services": services})
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type readyzResponse struct {
+	Status   string                   `json:"status"`
+	Services map[string]serviceHealth `json:"services"`
+}
+
+func TestReadiness(t *testing.T) {
+	// probed serves health checks with the status in code, recording the probed paths
+	probed := func(code *atomic.Int64, paths chan<- string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Gateway-Probe") != "readiness" {
+				t.Errorf("probe of %s without X-Gateway-Probe", r.URL.Path)
+			}
+			if paths != nil {
+				select {
+				case paths <- r.URL.Path:
+				default:
+				}
+			}
+			w.WriteHeader(int(code.Load()))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	var authCode, blogCode, brokenCode, userCode atomic.Int64
+	authCode.Store(http.StatusOK)
+	blogCode.Store(http.StatusNoContent)
+	brokenCode.Store(http.StatusServiceUnavailable)
+	userCode.Store(http.StatusInternalServerError)
+	blogPaths := make(chan string, 1)
+	auth, blog, broken, user := probed(&authCode, nil), probed(&blogCode, blogPaths), probed(&brokenCode, nil), probed(&userCode, nil)
+
+	g := newTestGateway(t, &Config{
+		AuthServiceURL:    auth.URL,
+		BlogServiceURL:    blog.URL + "," + broken.URL,
+		UserServiceURL:    user.URL,
+		ReadinessInterval: 10 * time.Millisecond,
+		CriticalServices:  []string{ServiceAuth, ServiceBlog},
+		Services:          map[string]ServiceConfig{ServiceBlog: {HealthPath: "/internal/health"}},
+	})
+	readyz := func() (int, readyzResponse) {
+		rec := httptest.NewRecorder()
+		g.ReadyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp readyzResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid /readyz body %q", rec.Body)
+		}
+		return rec.Code, resp
+	}
+	waitFor := func(status string) readyzResponse {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, resp := readyz()
+			if resp.Status == status {
+				return resp
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("/readyz stayed %q, want %q", resp.Status, status)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// Until the first probes, services are unknown and the gateway isn't ready
+	if code, resp := readyz(); code != http.StatusServiceUnavailable || resp.Services[ServiceAuth].Status != serviceUnknown {
+		t.Errorf("before probing: status %d, auth %+v", code, resp.Services[ServiceAuth])
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go g.ProbeUpstreams(ctx)
+
+	// A non-critical service that's down doesn't hold up readiness; neither does one
+	// failing instance of a critical service
+	resp := waitFor("ready")
+	if code, _ := readyz(); code != http.StatusOK {
+		t.Errorf("ready: status %d", code)
+	}
+	if s := resp.Services[ServiceUser]; s.Status != serviceDown || s.Critical || s.Error != "status 500" {
+		t.Errorf("user service %+v, want down with the probe's status", s)
+	}
+	if s := resp.Services[ServiceBlog]; s.Status != serviceUp || !s.Critical || s.Latency == "" || s.CheckedAt.IsZero() {
+		t.Errorf("blog service %+v, want up and critical", s)
+	}
+	if path := <-blogPaths; path != "/internal/health" {
+		t.Errorf("blog probed at %q, want its HealthPath", path)
+	}
+	for _, in := range g.upstreams()[ServiceBlog].instances() {
+		if ejected := in.ejected(time.Now()); ejected != (in.url.String() == broken.URL) {
+			t.Errorf("instance %s ejected %t", in.url, ejected)
+		}
+	}
+
+	authCode.Store(http.StatusBadGateway)
+	resp = waitFor("not ready")
+	if s := resp.Services[ServiceAuth]; s.Status != serviceDown || !strings.Contains(s.Error, "502") {
+		t.Errorf("auth service %+v, want down", s)
+	}
+	authCode.Store(http.StatusOK)
+	waitFor("ready")
+
+	g.BeginShutdown()
+	if code, resp := readyz(); code != http.StatusServiceUnavailable || resp.Status != "draining" {
+		t.Errorf("shutting down: status %d %q, want 503 draining", code, resp.Status)
+	}
+	rec := httptest.NewRecorder()
+	g.HealthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/healthz while draining: status %d, want the process still live", rec.Code)
+	}
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ipFilter holds the parsed allow and deny lists
+type ipFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func newIPFilter(allowed, denied []string) (*ipFilter, error) {
+	allow, err := parsePrefixes(allowed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed CIDR: %w", err)
+	}
+	deny, err := parsePrefixes(denied)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denied CIDR: %w", err)
+	}
+	return &ipFilter{allow: allow, deny: deny}, nil
+}
+
+// permits applies the denylist first, then the allowlist when one is configured
+func (f *ipFilter) permits(ip netip.Addr) bool {
+	if containsAddr(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsAddr(f.allow, ip)
+}
+
+// IPFilterMiddleware returns 403 for clients outside Config.AllowedCIDRs or inside Config.DeniedCIDRs.
+// It is meant for specific subrouters (admin, metrics), not the whole gateway.
+func (g *Gateway) IPFilterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, ok := g.clientIP(r)
+		if !ok || !g.ipFilter.permits(ip) {
+			g.Logger.WarnContext(r.Context(), "IP filter rejected request", "method", r.Method, "path", r.URL.Path, "client", ip.String())
+			writeJSONError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parsePrefixes parses CIDRs, accepting bare addresses as single-host prefixes
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	g := newTestGateway(t, &Config{
+		AllowedCIDRs:     []string{"10.0.0.0/8", "fd00::/8"},
+		DeniedCIDRs:      []string{"10.0.0.66", "fd00:bad::/32"},
+		TrustedProxyHops: 1,
+	})
+	h := g.IPFilterMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, tt := range []struct {
+		name, remote, xff string
+		want              int
+	}{
+		{"allowed IPv4", "10.1.2.3:4000", "", http.StatusNoContent},
+		{"allowed IPv6", "[fd00::1]:4000", "", http.StatusNoContent},
+		{"IPv4 outside the allowlist", "192.0.2.1:4000", "", http.StatusForbidden},
+		{"IPv6 outside the allowlist", "[2001:db8::1]:4000", "", http.StatusForbidden},
+		{"IPv4 denied inside the allowlist", "10.0.0.66:4000", "", http.StatusForbidden},
+		{"IPv6 denied inside the allowlist", "[fd00:bad::1]:4000", "", http.StatusForbidden},
+		{"IPv4-mapped IPv6", "[::ffff:10.1.2.3]:4000", "", http.StatusNoContent},
+		{"client behind the trusted hop", "192.0.2.1:4000", "10.1.2.3", http.StatusNoContent},
+		{"spoofed entry before the trusted hop", "10.1.2.3:4000", "10.9.9.9, 192.0.2.1", http.StatusForbidden},
+		{"denied client behind the trusted hop", "10.1.2.3:4000", "fd00:bad::1", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		rec := serve(t, h, req, nil)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusForbidden && rec.Header().Get("Content-Type") != ProblemContentType {
+			t.Errorf("%s: 403 with Content-Type %q, want a JSON error", tt.name, rec.Header().Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubeWatchTimeout      = 5 * time.Minute // the API server ends each watch after this long
+)
+
+// KubernetesConfig resolves service instances from the EndpointSlices of Kubernetes
+// Services, so the gateway balances across pods itself instead of going through
+// kube-proxy; services opt in with ServiceConfig.KubernetesService (see DiscoverInstances).
+// The pod's service account needs list and watch access to endpointslices.
+type KubernetesConfig struct {
+	Enabled bool
+	// Namespace of the Services; defaults to the gateway pod's own namespace
+	Namespace string
+	// APIServer defaults to the in-cluster address from KUBERNETES_SERVICE_HOST and
+	// KUBERNETES_SERVICE_PORT
+	APIServer string
+}
+
+// kubeClient talks to the API server with the pod's service account
+type kubeClient struct {
+	apiServer string
+	namespace string
+	client    *http.Client
+}
+
+func newKubeClient(cfg KubernetesConfig) (*kubeClient, error) {
+	kc := &kubeClient{apiServer: strings.TrimSuffix(cfg.APIServer, "/"), namespace: cfg.Namespace}
+	if kc.apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("not running in a cluster and no API server configured")
+		}
+		kc.apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+	if kc.namespace == "" {
+		ns, err := os.ReadFile(kubeServiceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read namespace: %w", err)
+		}
+		kc.namespace = strings.TrimSpace(string(ns))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ca, err := os.ReadFile(kubeServiceAccountDir + "/ca.crt"); err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid service account CA certificate")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	kc.client = &http.Client{Transport: transport}
+	return kc, nil
+}
+
+// get sends an authenticated GET for an API path below the configured namespace
+func (kc *kubeClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	target := fmt.Sprintf("%s/apis/discovery.k8s.io/v1/namespaces/%s/%s?%s", kc.apiServer, url.PathEscape(kc.namespace), path, query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes request: %w", err)
+	}
+	// Projected service account tokens are rotated, so the file is read on every request
+	if token, err := os.ReadFile(kubeServiceAccountDir + "/token"); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := kc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Kubernetes API returned status: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// kubeEndpointSlice is the part of a discovery.k8s.io/v1 EndpointSlice the gateway uses
+type kubeEndpointSlice struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	AddressType string `json:"addressType"`
+	Endpoints   []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"` // unknown readiness counts as ready
+		} `json:"conditions"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name string `json:"name"`
+		Port int    `json:"port"`
+	} `json:"ports"`
+}
+
+// watchKubernetes follows the EndpointSlices of the Kubernetes Service, relisting them
+// whenever a watch ends or fails
+func (g *Gateway) watchKubernetes(ctx context.Context, kc *kubeClient, up *upstream, service, port string) {
+	base := *up.instances()[0].url
+	retry := discoveryRetryMin
+	for {
+		err := g.syncEndpointSlices(ctx, kc, up, &base, service, port)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			retry = discoveryRetryMin
+			continue
+		}
+		g.Logger.Warn("Kubernetes watch failed, keeping current instances", "service", up.name, "kubernetes_service", service, "retry_in", retry, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry = min(retry*2, discoveryRetryMax)
+	}
+}
+
+// syncEndpointSlices lists the Service's EndpointSlices, then applies watch events to
+// them until the API server ends the watch
+func (g *Gateway) syncEndpointSlices(ctx context.Context, kc *kubeClient, up *upstream, base *url.URL, service, port string) error {
+	query := url.Values{"labelSelector": {"kubernetes.io/service-name=" + service}}
+	resp, err := kc.get(ctx, "endpointslices", query)
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []kubeEndpointSlice `json:"items"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode EndpointSlice list: %w", err)
+	}
+	endpointSlices := make(map[string]kubeEndpointSlice, len(list.Items))
+	for _, s := range list.Items {
+		endpointSlices[s.Metadata.Name] = s
+	}
+	g.updateInstances(up, "Kubernetes", endpointTargets(base, endpointSlices, port))
+
+	query.Set("watch", "true")
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("resourceVersion", list.Metadata.ResourceVersion)
+	query.Set("timeoutSeconds", strconv.Itoa(int(kubeWatchTimeout.Seconds())))
+	ctx, cancel := context.WithTimeout(ctx, kubeWatchTimeout+30*time.Second)
+	defer cancel()
+	if resp, err = kc.get(ctx, "endpointslices", query); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to decode watch event: %w", err)
+		}
+		var s kubeEndpointSlice
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			if err := json.Unmarshal(event.Object, &s); err != nil {
+				return fmt.Errorf("failed to decode EndpointSlice: %w", err)
+			}
+		case "BOOKMARK":
+			continue
+		default:
+			// ERROR, typically 410 Gone once the resource version is too old to watch from
+			return fmt.Errorf("watch event %s: %s", event.Type, event.Object)
+		}
+		if event.Type == "DELETED" {
+			delete(endpointSlices, s.Metadata.Name)
+		} else {
+			endpointSlices[s.Metadata.Name] = s
+		}
+		g.updateInstances(up, "Kubernetes", endpointTargets(base, endpointSlices, port))
+	}
+}
+
+// endpointTargets turns the ready endpoints of the slices into instance URLs based on base,
+// using the named port or, when port is empty, each slice's first port
+func endpointTargets(base *url.URL, endpointSlices map[string]kubeEndpointSlice, port string) []*url.URL {
+	var targets []*url.URL
+	for _, name := range sortedKeys(endpointSlices) {
+		s := endpointSlices[name]
+		if s.AddressType == "FQDN" {
+			continue
+		}
+		number := 0
+		for _, p := range s.Ports {
+			if p.Name == port || port == "" {
+				number = p.Port
+				break
+			}
+		}
+		if number == 0 {
+			continue
+		}
+		for _, e := range s.Endpoints {
+			if e.Conditions.Ready != nil && !*e.Conditions.Ready {
+				continue
+			}
+			for _, addr := range e.Addresses {
+				u := *base
+				u.Host = net.JoinHostPort(addr, strconv.Itoa(number))
+				targets = append(targets, &u)
+			}
+		}
+	}
+	return targets
+}
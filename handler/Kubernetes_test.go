@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// endpointSliceJSON describes a slice with metrics and http ports; each address is ready unless
+// it's listed in notReady, and addresses in unknown carry no readiness at all
+func endpointSliceJSON(name string, addrs []string, notReady, unknown map[string]bool) string {
+	var endpoints []map[string]any
+	for _, a := range addrs {
+		e := map[string]any{"addresses": []string{a}, "conditions": map[string]any{"ready": !notReady[a]}}
+		if unknown[a] {
+			e["conditions"] = map[string]any{}
+		}
+		endpoints = append(endpoints, e)
+	}
+	b, _ := json.Marshal(map[string]any{
+		"metadata":    map[string]string{"name": name},
+		"addressType": "IPv4",
+		"endpoints":   endpoints,
+		"ports":       []map[string]any{{"name": "metrics", "port": 9100}, {"name": "http", "port": 8080}},
+	})
+	return string(b)
+}
+
+func TestKubernetesDiscovery(t *testing.T) {
+	lists := make(chan string, 2)
+	watches, events := make(chan string), make(chan string)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/shop/endpointslices" || q.Get("labelSelector") != "kubernetes.io/service-name=blog" {
+			http.Error(w, "unexpected request", http.StatusForbidden)
+			return
+		}
+		if q.Get("watch") != "true" {
+			w.Write([]byte(<-lists))
+			return
+		}
+		select {
+		case watches <- q.Get("resourceVersion"):
+		case <-r.Context().Done():
+			return
+		}
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-events:
+				if event == "" {
+					return // the API server ends the watch
+				}
+				fmt.Fprintln(w, event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	t.Cleanup(api.Close)
+
+	fqdn := `{"metadata": {"name": "blog-fqdn"}, "addressType": "FQDN", "endpoints": [{"addresses": ["blog.example.com"]}], "ports": [{"name": "http", "port": 80}]}`
+	lists <- fmt.Sprintf(`{"metadata": {"resourceVersion": "100"}, "items": [%s, %s]}`,
+		endpointSliceJSON("blog-a", []string{"10.1.0.1", "10.1.0.2", "10.1.0.3"}, map[string]bool{"10.1.0.3": true}, map[string]bool{"10.1.0.2": true}), fqdn)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: "http://blog:80/api",
+		Kubernetes:     KubernetesConfig{Enabled: true, Namespace: "shop", APIServer: api.URL + "/"},
+		Services:       map[string]ServiceConfig{ServiceBlog: {KubernetesService: "blog", KubernetesPort: "http"}},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	g.DiscoverInstances(ctx)
+	up := g.upstreams()[ServiceBlog]
+	watch := func(resourceVersion string) {
+		t.Helper()
+		select {
+		case rv := <-watches:
+			if rv != resourceVersion {
+				t.Errorf("watch from resource version %q, want %q", rv, resourceVersion)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("EndpointSlices never watched")
+		}
+	}
+	event := func(typ, object string) { events <- fmt.Sprintf(`{"type": %q, "object": %s}`, typ, object) }
+
+	// Endpoints not ready and FQDN slices are left out; unknown readiness counts as ready
+	waitForInstances(t, up, "http://10.1.0.1:8080/api", "http://10.1.0.2:8080/api")
+	watch("100")
+	event("BOOKMARK", `{"metadata": {"resourceVersion": "150"}}`)
+	event("ADDED", endpointSliceJSON("blog-b", []string{"10.1.1.1"}, nil, nil))
+	waitForInstances(t, up, "http://10.1.0.1:8080/api", "http://10.1.0.2:8080/api", "http://10.1.1.1:8080/api")
+	event("MODIFIED", endpointSliceJSON("blog-a", []string{"10.1.0.1", "10.1.0.2"}, map[string]bool{"10.1.0.1": true}, nil))
+	waitForInstances(t, up, "http://10.1.0.2:8080/api", "http://10.1.1.1:8080/api")
+	event("DELETED", endpointSliceJSON("blog-b", nil, nil, nil))
+	waitForInstances(t, up, "http://10.1.0.2:8080/api")
+
+	// Deleting the last ready slice keeps the previous instances
+	event("DELETED", endpointSliceJSON("blog-a", nil, nil, nil))
+	event("ADDED", endpointSliceJSON("blog-c", []string{"10.1.2.1"}, map[string]bool{"10.1.2.1": true}, nil))
+	time.Sleep(20 * time.Millisecond)
+	waitForInstances(t, up, "http://10.1.0.2:8080/api")
+
+	// A watch the API server ends is followed by a fresh list
+	lists <- fmt.Sprintf(`{"metadata": {"resourceVersion": "200"}, "items": [%s]}`, endpointSliceJSON("blog-c", []string{"10.1.2.1"}, nil, nil))
+	events <- ""
+	waitForInstances(t, up, "http://10.1.2.1:8080/api")
+	watch("200")
+}
+
+func TestEndpointTargetsPort(t *testing.T) {
+	endpointSlices := map[string]kubeEndpointSlice{}
+	for name, addr := range map[string]string{"b": "10.0.0.2", "a": "10.0.0.1"} {
+		var s kubeEndpointSlice
+		if err := json.Unmarshal([]byte(endpointSliceJSON(name, []string{addr}, nil, nil)), &s); err != nil {
+			t.Fatal(err)
+		}
+		endpointSlices[name] = s
+	}
+	base, _ := url.Parse("https://blog/v1")
+	for port, want := range map[string][]string{
+		// No port name takes each slice's first port; slices come in name order
+		"":        {"https://10.0.0.1:9100/v1", "https://10.0.0.2:9100/v1"},
+		"http":    {"https://10.0.0.1:8080/v1", "https://10.0.0.2:8080/v1"},
+		"missing": nil,
+	} {
+		var got []string
+		for _, u := range endpointTargets(base, endpointSlices, port) {
+			got = append(got, u.String())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("port %q: targets %v, want %v", port, got, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxShedFraction = 0.9
+	defaultShedRetryAfter  = 5 * time.Second
+	shedLatencyAlpha       = 0.1 // EWMA smoothing for proxy latency
+)
+
+// LoadShedConfig sheds a share of API requests with 503 while the gateway is overloaded.
+// Admin, debug and root endpoints are never shed.
+type LoadShedConfig struct {
+	// MaxInFlight is the proxied in-flight count above which load is shed (0 disables)
+	MaxInFlight int
+	// LatencyTarget is the average proxy latency above which load is shed (0 disables)
+	LatencyTarget time.Duration
+	// MaxFraction caps the share of requests shed (default 0.9)
+	MaxFraction float64
+	// RetryAfter is sent with shed responses (default 5s)
+	RetryAfter time.Duration
+}
+
+// loadShedder tracks the smoothed latency of proxied requests
+type loadShedder struct {
+	mu      sync.Mutex
+	latency float64 // seconds
+}
+
+func (s *loadShedder) observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency += shedLatencyAlpha * (d.Seconds() - s.latency)
+}
+
+func (s *loadShedder) avgLatency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.latency * float64(time.Second))
+}
+
+// shedFraction is the share of requests to shed: how far the worse of the two signals is
+// over its threshold, relative to the threshold, capped at MaxFraction
+func (g *Gateway) shedFraction() float64 {
+	cfg := g.Config.LoadShed
+	var over float64
+	if cfg.MaxInFlight > 0 {
+		if n := g.inFlight.Load(); n > int64(cfg.MaxInFlight) {
+			over = float64(n-int64(cfg.MaxInFlight)) / float64(cfg.MaxInFlight)
+		}
+	}
+	if cfg.LatencyTarget > 0 {
+		if lat := g.shedder.avgLatency(); lat > cfg.LatencyTarget {
+			over = max(over, float64(lat-cfg.LatencyTarget)/float64(cfg.LatencyTarget))
+		}
+	}
+	limit := cfg.MaxFraction
+	if limit <= 0 {
+		limit = defaultMaxShedFraction
+	}
+	return min(over, limit)
+}
+
+// LoadShedMiddleware rejects a fraction of requests with 503 while in-flight count or
+// proxy latency exceed Config.LoadShed thresholds
+func (g *Gateway) LoadShedMiddleware(next http.Handler) http.Handler {
+	cfg := g.Config.LoadShed
+	if cfg.MaxInFlight <= 0 && cfg.LatencyTarget <= 0 {
+		return next
+	}
+	retry := cfg.RetryAfter
+	if retry <= 0 {
+		retry = defaultShedRetryAfter
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := g.shedFraction(); p > 0 && rand.Float64() < p {
+			g.Logger.WarnContext(r.Context(), "Shedding request", "method", r.Method, "path", r.URL.Path, "fraction", p)
+			w.Header().Set("Retry-After", retryAfter(retry))
+			writeJSONError(w, http.StatusServiceUnavailable, "gateway overloaded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
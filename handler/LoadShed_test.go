@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadSheddingAboveThresholds(t *testing.T) {
+	entered, release := make(chan struct{}, 16), make(chan struct{})
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/blog/slow" {
+			entered <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(blog.Close)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		LoadShed:       LoadShedConfig{MaxInFlight: 2, RetryAfter: 30 * time.Second},
+	})
+	h := g.LoadShedMiddleware(g.ProxyHandler(ServiceBlog))
+	burst := func() (passed, shed int) {
+		for range 200 {
+			rec := serve(t, h, httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil)
+			switch rec.Code {
+			case http.StatusOK:
+				passed++
+			case http.StatusServiceUnavailable:
+				if rec.Header().Get("Retry-After") != "30" {
+					t.Errorf("shed request: Retry-After %q, want 30", rec.Header().Get("Retry-After"))
+				}
+				shed++
+			default:
+				t.Fatalf("status %d", rec.Code)
+			}
+		}
+		return passed, shed
+	}
+
+	if passed, shed := burst(); shed != 0 {
+		t.Errorf("idle gateway: %d passed, %d shed, want none shed", passed, shed)
+	}
+
+	// Six slow requests in flight put the gateway at twice its threshold over, so the
+	// capped 90% of new requests are shed
+	var wg sync.WaitGroup
+	for slow := 0; slow < 6; {
+		rec := httptest.NewRecorder()
+		done := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done)
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/blog/slow", nil))
+		}()
+		select {
+		case <-entered:
+			slow++
+		case <-done:
+		}
+	}
+	passed, shed := burst()
+	close(release)
+	wg.Wait()
+	if passed == 0 || shed == 0 || shed < passed {
+		t.Errorf("overloaded gateway: %d passed, %d shed, want most but not all shed", passed, shed)
+	}
+
+	if passed, shed := burst(); shed != 0 {
+		t.Errorf("after the load is gone: %d passed, %d shed, want none shed", passed, shed)
+	}
+}
+
+func TestLoadSheddingOnLatency(t *testing.T) {
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		LoadShed:       LoadShedConfig{LatencyTarget: 100 * time.Millisecond, MaxFraction: 0.5},
+	})
+	for range 100 {
+		g.shedder.observe(time.Second)
+	}
+	h := g.LoadShedMiddleware(g.ProxyHandler(ServiceBlog))
+	var shed int
+	for range 400 {
+		if rec := serve(t, h, httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil); rec.Code == http.StatusServiceUnavailable {
+			shed++
+		}
+	}
+	// The fraction is capped at half; the requests themselves are fast and bring the
+	// average back down, so fewer than half are shed
+	if shed == 0 || shed > 250 {
+		t.Errorf("slow gateway: %d of 400 shed, want some but at most about half", shed)
+	}
+}
@@ -0,0 +1,442 @@
+package handler
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Local JWT defaults
+const (
+	defaultJWKSPath        = "/.well-known/jwks.json"
+	defaultJWKSRefresh     = 10 * time.Minute
+	jwksMinRefreshInterval = time.Minute // on-demand refreshes for unknown key IDs
+	jwtLeeway              = 30 * time.Second
+	jwksMaxBody            = 1 << 20
+)
+
+// LocalJWTConfig verifies tokens in the gateway instead of calling AuthService for every
+// request. Keys come from AuthService's JWKS, fetched at startup and refreshed
+// periodically, or from a shared HMAC secret. Zero values mean the defaults above.
+type LocalJWTConfig struct {
+	Enabled bool
+
+	// JWKSPath is fetched from AuthService, or used as is when it's an absolute URL
+	JWKSPath string
+
+	// Secret verifies HS256/384/512 tokens; when set the JWKS is not fetched
+	Secret string
+
+	RefreshInterval time.Duration
+
+	// Issuer, when set, must match the token's iss claim
+	Issuer string
+
+	// Audience, when set, must be the token's aud claim or one of its values
+	Audience string
+
+	// RemoteFallback validates tokens signed with an unknown key, or any token while no
+	// keys could be loaded, through AuthService as before
+	RemoteFallback bool
+}
+
+// errUnknownKey means the token may be fine but no loaded key can verify it
+var errUnknownKey = errors.New("no key to verify token")
+
+// jwtVerifier holds the verification keys by key ID ("" for keys without one)
+type jwtVerifier struct {
+	config LocalJWTConfig
+
+	mu          sync.RWMutex
+	keys        map[string]any // *rsa.PublicKey, *ecdsa.PublicKey or []byte
+	lastRefresh time.Time
+}
+
+func newJWTVerifier(config LocalJWTConfig) *jwtVerifier {
+	if config.JWKSPath == "" {
+		config.JWKSPath = defaultJWKSPath
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaultJWKSRefresh
+	}
+	v := &jwtVerifier{config: config, keys: make(map[string]any)}
+	if config.Secret != "" {
+		v.keys[""] = []byte(config.Secret)
+	}
+	return v
+}
+
+// FetchJWKS loads AuthService's signing keys; it's a no-op unless local JWT validation
+// uses a JWKS
+func (g *Gateway) FetchJWKS(ctx context.Context) error {
+	if g.jwt == nil || g.jwt.config.Secret != "" {
+		return nil
+	}
+	jwksURL, client := g.jwt.config.JWKSPath, g.Client
+	if !strings.Contains(jwksURL, "://") {
+		auth := g.upstreams()[ServiceAuth]
+		jwksURL, client = strings.TrimSuffix(auth.pick().url.String(), "/")+jwksURL, auth.client
+	}
+
+	g.jwt.mu.Lock()
+	g.jwt.lastRefresh = time.Now()
+	g.jwt.mu.Unlock()
+
+	keys, err := g.fetchJWKS(ctx, client, jwksURL)
+	if err != nil {
+		return err
+	}
+	g.jwt.mu.Lock()
+	g.jwt.keys = keys
+	g.jwt.mu.Unlock()
+	return nil
+}
+
+// fetchJWKS loads the signing keys of a JWKS by key ID
+func (g *Gateway) fetchJWKS(ctx context.Context, client *http.Client, jwksURL string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS returned status: %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, jwksMaxBody)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			g.Logger.Warn("Skipping JWKS key", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no usable signing keys")
+	}
+	return keys, nil
+}
+
+// RefreshJWKS reloads the JWKS every LocalJWTConfig.RefreshInterval until ctx is done
+func (g *Gateway) RefreshJWKS(ctx context.Context) {
+	if g.jwt == nil || g.jwt.config.Secret != "" {
+		return
+	}
+	ticker := time.NewTicker(g.jwt.config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := g.FetchJWKS(ctx); err != nil {
+				g.Logger.Error("JWKS refresh failed, keeping previous keys", "error", err)
+			}
+		}
+	}
+}
+
+// authenticate validates a token, answering from the token cache when possible, and
+// rejects revoked tokens either way
+func (g *Gateway) authenticate(ctx context.Context, token string) (*AuthValidateResponse, error) {
+	var identity *AuthValidateResponse
+	cached := false
+	if g.tokenCache != nil {
+		identity, cached = g.tokenCache.get(token)
+	}
+	if !cached {
+		var err error
+		if identity, err = g.verifyToken(ctx, token); err != nil {
+			return nil, err
+		}
+		if g.tokenCache != nil {
+			g.tokenCache.set(token, identity)
+		}
+	}
+	if g.revocations.revoked(token, identity) {
+		return nil, errTokenRevoked
+	}
+	return identity, nil
+}
+
+// verifyToken validates a token locally when configured, otherwise through AuthService
+func (g *Gateway) verifyToken(ctx context.Context, token string) (*AuthValidateResponse, error) {
+	if g.jwt == nil {
+		return g.validateJWT(ctx, token)
+	}
+	identity, err := g.jwt.verify(token)
+	if !errors.Is(err, errUnknownKey) {
+		return identity, err
+	}
+
+	// The key may have been rotated since the last refresh
+	if g.jwt.config.Secret == "" && g.jwt.claimRefresh() {
+		if err := g.FetchJWKS(ctx); err != nil {
+			g.Logger.Error("JWKS refresh failed", "error", err)
+		}
+		if identity, err = g.jwt.verify(token); !errors.Is(err, errUnknownKey) {
+			return identity, err
+		}
+	}
+	if g.jwt.config.RemoteFallback {
+		return g.validateJWT(ctx, token)
+	}
+	if !g.jwt.hasKeys() {
+		return nil, &AuthUnavailableError{Err: errors.New("no JWT verification keys loaded")}
+	}
+	return nil, err
+}
+
+// claimRefresh reports whether an on-demand refresh is due and, if so, marks it as
+// started, so concurrent requests with an unknown key ID fetch the JWKS only once
+func (v *jwtVerifier) claimRefresh() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if time.Since(v.lastRefresh) < jwksMinRefreshInterval {
+		return false
+	}
+	v.lastRefresh = time.Now()
+	return true
+}
+
+func (v *jwtVerifier) hasKeys() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.keys) > 0
+}
+
+// verify checks the token with verifyClaims and maps its claims to an identity
+func (v *jwtVerifier) verify(token string) (*AuthValidateResponse, error) {
+	claims, err := v.verifyClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	identity := &AuthValidateResponse{Claims: claims}
+	if identity.UserID, _ = claimValue(claims["userID"]); identity.UserID == "" {
+		identity.UserID, _ = claimValue(claims["sub"])
+	}
+	identity.Role, _ = claimValue(claims["role"])
+	identity.Username, _ = claimValue(claims["username"])
+	identity.TenantID, _ = claimValue(claims["tenantID"])
+	identity.ExpiresAt = int64(claims["exp"].(float64))
+	if iat, ok := claims["iat"].(float64); ok {
+		identity.IssuedAt = int64(iat)
+	}
+	if identity.UserID == "" || identity.Role == "" {
+		return nil, errors.New("token is missing userID or role")
+	}
+	return identity, nil
+}
+
+// verifyClaims checks the token's signature, time claims, issuer and audience and returns
+// its claims. Tokens without an expiry are rejected.
+func (v *jwtVerifier) verifyClaims(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+
+	v.mu.RLock()
+	key, ok := v.keys[header.Kid]
+	if !ok && header.Kid != "" && v.config.Secret != "" {
+		key, ok = v.keys[""]
+	}
+	v.mu.RUnlock()
+	if !ok {
+		return nil, errUnknownKey
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	now := time.Now()
+	exp, hasExp := claims["exp"].(float64)
+	if !hasExp {
+		return nil, errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token not yet valid")
+	}
+	if v.config.Issuer != "" && claims["iss"] != v.config.Issuer {
+		return nil, errors.New("token has wrong issuer")
+	}
+	if v.config.Audience != "" {
+		switch aud := claims["aud"].(type) {
+		case string:
+			if aud != v.config.Audience {
+				return nil, errors.New("token has wrong audience")
+			}
+		case []any:
+			if !slices.Contains(aud, any(v.config.Audience)) {
+				return nil, errors.New("token has wrong audience")
+			}
+		default:
+			return nil, errors.New("token has no audience")
+		}
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ecCurves is the curve each ES algorithm signs with
+var ecCurves = map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+
+// verifySignature checks sig over signed with the algorithm named by alg, which must
+// match the key's type, and for EC keys its curve ("none" is never accepted)
+func verifySignature(alg string, key any, signed string, sig []byte) error {
+	var hashType crypto.Hash
+	var newHash func() hash.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hashType, newHash = crypto.SHA256, sha256.New
+	case "384":
+		hashType, newHash = crypto.SHA384, sha512.New384
+	case "512":
+		hashType, newHash = crypto.SHA512, sha512.New
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	h := newHash()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(key, hashType, digest, sig) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if ecCurves[alg] == key.Curve.Params().Name && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return nil
+			}
+		}
+	case []byte:
+		mac := hmac.New(newHash, key)
+		mac.Write([]byte(signed))
+		if strings.HasPrefix(alg, "HS") && hmac.Equal(sig, mac.Sum(nil)) {
+			return nil
+		}
+	}
+	return errors.New("invalid token signature")
+}
+
+// jwk is a JSON Web Key; only RSA and EC public keys are supported
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (any, error) {
+	field := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, errors.New("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := field(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := field(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := field(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := field(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signHS256 builds an HS256 token with claims
+func signHS256(t *testing.T, secret string, claims map[string]any) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestLocalJWTClaims(t *testing.T) {
+	v := newJWTVerifier(LocalJWTConfig{Secret: "s3cr3t", Audience: "gateway"})
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	tests := []struct {
+		name   string
+		claims map[string]any
+		ok     bool
+	}{
+		{"valid", map[string]any{"sub": "u1", "role": "user", "exp": exp, "aud": "gateway"}, true},
+		{"audience list", map[string]any{"sub": "u1", "role": "user", "exp": exp, "aud": []string{"billing", "gateway"}}, true},
+		{"no expiry", map[string]any{"sub": "u1", "role": "user", "aud": "gateway"}, false},
+		{"expired", map[string]any{"sub": "u1", "role": "user", "exp": exp - 2*3600, "aud": "gateway"}, false},
+		{"wrong audience", map[string]any{"sub": "u1", "role": "user", "exp": exp, "aud": "billing"}, false},
+		{"no audience", map[string]any{"sub": "u1", "role": "user", "exp": exp}, false},
+	}
+	for _, tt := range tests {
+		id, err := v.verify(signHS256(t, "s3cr3t", tt.claims))
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("%s: identity %+v, error %v; want ok %v", tt.name, id, err, tt.ok)
+		}
+	}
+	if _, err := v.verify(signHS256(t, "other", map[string]any{"sub": "u1", "role": "user", "exp": exp, "aud": "gateway"})); err == nil {
+		t.Error("token signed with another secret was accepted")
+	}
+}
+
+func TestECKeysMustMatchTheAlgorithmsCurve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(alg string, hash crypto.Hash) (string, []byte) {
+		signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"`+alg+`"}`)) + ".e30"
+		h := hash.New()
+		h.Write([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed, append(r.FillBytes(make([]byte, 48)), s.FillBytes(make([]byte, 48))...)
+	}
+
+	if signed, sig := sign("ES384", crypto.SHA384); verifySignature("ES384", &key.PublicKey, signed, sig) != nil {
+		t.Error("ES384 token signed with a P-384 key rejected")
+	}
+	if signed, sig := sign("ES256", crypto.SHA256); verifySignature("ES256", &key.PublicKey, signed, sig) == nil {
+		t.Error("ES256 token signed with a P-384 key accepted")
+	}
+}
+
+func TestUnknownKeysRefreshTheJWKSOnce(t *testing.T) {
+	var fetches atomic.Int64
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		writeJSON(w, http.StatusOK, map[string]any{"keys": []any{}})
+	}))
+	defer jwks.Close()
+	g := newTestGateway(t, &Config{LocalJWT: LocalJWTConfig{Enabled: true, JWKSPath: jwks.URL}})
+
+	token := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"rotated"}`)) + ".e30.c2ln"
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			g.verifyToken(context.Background(), token)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("%d JWKS fetches for concurrent unknown keys, want 1", n)
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const defaultMaintenanceRetryAfter = 60 * time.Second
+
+// maintenance is the state of a service taken out of service for a deploy, either
+// immediately ("maintenance") or by letting in-flight requests finish ("draining")
+type maintenance struct {
+	Status     string
+	Since      time.Time
+	RetryAfter time.Duration
+}
+
+// maintenanceRequest is the body of POST /admin/maintenance and POST /admin/drain
+type maintenanceRequest struct {
+	Service string `json:"service"`
+	Enabled bool   `json:"enabled"`
+	// RetryAfter is a duration such as "2m" sent to clients in Retry-After (default 60s)
+	RetryAfter string `json:"retryAfter,omitempty"`
+}
+
+// MaintenanceHandler turns maintenance mode on or off for a service (POST /admin/maintenance).
+// While it's on, ProxyHandler answers 503 for the service instead of forwarding.
+func (g *Gateway) MaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	g.setOutOfService(w, r, "maintenance", func(up *upstream) *atomic.Pointer[maintenance] { return &up.maintenance })
+}
+
+// DrainHandler starts or stops draining a service before its deploy (POST /admin/drain).
+// New requests get 503 while in-flight ones finish; /admin/stats reports the service as
+// drained once its in-flight count reaches zero.
+func (g *Gateway) DrainHandler(w http.ResponseWriter, r *http.Request) {
+	g.setOutOfService(w, r, "draining", func(up *upstream) *atomic.Pointer[maintenance] { return &up.draining })
+}
+
+// setOutOfService applies a maintenanceRequest to the flag selected by field
+func (g *Gateway) setOutOfService(w http.ResponseWriter, r *http.Request, status string, field func(*upstream) *atomic.Pointer[maintenance]) {
+	var req maintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid "+status+" request")
+		return
+	}
+	up, ok := g.upstreams()[req.Service]
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown service "+req.Service)
+		return
+	}
+	flag := field(up)
+
+	if !req.Enabled {
+		flag.Store(nil)
+		g.Logger.Info("Service back in service", "service", req.Service, "status", status)
+	} else {
+		retry := defaultMaintenanceRetryAfter
+		if req.RetryAfter != "" {
+			d, err := time.ParseDuration(req.RetryAfter)
+			if err != nil || d <= 0 {
+				writeJSONError(w, http.StatusBadRequest, "retryAfter must be a positive duration")
+				return
+			}
+			retry = d
+		}
+		flag.Store(&maintenance{Status: status, Since: time.Now(), RetryAfter: retry})
+		g.Logger.Info("Service out of service", "service", req.Service, "status", status)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"service":  req.Service,
+		status:     req.Enabled,
+		"inFlight": up.stats.inFlight.Load(),
+	})
+}
+
+// outOfService returns the maintenance or draining state of a service, if any
+func (up *upstream) outOfService() *maintenance {
+	if m := up.maintenance.Load(); m != nil {
+		return m
+	}
+	return up.draining.Load()
+}
+
+// writeMaintenance answers a request for a service in maintenance mode or draining
+func writeMaintenance(w http.ResponseWriter, m *maintenance) {
+	w.Header().Set("Retry-After", retryAfter(m.RetryAfter))
+	writeJSONError(w, http.StatusServiceUnavailable, m.Status)
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	blog, users := newTestUpstream(t), newTestUpstream(t)
+	g := newTestGateway(t, &Config{BlogServiceURL: blog.URL, UserServiceURL: users.URL})
+	toggle := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/maintenance", strings.NewReader(body))
+		return serve(t, http.HandlerFunc(g.MaintenanceHandler), req, nil).Code
+	}
+	get := func(service, path string) *httptest.ResponseRecorder {
+		return serve(t, g.ProxyHandler(service), httptest.NewRequest(http.MethodGet, path, nil), nil)
+	}
+
+	if code := toggle(`{"service":"blog","enabled":true,"retryAfter":"2m"}`); code != http.StatusOK {
+		t.Fatalf("enabling maintenance: status %d", code)
+	}
+	var body map[string]any
+	rec := serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), &body)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "120" || body["detail"] != "maintenance" || blog.calls.Load() != 0 {
+		t.Errorf("blog in maintenance: status %d, Retry-After %q, body %v after %d backend calls",
+			rec.Code, rec.Header().Get("Retry-After"), body, blog.calls.Load())
+	}
+	if rec := get(ServiceUser, "/api/user/profile"); rec.Code != http.StatusOK {
+		t.Errorf("user service during blog maintenance: status %d", rec.Code)
+	}
+
+	if code := toggle(`{"service":"blog","enabled":false}`); code != http.StatusOK {
+		t.Fatalf("disabling maintenance: status %d", code)
+	}
+	if rec := get(ServiceBlog, "/api/blog/posts"); rec.Code != http.StatusOK || blog.calls.Load() != 1 {
+		t.Errorf("blog after maintenance: status %d after %d backend calls", rec.Code, blog.calls.Load())
+	}
+
+	for body, want := range map[string]int{
+		`{"service":"billing","enabled":true}`:                   http.StatusNotFound,
+		`{"service":"blog","enabled":true,"retryAfter":"later"}`: http.StatusBadRequest,
+		`not json`: http.StatusBadRequest,
+	} {
+		if code := toggle(body); code != want {
+			t.Errorf("%s: status %d, want %d", body, code, want)
+		}
+	}
+}
+
+func TestDrainingLetsInFlightRequestsFinish(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/user/slow" {
+			entered <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(users.Close)
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{BlogServiceURL: blog.URL, UserServiceURL: users.URL})
+	drain := func(body string) map[string]any {
+		var resp map[string]any
+		req := httptest.NewRequest(http.MethodPost, "/admin/drain", strings.NewReader(body))
+		if rec := serve(t, http.HandlerFunc(g.DrainHandler), req, &resp); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", body, rec.Code)
+		}
+		return resp
+	}
+	userStats := func() serviceStats {
+		var resp statsResponse
+		serve(t, http.HandlerFunc(g.StatsHandler), httptest.NewRequest(http.MethodGet, "/admin/stats", nil), &resp)
+		return resp.Services[ServiceUser]
+	}
+	get := func(service, path string) *httptest.ResponseRecorder {
+		return serve(t, g.ProxyHandler(service), httptest.NewRequest(http.MethodGet, path, nil), nil)
+	}
+
+	slow := make(chan int)
+	go func() { slow <- get(ServiceUser, "/api/user/slow").Code }()
+	<-entered
+	if resp := drain(`{"service":"user","enabled":true,"retryAfter":"30s"}`); resp["draining"] != true || resp["inFlight"] != 1.0 {
+		t.Errorf("drain response %v, want draining with 1 in flight", resp)
+	}
+
+	var body map[string]any
+	rec := serve(t, g.ProxyHandler(ServiceUser), httptest.NewRequest(http.MethodGet, "/api/user/profile", nil), &body)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "30" || body["detail"] != "draining" {
+		t.Errorf("new request while draining: status %d, Retry-After %q, body %v", rec.Code, rec.Header().Get("Retry-After"), body)
+	}
+	if s := userStats(); !s.Draining || s.Drained || s.InFlight != 1 {
+		t.Errorf("stats with a request in flight: %+v, want draining but not drained", s)
+	}
+	if rec := get(ServiceBlog, "/api/blog/posts"); rec.Code != http.StatusOK {
+		t.Errorf("blog while the user service drains: status %d", rec.Code)
+	}
+
+	close(release)
+	if code := <-slow; code != http.StatusOK {
+		t.Errorf("in-flight request: status %d, want it finished normally", code)
+	}
+	if s := userStats(); !s.Draining || !s.Drained || s.InFlight != 0 {
+		t.Errorf("stats after the last request: %+v, want drained", s)
+	}
+
+	drain(`{"service":"user","enabled":false}`)
+	if rec := get(ServiceUser, "/api/user/profile"); rec.Code != http.StatusOK {
+		t.Errorf("after undraining: status %d", rec.Code)
+	}
+	if s := userStats(); s.Draining || s.Drained {
+		t.Errorf("stats after undraining: %+v", s)
+	}
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the upstream latency histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Auth validation results counted by gateway_auth_validations_total
+const (
+	authSuccess     = "success"
+	authInvalid     = "invalid"
+	authUnavailable = "unavailable"
+	authStale       = "stale"
+	authDeadline    = "deadline"
+	authMissing     = "missing"
+	authAnonymous   = "anonymous" // no credentials on an optional auth path
+	authRevoked     = "revoked"
+)
+
+type requestLabels struct {
+	service, method, code string
+}
+
+type histogram struct {
+	counts []int64 // per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  int64
+}
+
+// metrics holds the counters exported in Prometheus text format on /metrics
+type metrics struct {
+	mu       sync.Mutex
+	requests map[requestLabels]int64
+	latency  map[string]*histogram
+	auth     map[string]int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[requestLabels]int64),
+		latency:  make(map[string]*histogram),
+		auth:     make(map[string]int64),
+	}
+}
+
+func (m *metrics) observeRequest(service, method string, status int, d time.Duration) {
+	if !slices.Contains(ProxyMethods, method) {
+		method = "OTHER" // keeps label cardinality bounded
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{service, method, strconv.Itoa(status)}]++
+	h, ok := m.latency[service]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(latencyBuckets)+1)}
+		m.latency[service] = h
+	}
+	secs := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, secs)
+	h.counts[i]++
+	h.sum += secs
+	h.count++
+}
+
+func (m *metrics) observeAuth(result string) {
+	m.mu.Lock()
+	m.auth[result]++
+	m.mu.Unlock()
+}
+
+// MetricsHandler exports request, latency, in-flight and auth validation metrics in
+// the Prometheus text format (GET /metrics)
+func (g *Gateway) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m := g.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(w, "gateway_requests_total", "counter", "Proxied requests by service, method and status code.")
+	keys := make([]requestLabels, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.service != b.service {
+			return a.service < b.service
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "gateway_requests_total{service=%q,method=%q,code=%q} %d\n", k.service, k.method, k.code, m.requests[k])
+	}
+
+	writeMetricHeader(w, "gateway_upstream_duration_seconds", "histogram", "Time to serve proxied requests by service.")
+	for _, service := range sortedKeys(m.latency) {
+		h := m.latency[service]
+		var cumulative int64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "gateway_upstream_duration_seconds_bucket{service=%q,le=%q} %d\n", service, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "gateway_upstream_duration_seconds_bucket{service=%q,le=\"+Inf\"} %d\n", service, h.count)
+		fmt.Fprintf(w, "gateway_upstream_duration_seconds_sum{service=%q} %g\n", service, h.sum)
+		fmt.Fprintf(w, "gateway_upstream_duration_seconds_count{service=%q} %d\n", service, h.count)
+	}
+
+	writeMetricHeader(w, "gateway_in_flight_requests", "gauge", "Proxied requests currently being served by service.")
+	upstreams := g.upstreams()
+	for _, service := range sortedKeys(upstreams) {
+		fmt.Fprintf(w, "gateway_in_flight_requests{service=%q} %d\n", service, upstreams[service].stats.inFlight.Load())
+	}
+
+	writeMetricHeader(w, "gateway_auth_validations_total", "counter", "JWT validations by result.")
+	for _, result := range sortedKeys(m.auth) {
+		fmt.Fprintf(w, "gateway_auth_validations_total{result=%q} %d\n", result, m.auth[result])
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrapeMetrics fetches /metrics and returns its samples keyed by name and labels,
+// failing on lines that aren't in the Prometheus text format
+func scrapeMetrics(t *testing.T, g *Gateway) map[string]float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	g.MetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	sample := regexp.MustCompile(`^([a-z_]+(?:\{[a-z]+="[^"]*"(?:,[a-z]+="[^"]*")*\})?) (\S+)$`)
+	samples := map[string]float64{}
+	sc := bufio.NewScanner(rec.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		m := sample.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("invalid metrics line %q", line)
+		}
+		v, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			t.Fatalf("invalid value in %q", line)
+		}
+		samples[m[1]] = v
+	}
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"alice-token": {UserID: "alice", Role: "user"}})
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/blog/slow":
+			time.Sleep(30 * time.Millisecond)
+		case "/api/blog/broken":
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(blog.Close)
+	g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, BlogServiceURL: blog.URL})
+	h := g.AuthMiddleware(g.ProxyHandler(ServiceBlog))
+	for _, tt := range []struct{ method, path, token string }{
+		{http.MethodGet, "/api/blog/posts", "alice-token"},
+		{http.MethodGet, "/api/blog/posts", "alice-token"},
+		{http.MethodPost, "/api/blog/posts", "alice-token"},
+		{http.MethodGet, "/api/blog/slow", "alice-token"},
+		{http.MethodGet, "/api/blog/broken", "alice-token"},
+		{http.MethodGet, "/api/blog/posts", "forged-token"},
+		{http.MethodGet, "/api/blog/posts", ""},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		serve(t, h, req, nil)
+	}
+	// Methods outside ProxyMethods share one label
+	g.metrics.observeRequest(ServiceBlog, "BREW", http.StatusOK, time.Millisecond)
+
+	samples := scrapeMetrics(t, g)
+	for sample, want := range map[string]float64{
+		`gateway_requests_total{service="blog",method="GET",code="200"}`:     3,
+		`gateway_requests_total{service="blog",method="POST",code="200"}`:    1,
+		`gateway_requests_total{service="blog",method="GET",code="500"}`:     1,
+		`gateway_requests_total{service="blog",method="OTHER",code="200"}`:   1,
+		`gateway_upstream_duration_seconds_count{service="blog"}`:            6,
+		`gateway_upstream_duration_seconds_bucket{service="blog",le="+Inf"}`: 6,
+		`gateway_upstream_duration_seconds_bucket{service="blog",le="10"}`:   6,
+		`gateway_in_flight_requests{service="blog"}`:                         0,
+		`gateway_in_flight_requests{service="user"}`:                         0,
+		`gateway_auth_validations_total{result="success"}`:                   5,
+		`gateway_auth_validations_total{result="invalid"}`:                   1,
+		`gateway_auth_validations_total{result="missing"}`:                   1,
+	} {
+		if got, ok := samples[sample]; !ok || got != want {
+			t.Errorf("%s = %g (present %t), want %g", sample, got, ok, want)
+		}
+	}
+	if sum := samples[`gateway_upstream_duration_seconds_sum{service="blog"}`]; sum < 0.03 {
+		t.Errorf("latency sum %gs, want at least the slow request's 30ms", sum)
+	}
+	if fast := samples[`gateway_upstream_duration_seconds_bucket{service="blog",le="0.025"}`]; fast > 5 {
+		t.Errorf("%g requests within 25ms, want the slow one in a later bucket", fast)
+	}
+	// Buckets are cumulative
+	prev := 0.0
+	for _, le := range latencyBuckets {
+		v := samples[`gateway_upstream_duration_seconds_bucket{service="blog",le="`+strconv.FormatFloat(le, 'g', -1, 64)+`"}`]
+		if v < prev {
+			t.Errorf("bucket le=%g has %g, below the %g of the one before", le, v, prev)
+		}
+		prev = v
+	}
+	// Requests turned away by the gateway never reach a service
+	if _, ok := samples[`gateway_requests_total{service="blog",method="GET",code="401"}`]; ok {
+		t.Error("unauthenticated requests counted as proxied")
+	}
+}
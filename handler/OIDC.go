@@ -0,0 +1,485 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDC defaults
+var defaultOIDCScopes = []string{"openid", "profile", "email"}
+
+const (
+	defaultOIDCLoginPath     = "/oidc/login"
+	defaultOIDCLogoutPath    = "/oidc/logout"
+	defaultOIDCSessionCookie = "gateway_session"
+	defaultOIDCSessionTTL    = 8 * time.Hour
+	defaultOIDCRoleClaim     = "role"
+	defaultOIDCRole          = "user"
+
+	oidcStateCookie = "gateway_oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+
+	// oidcTokenMaxBody bounds the token endpoint's response
+	oidcTokenMaxBody = 64 << 10
+)
+
+// OIDCConfig makes the gateway an OpenID Connect relying party: it runs the
+// authorization-code flow (with PKCE) against an external IdP such as Keycloak or Auth0,
+// keeps the resulting identity in a signed session cookie, and AuthMiddleware accepts
+// that cookie like a token. Zero values mean the defaults above.
+type OIDCConfig struct {
+	// Issuer is the IdP's issuer URL, discovered through its
+	// /.well-known/openid-configuration; empty disables OIDC
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered with the IdP; the gateway serves its path
+	RedirectURL string
+	Scopes      []string // default openid, profile and email
+
+	// RoleClaim names the ID token claim holding the role, dotted for nested claims such
+	// as Keycloak's realm_access.roles; of a list, the first entry is used. Users without
+	// one get DefaultRole (default "user").
+	RoleClaim   string
+	DefaultRole string
+
+	// SessionSecret signs the session cookie, so any replica sharing it accepts sessions
+	SessionSecret string
+	SessionCookie string        // default gateway_session
+	SessionTTL    time.Duration // default 8h
+	// Secure limits the session cookie to HTTPS; only turn it off for local development
+	Secure bool
+
+	LoginPath  string // default /oidc/login; ?redirect=/path returns there after login
+	LogoutPath string // default /oidc/logout
+}
+
+func (c OIDCConfig) withDefaults() OIDCConfig {
+	if len(c.Scopes) == 0 {
+		c.Scopes = defaultOIDCScopes
+	}
+	if c.RoleClaim == "" {
+		c.RoleClaim = defaultOIDCRoleClaim
+	}
+	if c.DefaultRole == "" {
+		c.DefaultRole = defaultOIDCRole
+	}
+	if c.SessionCookie == "" {
+		c.SessionCookie = defaultOIDCSessionCookie
+	}
+	if c.SessionTTL <= 0 {
+		c.SessionTTL = defaultOIDCSessionTTL
+	}
+	if c.LoginPath == "" {
+		c.LoginPath = defaultOIDCLoginPath
+	}
+	if c.LogoutPath == "" {
+		c.LogoutPath = defaultOIDCLogoutPath
+	}
+	return c
+}
+
+// validate rejects an enabled configuration missing what the flow needs
+func (c OIDCConfig) validate() error {
+	if c.Issuer == "" {
+		return nil
+	}
+	if c.ClientID == "" || c.SessionSecret == "" {
+		return errors.New("OIDC requires a client ID and a session secret")
+	}
+	if u, err := url.Parse(c.RedirectURL); err != nil || !u.IsAbs() {
+		return fmt.Errorf("invalid OIDC redirect URL %q", c.RedirectURL)
+	}
+	return nil
+}
+
+// oidcProvider holds the IdP's endpoints and keys, discovered on first use
+type oidcProvider struct {
+	config       OIDCConfig
+	callbackPath string
+	verifier     *jwtVerifier
+
+	mu                    sync.Mutex
+	discovered            bool
+	authorizationEndpoint string
+	tokenEndpoint         string
+	endSessionEndpoint    string
+}
+
+func newOIDCProvider(config OIDCConfig) *oidcProvider {
+	config = config.withDefaults()
+	u, _ := url.Parse(config.RedirectURL) // checked by validate
+	return &oidcProvider{
+		config:       config,
+		callbackPath: u.Path,
+		verifier:     newJWTVerifier(LocalJWTConfig{Issuer: config.Issuer, Audience: config.ClientID}),
+	}
+}
+
+// DiscoverOIDC reads the IdP's configuration and signing keys; it's a no-op unless OIDC
+// is configured. Login retries discovery until it succeeds.
+func (g *Gateway) DiscoverOIDC(ctx context.Context) error {
+	if g.oidc == nil {
+		return nil
+	}
+	p := g.oidc
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovered {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create OIDC discovery request: %w", err)
+	}
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch OIDC configuration: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OIDC discovery returned status: %d", resp.StatusCode)
+	}
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		EndSessionEndpoint    string `json:"end_session_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("failed to decode OIDC configuration: %w", err)
+	}
+	if doc.Issuer != p.config.Issuer {
+		return fmt.Errorf("OIDC configuration is for issuer %q", doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return errors.New("OIDC configuration is missing endpoints")
+	}
+
+	p.verifier.config.JWKSPath = doc.JWKSURI
+	if err := g.fetchOIDCKeys(ctx); err != nil {
+		return err
+	}
+	p.authorizationEndpoint, p.tokenEndpoint, p.endSessionEndpoint = doc.AuthorizationEndpoint, doc.TokenEndpoint, doc.EndSessionEndpoint
+	p.discovered = true
+	return nil
+}
+
+// fetchOIDCKeys loads the IdP's JWKS; HMAC-signed ID tokens are verified with the client secret
+func (g *Gateway) fetchOIDCKeys(ctx context.Context) error {
+	v := g.oidc.verifier
+	v.mu.Lock()
+	v.lastRefresh = time.Now()
+	v.mu.Unlock()
+	keys, err := g.fetchJWKS(ctx, g.Client, v.config.JWKSPath)
+	if err != nil {
+		if g.oidc.config.ClientSecret == "" {
+			return err
+		}
+		g.Logger.Warn("No OIDC signing keys, only HMAC-signed ID tokens will verify", "error", err)
+		keys = make(map[string]any, 1)
+	}
+	if _, ok := keys[""]; !ok && g.oidc.config.ClientSecret != "" {
+		keys[""] = []byte(g.oidc.config.ClientSecret)
+	}
+	v.mu.Lock()
+	v.keys = keys
+	v.mu.Unlock()
+	return nil
+}
+
+// OIDCPaths are the login, callback and logout paths for the router
+func (g *Gateway) OIDCPaths() []string {
+	if g.oidc == nil {
+		return nil
+	}
+	return []string{g.oidc.config.LoginPath, g.oidc.callbackPath, g.oidc.config.LogoutPath}
+}
+
+// OIDCHandler serves the OIDCPaths: login and callback with GET, logout with POST only,
+// so another site can't log users out with a link or an image
+func (g *Gateway) OIDCHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := http.MethodGet
+		if r.URL.Path == g.oidc.config.LogoutPath {
+			method = http.MethodPost
+		}
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSONError(w, http.StatusMethodNotAllowed, "method "+r.Method+" not allowed")
+			return
+		}
+		switch r.URL.Path {
+		case g.oidc.config.LoginPath:
+			g.oidcLogin(w, r)
+		case g.oidc.callbackPath:
+			g.oidcCallback(w, r)
+		case g.oidc.config.LogoutPath:
+			g.oidcLogout(w, r)
+		default:
+			writeJSONError(w, http.StatusNotFound, "not found")
+		}
+	})
+}
+
+// oidcState is kept in a signed cookie between login and callback
+type oidcState struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	Redirect  string `json:"redirect"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// oidcLogin redirects the browser to the IdP's authorization endpoint
+func (g *Gateway) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	p := g.oidc
+	if err := g.DiscoverOIDC(r.Context()); err != nil {
+		g.Logger.ErrorContext(r.Context(), "OIDC discovery failed", "error", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "identity provider unavailable")
+		return
+	}
+	st := oidcState{
+		State:     randomToken(),
+		Nonce:     randomToken(),
+		Verifier:  randomToken(),
+		Redirect:  localRedirect(r.URL.Query().Get("redirect")),
+		ExpiresAt: time.Now().Add(oidcStateTTL).Unix(),
+	}
+	http.SetCookie(w, p.cookie(oidcStateCookie, p.sign(st), p.callbackPath, int(oidcStateTTL.Seconds())))
+
+	challenge := sha256.Sum256([]byte(st.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {st.State},
+		"nonce":                 {st.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	http.Redirect(w, r, p.authorizationEndpoint+"?"+q.Encode(), http.StatusFound)
+}
+
+// oidcCallback exchanges the authorization code for an ID token and starts the session
+func (g *Gateway) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	p := g.oidc
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		g.Logger.InfoContext(r.Context(), "OIDC login failed", "error", e, "description", q.Get("error_description"))
+		writeJSONError(w, http.StatusUnauthorized, "login failed: "+e)
+		return
+	}
+	var st oidcState
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || !p.verify(cookie.Value, &st) || time.Now().Unix() > st.ExpiresAt ||
+		subtle.ConstantTimeCompare([]byte(st.State), []byte(q.Get("state"))) != 1 {
+		writeJSONError(w, http.StatusBadRequest, "invalid or expired login state")
+		return
+	}
+	http.SetCookie(w, p.cookie(oidcStateCookie, "", p.callbackPath, -1))
+	if err := g.DiscoverOIDC(r.Context()); err != nil {
+		g.Logger.ErrorContext(r.Context(), "OIDC discovery failed", "error", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "identity provider unavailable")
+		return
+	}
+
+	idToken, err := g.exchangeOIDCCode(r.Context(), q.Get("code"), st.Verifier)
+	if err != nil {
+		g.Logger.ErrorContext(r.Context(), "OIDC code exchange failed", "error", err)
+		writeJSONError(w, http.StatusBadGateway, "identity provider rejected the login")
+		return
+	}
+	claims, err := p.verifier.verifyClaims(idToken)
+	if errors.Is(err, errUnknownKey) && p.verifier.claimRefresh() {
+		if err := g.fetchOIDCKeys(r.Context()); err != nil {
+			g.Logger.ErrorContext(r.Context(), "OIDC JWKS refresh failed", "error", err)
+		}
+		claims, err = p.verifier.verifyClaims(idToken)
+	}
+	if err == nil && claims["nonce"] != st.Nonce {
+		err = errors.New("ID token nonce mismatch")
+	}
+	if err != nil {
+		g.Logger.WarnContext(r.Context(), "Invalid OIDC ID token", "error", err)
+		writeJSONError(w, http.StatusUnauthorized, "invalid ID token")
+		return
+	}
+
+	id := p.identity(claims)
+	if id.UserID == "" {
+		writeJSONError(w, http.StatusUnauthorized, "ID token has no subject")
+		return
+	}
+	http.SetCookie(w, p.cookie(p.config.SessionCookie, p.sign(id), "/", int(p.config.SessionTTL.Seconds())))
+	g.Logger.InfoContext(r.Context(), "OIDC login", "user_id", id.UserID, "role", id.Role)
+	http.Redirect(w, r, st.Redirect, http.StatusFound)
+}
+
+// exchangeOIDCCode redeems an authorization code at the token endpoint for an ID token
+func (g *Gateway) exchangeOIDCCode(ctx context.Context, code, verifier string) (string, error) {
+	p := g.oidc
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to contact token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcTokenMaxBody)).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("token endpoint returned status %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return "", fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, tokens.Error)
+	}
+	return tokens.IDToken, nil
+}
+
+// oidcLogout ends the session and, when the IdP supports it, the IdP's session too
+func (g *Gateway) oidcLogout(w http.ResponseWriter, r *http.Request) {
+	p := g.oidc
+	http.SetCookie(w, p.cookie(p.config.SessionCookie, "", "/", -1))
+	redirect := localRedirect(r.URL.Query().Get("redirect"))
+	p.mu.Lock()
+	endSession := p.endSessionEndpoint
+	p.mu.Unlock()
+	if endSession != "" {
+		// redirect may carry a query, which mustn't end up escaped in the path
+		back, _ := url.Parse(p.config.RedirectURL)
+		target, err := url.Parse(redirect)
+		if err != nil {
+			target = &url.URL{Path: "/"}
+		}
+		back.Path, back.RawPath, back.RawQuery, back.Fragment = target.Path, target.RawPath, target.RawQuery, ""
+		q := url.Values{"client_id": {p.config.ClientID}, "post_logout_redirect_uri": {back.String()}}
+		redirect = endSession + "?" + q.Encode()
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// identity maps ID token claims to the identity forwarded upstream
+func (p *oidcProvider) identity(claims map[string]any) *AuthValidateResponse {
+	now := time.Now()
+	id := &AuthValidateResponse{
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(p.config.SessionTTL).Unix(),
+	}
+	id.UserID, _ = claimValue(claims["sub"])
+	if id.Username, _ = claimValue(claims["preferred_username"]); id.Username == "" {
+		id.Username, _ = claimValue(claims["email"])
+	}
+	var role any = claims
+	for _, key := range strings.Split(p.config.RoleClaim, ".") {
+		m, _ := role.(map[string]any)
+		role = m[key]
+	}
+	if roles, ok := role.([]any); ok && len(roles) > 0 {
+		role = roles[0]
+	}
+	if id.Role, _ = claimValue(role); id.Role == "" {
+		id.Role = p.config.DefaultRole
+	}
+	return id
+}
+
+// session returns the identity of a valid session cookie and the cookie's value
+func (p *oidcProvider) session(r *http.Request) (*AuthValidateResponse, string) {
+	cookie, err := r.Cookie(p.config.SessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, ""
+	}
+	var id AuthValidateResponse
+	if !p.verify(cookie.Value, &id) || time.Now().Unix() >= id.ExpiresAt {
+		return nil, ""
+	}
+	return &id, cookie.Value
+}
+
+// sign encodes v as base64url JSON with an HMAC-SHA256 of it
+func (p *oidcProvider) sign(v any) string {
+	payload, _ := json.Marshal(v)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(p.config.SessionSecret))
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify decodes a value made by sign into v, reporting whether its signature holds
+func (p *oidcProvider) verify(value string, v any) bool {
+	encoded, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(p.config.SessionSecret))
+	mac.Write([]byte(encoded))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	return err == nil && json.Unmarshal(payload, v) == nil
+}
+
+// cookie builds an HttpOnly cookie; Lax so it comes along on the IdP's redirect back
+func (p *oidcProvider) cookie(name, value, path string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		Secure:   p.config.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// randomToken returns 32 random bytes as base64url
+func randomToken() string {
+	var b [32]byte
+	rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// localRedirect keeps post-login redirects on the gateway's own site
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.Contains(target, `\`) {
+		return "/"
+	}
+	return target
+}
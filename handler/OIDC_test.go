@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIdP is an OIDC provider signing HS256 ID tokens with the client secret for the
+// code "good-code", once the verifier matches the login's code challenge
+func testIdP(t *testing.T, nonce *string, challenge *string) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			writeJSON(w, http.StatusOK, map[string]string{
+				"issuer":                 srv.URL,
+				"authorization_endpoint": srv.URL + "/authorize",
+				"token_endpoint":         srv.URL + "/token",
+				"end_session_endpoint":   srv.URL + "/logout",
+				"jwks_uri":               srv.URL + "/jwks",
+			})
+		case "/jwks":
+			writeJSON(w, http.StatusOK, map[string]any{"keys": []any{}})
+		case "/token":
+			id, secret, _ := r.BasicAuth()
+			sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+			if id != "gateway" || secret != "client-secret" || r.PostFormValue("code") != "good-code" ||
+				base64.RawURLEncoding.EncodeToString(sum[:]) != *challenge {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"id_token": signHS256(t, "client-secret", map[string]any{
+				"iss":                srv.URL,
+				"aud":                "gateway",
+				"sub":                "alice",
+				"exp":                float64(time.Now().Add(5 * time.Minute).Unix()),
+				"nonce":              *nonce,
+				"preferred_username": "alice@example.com",
+				"realm_access":       map[string]any{"roles": []string{"admin", "user"}},
+			})})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOIDCLogin(t *testing.T) {
+	var nonce, challenge string
+	idp := testIdP(t, &nonce, &challenge)
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{BlogServiceURL: blog.URL, OIDC: OIDCConfig{
+		Issuer:        idp.URL,
+		ClientID:      "gateway",
+		ClientSecret:  "client-secret",
+		RedirectURL:   "https://gateway.example.com/oidc/callback",
+		RoleClaim:     "realm_access.roles",
+		SessionSecret: "session-secret",
+	}})
+	oidc := g.OIDCHandler()
+
+	rec := serve(t, oidc, httptest.NewRequest(http.MethodGet, "/oidc/login?redirect=/blog/new", nil), nil)
+	authorize, err := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusFound || err != nil || !strings.HasPrefix(authorize.String(), idp.URL+"/authorize?") {
+		t.Fatalf("login got %d to %q, want a redirect to the IdP", rec.Code, rec.Header().Get("Location"))
+	}
+	q := authorize.Query()
+	nonce, challenge = q.Get("nonce"), q.Get("code_challenge")
+	state := rec.Result().Cookies()[0]
+
+	callback := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/oidc/callback?"+query, nil)
+		req.AddCookie(state)
+		return serve(t, oidc, req, nil)
+	}
+	if rec := callback("code=good-code&state=forged"); rec.Code != http.StatusBadRequest {
+		t.Errorf("callback with a forged state got %d, want 400", rec.Code)
+	}
+	if rec := callback("code=bad-code&state=" + q.Get("state")); rec.Code != http.StatusBadGateway {
+		t.Errorf("callback with a rejected code got %d, want 502", rec.Code)
+	}
+	rec = callback("code=good-code&state=" + q.Get("state"))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/blog/new" {
+		t.Fatalf("callback got %d to %q, want a redirect to /blog/new: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == defaultOIDCSessionCookie {
+			session = c
+		}
+	}
+	if session == nil || !session.HttpOnly {
+		t.Fatalf("callback set cookies %v, want an HttpOnly session", rec.Result().Cookies())
+	}
+
+	h := g.AuthMiddleware(g.ProxyHandler(ServiceBlog))
+	req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+	req.AddCookie(session)
+	var resp testUpstreamResponse
+	if rec := serve(t, h, req, &resp); rec.Code != http.StatusOK {
+		t.Fatalf("request with a session got %d: %s", rec.Code, rec.Body)
+	}
+	if resp.UserID != "alice" || resp.Header.Get("X-User-Role") != "admin" || resp.Header.Get("X-Username") != "alice@example.com" {
+		t.Errorf("upstream got user %q, role %q, username %q", resp.UserID, resp.Header.Get("X-User-Role"), resp.Header.Get("X-Username"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+	req.AddCookie(&http.Cookie{Name: session.Name, Value: session.Value + "x"})
+	if rec := serve(t, h, req, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("tampered session got %d, want 401", rec.Code)
+	}
+
+	rec = serve(t, oidc, httptest.NewRequest(http.MethodGet, "/oidc/logout", nil), nil)
+	if rec.Code != http.StatusMethodNotAllowed || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("logout over GET got %d with cookies %v, want 405 leaving the session", rec.Code, rec.Result().Cookies())
+	}
+	rec = serve(t, oidc, httptest.NewRequest(http.MethodPost, "/oidc/logout?redirect=//evil.example", nil), nil)
+	logout, _ := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusSeeOther || logout.Path != "/logout" || logout.Query().Get("post_logout_redirect_uri") != "https://gateway.example.com/" {
+		t.Errorf("logout got %d to %q, want the IdP's logout returning to the gateway", rec.Code, logout)
+	}
+	if c := rec.Result().Cookies(); len(c) != 1 || c[0].Name != defaultOIDCSessionCookie || c[0].MaxAge >= 0 {
+		t.Errorf("logout set cookies %v, want the session cleared", c)
+	}
+	rec = serve(t, oidc, httptest.NewRequest(http.MethodPost, "/oidc/logout?redirect="+url.QueryEscape("/bye?from=app"), nil), nil)
+	logout, _ = url.Parse(rec.Header().Get("Location"))
+	if back := logout.Query().Get("post_logout_redirect_uri"); back != "https://gateway.example.com/bye?from=app" {
+		t.Errorf("logout returns to %q, want the redirect's path and query", back)
+	}
+}
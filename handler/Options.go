@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Option customizes a Gateway built by NewGateway, mainly so tests can swap out the
+// network-facing parts
+type Option func(*gatewayOptions)
+
+type gatewayOptions struct {
+	client   *http.Client
+	targets  map[string][]*url.URL
+	proxies  map[string]http.Handler
+	resolver *net.Resolver
+}
+
+// WithHTTPClient replaces the client used for the gateway's own calls, such as AuthService
+// validation, aggregation and replays; services' credentials are still attached
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *gatewayOptions) {
+		o.client = client
+	}
+}
+
+// WithServiceTargets points a service at the given instances instead of its Config URL
+func WithServiceTargets(name string, targets ...*url.URL) Option {
+	return func(o *gatewayOptions) {
+		if o.targets == nil {
+			o.targets = make(map[string][]*url.URL)
+		}
+		o.targets[name] = targets
+	}
+}
+
+// WithProxy replaces a service's reverse proxy with h. The gateway's own layers
+// (balancing, throttling, caching, ...) still wrap it.
+func WithProxy(name string, h http.Handler) Option {
+	return func(o *gatewayOptions) {
+		if o.proxies == nil {
+			o.proxies = make(map[string]http.Handler)
+		}
+		o.proxies[name] = h
+	}
+}
+
+// WithResolver replaces the resolver RecycleConnections looks up upstream hostnames with
+func WithResolver(r *net.Resolver) Option {
+	return func(o *gatewayOptions) {
+		o.resolver = r
+	}
+}
+
+// serviceTargets returns the WithServiceTargets instances for a service, or parses its Config URL
+func (o *gatewayOptions) serviceTargets(name, raw string) ([]*url.URL, error) {
+	if targets, ok := o.targets[name]; ok {
+		return targets, nil
+	}
+	return parseTargets(raw)
+}
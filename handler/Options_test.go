@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// roundTripFunc lets a function serve as an http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestOptionsReplaceNetworkParts(t *testing.T) {
+	// AuthService answers through the injected client; its URL is never dialed
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := `{"error":"invalid token"}`
+		status := http.StatusUnauthorized
+		if r.URL.Path == "/api/auth/jwt" && r.Header.Get("Authorization") == "Bearer alice-token" {
+			body, status = `{"userID":"alice","role":"user"}`, http.StatusOK
+		}
+		return &http.Response{StatusCode: status, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})}
+	users := newTestUpstream(t)
+	target, _ := url.Parse(users.URL)
+	blog := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, testUpstreamResponse{Path: "stub " + r.URL.Path, UserID: r.Header.Get("X-User-ID")})
+	})
+	g := newTestGateway(t, &Config{
+		AuthServiceURL: "http://auth.invalid",
+		UserServiceURL: "http://users.invalid",
+	},
+		WithHTTPClient(client),
+		WithServiceTargets(ServiceUser, target),
+		WithProxy(ServiceBlog, blog),
+	)
+
+	for _, tt := range []struct{ service, path, want string }{
+		{ServiceUser, "/api/user/profile", "/api/user/profile"},
+		{ServiceBlog, "/api/blog/posts", "stub /api/blog/posts"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		var resp testUpstreamResponse
+		rec := serve(t, g.AuthMiddleware(g.ProxyHandler(tt.service)), req, &resp)
+		if rec.Code != http.StatusOK || resp.Path != tt.want || resp.UserID != "alice" {
+			t.Errorf("%s: status %d, response %+v; want %s for alice", tt.path, rec.Code, resp, tt.want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/profile", nil)
+	req.Header.Set("Authorization", "Bearer mallory-token")
+	if rec := serve(t, g.AuthMiddleware(g.ProxyHandler(ServiceUser)), req, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown token: status %d, want 401", rec.Code)
+	}
+}
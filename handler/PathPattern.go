@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultPublicPaths are the paths that skip JWT validation when Config.PublicPaths is unset
+var DefaultPublicPaths = []string{"/api/auth/"}
+
+// pathPatterns is a precompiled set of path patterns
+type pathPatterns []*regexp.Regexp
+
+// compilePathPatterns compiles path patterns once at startup. A pattern starting with
+// "^" is a regular expression. Anything else is a glob where "*" matches within one
+// path segment and "**" matches across segments; a glob ending in "/" matches everything
+// under that prefix, and one ending in "/**" also matches the prefix itself.
+func compilePathPatterns(patterns []string) (pathPatterns, error) {
+	compiled := make(pathPatterns, 0, len(patterns))
+	for _, p := range patterns {
+		expr := p
+		if !strings.HasPrefix(p, "^") {
+			expr = globToRegexp(p)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// globToRegexp translates a path glob to an anchored regular expression
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case glob[i:] == "/**":
+			// "/api/user/admin/**" covers /api/user/admin as well
+			b.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	if !strings.HasSuffix(glob, "/") {
+		b.WriteByte('$')
+	}
+	return b.String()
+}
+
+// match reports whether path matches any of the patterns
+func (ps pathPatterns) match(path string) bool {
+	for _, re := range ps {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
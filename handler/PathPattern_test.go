@@ -0,0 +1,30 @@
+package handler
+
+import "testing"
+
+func TestPathPatterns(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/api/user/admin/**", "/api/user/admin", true},
+		{"/api/user/admin/**", "/api/user/admin/", true},
+		{"/api/user/admin/**", "/api/user/admin/users/7", true},
+		{"/api/user/admin/**", "/api/user/administrator", false},
+		{"/api/blog/*/comments", "/api/blog/7/comments", true},
+		{"/api/blog/*/comments", "/api/blog/7/8/comments", false},
+		{"/api/blog/**/comments", "/api/blog/7/8/comments", true},
+		{"/api/auth/", "/api/auth/login", true},
+		{"/api/auth/", "/api/auth", false},
+		{"^/api/v[0-9]+/", "/api/v2/posts", true},
+	}
+	for _, tt := range tests {
+		ps, err := compilePathPatterns([]string{tt.pattern})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.pattern, err)
+		}
+		if got := ps.match(tt.path); got != tt.want {
+			t.Errorf("%s matching %s = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
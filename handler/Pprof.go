@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// PprofHandler serves the net/http/pprof endpoints under /debug/pprof/. CPU profiles and
+// traces run longer than the server's write timeout, so their deadline is lifted.
+func (g *Gateway) PprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", withoutWriteDeadline(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", withoutWriteDeadline(pprof.Trace))
+	return mux
+}
+
+func withoutWriteDeadline(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		next(w, r)
+	}
+}
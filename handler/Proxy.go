@@ -0,0 +1,352 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// upstream holds the proxy and runtime state of a single service
+type upstream struct {
+	name      string
+	svc       ServiceConfig
+	pool      atomic.Pointer[[]*instance] // replaced as a whole when discovery updates it
+	health    HealthScoreConfig
+	strategy  string        // LoadBalance* strategy across instances
+	next      atomic.Uint64 // round-robin position
+	proxy     *httputil.ReverseProxy
+	transport *http.Transport
+	handler   http.Handler // proxy wrapped with the service's optional layers
+
+	throttle *adaptiveThrottle // nil unless the service reports overload signals
+	bulkhead *bulkhead         // nil unless a concurrency limit is configured
+	cache    *responseCache    // nil unless response caching is enabled
+	breaker  *circuitBreaker   // nil unless a failure threshold is configured
+
+	// credentials identify the gateway to the service; nil unless configured
+	credentials *serviceCredentials
+
+	// client makes the gateway's own calls to the service (token validation, warm-up,
+	// replays, ...) over its transport, so its mTLS and egress proxy apply
+	client *http.Client
+
+	versions map[string]*upstream // extra API versions of the service, keyed like "v2"
+
+	maintenance atomic.Pointer[maintenance] // nil unless the service is in maintenance mode
+	draining    atomic.Pointer[maintenance] // nil unless the service is being drained
+	stats       requestStats
+	shadow      *shadow // nil unless the service mirrors traffic
+}
+
+// newUpstream builds the reverse proxy for a service, applying its ServiceConfig
+func (g *Gateway) newUpstream(name string, svc ServiceConfig, targets []*url.URL) (*upstream, error) {
+	cookieAuth := name == ServiceAuth && g.Config.CookieAuth.Enabled
+	cookieCfg := g.Config.CookieAuth.withDefaults()
+	transport, err := g.newTransport(svc)
+	if err != nil {
+		return nil, fmt.Errorf("%s service: %w", name, err)
+	}
+	if svc.GRPC {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	up := &upstream{
+		name:      name,
+		svc:       svc,
+		health:    g.Config.HealthScore.withDefaults(),
+		transport: transport,
+	}
+	if up.strategy, err = g.loadBalancing(svc); err != nil {
+		return nil, fmt.Errorf("%s service: %w", name, err)
+	}
+	up.setInstances(targets)
+	if svc.Credentials.enabled() {
+		if up.credentials, err = g.newServiceCredentials(svc.Credentials); err != nil {
+			return nil, fmt.Errorf("%s service: %w", name, err)
+		}
+	}
+	var base http.RoundTripper = up.transport
+	if g.options.client != nil {
+		base = g.options.client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+	}
+	up.client = &http.Client{Transport: up.withCredentials(base), Timeout: g.Client.Timeout}
+
+	// Event streams are flushed immediately by ReverseProxy; FlushInterval covers other chunked bodies
+	proxy := &httputil.ReverseProxy{Transport: up.withCredentials(up.transport), FlushInterval: g.Config.FlushInterval}
+	if g.tracer != nil {
+		proxy.Transport = &tracingTransport{tracer: g.tracer, service: name, next: proxy.Transport}
+	}
+	proxy.Director = func(r *http.Request) {
+		if svc.StripPrefix {
+			stripPrefix(r.URL, g.servicePrefix(name))
+		}
+		renameHeaders(r.Header, svc.HeaderRenames)
+		if cookieAuth {
+			cookieCfg.injectRefreshCookie(r)
+		}
+		setClaimHeaders(r, svc.ClaimHeaders)
+		svc.RequestHeaders.apply(r.Header)
+		g.setForwardedHeaders(r)
+		setTimeoutHeader(r)
+		up.instanceFrom(r).director(r)
+	}
+	proxy.ErrorHandler = g.proxyErrorHandler(name)
+	up.proxy = proxy
+
+	modifiers := []func(*http.Response) error{acceptBearerProtocol}
+	if svc.OverloadHeader != "" {
+		up.throttle = newAdaptiveThrottle()
+		modifiers = append(modifiers, func(resp *http.Response) error {
+			if svc.overloaded(resp) {
+				g.Logger.Warn("Overload signal, throttling", "service", name, "signal", up.throttle.Signal())
+			} else {
+				up.throttle.Success()
+			}
+			return nil
+		})
+	}
+	if cookieAuth {
+		modifiers = append(modifiers, cookieCfg.setTokenCookies)
+	}
+	if len(svc.StatusRemaps) > 0 {
+		modifiers = append(modifiers, func(resp *http.Response) error {
+			return remapStatus(resp, svc.StatusRemaps)
+		})
+	}
+	if g.Config.ProblemUpstreamErrors {
+		modifiers = append(modifiers, problemResponse)
+	}
+	// Runs after the overload check so rules can strip the backend's signal headers
+	if !svc.ResponseHeaders.empty() {
+		modifiers = append(modifiers, func(resp *http.Response) error {
+			svc.ResponseHeaders.apply(resp.Header)
+			return nil
+		})
+	}
+	if version := g.Config.GatewayVersion; version != "" {
+		modifiers = append(modifiers, func(resp *http.Response) error {
+			resp.Header.Set("X-Gateway-Version", version)
+			return nil
+		})
+	}
+	// Last, so it buffers the body as the client will see it
+	if limit := g.Config.ResponseBufferLimit; limit > 0 {
+		modifiers = append(modifiers, func(resp *http.Response) error {
+			return bufferResponse(resp, limit)
+		})
+	}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		for _, m := range modifiers {
+			if err := m(resp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Layers are wrapped innermost first
+	var h http.Handler = proxy
+	if svc.GRPC {
+		routes, err := parseGRPCRoutes(svc.GRPCMethods)
+		if err != nil {
+			return nil, fmt.Errorf("%s service: %w", name, err)
+		}
+		h = g.grpcTranscoder(up, proxy.Transport, routes)
+	}
+	if override, ok := g.proxies[name]; ok {
+		h = override
+	}
+	if cookieAuth {
+		h = g.cookieAuthHandler(h)
+	}
+	h = g.balanceHandler(up, h)
+	if rc := g.retryConfig(svc); rc.MaxAttempts > 1 {
+		h = g.retryHandler(up, rc, h)
+	}
+	if bc := g.breakerConfig(svc); bc.FailureThreshold > 0 {
+		up.breaker = newCircuitBreaker(bc)
+		h = g.breakerHandler(up, h)
+	}
+	tunnel := h
+	if up.throttle != nil {
+		h = g.throttleHandler(up, svc, h)
+	}
+	if limit, queue := g.bulkheadLimits(svc); limit > 0 {
+		up.bulkhead = newBulkhead(limit, queue)
+		h = g.bulkheadHandler(up, h)
+	}
+	if svc.Coalesce {
+		h = g.coalesceHandler(up, newCoalescer(svc), h)
+	}
+	if svc.CacheTTL > 0 {
+		up.cache = g.newResponseCache(name, svc)
+		h = up.cache.handler(h)
+	}
+	if svc.Timeout > 0 || len(g.Config.RouteTimeouts) > 0 {
+		h = g.timeoutHandler(up, svc, h)
+	}
+	if g.auditLog != nil {
+		h = g.auditHandler(up, h)
+	}
+	if g.captures != nil {
+		h = g.captureHandler(up, h)
+	}
+	if svc.ShadowURL != "" && svc.ShadowPercent > 0 {
+		if up.shadow, err = g.newShadow(svc); err != nil {
+			return nil, fmt.Errorf("%s service: %w", name, err)
+		}
+		h = g.shadowHandler(up, h)
+	}
+	h = g.streamHandler(h)
+	up.handler = g.websocketHandler(tunnel, h)
+	return up, nil
+}
+
+// withCredentials adds the service's credentials to requests sent through rt
+func (up *upstream) withCredentials(rt http.RoundTripper) http.RoundTripper {
+	if up.credentials == nil {
+		return rt
+	}
+	return &credentialsTransport{creds: up.credentials, next: rt}
+}
+
+// clientFor returns the client of the service with an instance at target's host, so
+// calls to it use the service's transport, or g.Client for other URLs
+func (g *Gateway) clientFor(target string) *http.Client {
+	u, err := url.Parse(target)
+	if err != nil {
+		return g.Client
+	}
+	for _, up := range g.upstreams() {
+		for _, in := range up.instances() {
+			if in.url.Scheme == u.Scheme && in.url.Host == u.Host {
+				return up.client
+			}
+		}
+	}
+	return g.Client
+}
+
+// ProxyHandler forwards requests to the named service
+func (g *Gateway) ProxyHandler(name string) http.HandlerFunc {
+	base := g.upstreams()[name]
+	counted := g.statsHandler(base, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if deadlineExceeded(r) {
+			writeDeadlineExceeded(w, r)
+			return
+		}
+		up, ok := base.selectVersion(r)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "unsupported API version "+requestedVersion(r))
+			return
+		}
+		if tl := timelineFrom(r.Context()); tl != nil {
+			tl.mark("proxy_start")
+			r = r.WithContext(httptrace.WithClientTrace(r.Context(), tl.clientTrace()))
+		}
+		up.handler.ServeHTTP(w, r)
+	}))
+	// Requests turned away for maintenance or draining never count as in flight
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m := base.outOfService(); m != nil {
+			writeMaintenance(w, m)
+			return
+		}
+		counted.ServeHTTP(w, r)
+	}
+}
+
+// proxyErrorHandler reports upstream failures as 502, except when the client itself went
+// away: the request context is already canceled then, which aborted the upstream call
+func (g *Gateway) proxyErrorHandler(name string) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(r.Context().Err(), context.Canceled) {
+			g.Logger.InfoContext(r.Context(), "Request canceled by client mid-flight", "method", r.Method, "path", r.URL.Path, "service", name)
+			return
+		}
+		if limit, ok := bodyTooLarge(err); ok {
+			g.Logger.WarnContext(r.Context(), "Request body too large", "method", r.Method, "path", r.URL.Path, "service", name, "limit", limit)
+			writeBodyTooLarge(w, limit)
+			return
+		}
+		if deadlineExceeded(r) {
+			g.Logger.WarnContext(r.Context(), "Request deadline exceeded", "method", r.Method, "path", r.URL.Path, "service", name)
+			writeDeadlineExceeded(w, r)
+			return
+		}
+		g.Logger.ErrorContext(r.Context(), "Proxy error", "method", r.Method, "path", r.URL.Path, "service", name, "error", err)
+		writeJSONError(w, http.StatusBadGateway, "upstream unavailable")
+	}
+}
+
+// upstreamURL resolves a gateway path against an instance, rewriting it the same way the proxy would
+func (g *Gateway) upstreamURL(up *upstream, in *instance, path string) (*url.URL, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q: %w", path, err)
+	}
+	if up.svc.StripPrefix {
+		stripPrefix(u, g.servicePrefix(up.name))
+	}
+	return in.resolve(u), nil
+}
+
+// retryAfter formats d as a Retry-After header value in whole seconds, defaulting to 1
+func retryAfter(d time.Duration) string {
+	if d <= 0 {
+		d = time.Second
+	}
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// renameHeaders moves every value of each from-header to its to-header, keeping multi-value headers intact
+func renameHeaders(h http.Header, renames map[string]string) {
+	for from, to := range renames {
+		values := h.Values(from)
+		if len(values) == 0 {
+			continue
+		}
+		h.Del(from)
+		h.Del(to)
+		h[to] = append([]string(nil), values...)
+	}
+}
+
+// stripPrefix removes prefix from the request path, so /api/blog/posts becomes /posts.
+// The prefix must match a whole path segment; an empty result becomes "/".
+func stripPrefix(u *url.URL, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	rest := strings.TrimPrefix(u.Path, prefix)
+	if len(rest) == len(u.Path) || (rest != "" && rest[0] != '/') {
+		return
+	}
+	if rest == "" {
+		rest = "/"
+	}
+	u.Path = rest
+
+	if u.RawPath != "" {
+		rawRest, ok := strings.CutPrefix(u.RawPath, prefix)
+		switch {
+		case !ok:
+			u.RawPath = ""
+		case rawRest == "":
+			u.RawPath = "/"
+		default:
+			u.RawPath = rawRest
+		}
+	}
+}
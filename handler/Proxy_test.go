@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStripPrefix(t *testing.T) {
+	users, blog := newTestUpstream(t), newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		UserServiceURL: users.URL,
+		BlogServiceURL: blog.URL,
+		Services:       map[string]ServiceConfig{ServiceUser: {StripPrefix: true}},
+	})
+
+	for _, tt := range []struct {
+		service, target, path, query string
+	}{
+		{ServiceUser, "/api/user/profile?tab=posts", "/profile", "tab=posts"},
+		{ServiceUser, "/api/user", "/", ""},
+		{ServiceUser, "/api/user/a%2Fb", "/a/b", ""},
+		{ServiceBlog, "/api/blog/posts", "/api/blog/posts", ""},
+	} {
+		var resp testUpstreamResponse
+		rec := serve(t, g.ProxyHandler(tt.service), httptest.NewRequest(http.MethodGet, tt.target, nil), &resp)
+		if rec.Code != http.StatusOK || resp.Path != tt.path || resp.Query != tt.query {
+			t.Errorf("%s reached the service as %s?%s (status %d), want %s?%s", tt.target, resp.Path, resp.Query, rec.Code, tt.path, tt.query)
+		}
+	}
+}
+
+func TestHeaderRenames(t *testing.T) {
+	users := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		UserServiceURL: users.URL,
+		Services: map[string]ServiceConfig{ServiceUser: {
+			HeaderRenames: map[string]string{"X-Client-Version": "X-App-Version"},
+		}},
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/user/profile", nil)
+	req.Header.Add("X-Client-Version", "1.2")
+	req.Header.Add("X-Client-Version", "ios")
+	req.Header.Set("X-App-Version", "stale")
+	var resp testUpstreamResponse
+	serve(t, g.ProxyHandler(ServiceUser), req, &resp)
+	if got := resp.Header.Values("X-App-Version"); !slices.Equal(got, []string{"1.2", "ios"}) {
+		t.Errorf("X-App-Version = %q, want both renamed values", got)
+	}
+	if got := resp.Header.Get("X-Client-Version"); got != "" {
+		t.Errorf("X-Client-Version = %q, want it renamed away", got)
+	}
+}
+
+func TestClientCancellationReachesUpstreams(t *testing.T) {
+	arrived, cancelled := make(chan string, 2), make(chan string, 2)
+	blocking := func() *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			arrived <- r.URL.Path
+			<-r.Context().Done()
+			cancelled <- r.URL.Path
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	g := newTestGateway(t, &Config{AuthServiceURL: blocking().URL, BlogServiceURL: blocking().URL})
+	log := captureLog(g)
+
+	for _, tt := range []struct {
+		name     string
+		h        http.Handler
+		upstream string
+		logged   string
+	}{
+		{"proxied call", g.ProxyHandler(ServiceBlog), "/api/blog/posts", "Request canceled by client mid-flight"},
+		{"JWT validation", g.AuthMiddleware(g.ProxyHandler(ServiceBlog)), "/api/auth/jwt", ""},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			tt.h.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+
+		if path := <-arrived; path != tt.upstream {
+			t.Fatalf("%s: upstream got %s, want %s", tt.name, path, tt.upstream)
+		}
+		cancel()
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: the upstream call outlived the client", tt.name)
+		}
+		<-done
+		if tt.logged != "" && !strings.Contains(log.String(), tt.logged) {
+			t.Errorf("%s: log %q doesn't mention the cancellation", tt.name, log)
+		}
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.25")
+		w.Header().Set("X-Backend-Trace", "abc")
+		w.Header().Set("Cache-Control", "public")
+		writeJSON(w, http.StatusOK, testUpstreamResponse{Header: r.Header})
+	}))
+	defer blog.Close()
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		Services: map[string]ServiceConfig{ServiceBlog: {
+			RequestHeaders: HeaderRules{
+				Remove: []string{"X-Debug"},
+				Rename: map[string]string{"X-Old-Client": "X-Client"},
+				Set:    map[string]string{"X-Internal-Caller": "gateway"},
+				Add:    map[string]string{"X-Tag": "gw"},
+			},
+			ResponseHeaders: HeaderRules{
+				Remove: []string{"Server"},
+				Rename: map[string]string{"X-Backend-Trace": "X-Trace"},
+				Set:    map[string]string{"Cache-Control": "no-store"},
+				Add:    map[string]string{"X-Frame-Options": "DENY"},
+			},
+		}},
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+	req.Header.Set("X-Debug", "1")
+	req.Header.Set("X-Old-Client", "web")
+	req.Header.Set("X-Internal-Caller", "spoofed")
+	req.Header.Set("X-Tag", "client")
+	var resp testUpstreamResponse
+	rec := serve(t, g.ProxyHandler(ServiceBlog), req, &resp)
+
+	sent := resp.Header
+	if sent.Get("X-Debug") != "" || sent.Get("X-Old-Client") != "" || sent.Get("X-Client") != "web" ||
+		!slices.Equal(sent.Values("X-Internal-Caller"), []string{"gateway"}) || !slices.Equal(sent.Values("X-Tag"), []string{"client", "gw"}) {
+		t.Errorf("backend got headers %v", sent)
+	}
+	got := rec.Header()
+	if got.Get("Server") != "" || got.Get("X-Backend-Trace") != "" || got.Get("X-Trace") != "abc" ||
+		!slices.Equal(got.Values("Cache-Control"), []string{"no-store"}) || got.Get("X-Frame-Options") != "DENY" {
+		t.Errorf("client got headers %v", got)
+	}
+}
+
+func TestUpstreamBasePath(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.EscapedPath() + "?" + r.URL.RawQuery))
+	}))
+	defer backend.Close()
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: backend.URL + "/blog-api/",
+		UserServiceURL: backend.URL + "/users",
+		Services:       map[string]ServiceConfig{ServiceUser: {StripPrefix: true}},
+	})
+
+	for _, tt := range []struct {
+		service, target, want string
+	}{
+		{ServiceBlog, "/api/blog/posts?page=2", "/blog-api/api/blog/posts?page=2"},
+		{ServiceBlog, "/api/blog/posts/a%2Fb", "/blog-api/api/blog/posts/a%2Fb?"},
+		{ServiceUser, "/api/user/profile", "/users/profile?"},
+		{ServiceUser, "/api/user", "/users/?"},
+	} {
+		rec := serve(t, g.ProxyHandler(tt.service), httptest.NewRequest(http.MethodGet, tt.target, nil), nil)
+		if rec.Body.String() != tt.want {
+			t.Errorf("%s reached the backend as %q, want %q", tt.target, rec.Body, tt.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"maps"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often idle buckets are dropped
+const rateLimitSweepInterval = time.Minute
+
+// RateLimit allows Requests per Window, refilled continuously (token bucket).
+// Burst is the bucket size and defaults to Requests.
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+	Burst    int
+}
+
+// ParseRateLimit parses "<requests>/<window>", e.g. "100/1m"
+func ParseRateLimit(s string) (RateLimit, bool) {
+	n, w, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return RateLimit{}, false
+	}
+	requests, err := strconv.Atoi(n)
+	if err != nil {
+		return RateLimit{}, false
+	}
+	window, err := time.ParseDuration(w)
+	if err != nil {
+		return RateLimit{}, false
+	}
+	return RateLimit{Requests: requests, Window: window}, true
+}
+
+// Enabled reports whether the limit is configured
+func (l RateLimit) Enabled() bool {
+	return l.Requests > 0 && l.Window > 0
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// rate returns the refill rate in tokens per second
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Window.Seconds()
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket has refilled, so it can be dropped
+}
+
+// rateDecision is the outcome of a rate limit check
+type rateDecision struct {
+	allowed    bool
+	limit      RateLimit
+	remaining  int
+	retryAfter time.Duration // until the next token when denied
+	reset      time.Duration // until the bucket is full again
+}
+
+// rateLimitBackend keeps the token buckets, in memory or shared through Redis
+type rateLimitBackend interface {
+	allow(key string, limit RateLimit) rateDecision
+}
+
+// rateLimiter keeps a token bucket per key
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+// allow takes a token from key's bucket under limit
+func (l *rateLimiter) allow(key string, limit RateLimit) rateDecision {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	burst, rate := limit.burst(), limit.rate()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	d := limit.decision(allowed, b.tokens)
+	b.full = now.Add(d.reset)
+	return d
+}
+
+// decision describes a check that left tokens in the bucket
+func (l RateLimit) decision(allowed bool, tokens float64) rateDecision {
+	d := rateDecision{allowed: allowed, limit: l, remaining: int(tokens)}
+	if !allowed {
+		d.retryAfter = time.Duration((1 - tokens) / l.rate() * float64(time.Second))
+	}
+	d.reset = time.Duration((l.burst() - tokens) / l.rate() * float64(time.Second))
+	return d
+}
+
+// sweep drops buckets that have been idle long enough to be full again, which a new
+// bucket for the key would be too
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.After(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateLimitCount is the number of requests a limit allowed and rejected
+type rateLimitCount struct {
+	Allowed int64 `json:"allowed"`
+	Limited int64 `json:"limited"`
+}
+
+// rateLimitCounters counts decisions for /admin/rate-limits per configured limit rather
+// than per client, so the number of keys stays bounded
+type rateLimitCounters struct {
+	mu     sync.Mutex
+	counts map[string]rateLimitCount
+}
+
+func newRateLimitCounters() *rateLimitCounters {
+	return &rateLimitCounters{counts: make(map[string]rateLimitCount)}
+}
+
+func (c *rateLimitCounters) observe(key string, allowed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.counts[key]
+	if allowed {
+		n.Allowed++
+	} else {
+		n.Limited++
+	}
+	c.counts[key] = n
+}
+
+func (c *rateLimitCounters) snapshot() map[string]rateLimitCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.counts)
+}
+
+// Keys of rateLimitCounters
+const defaultTenantLimitKey = "tenant-default"
+
+func tenantLimitKey(tenant string) string { return "tenant:" + tenant }
+func routeLimitKey(prefix string) string  { return "route:" + strings.TrimSuffix(prefix, "/") }
+
+// writeRateLimited responds 429 with a Retry-After header. With Config.RateLimitDetails
+// the body also explains the quota and which key (user, tenant, ip) was limited.
+func (g *Gateway) writeRateLimited(w http.ResponseWriter, d rateDecision, keyType string) {
+	w.Header().Set("Retry-After", retryAfter(d.retryAfter))
+	setRateLimitHeaders(w, d)
+	if !g.Config.RateLimitDetails {
+		writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+	writeProblem(w, http.StatusTooManyRequests, "rate limit exceeded", map[string]any{
+		"limit":         d.limit.Requests,
+		"window":        d.limit.Window.String(),
+		"windowSeconds": d.limit.Window.Seconds(),
+		"remaining":     0,
+		"reset":         time.Now().Add(d.reset).UTC().Truncate(time.Second),
+		"key":           keyType,
+	})
+}
+
+// TenantRateLimitMiddleware limits requests per tenant, keyed on the X-Tenant-ID set by
+// AuthMiddleware. Tenants listed in Config.TenantRateLimits get their own limit, all
+// others get Config.DefaultTenantRateLimit. Requests without a tenant are not limited.
+func (g *Gateway) TenantRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get("X-Tenant-ID")
+		if tenant == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		live := g.routing.Load()
+		limit, ok := live.tenantRateLimits[tenant]
+		counter := tenantLimitKey(tenant)
+		if !ok {
+			limit, counter = live.defaultTenantRateLimit, defaultTenantLimitKey
+		}
+		if !limit.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		d := g.limiter.allow("tenant:"+tenant, limit)
+		g.rateLimitCounts.observe(counter, d.allowed)
+		if !d.allowed {
+			g.Logger.WarnContext(r.Context(), "Rate limit exceeded", "tenant", tenant, "limit", limit.Requests, "window", limit.Window)
+			g.writeRateLimited(w, d, "tenant")
+			return
+		}
+		setRateLimitHeaders(w, d)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RouteRateLimitMiddleware applies Config.RouteRateLimits. It runs after AuthMiddleware
+// so authenticated clients are limited per user rather than per IP.
+func (g *Gateway) RouteRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix, limit := g.routeRateLimit(r.URL.Path)
+		if !limit.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keyType, client := "user", r.Header.Get("X-User-ID")
+		if client == "" {
+			keyType = "ip"
+			if ip, ok := g.clientIP(r); ok {
+				client = ip.String()
+			}
+		}
+		d := g.limiter.allow("route:"+prefix+":"+keyType+":"+client, limit)
+		g.rateLimitCounts.observe(routeLimitKey(prefix), d.allowed)
+		if !d.allowed {
+			g.Logger.WarnContext(r.Context(), "Rate limit exceeded", "route", prefix, keyType, client, "limit", limit.Requests, "window", limit.Window)
+			g.writeRateLimited(w, d, keyType)
+			return
+		}
+		setRateLimitHeaders(w, d)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeRateLimit returns the limit of the longest prefix in Config.RouteRateLimits matching path
+func (g *Gateway) routeRateLimit(path string) (string, RateLimit) {
+	prefix, limit, _ := longestPrefixMatch(g.routing.Load().routeRateLimits, path)
+	return prefix, limit
+}
+
+// longestPrefixMatch finds the longest path prefix in m that path is at or below
+func longestPrefixMatch[V any](m map[string]V, path string) (string, V, bool) {
+	var match string
+	var value V
+	found := false
+	for prefix, v := range m {
+		p := strings.TrimSuffix(prefix, "/")
+		if (path == p || strings.HasPrefix(path, p+"/")) && (!found || len(p) > len(match)) {
+			match, value, found = p, v, true
+		}
+	}
+	return match, value, found
+}
+
+// setRateLimitHeaders reports the quota left after a rate limit decision; the reset is
+// the number of seconds until the bucket is full again
+func setRateLimitHeaders(w http.ResponseWriter, d rateDecision) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(d.limit.Requests))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(d.remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(d.reset.Seconds()))))
+}
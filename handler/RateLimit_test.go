@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTenantRateLimits(t *testing.T) {
+	g := newTestGateway(t, &Config{
+		TenantRateLimits:       map[string]RateLimit{"premium": {Requests: 5, Window: time.Hour}},
+		DefaultTenantRateLimit: RateLimit{Requests: 2, Window: time.Hour},
+	})
+	h := g.TenantRateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	allowed := func(tenant string) int {
+		var n int
+		for range 10 {
+			req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+			req.Header.Set("X-Tenant-ID", tenant)
+			if serve(t, h, req, nil).Code == http.StatusNoContent {
+				n++
+			}
+		}
+		return n
+	}
+
+	for tenant, want := range map[string]int{"premium": 5, "free-1": 2, "free-2": 2, "": 10} {
+		if n := allowed(tenant); n != want {
+			t.Errorf("tenant %q: %d of 10 requests allowed, want %d", tenant, n, want)
+		}
+	}
+}
+
+func TestRateLimitedBodyDetails(t *testing.T) {
+	for _, details := range []bool{true, false} {
+		g := newTestGateway(t, &Config{
+			DefaultTenantRateLimit: RateLimit{Requests: 1, Window: time.Minute},
+			RateLimitDetails:       details,
+		})
+		h := g.TenantRateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		var rec *httptest.ResponseRecorder
+		for range 2 {
+			req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+			req.Header.Set("X-Tenant-ID", "free")
+			rec = serve(t, h, req, nil)
+		}
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("details %v: status %d with Retry-After %q, want 429 with one", details, rec.Code, rec.Header().Get("Retry-After"))
+		}
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("details %v: invalid JSON body %q", details, rec.Body)
+		}
+
+		if !details {
+			if body["status"] != 429.0 || body["error"] != "rate limit exceeded" || body["limit"] != nil {
+				t.Errorf("body without details %v, want only the problem", body)
+			}
+			continue
+		}
+		reset, _ := time.Parse(time.RFC3339, fmt.Sprint(body["reset"]))
+		if body["limit"] != 1.0 || body["window"] != "1m0s" || body["windowSeconds"] != 60.0 || body["remaining"] != 0.0 ||
+			body["key"] != "tenant" || time.Until(reset) <= 0 || time.Until(reset) > time.Minute {
+			t.Errorf("body with details %v, want limit 1, window 1m0s, remaining 0, key tenant and a reset within the minute", body)
+		}
+	}
+}
+
+func TestRateLimitSweepKeepsRefillingBuckets(t *testing.T) {
+	l := newRateLimiter()
+	limit := RateLimit{Requests: 10, Window: 24 * time.Hour}
+	for range 10 {
+		l.allow("client", limit)
+	}
+	if l.allow("client", limit).allowed {
+		t.Fatal("request over the limit allowed")
+	}
+
+	// An hour refills less than one token of a day's ten
+	now := time.Now()
+	l.sweep(now.Add(2 * time.Hour))
+	if l.buckets["client"] == nil {
+		t.Fatal("emptied bucket swept after 2h, which would hand out a full one")
+	}
+	l.sweep(now.Add(25 * time.Hour))
+	if l.buckets["client"] != nil {
+		t.Error("bucket kept after it refilled")
+	}
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCacheRedisPrefix = "gateway:cache:"
+	redisScanCount          = 500
+)
+
+// redisCache shares a service's cached responses between gateway replicas. Keys are
+// <prefix><path hash>:<key hash>, so purging a path matches all of its variants. While
+// Redis is unavailable requests skip the cache, retrying it every redisRetryInterval.
+type redisCache struct {
+	client    *redisClient
+	prefix    string // Config.CacheRedisPrefix and the service name
+	logger    *slog.Logger
+	downUntil atomic.Int64 // unix nanoseconds
+}
+
+// redisCacheMeta is stored as a JSON line ahead of the raw body
+type redisCacheMeta struct {
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Expires time.Time   `json:"expires"`
+}
+
+func (c *redisCache) get(path, key string) *cacheEntry {
+	if !c.available() {
+		return nil
+	}
+	reply, err := c.client.do(context.Background(), "GET", c.entryKey(path, key))
+	if err != nil {
+		c.failed(err)
+		return nil
+	}
+	c.restored()
+	s, ok := reply.(string)
+	if !ok {
+		return nil // a miss
+	}
+	meta, body, ok := strings.Cut(s, "\n")
+	var m redisCacheMeta
+	if !ok || json.Unmarshal([]byte(meta), &m) != nil {
+		return nil
+	}
+	return &cacheEntry{key: key, path: path, status: m.Status, header: m.Header, body: []byte(body), expires: m.Expires}
+}
+
+func (c *redisCache) set(e *cacheEntry) {
+	ttl := time.Until(e.expires)
+	if ttl < time.Millisecond || !c.available() {
+		return
+	}
+	meta, err := json.Marshal(redisCacheMeta{Status: e.status, Header: e.header, Expires: e.expires})
+	if err != nil || len(meta)+1+len(e.body) > redisMaxReply {
+		return // couldn't be read back
+	}
+	value := string(meta) + "\n" + string(e.body)
+	if _, err := c.client.do(context.Background(), "SET", c.entryKey(e.path, e.key), value, "PX", strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		c.failed(err)
+		return
+	}
+	c.restored()
+}
+
+// purge deletes matching keys with SCAN, so it takes a while on large databases but
+// doesn't block Redis
+func (c *redisCache) purge(path string) (int, error) {
+	pattern := redisGlobEscape(c.prefix) + "*"
+	if path != "" {
+		pattern = redisGlobEscape(c.prefix+hashKey(path)+":") + "*"
+	}
+	ctx := context.Background()
+	n := 0
+	cursor := "0"
+	for {
+		reply, err := c.client.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(redisScanCount))
+		if err != nil {
+			return n, err
+		}
+		items, ok := reply.([]any)
+		if !ok || len(items) != 2 {
+			return n, fmt.Errorf("unexpected SCAN reply %v", reply)
+		}
+		cursor, _ = items[0].(string)
+		keys, _ := items[1].([]any)
+		if len(keys) > 0 {
+			args := []string{"UNLINK"}
+			for _, k := range keys {
+				if s, ok := k.(string); ok {
+					args = append(args, s)
+				}
+			}
+			deleted, err := c.client.do(ctx, args...)
+			if err != nil {
+				return n, err
+			}
+			d, _ := deleted.(int64)
+			n += int(d)
+		}
+		if cursor == "0" || cursor == "" {
+			return n, nil
+		}
+	}
+}
+
+func (c *redisCache) entryKey(path, key string) string {
+	return c.prefix + hashKey(path) + ":" + hashKey(key)
+}
+
+func (c *redisCache) available() bool {
+	return time.Now().UnixNano() >= c.downUntil.Load()
+}
+
+func (c *redisCache) failed(err error) {
+	if c.downUntil.Swap(time.Now().Add(redisRetryInterval).UnixNano()) == 0 {
+		c.logger.Error("Redis response cache unavailable, bypassing cache", "error", err)
+	}
+}
+
+func (c *redisCache) restored() {
+	if down := c.downUntil.Load(); down != 0 && c.downUntil.CompareAndSwap(down, 0) {
+		c.logger.Info("Redis response cache restored")
+	}
+}
+
+func hashKey(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// redisGlobEscape quotes the characters SCAN MATCH treats as a pattern
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedisStore keeps string keys for GET, SET, SCAN and UNLINK, returning at most two
+// keys per SCAN page so purges have to follow the cursor
+type fakeRedisStore struct {
+	*fakeRedis
+	down atomic.Bool
+
+	mu   sync.Mutex
+	data map[string]string
+	ttls map[string]time.Duration
+}
+
+func newFakeRedisStore(t *testing.T) *fakeRedisStore {
+	s := &fakeRedisStore{data: map[string]string{}, ttls: map[string]time.Duration{}}
+	s.fakeRedis = newFakeRedis(t, s.handle)
+	return s
+}
+
+func (s *fakeRedisStore) handle(args []string) string {
+	if s.down.Load() {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch args[0] {
+	case "GET":
+		v, ok := s.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return respBulk(v)
+	case "SET":
+		s.data[args[1]] = args[2]
+		if len(args) == 5 && args[3] == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			s.ttls[args[1]] = time.Duration(ms) * time.Millisecond
+		}
+		return "+OK\r\n"
+	case "SCAN":
+		// The cursor is the last key returned, so deletions between pages skip nothing
+		var keys []string
+		for k := range s.data {
+			if ok, _ := path.Match(args[3], k); ok && (args[1] == "0" || k > args[1]) {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		next := "0"
+		if len(keys) > 2 {
+			keys, next = keys[:2], keys[1]
+		}
+		reply := "*2\r\n" + respBulk(next) + "*" + strconv.Itoa(len(keys)) + "\r\n"
+		for _, k := range keys {
+			reply += respBulk(k)
+		}
+		return reply
+	case "UNLINK":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := s.data[k]; ok {
+				delete(s.data, k)
+				n++
+			}
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func (s *fakeRedisStore) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestRedisCacheSharedBetweenReplicas(t *testing.T) {
+	store := newFakeRedisStore(t)
+	blog := newTestUpstream(t)
+	replica := func() *Gateway {
+		return newTestGateway(t, &Config{
+			BlogServiceURL: blog.URL,
+			CacheRedisURL:  store.url(),
+			Services:       map[string]ServiceConfig{ServiceBlog: {CacheTTL: time.Minute}},
+		})
+	}
+	a, b := replica(), replica()
+	get := func(g *Gateway, target string) (string, int64) {
+		var resp testUpstreamResponse
+		rec := serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, target, nil), &resp)
+		return rec.Header().Get("X-Cache"), resp.Call
+	}
+
+	for _, tt := range []struct {
+		name, target string
+		g            *Gateway
+		cache        string
+		call         int64
+	}{
+		{"first replica", "/api/blog/posts", a, "MISS", 1},
+		{"second replica", "/api/blog/posts", b, "HIT", 1},
+		{"other query", "/api/blog/posts?page=2", b, "MISS", 2},
+		{"other query again", "/api/blog/posts?page=2", a, "HIT", 2},
+		{"other path", "/api/blog/tags", a, "MISS", 3},
+	} {
+		if cache, call := get(tt.g, tt.target); cache != tt.cache || call != tt.call {
+			t.Errorf("%s: X-Cache %q from call %d, want %q from call %d", tt.name, cache, call, tt.cache, tt.call)
+		}
+	}
+	keys := store.keys()
+	if len(keys) != 3 {
+		t.Fatalf("%d keys stored, want 3: %v", len(keys), keys)
+	}
+	store.mu.Lock()
+	for _, k := range keys {
+		if !strings.HasPrefix(k, defaultCacheRedisPrefix+ServiceBlog+":") || store.ttls[k] <= 59*time.Second || store.ttls[k] > time.Minute {
+			t.Errorf("key %q stored for %s, want the service prefix and the cache TTL", k, store.ttls[k])
+		}
+	}
+	store.mu.Unlock()
+
+	// A purge of one path drops its variants for every replica
+	purge := func(g *Gateway, query string) *httptest.ResponseRecorder {
+		return serve(t, http.HandlerFunc(g.CachePurgeHandler), httptest.NewRequest(http.MethodDelete, "/admin/cache?"+query, nil), nil)
+	}
+	var purged map[string]int
+	rec := purge(b, "service=blog&path=/api/blog/posts")
+	if err := json.Unmarshal(rec.Body.Bytes(), &purged); err != nil || rec.Code != http.StatusOK || purged["purged"] != 2 {
+		t.Errorf("path purge: status %d, body %s, want 2 entries purged", rec.Code, rec.Body)
+	}
+	if cache, _ := get(a, "/api/blog/posts"); cache != "MISS" {
+		t.Errorf("after the purge: X-Cache %q, want a miss", cache)
+	}
+	if cache, _ := get(a, "/api/blog/tags"); cache != "HIT" {
+		t.Errorf("other path after the purge: X-Cache %q, want it kept", cache)
+	}
+	// Three or more keys take several SCAN pages
+	get(a, "/api/blog/posts?page=3")
+	if rec := purge(a, ""); rec.Body.String() != `{"purged":3}`+"\n" || len(store.keys()) != 0 {
+		t.Errorf("full purge: %s, %d keys left", rec.Body, len(store.keys()))
+	}
+}
+
+func TestRedisCacheOutage(t *testing.T) {
+	store := newFakeRedisStore(t)
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		CacheRedisURL:  store.url(),
+		Services:       map[string]ServiceConfig{ServiceBlog: {CacheTTL: time.Minute}},
+	})
+	var logs lockedBuffer
+	up := g.upstreams()[ServiceBlog]
+	rc := up.cache.store.(*redisCache)
+	rc.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	get := func() (*httptest.ResponseRecorder, int64) {
+		var resp testUpstreamResponse
+		rec := serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), &resp)
+		return rec, resp.Call
+	}
+
+	store.down.Store(true)
+	for i := range 2 {
+		if rec, call := get(); rec.Code != http.StatusOK || call != int64(i+1) {
+			t.Fatalf("request %d while Redis is down: status %d from call %d, want it proxied", i+1, rec.Code, call)
+		}
+	}
+	if n := strings.Count(logs.String(), "bypassing cache"); n != 1 {
+		t.Errorf("outage logged %d times, want once", n)
+	}
+	if commands := len(store.sent()); commands != 1 {
+		t.Errorf("%d commands sent while down, want Redis left alone until redisRetryInterval", commands)
+	}
+
+	store.down.Store(false)
+	rc.downUntil.Store(time.Now().Add(-time.Millisecond).UnixNano())
+	get()
+	if rec, call := get(); rec.Header().Get("X-Cache") != "HIT" || call != 3 || !strings.Contains(logs.String(), "Redis response cache restored") {
+		t.Errorf("after the retry interval: X-Cache %q from call %d", rec.Header().Get("X-Cache"), call)
+	}
+
+	// A value that doesn't decode is a miss
+	for _, k := range store.keys() {
+		store.mu.Lock()
+		store.data[k] = "not json"
+		store.mu.Unlock()
+	}
+	if rec, call := get(); rec.Header().Get("X-Cache") != "MISS" || call != 4 {
+		t.Errorf("corrupt entry: X-Cache %q from call %d, want a miss", rec.Header().Get("X-Cache"), call)
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	redisRateLimitPrefix = "gateway:ratelimit:"
+	// redisRetryInterval is how long limits stay local after Redis fails
+	redisRetryInterval = 5 * time.Second
+)
+
+// redisTokenBucket refills and takes from a bucket stored as a hash, using Redis' clock
+// so replicas agree on time. It returns whether a token was taken and the tokens left.
+const redisTokenBucket = `
+redis.replicate_commands()
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(b[1]) or burst
+local last = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('EXPIRE', KEYS[1], math.ceil(burst / rate) + 1)
+return {allowed, tostring(tokens)}
+`
+
+// redisRateLimiter enforces limits cluster-wide through Redis and degrades to the local
+// limiter while Redis is unavailable, retrying it every redisRetryInterval
+type redisRateLimiter struct {
+	client    *redisClient
+	local     *rateLimiter
+	logger    *slog.Logger
+	downUntil atomic.Int64 // unix nanoseconds
+}
+
+func newRedisRateLimiter(rawURL string, logger *slog.Logger) (*redisRateLimiter, error) {
+	client, err := newRedisClient(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &redisRateLimiter{client: client, local: newRateLimiter(), logger: logger}, nil
+}
+
+func (l *redisRateLimiter) allow(key string, limit RateLimit) rateDecision {
+	down := l.downUntil.Load()
+	if time.Now().UnixNano() < down {
+		return l.local.allow(key, limit)
+	}
+
+	allowed, tokens, err := l.take(key, limit)
+	if err != nil {
+		if down == 0 {
+			l.logger.Error("Redis rate limiting unavailable, enforcing limits locally", "error", err)
+		}
+		l.downUntil.Store(time.Now().Add(redisRetryInterval).UnixNano())
+		return l.local.allow(key, limit)
+	}
+	if down != 0 && l.downUntil.CompareAndSwap(down, 0) {
+		l.logger.Info("Redis rate limiting restored")
+	}
+	return limit.decision(allowed, tokens)
+}
+
+func (l *redisRateLimiter) take(key string, limit RateLimit) (bool, float64, error) {
+	reply, err := l.client.evalScript(context.Background(), redisTokenBucket,
+		[]string{redisRateLimitPrefix + key},
+		strconv.FormatFloat(limit.burst(), 'g', -1, 64),
+		strconv.FormatFloat(limit.rate(), 'g', -1, 64))
+	if err != nil {
+		return false, 0, err
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	allowed, _ := items[0].(int64)
+	s, _ := items[1].(string)
+	tokens, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	return allowed == 1, tokens, nil
+}
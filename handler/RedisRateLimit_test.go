@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedisBuckets answers the token bucket script without refilling, so a key allows
+// exactly its burst
+func fakeRedisBuckets(t *testing.T, down *atomic.Bool) *fakeRedis {
+	var mu sync.Mutex
+	taken := map[string]int{}
+	return newFakeRedis(t, func(args []string) string {
+		if down.Load() {
+			return ""
+		}
+		if args[0] != "EVALSHA" && args[0] != "EVAL" {
+			return "-ERR unknown command\r\n"
+		}
+		key := args[3]
+		burst, _ := strconv.ParseFloat(args[4], 64)
+		mu.Lock()
+		defer mu.Unlock()
+		allowed := 0
+		if float64(taken[key]) < burst {
+			taken[key]++
+			allowed = 1
+		}
+		return fmt.Sprintf("*2\r\n:%d\r\n%s", allowed, respBulk(strconv.FormatFloat(burst-float64(taken[key]), 'g', -1, 64)))
+	})
+}
+
+func TestRedisRateLimiter(t *testing.T) {
+	var down atomic.Bool
+	f := fakeRedisBuckets(t, &down)
+	var logs lockedBuffer
+	l, err := newRedisRateLimiter(f.url(), slog.New(slog.NewJSONHandler(&logs, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit := RateLimit{Requests: 2, Window: time.Minute}
+
+	for i, want := range []bool{true, true, false} {
+		if d := l.allow("tenant:free", limit); d.allowed != want || d.remaining != max(0, 1-i) {
+			t.Errorf("request %d: allowed %t with %d remaining", i+1, d.allowed, d.remaining)
+		}
+	}
+	if d := l.allow("tenant:premium", limit); !d.allowed {
+		t.Error("other key refused, want a bucket of its own")
+	}
+	f.mu.Lock()
+	key := f.commands[0][3]
+	f.mu.Unlock()
+	if key != redisRateLimitPrefix+"tenant:free" {
+		t.Errorf("bucket key %q", key)
+	}
+
+	// While Redis is down, limits are enforced by the local buckets
+	down.Store(true)
+	for i, want := range []bool{true, true, false} {
+		if d := l.allow("tenant:free", limit); d.allowed != want {
+			t.Errorf("local request %d: allowed %t", i+1, d.allowed)
+		}
+	}
+	if n := strings.Count(logs.String(), "enforcing limits locally"); n != 1 {
+		t.Errorf("outage logged %d times, want once", n)
+	}
+	dials := f.dials.Load()
+	l.allow("tenant:other", limit)
+	if f.dials.Load() != dials {
+		t.Error("Redis retried before redisRetryInterval")
+	}
+
+	down.Store(false)
+	l.downUntil.Store(time.Now().Add(-time.Millisecond).UnixNano())
+	if d := l.allow("tenant:free", limit); d.allowed || !strings.Contains(logs.String(), "Redis rate limiting restored") {
+		t.Errorf("after the retry interval: allowed %t, want Redis' empty bucket; logs %s", d.allowed, logs.String())
+	}
+}
+
+func TestRedisRateLimiterOnTheGateway(t *testing.T) {
+	var down atomic.Bool
+	f := fakeRedisBuckets(t, &down)
+	g := newTestGateway(t, &Config{
+		RateLimitRedisURL:      f.url(),
+		DefaultTenantRateLimit: RateLimit{Requests: 3, Window: time.Hour},
+	})
+	h := g.TenantRateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	var rec *httptest.ResponseRecorder
+	for range 4 {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("X-Tenant-ID", "free")
+		rec = serve(t, h, req, nil)
+	}
+	if rec.Code != http.StatusTooManyRequests || len(f.sent()) != 4 {
+		t.Errorf("fourth request: status %d after %d Redis commands, want 429 from Redis' bucket", rec.Code, len(f.sent()))
+	}
+
+	rec = serve(t, http.HandlerFunc(g.RateLimitsHandler), httptest.NewRequest(http.MethodGet, "/admin/rate-limits", nil), nil)
+	var status rateLimitsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || status.Backend != "redis" {
+		t.Errorf("rate limits status: backend %q (%v), want redis", status.Backend, err)
+	}
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeRedis speaks enough RESP to stand in for Redis: it parses each command and writes
+// back the raw reply its handler returns, closing the connection on an empty one
+type fakeRedis struct {
+	addr   string
+	handle func(args []string) string
+	dials  atomic.Int64
+
+	mu       sync.Mutex
+	commands [][]string
+	conns    []net.Conn
+}
+
+func newFakeRedis(t *testing.T, handle func(args []string) string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{addr: ln.Addr().String(), handle: handle}
+	t.Cleanup(func() {
+		ln.Close()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, c := range f.conns {
+			c.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.dials.Add(1)
+			f.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) url() string { return "redis://" + f.addr }
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, args)
+		f.mu.Unlock()
+		reply := f.handle(args)
+		if reply == "" {
+			return
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// sent returns the names of the commands received so far
+func (f *fakeRedis) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, len(f.commands))
+	for i, args := range f.commands {
+		names[i] = args[0]
+	}
+	return names
+}
+
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	if line[0] != '*' || err != nil {
+		return nil, fmt.Errorf("not a command: %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+		if line[0] != '$' || err != nil {
+			return nil, fmt.Errorf("not a bulk string: %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func respBulk(s string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s) }
+
+func TestRedisClientReplies(t *testing.T) {
+	replies := map[string]string{
+		"PING":   "+PONG\r\n",
+		"INCR":   ":42\r\n",
+		"GET":    respBulk("line one\r\nline two"),
+		"MISS":   "$-1\r\n",
+		"EMPTY":  "*0\r\n",
+		"NESTED": "*3\r\n" + respBulk("a") + ":-7\r\n*2\r\n-ERR inner\r\n$-1\r\n",
+		"FAIL":   "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+		"HUGE":   fmt.Sprintf("$%d\r\n", redisMaxReply+1),
+		"BOGUS":  "?what\r\n",
+	}
+	f := newFakeRedis(t, func(args []string) string { return replies[args[0]] })
+	c, err := newRedisClient(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for cmd, want := range map[string]any{
+		"PING": "PONG",
+		"INCR": int64(42),
+		"GET":  "line one\r\nline two",
+		"MISS": nil,
+	} {
+		if got, err := c.do(ctx, cmd); err != nil || got != want {
+			t.Errorf("%s: %#v (%v), want %#v", cmd, got, err, want)
+		}
+	}
+	if got, err := c.do(ctx, "EMPTY"); err != nil || len(got.([]any)) != 0 {
+		t.Errorf("EMPTY: %#v (%v), want an empty array", got, err)
+	}
+	got, err := c.do(ctx, "NESTED")
+	items, _ := got.([]any)
+	if err != nil || len(items) != 3 || items[0] != "a" || items[1] != int64(-7) {
+		t.Fatalf("NESTED: %#v (%v)", got, err)
+	}
+	if inner, _ := items[2].([]any); len(inner) != 2 || inner[0] != redisError("ERR inner") || inner[1] != nil {
+		t.Errorf("NESTED: inner array %#v, want the error reply as a value", items[2])
+	}
+
+	// An error reply leaves the connection in the pool
+	var rerr redisError
+	if _, err := c.do(ctx, "FAIL"); !errors.As(err, &rerr) || !strings.HasPrefix(string(rerr), "WRONGTYPE") {
+		t.Errorf("FAIL: %v, want the error reply", err)
+	}
+	if f.dials.Load() != 1 {
+		t.Errorf("%d connections dialed, want 1 reused for every reply", f.dials.Load())
+	}
+	// A reply that can't be read drops it
+	for _, cmd := range []string{"HUGE", "BOGUS"} {
+		if _, err := c.do(ctx, cmd); err == nil || errors.As(err, &rerr) {
+			t.Errorf("%s: %v, want a protocol error", cmd, err)
+		}
+	}
+	if _, err := c.do(ctx, "PING"); err != nil || f.dials.Load() != 3 {
+		t.Errorf("PING after protocol errors: %v with %d dials, want a fresh connection each time", err, f.dials.Load())
+	}
+}
+
+func TestRedisClientSetup(t *testing.T) {
+	f := newFakeRedis(t, func(args []string) string {
+		if args[0] == "AUTH" && args[len(args)-1] != "secret" {
+			return "-WRONGPASS invalid username-password pair\r\n"
+		}
+		return "+OK\r\n"
+	})
+	c, err := newRedisClient("redis://gateway:secret@" + f.addr + "/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.do(context.Background(), "PING"); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	commands := fmt.Sprint(f.commands)
+	f.mu.Unlock()
+	if commands != "[[AUTH gateway secret] [SELECT 2] [PING]]" {
+		t.Errorf("commands %s, want the connection set up once before PING", commands)
+	}
+
+	c, _ = newRedisClient("redis://:wrong@" + f.addr)
+	if _, err := c.do(context.Background(), "PING"); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("wrong password: %v", err)
+	}
+
+	for _, rawURL := range []string{"http://localhost:6379", "redis://localhost/db", "redis://%zz"} {
+		if _, err := newRedisClient(rawURL); err == nil {
+			t.Errorf("newRedisClient(%q) accepted", rawURL)
+		}
+	}
+	if c, err := newRedisClient("rediss://cache.internal"); err != nil || c.addr != "cache.internal:6379" || c.tls == nil {
+		t.Errorf("rediss://cache.internal: %+v (%v), want TLS on the default port", c, err)
+	}
+}
+
+func TestRedisEvalScript(t *testing.T) {
+	loaded := false
+	f := newFakeRedis(t, func(args []string) string {
+		switch {
+		case args[0] == "EVAL":
+			loaded = true
+		case !loaded:
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		}
+		return respBulk(args[3] + "=" + args[4])
+	})
+	c, _ := newRedisClient(f.url())
+	for range 2 {
+		if got, err := c.evalScript(context.Background(), "return 1", []string{"k"}, "v"); err != nil || got != "k=v" {
+			t.Fatalf("evalScript: %#v (%v)", got, err)
+		}
+	}
+	if sent := strings.Join(f.sent(), " "); sent != "EVALSHA EVAL EVALSHA" {
+		t.Errorf("commands %q, want the script loaded once", sent)
+	}
+}
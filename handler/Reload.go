@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// routing is the part of the configuration Reload can change. Requests read it through
+// Gateway.routing, so a reload swaps all of it at once and in-flight requests finish with
+// the state they started with.
+type routing struct {
+	upstreams map[string]*upstream
+	// routes are the declared routes as configured; see Gateway.Routes
+	routes         []RouteConfig
+	publicPatterns []string
+	publicPaths    pathPatterns
+	accessRules    []accessRule
+	apiKeys        apiKeyIndex
+	streamRoutes   []string
+	cors           CORSConfig
+	routeCORS      map[string]CORSConfig
+
+	// optionalAuthPaths authenticate callers with credentials and let others through anonymously
+	optionalAuthPatterns []string
+	optionalAuthPaths    pathPatterns
+
+	tenantRateLimits       map[string]RateLimit
+	defaultTenantRateLimit RateLimit
+	routeRateLimits        map[string]RateLimit
+}
+
+// upstreams returns the current services by name; the map must not be modified
+func (g *Gateway) upstreams() map[string]*upstream {
+	return g.routing.Load().upstreams
+}
+
+// streamRoute reports whether path is under Config.StreamRoutes or a declared stream route
+func (g *Gateway) streamRoute(path string) bool {
+	return hasAnyPrefix(path, g.routing.Load().streamRoutes)
+}
+
+// configTargets parses the instance URLs of the built-in and declared services, and
+// merges the options of declared routes into config.Services
+func configTargets(config *Config, opts *gatewayOptions) (map[string][]*url.URL, error) {
+	targets := make(map[string][]*url.URL)
+	for name, raw := range map[string]string{
+		ServiceAuth: config.AuthServiceURL,
+		ServiceBlog: config.BlogServiceURL,
+		ServiceUser: config.UserServiceURL,
+		ServiceAsp:  config.AspServiceURL,
+	} {
+		urls, err := opts.serviceTargets(name, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s service URL: %w", name, err)
+		}
+		targets[name] = urls
+	}
+
+	if err := validateRoutes(config.Routes); err != nil {
+		return nil, err
+	}
+	for _, rt := range config.Routes {
+		urls, err := opts.serviceTargets(rt.Name, rt.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s service URL: %w", rt.Name, err)
+		}
+		targets[rt.Name] = urls
+		if config.Services == nil {
+			config.Services = make(map[string]ServiceConfig)
+		}
+		svc := config.Services[rt.Name]
+		svc.StripPrefix = svc.StripPrefix || rt.StripPrefix
+		svc.GRPC = svc.GRPC || rt.GRPC
+		if rt.GRPCMethods != nil {
+			svc.GRPCMethods = rt.GRPCMethods
+		}
+		if rt.Timeout > 0 {
+			svc.Timeout = rt.Timeout
+		}
+		config.Services[rt.Name] = svc
+	}
+	return targets, nil
+}
+
+// newRouting builds the routing state of config. Upstreams of prev whose ServiceConfig is
+// unchanged are kept along with their runtime state (health signals, breakers, caches,
+// maintenance mode), and only get their instances replaced by retarget once the new
+// state is in place. It returns the changes from prev in words.
+func (g *Gateway) newRouting(config *Config, targets map[string][]*url.URL, prev *routing) (*routing, map[*upstream][]*url.URL, []string, error) {
+	publicPatterns := config.PublicPaths
+	if publicPatterns == nil {
+		publicPatterns = DefaultPublicPaths
+	}
+	optionalAuthPatterns := config.OptionalAuthPaths
+	streamRoutes := config.StreamRoutes
+	for _, rt := range config.Routes {
+		if rt.Public {
+			publicPatterns = append(slices.Clip(publicPatterns), strings.TrimSuffix(rt.Prefix, "/")+"/")
+		}
+		if rt.OptionalAuth {
+			optionalAuthPatterns = append(slices.Clip(optionalAuthPatterns), strings.TrimSuffix(rt.Prefix, "/")+"/")
+		}
+		if rt.Stream {
+			streamRoutes = append(slices.Clip(streamRoutes), rt.Prefix)
+		}
+	}
+	publicPaths, err := compilePathPatterns(publicPatterns)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	optionalAuthPaths, err := compilePathPatterns(optionalAuthPatterns)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	accessRules, err := compileAccessRules(config.AccessRules)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	apiKeys, err := newAPIKeyIndex(config.APIKeys.Keys)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cors := config.CORS.withDefaults()
+	next := &routing{
+		upstreams:              make(map[string]*upstream, len(targets)),
+		routes:                 config.Routes,
+		publicPatterns:         publicPatterns,
+		publicPaths:            publicPaths,
+		optionalAuthPatterns:   optionalAuthPatterns,
+		optionalAuthPaths:      optionalAuthPaths,
+		accessRules:            accessRules,
+		apiKeys:                apiKeys,
+		streamRoutes:           streamRoutes,
+		cors:                   cors,
+		routeCORS:              routeCORS(config, cors),
+		tenantRateLimits:       config.TenantRateLimits,
+		defaultTenantRateLimit: config.DefaultTenantRateLimit,
+		routeRateLimits:        config.RouteRateLimits,
+	}
+	retarget := make(map[*upstream][]*url.URL)
+	var changes []string
+	for _, name := range sortedKeys(targets) {
+		svc := config.Services[name]
+		var up *upstream
+		if prev != nil {
+			up = prev.upstreams[name]
+			_, builtIn := ServicePrefixes[name]
+			switch {
+			case up == nil:
+				changes = append(changes, fmt.Sprintf("service %s added", name))
+			case reflect.DeepEqual(up.svc, svc):
+			case builtIn:
+				// Built-in services are wired into the gateway beyond their routes
+				changes = append(changes, fmt.Sprintf("service %s options changed, restart to apply", name))
+			default:
+				changes = append(changes, fmt.Sprintf("service %s rebuilt with new options", name))
+				up = nil
+			}
+		}
+		if up != nil {
+			if !g.discovered(up.svc) && !sameTargets(up.instances(), targets[name]) {
+				retarget[up] = targets[name]
+				changes = append(changes, fmt.Sprintf("service %s instances %s -> %s", name, instanceList(up.instances()), urlList(targets[name])))
+			}
+			next.upstreams[name] = up
+			continue
+		}
+
+		up, err := g.newUpstream(name, svc, targets[name])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := g.newVersionUpstreams(up); err != nil {
+			return nil, nil, nil, err
+		}
+		next.upstreams[name] = up
+	}
+	if prev == nil {
+		return next, retarget, nil, nil
+	}
+
+	for _, name := range sortedKeys(prev.upstreams) {
+		if _, ok := next.upstreams[name]; !ok {
+			changes = append(changes, fmt.Sprintf("service %s removed", name))
+		}
+	}
+	for _, rt := range config.Routes {
+		// URL changes are reported with the service's instances
+		i := slices.IndexFunc(prev.routes, func(p RouteConfig) bool { return p.Name == rt.Name })
+		if i < 0 {
+			continue
+		}
+		old := prev.routes[i]
+		old.URL = rt.URL
+		if !reflect.DeepEqual(old, rt) {
+			changes = append(changes, fmt.Sprintf("route %s changed", rt.Name))
+		}
+	}
+	if !slices.Equal(prev.publicPatterns, next.publicPatterns) {
+		changes = append(changes, "public paths changed")
+	}
+	if !slices.Equal(prev.optionalAuthPatterns, next.optionalAuthPatterns) {
+		changes = append(changes, "optional auth paths changed")
+	}
+	if !slices.EqualFunc(prev.accessRules, next.accessRules, func(a, b accessRule) bool { return reflect.DeepEqual(a.AccessRule, b.AccessRule) }) {
+		changes = append(changes, "access rules changed")
+	}
+	if !maps.EqualFunc(prev.apiKeys, next.apiKeys, func(a, b *APIKey) bool { return *a == *b }) {
+		changes = append(changes, "API keys changed")
+	}
+	if !slices.Equal(prev.streamRoutes, next.streamRoutes) {
+		changes = append(changes, "stream routes changed")
+	}
+	if !slices.Equal(prev.cors.Origins, next.cors.Origins) {
+		changes = append(changes, fmt.Sprintf("CORS origins %s -> %s", strings.Join(prev.cors.Origins, ","), strings.Join(next.cors.Origins, ",")))
+	} else if !reflect.DeepEqual(prev.cors, next.cors) {
+		changes = append(changes, "CORS policy changed")
+	}
+	if !reflect.DeepEqual(prev.routeCORS, next.routeCORS) {
+		changes = append(changes, "route CORS policies changed")
+	}
+	if !maps.Equal(prev.tenantRateLimits, next.tenantRateLimits) || prev.defaultTenantRateLimit != next.defaultTenantRateLimit {
+		changes = append(changes, "tenant rate limits changed")
+	}
+	if !maps.Equal(prev.routeRateLimits, next.routeRateLimits) {
+		changes = append(changes, "route rate limits changed")
+	}
+	return next, retarget, changes, nil
+}
+
+// Reload applies the declared routes, service URLs, public and stream paths, access
+// rules, CORS policy and rate limits of config without dropping in-flight requests, and
+// logs what changed.
+// Declared services whose options changed are rebuilt; option changes of built-in
+// services and all other settings take effect on restart. When config is invalid the
+// gateway keeps its current state. Callers serving declared routes through their own
+// router should rebuild it from Routes afterwards.
+func (g *Gateway) Reload(config *Config) error {
+	g.reloadMu.Lock()
+	defer g.reloadMu.Unlock()
+
+	targets, err := configTargets(config, &g.options)
+	if err != nil {
+		return err
+	}
+	prev := g.routing.Load()
+	next, retarget, changes, err := g.newRouting(config, targets, prev)
+	if err != nil {
+		return err
+	}
+	g.routing.Store(next)
+	for up, urls := range retarget {
+		up.setInstances(urls)
+	}
+	if !reflect.DeepEqual(prev.cors, next.cors) || !reflect.DeepEqual(prev.routeCORS, next.routeCORS) {
+		g.checkCookieCORS()
+	}
+	critical := config.CriticalServices
+	if critical == nil {
+		critical = DefaultCriticalServices
+	}
+	g.readiness.track(sortedKeys(next.upstreams), critical)
+	// Idle connections of replaced upstreams would otherwise linger until IdleConnTimeout
+	for name, up := range prev.upstreams {
+		if next.upstreams[name] != up {
+			up.transport.CloseIdleConnections()
+		}
+	}
+
+	if len(changes) == 0 {
+		g.Logger.Info("Configuration reloaded, nothing changed")
+		return nil
+	}
+	g.Logger.Info("Configuration reloaded", "changes", changes)
+	return nil
+}
+
+// discovered reports whether a registry, rather than the configured URLs, provides
+// the service's instances
+func (g *Gateway) discovered(svc ServiceConfig) bool {
+	return svc.ConsulService != "" && g.Config.Consul.Address != "" ||
+		svc.KubernetesService != "" && g.Config.Kubernetes.Enabled
+}
+
+func instanceList(instances []*instance) string {
+	return strings.Join(instanceURLs(instances), ",")
+}
+
+func urlList(targets []*url.URL) string {
+	urls := make([]string, len(targets))
+	for i, t := range targets {
+		urls[i] = t.String()
+	}
+	return strings.Join(urls, ",")
+}
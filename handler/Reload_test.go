@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadWithRequestsInFlight(t *testing.T) {
+	arrived, release := make(chan struct{}), make(chan struct{})
+	oldBlog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		writeJSON(w, http.StatusOK, map[string]string{"instance": "old"})
+	}))
+	t.Cleanup(oldBlog.Close)
+	newBlog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"instance": "new"})
+	}))
+	t.Cleanup(newBlog.Close)
+
+	config := func(blogURL, origin string) *Config {
+		return &Config{BlogServiceURL: blogURL, CORS: CORSConfig{Origins: []string{origin}}}
+	}
+	g := newTestGateway(t, config(oldBlog.URL, "https://old.example.com"))
+	h := g.CORSMiddleware(g.ProxyHandler(ServiceBlog))
+	get := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() { inFlight <- get("https://old.example.com") }()
+	select {
+	case <-arrived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the old instance")
+	}
+
+	next := config(newBlog.URL, "https://new.example.com")
+	next.AuthServiceURL, next.UserServiceURL, next.AspServiceURL = g.Config.AuthServiceURL, g.Config.UserServiceURL, g.Config.AspServiceURL
+	if err := g.Reload(next); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	// New requests see the new instance and origins at once
+	rec := get("https://new.example.com")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"new"`) || rec.Header().Get("Access-Control-Allow-Origin") != "https://new.example.com" {
+		t.Errorf("after reload: status %d, body %s, allowed origin %q", rec.Code, rec.Body, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if rec := get("https://old.example.com"); rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("old origin still allowed after reload")
+	}
+
+	// The request in flight finishes against the instance it started on
+	close(release)
+	select {
+	case rec := <-inFlight:
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"old"`) {
+			t.Errorf("in-flight request: status %d, body %s", rec.Code, rec.Body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request never finished")
+	}
+}
+
+func TestReloadReportsWhatNeedsARestart(t *testing.T) {
+	comments := newTestUpstream(t)
+	config := func(timeout time.Duration) *Config {
+		return &Config{
+			Routes: []RouteConfig{{Name: "comments", Prefix: "/api/comments", URL: comments.URL}},
+			Services: map[string]ServiceConfig{
+				ServiceBlog: {CacheTTL: timeout},
+				"comments":  {CacheTTL: timeout},
+			},
+		}
+	}
+	g := newTestGateway(t, config(time.Minute))
+	var logs lockedBuffer
+	g.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	blog, routed := g.upstreams()[ServiceBlog], g.upstreams()["comments"]
+
+	next := config(2 * time.Minute)
+	next.AuthServiceURL, next.BlogServiceURL, next.UserServiceURL, next.AspServiceURL = g.Config.AuthServiceURL, g.Config.BlogServiceURL, g.Config.UserServiceURL, g.Config.AspServiceURL
+	if err := g.Reload(next); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if !strings.Contains(logs.String(), "service blog options changed, restart to apply") {
+		t.Errorf("built-in service option change not reported as needing a restart: %s", logs.String())
+	}
+	if g.upstreams()[ServiceBlog] != blog || g.upstreams()[ServiceBlog].svc.CacheTTL != time.Minute {
+		t.Error("built-in service rebuilt on reload, want its options kept until restart")
+	}
+	if !strings.Contains(logs.String(), "service comments rebuilt with new options") || g.upstreams()["comments"] == routed {
+		t.Errorf("declared service not rebuilt: %s", logs.String())
+	}
+
+	// An invalid configuration leaves the gateway as it was
+	bad := config(2 * time.Minute)
+	bad.AccessRules = []AccessRule{{Path: "^(", Roles: []string{"admin"}}}
+	if err := g.Reload(bad); err == nil || g.upstreams()["comments"] == nil {
+		t.Errorf("Reload of an invalid configuration: %v", err)
+	}
+}
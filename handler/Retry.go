@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Retry defaults
+const (
+	defaultRetryBaseDelay = 50 * time.Millisecond
+	defaultRetryMaxDelay  = time.Second
+	defaultRetryBudget    = 0.2
+	retryBudgetCap        = 10 // retries banked for quiet periods
+	retryMaxBody          = 64 << 10
+)
+
+var defaultRetryMethods = []string{http.MethodGet, http.MethodHead}
+
+// RetryConfig retries requests that fail with a connection error, 502 or 503, up to
+// MaxAttempts attempts in total, each preferring an instance not tried yet. Attempts are
+// spaced by exponential backoff with full jitter between BaseDelay and MaxDelay.
+// Budget caps retries at that fraction of the service's requests, so retries can't
+// multiply the load on a service that is already failing. MaxAttempts below 2 disables
+// retries; other zero values mean the defaults above.
+type RetryConfig struct {
+	MaxAttempts int
+	Methods     []string // default GET and HEAD; only list idempotent methods
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Budget      float64
+}
+
+// retryConfig resolves a service's retry settings; non-zero service fields override Config.Retry
+func (g *Gateway) retryConfig(svc ServiceConfig) RetryConfig {
+	c := g.Config.Retry
+	if svc.Retry.MaxAttempts != 0 {
+		c.MaxAttempts = svc.Retry.MaxAttempts
+	}
+	if len(svc.Retry.Methods) > 0 {
+		c.Methods = svc.Retry.Methods
+	}
+	if svc.Retry.BaseDelay != 0 {
+		c.BaseDelay = svc.Retry.BaseDelay
+	}
+	if svc.Retry.MaxDelay != 0 {
+		c.MaxDelay = svc.Retry.MaxDelay
+	}
+	if svc.Retry.Budget != 0 {
+		c.Budget = svc.Retry.Budget
+	}
+	if len(c.Methods) == 0 {
+		c.Methods = defaultRetryMethods
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = defaultRetryBaseDelay
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = defaultRetryMaxDelay
+	}
+	if c.Budget <= 0 {
+		c.Budget = defaultRetryBudget
+	}
+	return c
+}
+
+// backoff returns the delay before retry n (1-based): a random duration up to
+// BaseDelay doubled n-1 times, capped at MaxDelay
+func (c RetryConfig) backoff(n int) time.Duration {
+	d := c.MaxDelay
+	if n < 32 {
+		d = min(c.BaseDelay<<(n-1), c.MaxDelay)
+	}
+	return rand.N(d + 1)
+}
+
+// retryBudget earns a fraction of a retry for every request and spends one per retry
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio, tokens: retryBudgetCap}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	b.tokens = min(b.tokens+b.ratio, retryBudgetCap)
+	b.mu.Unlock()
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retryHandler retries failed attempts of retryable requests. An attempt's response is
+// held back only until its status is known, so successful responses still stream.
+func (g *Gateway) retryHandler(up *upstream, cfg RetryConfig, next http.Handler) http.Handler {
+	budget := newRetryBudget(cfg.Budget)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget.deposit()
+		if !slices.Contains(cfg.Methods, r.Method) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, ok := replayableBody(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// balanceHandler records each attempt's instance so retries go elsewhere
+		tried := make(map[*instance]bool)
+		r = r.WithContext(context.WithValue(r.Context(), triedInstanceKey{}, tried))
+		for attempt := 1; ; attempt++ {
+			if body != nil {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			last := attempt >= cfg.MaxAttempts
+			rw := &retryWriter{w: w, header: make(http.Header), retry: !last}
+			next.ServeHTTP(rw, r)
+			if !rw.failed {
+				return
+			}
+
+			// A retry the request's deadline can't wait for would only fail later, so the
+			// request fails now as timed out rather than with the attempt's error
+			delay := cfg.backoff(attempt)
+			if deadline, ok := r.Context().Deadline(); ok && time.Until(deadline) < delay {
+				g.Logger.WarnContext(r.Context(), "Request deadline leaves no time to retry", "service", up.name, "method", r.Method, "path", r.URL.Path, "status", rw.status, "delay", delay)
+				writeDeadlineExceeded(w, r)
+				return
+			}
+			if !budget.withdraw() {
+				g.Logger.WarnContext(r.Context(), "Retry budget exhausted", "service", up.name, "method", r.Method, "path", r.URL.Path)
+				rw.commit(rw.status)
+				return
+			}
+			g.Logger.InfoContext(r.Context(), "Retrying request", "method", r.Method, "path", r.URL.Path, "service", up.name, "status", rw.status, "delay", delay, "attempt", attempt+1, "max_attempts", cfg.MaxAttempts)
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				if deadlineExceeded(r) {
+					writeDeadlineExceeded(w, r)
+					return
+				}
+				rw.commit(rw.status)
+				return
+			}
+		}
+	})
+}
+
+// replayableBody reads a small request body so it can be sent again; ok is false when
+// the body is too large to hold, in which case r.Body is left ready to stream
+func replayableBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, retryMaxBody+1))
+	if err != nil || len(body) > retryMaxBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body.Close()
+	return body, true
+}
+
+// retryWriter passes a response through once its status shows it isn't retryable;
+// a retryable failure is dropped, leaving failed set, unless retry is false
+type retryWriter struct {
+	w         http.ResponseWriter
+	header    http.Header
+	retry     bool
+	status    int
+	failed    bool
+	committed bool
+}
+
+func (rw *retryWriter) Header() http.Header {
+	if rw.committed {
+		return rw.w.Header()
+	}
+	return rw.header
+}
+
+func (rw *retryWriter) WriteHeader(code int) {
+	if rw.committed || rw.failed {
+		return
+	}
+	if code >= 100 && code < 200 {
+		for k, v := range rw.header {
+			rw.w.Header()[k] = v
+		}
+		rw.w.WriteHeader(code)
+		return
+	}
+	rw.status = code
+	if rw.retry && (code == http.StatusBadGateway || code == http.StatusServiceUnavailable) {
+		rw.failed = true
+		return
+	}
+	rw.commit(code)
+}
+
+func (rw *retryWriter) Write(b []byte) (int, error) {
+	if !rw.committed && !rw.failed {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.failed {
+		return len(b), nil
+	}
+	return rw.w.Write(b)
+}
+
+// commit sends the held headers with status to the client; a dropped failure's body
+// is gone by then, so it's answered with the gateway's own error, keeping the
+// upstream's Retry-After
+func (rw *retryWriter) commit(status int) {
+	if rw.committed {
+		return
+	}
+	rw.committed = true
+	if rw.failed {
+		if v := rw.header.Get("Retry-After"); v != "" {
+			rw.w.Header().Set("Retry-After", v)
+		}
+		writeJSONError(rw.w, status, "upstream unavailable")
+		return
+	}
+	for k, v := range rw.header {
+		rw.w.Header()[k] = v
+	}
+	rw.w.WriteHeader(status)
+}
+
+// Flush keeps streaming responses working through the wrapper
+func (rw *retryWriter) Flush() {
+	if rw.committed && !rw.failed {
+		http.NewResponseController(rw.w).Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *retryWriter) Unwrap() http.ResponseWriter {
+	return rw.w
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryOutOfDeadlineIsGatewayTimeout(t *testing.T) {
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer blog.Close()
+	// Backoffs are random up to 10s, so the 100ms deadline almost never covers one; when
+	// it does, later attempts run into the deadline instead
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		Services: map[string]ServiceConfig{ServiceBlog: {
+			Retry: RetryConfig{MaxAttempts: 10, BaseDelay: 10 * time.Second, MaxDelay: 10 * time.Second},
+		}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil).WithContext(ctx)
+	start := time.Now()
+	rec := serve(t, g.ProxyHandler(ServiceBlog), req, nil)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status %d: %s, want 504", rec.Code, rec.Body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s, want the request failed by its deadline", elapsed)
+	}
+}
+
+func TestRetryGivingUpKeepsRetryAfter(t *testing.T) {
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer blog.Close()
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		Services: map[string]ServiceConfig{ServiceBlog: {
+			Retry: RetryConfig{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Budget: 0.01},
+		}},
+	})
+	h := g.ProxyHandler(ServiceBlog)
+
+	// The budget's banked retries run out after ten requests
+	var rec *httptest.ResponseRecorder
+	for range 11 {
+		rec = serve(t, h, httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil)
+	}
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "7" {
+		t.Errorf("out of budget got %d with Retry-After %q, want 503 with the upstream's 7", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Revocation defaults
+const (
+	defaultRevocationSyncInterval = 30 * time.Second
+	defaultRevocationMaxTokenAge  = 24 * time.Hour
+)
+
+// errTokenRevoked means the token was valid but has been revoked since
+var errTokenRevoked = errors.New("token revoked")
+
+// RevocationConfig rejects tokens revoked before their expiry, e.g. after a logout or a
+// password change. Revocations arrive through POST /admin/revocations, which AuthService
+// can call as a webhook, and, when SyncPath is set, by polling AuthService's list.
+type RevocationConfig struct {
+	// SyncPath on AuthService returns {"revocations": [...]}, fetched every SyncInterval
+	// (default 30s); empty disables syncing
+	SyncPath     string
+	SyncInterval time.Duration
+
+	// MaxTokenAge is how long a revocation is remembered when it doesn't say when the
+	// token expires, and how long a user's revocation lasts (default 24h). It should be
+	// at least the lifetime of the tokens AuthService issues.
+	MaxTokenAge time.Duration
+}
+
+// Revocation revokes one token or every token a user was issued before RevokedAt. It's
+// the body of POST /admin/revocations and an entry of the synced list.
+type Revocation struct {
+	// Token, or its SHA-256 as hex, revokes that token until ExpiresAt
+	Token       string `json:"token,omitempty"`
+	TokenSHA256 string `json:"tokenSHA256,omitempty"`
+	ExpiresAt   int64  `json:"expiresAt,omitempty"`
+
+	// UserID revokes the user's tokens issued before RevokedAt (default now). Tokens
+	// whose issue time is unknown count as issued before.
+	UserID    string `json:"userID,omitempty"`
+	RevokedAt int64  `json:"revokedAt,omitempty"`
+}
+
+// revocationList holds revoked token hashes and users until their revocations lapse
+type revocationList struct {
+	maxTokenAge time.Duration
+
+	mu     sync.RWMutex
+	tokens map[[sha256.Size]byte]time.Time // until
+	users  map[string]time.Time            // revoked at
+}
+
+type revocationStats struct {
+	Tokens int `json:"tokens"`
+	Users  int `json:"users"`
+}
+
+func newRevocationList(maxTokenAge time.Duration) *revocationList {
+	if maxTokenAge <= 0 {
+		maxTokenAge = defaultRevocationMaxTokenAge
+	}
+	return &revocationList{
+		maxTokenAge: maxTokenAge,
+		tokens:      make(map[[sha256.Size]byte]time.Time),
+		users:       make(map[string]time.Time),
+	}
+}
+
+// add records rev, dropping lapsed revocations on the way
+func (l *revocationList) add(rev Revocation) error {
+	now := time.Now()
+	var key [sha256.Size]byte
+	hasToken := rev.Token != "" || rev.TokenSHA256 != ""
+	switch {
+	case rev.Token != "":
+		key = sha256.Sum256([]byte(rev.Token))
+	case rev.TokenSHA256 != "":
+		sum, err := hex.DecodeString(rev.TokenSHA256)
+		if err != nil || len(sum) != sha256.Size {
+			return errors.New("tokenSHA256 must be a hex SHA-256")
+		}
+		copy(key[:], sum)
+	case rev.UserID == "":
+		return errors.New("revocation needs a token, tokenSHA256 or userID")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire(now)
+	if hasToken {
+		until := now.Add(l.maxTokenAge)
+		if rev.ExpiresAt != 0 {
+			until = time.Unix(rev.ExpiresAt, 0).Add(jwtLeeway)
+		}
+		if until.After(now) && until.After(l.tokens[key]) {
+			l.tokens[key] = until
+		}
+	}
+	if rev.UserID != "" {
+		at := now
+		if rev.RevokedAt != 0 {
+			at = time.Unix(rev.RevokedAt, 0)
+		}
+		if at.Add(l.maxTokenAge).After(now) && at.After(l.users[rev.UserID]) {
+			l.users[rev.UserID] = at
+		}
+	}
+	return nil
+}
+
+// expire drops lapsed revocations; l.mu must be held
+func (l *revocationList) expire(now time.Time) {
+	for key, until := range l.tokens {
+		if !now.Before(until) {
+			delete(l.tokens, key)
+		}
+	}
+	for userID, at := range l.users {
+		if !now.Before(at.Add(l.maxTokenAge)) {
+			delete(l.users, userID)
+		}
+	}
+}
+
+// revoked reports whether token, validated as identity, has been revoked
+func (l *revocationList) revoked(token string, identity *AuthValidateResponse) bool {
+	now := time.Now()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.tokens) == 0 && len(l.users) == 0 {
+		return false
+	}
+	if until, ok := l.tokens[sha256.Sum256([]byte(token))]; ok && now.Before(until) {
+		return true
+	}
+	at, ok := l.users[identity.UserID]
+	if !ok || !now.Before(at.Add(l.maxTokenAge)) {
+		return false
+	}
+	return identity.IssuedAt == 0 || time.Unix(identity.IssuedAt, 0).Before(at)
+}
+
+func (l *revocationList) stats() *revocationStats {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return &revocationStats{Tokens: len(l.tokens), Users: len(l.users)}
+}
+
+// RevocationHandler revokes a token or a user's tokens (POST /admin/revocations), for
+// AuthService to call on logout or password change
+func (g *Gateway) RevocationHandler(w http.ResponseWriter, r *http.Request) {
+	var rev Revocation
+	if err := json.NewDecoder(r.Body).Decode(&rev); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid revocation request")
+		return
+	}
+	if err := g.revocations.add(rev); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	g.Logger.InfoContext(r.Context(), "Token revoked", "user_id", rev.UserID, "token", rev.Token != "" || rev.TokenSHA256 != "")
+	writeJSON(w, http.StatusOK, g.revocations.stats())
+}
+
+// SyncRevocations fetches AuthService's revocation list every RevocationConfig.SyncInterval
+// until ctx is done; it's a no-op unless RevocationConfig.SyncPath is set
+func (g *Gateway) SyncRevocations(ctx context.Context) {
+	cfg := g.Config.Revocation
+	if cfg.SyncPath == "" {
+		return
+	}
+	interval := cfg.SyncInterval
+	if interval <= 0 {
+		interval = defaultRevocationSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := g.FetchRevocations(ctx); err != nil && ctx.Err() == nil {
+			g.Logger.Error("Revocation sync failed, keeping previous revocations", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// FetchRevocations adds the revocations listed at RevocationConfig.SyncPath on AuthService
+func (g *Gateway) FetchRevocations(ctx context.Context) error {
+	auth := g.upstreams()[ServiceAuth]
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(auth.pick().url.String(), "/")+g.Config.Revocation.SyncPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create revocation list request: %w", err)
+	}
+	resp, err := auth.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch revocation list: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revocation list returned status: %d", resp.StatusCode)
+	}
+
+	var list struct {
+		Revocations []Revocation `json:"revocations"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("failed to decode revocation list: %w", err)
+	}
+	for _, rev := range list.Revocations {
+		if err := g.revocations.add(rev); err != nil {
+			g.Logger.Warn("Skipping revocation", "user_id", rev.UserID, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRevokedTokensAreRejected(t *testing.T) {
+	now := time.Now()
+	old := signHS256(t, "s3cr3t", map[string]any{"sub": "alice", "role": "user", "exp": float64(now.Add(time.Hour).Unix()), "iat": float64(now.Add(-time.Hour).Unix())})
+	other := signHS256(t, "s3cr3t", map[string]any{"sub": "alice", "role": "user", "exp": float64(now.Add(2 * time.Hour).Unix()), "iat": float64(now.Add(-time.Hour).Unix())})
+	fresh := signHS256(t, "s3cr3t", map[string]any{"sub": "alice", "role": "user", "exp": float64(now.Add(time.Hour).Unix()), "iat": float64(now.Add(time.Minute).Unix())})
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		LocalJWT:       LocalJWTConfig{Enabled: true, Secret: "s3cr3t"},
+		TokenCacheTTL:  time.Minute,
+	})
+	h := g.AuthMiddleware(g.ProxyHandler(ServiceBlog))
+	status := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return serve(t, h, req, nil).Code
+	}
+	revoke := func(body string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/admin/revocations", bytes.NewBufferString(body))
+		if rec := serve(t, http.HandlerFunc(g.RevocationHandler), req, nil); rec.Code != http.StatusOK {
+			t.Fatalf("revocation %s got %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+
+	// Cache both tokens before revoking
+	if status(old) != http.StatusOK || status(other) != http.StatusOK {
+		t.Fatal("valid tokens rejected")
+	}
+	revoke(`{"token":"` + old + `"}`)
+	if got := status(old); got != http.StatusUnauthorized {
+		t.Errorf("revoked token got %d, want 401", got)
+	}
+	if got := status(other); got != http.StatusOK {
+		t.Errorf("other token got %d after revoking one token, want 200", got)
+	}
+
+	revoke(`{"userID":"alice"}`)
+	if got := status(other); got != http.StatusUnauthorized {
+		t.Errorf("token issued before the user's revocation got %d, want 401", got)
+	}
+	if got := status(fresh); got != http.StatusOK {
+		t.Errorf("token issued after the user's revocation got %d, want 200", got)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/revocations", bytes.NewBufferString(`{}`))
+	if rec := serve(t, http.HandlerFunc(g.RevocationHandler), req, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty revocation got %d, want 400", rec.Code)
+	}
+}
+
+func TestFetchRevocations(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/auth/revocations" {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"revocations": []Revocation{
+			{TokenSHA256: "not hex"},
+			{UserID: "bob", RevokedAt: time.Now().Unix()},
+		}})
+	}))
+	defer auth.Close()
+	g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, Revocation: RevocationConfig{SyncPath: "/api/auth/revocations"}})
+
+	if err := g.FetchRevocations(context.Background()); err != nil {
+		t.Fatalf("FetchRevocations: %v", err)
+	}
+	if !g.revocations.revoked("token", &AuthValidateResponse{UserID: "bob"}) {
+		t.Error("synced user revocation not applied to a token without an issue time")
+	}
+	if s := g.revocations.stats(); s.Users != 1 || s.Tokens != 0 {
+		t.Errorf("revocations %+v, want the user only", s)
+	}
+}
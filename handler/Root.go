@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Version is the gateway build version, set at build time with
+// -ldflags "-X github.com/MicroSOA-09/gateway-service/handler.Version=1.2.3"
+var Version = "dev"
+
+// GatewayName identifies the gateway in the root banner
+const GatewayName = "gateway-service"
+
+// RootHandler answers GET / with a small status banner for health checkers and humans
+func (g *Gateway) RootHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"name":    GatewayName,
+		"version": Version,
+		"uptime":  time.Since(g.started).Truncate(time.Second).String(),
+	})
+}
+
+// redirectFor returns the configured redirect target for a non-API path
+func (g *Gateway) redirectFor(path string) (string, bool) {
+	if strings.HasPrefix(path, "/api/") {
+		return "", false
+	}
+	target, ok := g.Config.Redirects[path]
+	return target, ok
+}
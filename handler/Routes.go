@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RouteConfig declares a proxied service in a routes file, so new services can be added
+// without recompiling the gateway. Per-service options in Config.Services apply to
+// declared services under their Name as well.
+type RouteConfig struct {
+	Name    string   `json:"name"`
+	Prefix  string   `json:"prefix"` // e.g. /api/comments
+	URL     string   `json:"url"`    // comma-separated instances, like the service URLs
+	Methods []string `json:"methods,omitempty"`
+	// Public routes skip JWT validation
+	Public bool `json:"public,omitempty"`
+	// OptionalAuth routes validate a token when there is one and otherwise proxy anonymously
+	OptionalAuth bool `json:"optionalAuth,omitempty"`
+	StripPrefix  bool `json:"stripPrefix,omitempty"`
+	// Stream adds the prefix to Config.StreamRoutes, for long-lived responses such as SSE
+	Stream bool `json:"stream,omitempty"`
+	// GRPC and GRPCMethods set ServiceConfig.GRPC and ServiceConfig.GRPCMethods
+	GRPC        bool              `json:"grpc,omitempty"`
+	GRPCMethods map[string]string `json:"grpcMethods,omitempty"`
+	// Timeout bounds each request to the service, e.g. "5s"
+	Timeout time.Duration `json:"-"`
+}
+
+// LoadRoutes reads a JSON routes file: an array of routes whose timeout is a duration
+// string, e.g. [{"name":"comments","prefix":"/api/comments","url":"http://comments:8080","timeout":"5s"}]
+func LoadRoutes(path string) ([]RouteConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes file: %w", err)
+	}
+	var raw []struct {
+		RouteConfig
+		Timeout string `json:"timeout,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid routes file %s: %w", path, err)
+	}
+	routes := make([]RouteConfig, 0, len(raw))
+	for _, r := range raw {
+		rt := r.RouteConfig
+		if r.Timeout != "" {
+			if rt.Timeout, err = time.ParseDuration(r.Timeout); err != nil {
+				return nil, fmt.Errorf("route %s: invalid timeout: %w", rt.Name, err)
+			}
+		}
+		routes = append(routes, rt)
+	}
+	return routes, nil
+}
+
+// validateRoutes checks declared routes against each other and the built-in services
+func validateRoutes(routes []RouteConfig) error {
+	seen := make(map[string]bool)
+	for _, rt := range routes {
+		switch {
+		case rt.Name == "":
+			return fmt.Errorf("route with prefix %q has no name", rt.Prefix)
+		case seen[rt.Name] || ServicePrefixes[rt.Name] != "":
+			return fmt.Errorf("route %s: duplicate service name", rt.Name)
+		case !strings.HasPrefix(rt.Prefix, "/") || rt.Prefix == "/":
+			return fmt.Errorf("route %s: prefix must be a path below /", rt.Name)
+		}
+		seen[rt.Name] = true
+	}
+	return nil
+}
+
+// Routes returns the declared routes, longest prefix first so they can be registered
+// ahead of shorter, overlapping ones
+func (g *Gateway) Routes() []RouteConfig {
+	routes := append([]RouteConfig(nil), g.routing.Load().routes...)
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].Prefix) > len(routes[j].Prefix) })
+	for i := range routes {
+		routes[i].Prefix = strings.TrimSuffix(routes[i].Prefix, "/")
+		// Routers may normalize the methods in place, so each call returns its own copy
+		if len(routes[i].Methods) == 0 {
+			routes[i].Methods = slices.Clone(ProxyMethods)
+		} else {
+			routes[i].Methods = slices.Clone(routes[i].Methods)
+		}
+	}
+	return routes
+}
+
+// servicePrefix returns the path prefix a service is mounted under
+func (g *Gateway) servicePrefix(name string) string {
+	if prefix, ok := ServicePrefixes[name]; ok {
+		return prefix
+	}
+	for _, rt := range g.routing.Load().routes {
+		if rt.Name == name {
+			return strings.TrimSuffix(rt.Prefix, "/")
+		}
+	}
+	return ""
+}
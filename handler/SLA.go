@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSLAWindow         = time.Hour
+	defaultSLAMinRequests    = 20
+	defaultSLAEvalInterval   = 30 * time.Second
+	defaultSLANotifyInterval = 15 * time.Minute
+	slaSlots                 = 60
+)
+
+// slaLatencyBounds are the histogram bucket upper bounds used to estimate P95
+var slaLatencyBounds = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond, time.Second,
+	2500 * time.Millisecond, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+}
+
+// SLAConfig defines the service level a route prefix must meet over a rolling window
+type SLAConfig struct {
+	Prefix string
+	// Availability is the minimum fraction of non-5xx responses, e.g. 0.999
+	Availability float64
+	// P95 is the maximum 95th percentile latency (0 disables the latency objective)
+	P95 time.Duration
+	// Window is the rolling evaluation window, default one hour
+	Window time.Duration
+	// MinRequests is the sample size below which the SLA isn't evaluated
+	MinRequests int
+	// Webhook overrides Config.SLAWebhook for this route
+	Webhook string
+}
+
+// slaSlot aggregates the requests of one slice of the rolling window
+type slaSlot struct {
+	start   time.Time
+	total   int
+	errors  int
+	latency [13]int // one count per slaLatencyBounds entry plus overflow
+}
+
+// slaRoute tracks one SLA definition
+type slaRoute struct {
+	cfg   SLAConfig
+	mu    sync.Mutex
+	slots [slaSlots]slaSlot
+
+	breached     bool
+	lastNotified time.Time
+}
+
+func newSLARoutes(configs []SLAConfig) []*slaRoute {
+	routes := make([]*slaRoute, 0, len(configs))
+	for _, c := range configs {
+		if c.Window <= 0 {
+			c.Window = defaultSLAWindow
+		}
+		if c.MinRequests <= 0 {
+			c.MinRequests = defaultSLAMinRequests
+		}
+		routes = append(routes, &slaRoute{cfg: c})
+	}
+	// Longest prefix first so the most specific SLA wins
+	sort.Slice(routes, func(i, j int) bool { return len(routes[i].cfg.Prefix) > len(routes[j].cfg.Prefix) })
+	return routes
+}
+
+func (s *slaRoute) slotWidth() time.Duration {
+	return s.cfg.Window / slaSlots
+}
+
+func (s *slaRoute) record(now time.Time, failed bool, latency time.Duration) {
+	width := s.slotWidth()
+	start := now.Truncate(width)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slot := &s.slots[int(start.UnixNano()/int64(width))%slaSlots]
+	if !slot.start.Equal(start) {
+		*slot = slaSlot{start: start}
+	}
+	slot.total++
+	if failed {
+		slot.errors++
+	}
+	i := sort.Search(len(slaLatencyBounds), func(i int) bool { return latency <= slaLatencyBounds[i] })
+	slot.latency[i]++
+}
+
+// snapshot returns request count, availability and estimated P95 over the window
+func (s *slaRoute) snapshot(now time.Time) (total int, availability float64, p95 time.Duration) {
+	var errors int
+	var hist [13]int
+	cutoff := now.Add(-s.cfg.Window)
+	s.mu.Lock()
+	for _, slot := range s.slots {
+		if slot.start.Before(cutoff) {
+			continue
+		}
+		total += slot.total
+		errors += slot.errors
+		for i, n := range slot.latency {
+			hist[i] += n
+		}
+	}
+	s.mu.Unlock()
+
+	if total == 0 {
+		return 0, 1, 0
+	}
+	availability = float64(total-errors) / float64(total)
+	rank := (total*95 + 99) / 100
+	for i, n := range hist {
+		if rank -= n; rank <= 0 {
+			if i < len(slaLatencyBounds) {
+				p95 = slaLatencyBounds[i]
+			} else {
+				p95 = 2 * slaLatencyBounds[len(slaLatencyBounds)-1]
+			}
+			break
+		}
+	}
+	return total, availability, p95
+}
+
+// SLAMiddleware records the outcome of requests on routes with an SLA
+func (g *Gateway) SLAMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := g.slaRoute(r.URL.Path)
+		if route == nil || g.longLived(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+		route.record(time.Now(), rec.status >= 500, time.Since(start))
+	})
+}
+
+func (g *Gateway) slaRoute(path string) *slaRoute {
+	for _, route := range g.slaRoutes {
+		if strings.HasPrefix(path, route.cfg.Prefix) {
+			return route
+		}
+	}
+	return nil
+}
+
+// slaEvent is the webhook payload for breaches and recoveries
+type slaEvent struct {
+	Event              string    `json:"event"`
+	Route              string    `json:"route"`
+	Requests           int       `json:"requests"`
+	Availability       float64   `json:"availability"`
+	TargetAvailability float64   `json:"targetAvailability"`
+	P95Ms              int64     `json:"p95Ms"`
+	TargetP95Ms        int64     `json:"targetP95Ms,omitempty"`
+	Window             string    `json:"window"`
+	Timestamp          time.Time `json:"timestamp"`
+}
+
+// MonitorSLAs evaluates every SLA periodically until ctx is done, firing the webhook
+// when a route starts breaching and again when it recovers. Breach notifications for
+// a route are sent at most once per Config.SLANotifyInterval.
+func (g *Gateway) MonitorSLAs(ctx context.Context) {
+	if len(g.slaRoutes) == 0 {
+		return
+	}
+	interval := g.Config.SLAEvalInterval
+	if interval <= 0 {
+		interval = defaultSLAEvalInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, route := range g.slaRoutes {
+				g.evaluateSLA(ctx, route, now)
+			}
+		}
+	}
+}
+
+func (g *Gateway) evaluateSLA(ctx context.Context, route *slaRoute, now time.Time) {
+	total, availability, p95 := route.snapshot(now)
+	if total < route.cfg.MinRequests {
+		return
+	}
+	breach := availability < route.cfg.Availability || (route.cfg.P95 > 0 && p95 > route.cfg.P95)
+
+	notifyInterval := g.Config.SLANotifyInterval
+	if notifyInterval <= 0 {
+		notifyInterval = defaultSLANotifyInterval
+	}
+
+	var event string
+	switch {
+	case breach && !route.breached && now.Sub(route.lastNotified) >= notifyInterval:
+		event = "sla_breach"
+		route.breached = true
+	case !breach && route.breached:
+		event = "sla_recovered"
+		route.breached = false
+	default:
+		return
+	}
+	route.lastNotified = now
+
+	g.Logger.Warn("SLA "+event, "route", route.cfg.Prefix, "availability", availability, "p95", p95, "requests", total)
+	err := g.postWebhook(ctx, route.cfg.webhook(g.Config.SLAWebhook), slaEvent{
+		Event:              event,
+		Route:              route.cfg.Prefix,
+		Requests:           total,
+		Availability:       availability,
+		TargetAvailability: route.cfg.Availability,
+		P95Ms:              p95.Milliseconds(),
+		TargetP95Ms:        route.cfg.P95.Milliseconds(),
+		Window:             route.cfg.Window.String(),
+		Timestamp:          now.UTC(),
+	})
+	if err != nil {
+		g.Logger.Error("SLA webhook failed", "error", err)
+	}
+}
+
+func (c SLAConfig) webhook(def string) string {
+	if c.Webhook != "" {
+		return c.Webhook
+	}
+	return def
+}
+
+// postWebhook POSTs payload as JSON to url, through the client of the service it
+// points at, if any
+func (g *Gateway) postWebhook(ctx context.Context, url string, payload any) error {
+	if url == "" {
+		return nil
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := g.clientFor(url).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
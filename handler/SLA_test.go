@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSLABreachAndRecoveryWebhooks(t *testing.T) {
+	var failing atomic.Bool
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer blog.Close()
+	events := make(chan slaEvent, 4)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e slaEvent
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer webhook.Close()
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		SLAs:           []SLAConfig{{Prefix: "/api/blog/", Availability: 0.9, MinRequests: 10}},
+		SLAWebhook:     webhook.URL,
+	})
+	h := g.SLAMiddleware(g.ProxyHandler(ServiceBlog))
+	route := g.slaRoute("/api/blog/posts")
+	send := func(n int, fail bool) {
+		failing.Store(fail)
+		for range n {
+			serve(t, h, httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil)
+		}
+	}
+	evaluate := func() (slaEvent, bool) {
+		g.evaluateSLA(context.Background(), route, time.Now())
+		select {
+		case e := <-events:
+			return e, true
+		default:
+			return slaEvent{}, false
+		}
+	}
+
+	send(9, true)
+	if e, ok := evaluate(); ok {
+		t.Fatalf("webhook fired below MinRequests: %+v", e)
+	}
+	send(1, true)
+	if e, ok := evaluate(); !ok || e.Event != "sla_breach" || e.Route != "/api/blog/" || e.Requests != 10 || e.Availability != 0 {
+		t.Fatalf("after 10 failures: webhook %+v (fired %v), want a breach", e, ok)
+	}
+	if e, ok := evaluate(); ok {
+		t.Fatalf("ongoing breach notified again: %+v", e)
+	}
+	send(90, false)
+	if e, ok := evaluate(); !ok || e.Event != "sla_recovered" || e.Requests != 100 || e.Availability != 0.9 {
+		t.Fatalf("after 90 successes: webhook %+v (fired %v), want a recovery", e, ok)
+	}
+}
@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// schemaMaxBody bounds the request bodies buffered for validation
+const schemaMaxBody = 1 << 20
+
+// SchemaRule validates request bodies of Method requests under Prefix against the JSON
+// Schema in File. A subset of the spec is supported: type, enum, required, properties,
+// additionalProperties (boolean), items, min/maxLength, pattern, minimum/maximum and
+// min/maxItems.
+type SchemaRule struct {
+	Method string
+	Prefix string
+	File   string
+}
+
+type schemaRoute struct {
+	method string
+	prefix string
+	schema *jsonSchema
+}
+
+type jsonSchema struct {
+	Type                 any                    `json:"type"` // a type name or a list of them
+	Enum                 []any                  `json:"enum"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+
+	pattern *regexp.Regexp
+}
+
+// loadSchemaRoutes reads and compiles the schema files of rules
+func loadSchemaRoutes(rules []SchemaRule) ([]schemaRoute, error) {
+	routes := make([]schemaRoute, 0, len(rules))
+	for _, rule := range rules {
+		data, err := os.ReadFile(rule.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema: %w", err)
+		}
+		var s jsonSchema
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("invalid schema %s: %w", rule.File, err)
+		}
+		if err := s.compile(); err != nil {
+			return nil, fmt.Errorf("invalid schema %s: %w", rule.File, err)
+		}
+		routes = append(routes, schemaRoute{method: strings.ToUpper(rule.Method), prefix: rule.Prefix, schema: &s})
+	}
+	return routes, nil
+}
+
+func (s *jsonSchema) compile() error {
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = re
+	}
+	for _, p := range s.Properties {
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// SchemaMiddleware rejects request bodies that don't match the schema configured for
+// their route and method with 422 and the list of violations. Unconfigured routes pass.
+func (g *Gateway) SchemaMiddleware(next http.Handler) http.Handler {
+	if len(g.schemaRoutes) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := slices.IndexFunc(g.schemaRoutes, func(sr schemaRoute) bool {
+			return sr.method == r.Method && strings.HasPrefix(r.URL.Path, sr.prefix)
+		})
+		if i < 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, schemaMaxBody+1))
+		if limit, ok := bodyTooLarge(err); ok {
+			writeBodyTooLarge(w, limit)
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "failed to read request body")
+			return
+		}
+		if len(body) > schemaMaxBody {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "request body too large to validate")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var doc any
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			writeProblem(w, http.StatusUnprocessableEntity, "invalid JSON body", map[string]any{"details": []string{err.Error()}})
+			return
+		}
+		if errs := g.schemaRoutes[i].schema.validate("$", doc, nil); len(errs) > 0 {
+			writeProblem(w, http.StatusUnprocessableEntity, "request body failed validation", map[string]any{"details": errs})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validate appends a message for every violation of s by v, located by JSON path
+func (s *jsonSchema) validate(path string, v any, errs []string) []string {
+	if t := jsonType(v); !s.allowsType(t) {
+		return append(errs, fmt.Sprintf("%s: expected %v, got %s", path, s.Type, t))
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return jsonEqual(e, v) }) {
+		errs = append(errs, fmt.Sprintf("%s: value is not one of the allowed values", path))
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			errs = append(errs, fmt.Sprintf("%s: shorter than %d characters", path, *s.MinLength))
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			errs = append(errs, fmt.Sprintf("%s: longer than %d characters", path, *s.MaxLength))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			errs = append(errs, fmt.Sprintf("%s: does not match pattern %s", path, s.Pattern))
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			errs = append(errs, fmt.Sprintf("%s: less than %v", path, *s.Minimum))
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			errs = append(errs, fmt.Sprintf("%s: greater than %v", path, *s.Maximum))
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			errs = append(errs, fmt.Sprintf("%s: fewer than %d items", path, *s.MinItems))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			errs = append(errs, fmt.Sprintf("%s: more than %d items", path, *s.MaxItems))
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				errs = s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, fmt.Sprintf("%s.%s: is required", path, name))
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				errs = prop.validate(path+"."+name, v[name], errs)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				errs = append(errs, fmt.Sprintf("%s.%s: is not allowed", path, name))
+			}
+		}
+	}
+	return errs
+}
+
+func (s *jsonSchema) allowsType(t string) bool {
+	switch want := s.Type.(type) {
+	case nil:
+		return true
+	case string:
+		return typeMatches(want, t)
+	case []any:
+		return slices.ContainsFunc(want, func(w any) bool {
+			name, _ := w.(string)
+			return typeMatches(name, t)
+		})
+	}
+	return false
+}
+
+// typeMatches reports whether a value of JSON type t satisfies the schema type want
+func typeMatches(want, t string) bool {
+	return want == t || (want == "number" && t == "integer")
+}
+
+// jsonType names the JSON Schema type of a decoded value
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// jsonEqual compares a schema enum value (decoded without UseNumber) with a body value
+func jsonEqual(a, b any) bool {
+	if n, ok := b.(json.Number); ok {
+		f, _ := n.Float64()
+		b = f
+	}
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaValidation(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "post.json")
+	if err := os.WriteFile(schema, []byte(`{
+		"type": "object",
+		"required": ["title", "body"],
+		"additionalProperties": false,
+		"properties": {
+			"title": {"type": "string", "minLength": 1, "maxLength": 20},
+			"body": {"type": "string"},
+			"tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "pattern": "^[a-z]+$"}},
+			"rating": {"type": "integer", "minimum": 1, "maximum": 5}
+		}
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var forwarded []string
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = append(forwarded, r.Method+" "+r.URL.Path+" "+string(body))
+	}))
+	t.Cleanup(blog.Close)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		SchemaRules:    []SchemaRule{{Method: "post", Prefix: "/api/blog/posts", File: schema}},
+	})
+	h := g.SchemaMiddleware(g.ProxyHandler(ServiceBlog))
+	send := func(method, path, body string, v any) *httptest.ResponseRecorder {
+		return serve(t, h, httptest.NewRequest(method, path, strings.NewReader(body)), v)
+	}
+
+	valid := `{"title":"Hello","body":"First post","tags":["go"],"rating":5}`
+	if rec := send(http.MethodPost, "/api/blog/posts", valid, nil); rec.Code != http.StatusOK {
+		t.Errorf("valid body: status %d: %s", rec.Code, rec.Body)
+	}
+	if want := []string{"POST /api/blog/posts " + valid}; !reflect.DeepEqual(forwarded, want) {
+		t.Errorf("backend got %q, want the validated body intact", forwarded)
+	}
+
+	var resp struct {
+		Error   string   `json:"error"`
+		Details []string `json:"details"`
+	}
+	rec := send(http.MethodPost, "/api/blog/posts", `{"title":"","tags":["Go","a","b"],"rating":4.5,"draft":true}`, &resp)
+	want := []string{
+		"$.body: is required",
+		"$.draft: is not allowed",
+		"$.rating: expected integer, got number",
+		"$.tags: more than 2 items",
+		"$.tags[0]: does not match pattern ^[a-z]+$",
+		"$.title: shorter than 1 characters",
+	}
+	if rec.Code != http.StatusUnprocessableEntity || resp.Error == "" || !reflect.DeepEqual(resp.Details, want) {
+		t.Errorf("invalid body: status %d, error %q, details %q, want 422 with %q", rec.Code, resp.Error, resp.Details, want)
+	}
+	if rec := send(http.MethodPost, "/api/blog/posts", `{"title":`, &resp); rec.Code != http.StatusUnprocessableEntity || len(resp.Details) != 1 {
+		t.Errorf("malformed JSON: status %d, details %q, want 422 with the parse error", rec.Code, resp.Details)
+	}
+	if len(forwarded) != 1 {
+		t.Errorf("backend got %d requests, want the rejected ones never forwarded", len(forwarded))
+	}
+
+	// Routes and methods without a schema pass as they are
+	for _, tt := range []struct{ method, path string }{
+		{http.MethodPut, "/api/blog/posts/1"},
+		{http.MethodPost, "/api/blog/comments"},
+	} {
+		if rec := send(tt.method, tt.path, `not json`, nil); rec.Code != http.StatusOK {
+			t.Errorf("%s %s without a schema: status %d", tt.method, tt.path, rec.Code)
+		}
+	}
+	if len(forwarded) != 3 {
+		t.Errorf("backend got %d requests, want the unvalidated ones forwarded", len(forwarded))
+	}
+
+	config := &Config{BlogServiceURL: blog.URL, UserServiceURL: blog.URL, AuthServiceURL: blog.URL, AspServiceURL: blog.URL,
+		SchemaRules: []SchemaRule{{Method: "POST", Prefix: "/api/blog/posts", File: filepath.Join(t.TempDir(), "missing.json")}}}
+	if _, err := NewGateway(config, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("NewGateway accepted a missing schema file")
+	}
+}
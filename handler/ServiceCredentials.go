@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Service credentials defaults
+const (
+	defaultServiceCredentialsHeader = "X-Service-Authorization"
+	defaultServiceTokenTTL          = 5 * time.Minute // when the token response has no expires_in
+
+	// serviceTokenEarlyRefresh renews tokens this long before they expire, so one never
+	// lapses in flight
+	serviceTokenEarlyRefresh = 30 * time.Second
+
+	// serviceTokenFetchTimeout bounds a token request, which doesn't end with the request
+	// that started it since others may be waiting on it
+	serviceTokenFetchTimeout = 10 * time.Second
+
+	// serviceTokenFailureBackoff is how long a failed token request is answered from
+	// cache, so a down token endpoint isn't called on every request
+	serviceTokenFailureBackoff = 5 * time.Second
+
+	// serviceTokenMaxBody bounds the token endpoint's response
+	serviceTokenMaxBody = 64 << 10
+)
+
+// ServiceCredentials identify the gateway itself to a service, on its own calls (token
+// validation, JWKS, warm-up, ...) and on proxied requests. The credential goes in Header
+// (default X-Service-Authorization), since Authorization carries the user's token.
+type ServiceCredentials struct {
+	// TokenURL, ClientID and ClientSecret obtain a token with the OAuth2 client credentials
+	// grant, sent as "Bearer <token>" and renewed shortly before it expires
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// StaticKey is sent as is when there's no TokenURL
+	StaticKey string
+
+	Header string
+}
+
+func (c ServiceCredentials) enabled() bool {
+	return c.TokenURL != "" || c.StaticKey != ""
+}
+
+// serviceCredentials holds the current client credentials token of one service
+type serviceCredentials struct {
+	config ServiceCredentials
+	header string
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	err     error     // the last fetch's failure, returned until retryAt
+	retryAt time.Time // when a fetch is tried again after err
+	pending *tokenFetch
+}
+
+// tokenFetch is a token request in flight that callers of value wait on
+type tokenFetch struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+func (g *Gateway) newServiceCredentials(config ServiceCredentials) (*serviceCredentials, error) {
+	if config.TokenURL != "" {
+		if u, err := url.Parse(config.TokenURL); err != nil || !u.IsAbs() {
+			return nil, fmt.Errorf("invalid service token URL %q", config.TokenURL)
+		}
+		if config.ClientID == "" {
+			return nil, errors.New("service token URL requires a client ID")
+		}
+	}
+	header := config.Header
+	if header == "" {
+		header = defaultServiceCredentialsHeader
+	}
+	// Tokens are fetched with the plain client, which never carries service credentials
+	return &serviceCredentials{config: config, header: header, client: g.Client}, nil
+}
+
+// value returns the header value, fetching a new token when the current one is due.
+// Concurrent callers share one fetch, which isn't canceled with the caller's context.
+func (c *serviceCredentials) value(ctx context.Context) (string, error) {
+	if c.config.TokenURL == "" {
+		return c.config.StaticKey, nil
+	}
+	c.mu.Lock()
+	now := time.Now()
+	if c.token != "" && now.Before(c.expires.Add(-serviceTokenEarlyRefresh)) {
+		c.mu.Unlock()
+		return "Bearer " + c.token, nil
+	}
+	if c.err != nil && now.Before(c.retryAt) {
+		err := c.err
+		c.mu.Unlock()
+		return "", err
+	}
+	f := c.pending
+	if f == nil {
+		f = &tokenFetch{done: make(chan struct{})}
+		c.pending = f
+		go c.refresh(context.WithoutCancel(ctx), f)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// refresh runs f and records its token, or its failure for serviceTokenFailureBackoff
+func (c *serviceCredentials) refresh(ctx context.Context, f *tokenFetch) {
+	ctx, cancel := context.WithTimeout(ctx, serviceTokenFetchTimeout)
+	defer cancel()
+	token, ttl, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	c.pending = nil
+	if err != nil {
+		c.err, c.retryAt = err, time.Now().Add(serviceTokenFailureBackoff)
+		f.err = err
+	} else {
+		c.token, c.expires, c.err = token, time.Now().Add(ttl), nil
+		f.value = "Bearer " + token
+	}
+	c.mu.Unlock()
+	close(f.done)
+}
+
+// fetch requests a token with the client credentials grant
+func (c *serviceCredentials) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.config.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create service token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to fetch service token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, serviceTokenMaxBody)).Decode(&body); err != nil {
+		return "", 0, fmt.Errorf("token endpoint returned status %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", 0, fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, body.Error)
+	}
+	ttl := defaultServiceTokenTTL
+	if body.ExpiresIn > 0 {
+		ttl = time.Duration(body.ExpiresIn) * time.Second
+	}
+	return body.AccessToken, ttl, nil
+}
+
+// credentialsTransport adds a service's credentials to every request sent through it,
+// replacing any the client sent in the same header
+type credentialsTransport struct {
+	creds *serviceCredentials
+	next  http.RoundTripper
+}
+
+func (t *credentialsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	value, err := t.creds.value(r.Context())
+	if err != nil {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set(t.creds.header, value)
+	return t.next.RoundTrip(r)
+}
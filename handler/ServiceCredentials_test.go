@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServiceCredentials(t *testing.T) {
+	var issued atomic.Int64
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "gateway" || secret != "s3cr3t" || r.PostFormValue("grant_type") != "client_credentials" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+			return
+		}
+		issued.Add(1)
+		writeJSON(w, http.StatusOK, map[string]any{"access_token": "service-token", "expires_in": 300})
+	}))
+	defer idp.Close()
+	// AuthService only validates tokens for the gateway itself
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Service-Authorization") != "Bearer service-token" {
+			writeJSON(w, http.StatusForbidden, AuthValidateResponse{Error: "unknown caller"})
+			return
+		}
+		writeJSON(w, http.StatusOK, testUser{UserID: "alice", Role: "user"})
+	}))
+	defer auth.Close()
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		AuthServiceURL: auth.URL,
+		BlogServiceURL: blog.URL,
+		Services: map[string]ServiceConfig{
+			ServiceAuth: {Credentials: ServiceCredentials{TokenURL: idp.URL, ClientID: "gateway", ClientSecret: "s3cr3t"}},
+			ServiceBlog: {Credentials: ServiceCredentials{StaticKey: "blog-key", Header: "X-Service-Key"}},
+		},
+	})
+	h := g.AuthMiddleware(g.ProxyHandler(ServiceBlog))
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		req.Header.Set("X-Service-Key", "forged")
+		var resp testUpstreamResponse
+		if rec := serve(t, h, req, &resp); rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if resp.UserID != "alice" || resp.Header.Get("X-Service-Key") != "blog-key" {
+			t.Errorf("blog got user %q with service key %q, want alice with blog-key", resp.UserID, resp.Header.Get("X-Service-Key"))
+		}
+	}
+	if n := issued.Load(); n != 1 {
+		t.Errorf("%d service tokens issued, want 1 reused for both validations", n)
+	}
+
+	config := &Config{Services: map[string]ServiceConfig{ServiceAuth: {Credentials: ServiceCredentials{TokenURL: idp.URL}}}}
+	for _, u := range []*string{&config.AuthServiceURL, &config.BlogServiceURL, &config.UserServiceURL, &config.AspServiceURL} {
+		*u = auth.URL
+	}
+	if _, err := NewGateway(config, g.Logger); err == nil {
+		t.Error("NewGateway accepted a service token URL without a client ID")
+	}
+}
+
+func TestServiceCredentialsShareOneFetch(t *testing.T) {
+	var calls atomic.Int64
+	release := make(chan struct{})
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "temporarily_unavailable"})
+	}))
+	defer idp.Close()
+	g := newTestGateway(t, &Config{})
+	creds, err := g.newServiceCredentials(ServiceCredentials{TokenURL: idp.URL, ClientID: "gateway"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first caller going away doesn't cancel the fetch the others wait on
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := creds.value(ctx)
+		first <- err
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := creds.value(context.Background())
+			errs <- err
+		}()
+	}
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("canceled caller got %v, want context.Canceled", err)
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err == nil {
+			t.Error("waiter got a token from a failed fetch")
+		}
+	}
+
+	// The failure is remembered for a while rather than retried on every call
+	if _, err := creds.value(context.Background()); err == nil {
+		t.Error("value succeeded right after a failed fetch")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d token requests, want 1 shared by every caller", n)
+	}
+}
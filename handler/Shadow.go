@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	shadowTimeout     = 10 * time.Second
+	shadowMaxInFlight = 100
+	shadowMaxBody     = 1 << 20
+)
+
+// shadow mirrors sampled requests of a service to a second backend, e.g. a new
+// implementation under migration. Its responses are only compared, never returned.
+type shadow struct {
+	target  *instance
+	percent float64
+	client  *http.Client
+	slots   chan struct{} // bounds concurrent shadow calls; requests over it aren't mirrored
+}
+
+func (g *Gateway) newShadow(svc ServiceConfig) (*shadow, error) {
+	targets, err := parseTargets(svc.ShadowURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shadow URL: %w", err)
+	}
+	transport, err := g.newTransport(svc)
+	if err != nil {
+		return nil, err
+	}
+	return &shadow{
+		target:  newInstance(targets[0]),
+		percent: svc.ShadowPercent,
+		client:  &http.Client{Transport: transport, Timeout: shadowTimeout},
+		slots:   make(chan struct{}, shadowMaxInFlight),
+	}, nil
+}
+
+type shadowResult struct {
+	status  int
+	latency time.Duration
+	err     error
+}
+
+// shadowHandler sends a sampled share of idempotent requests to the shadow backend in the
+// background and logs how its status and latency compare with the primary's. The client
+// always gets the primary response, and the primary never waits for the shadow.
+func (g *Gateway) shadowHandler(up *upstream, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sh := up.shadow
+		if !isIdempotent(r.Method) || rand.Float64()*100 >= sh.percent {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case sh.slots <- struct{}{}:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		out, err := g.shadowRequest(up, r)
+		if err != nil {
+			<-sh.slots
+			g.Logger.WarnContext(r.Context(), "Shadow request skipped", "method", r.Method, "path", r.URL.Path, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		primary := make(chan shadowResult, 1)
+		go func() {
+			defer func() { <-sh.slots }()
+			start := time.Now()
+			res := shadowResult{}
+			resp, err := sh.client.Do(out)
+			res.latency = time.Since(start)
+			if err != nil {
+				res.err = err
+			} else {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				res.status = resp.StatusCode
+			}
+			p := <-primary
+			switch {
+			case res.err != nil:
+				g.Logger.WarnContext(r.Context(), "Shadow request failed", "method", out.Method, "path", r.URL.Path, "error", res.err, "primary_status", p.status, "primary_latency", p.latency)
+			case res.status != p.status:
+				g.Logger.WarnContext(r.Context(), "Shadow status mismatch", "method", out.Method, "path", r.URL.Path, "primary_status", p.status, "primary_latency", p.latency, "shadow_status", res.status, "shadow_latency", res.latency)
+			default:
+				g.Logger.InfoContext(r.Context(), "Shadow request matched", "method", out.Method, "path", r.URL.Path, "status", res.status, "primary_latency", p.latency, "shadow_latency", res.latency)
+			}
+		}()
+
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+		primary <- shadowResult{status: rec.status, latency: time.Since(start)}
+	})
+}
+
+// shadowRequest clones r for the shadow backend. The body is buffered and handed to
+// both requests; the clone's context isn't canceled with the client's request.
+func (g *Gateway) shadowRequest(up *upstream, r *http.Request) (*http.Request, error) {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, shadowMaxBody+1))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > shadowMaxBody {
+			return nil, fmt.Errorf("body larger than %d bytes", shadowMaxBody)
+		}
+	}
+
+	target, err := g.upstreamURL(up, up.shadow.target, r.URL.RequestURI())
+	if err != nil {
+		return nil, err
+	}
+	out, err := http.NewRequestWithContext(context.WithoutCancel(r.Context()), r.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	out.Header = r.Header.Clone()
+	out.Header.Set("X-Gateway-Shadow", "true")
+	out.Host, out.RemoteAddr = r.Host, r.RemoteAddr
+	g.setForwardedHeaders(out)
+	return out, nil
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writes and reads
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestShadowMirrorsRequestsWithoutDelayingThePrimary(t *testing.T) {
+	mirrored, release := make(chan *http.Request, 4), make(chan struct{})
+	shadowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored <- r.Clone(r.Context())
+		<-release
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}))
+	t.Cleanup(shadowSrv.Close)
+	t.Cleanup(func() { close(release) })
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		Services: map[string]ServiceConfig{ServiceBlog: {
+			ShadowURL:     shadowSrv.URL,
+			ShadowPercent: 100,
+		}},
+	})
+	// The shadow logs from its own goroutine
+	var logs lockedBuffer
+	g.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/blog/posts?page=2", nil)
+	req.Header.Set("X-Client", "web")
+	start := time.Now()
+	var got testUpstreamResponse
+	rec := serve(t, g.ProxyHandler(ServiceBlog), req, &got)
+	if rec.Code != http.StatusOK || got.Path != "/api/blog/posts" || got.Query != "page=2" {
+		t.Errorf("client got status %d from %s?%s, want the primary's 200", rec.Code, got.Path, got.Query)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("primary took %s while the shadow was stuck", elapsed)
+	}
+
+	select {
+	case sr := <-mirrored:
+		if sr.Method != http.MethodGet || sr.URL.Path != "/api/blog/posts" || sr.URL.RawQuery != "page=2" {
+			t.Errorf("shadow got %s %s", sr.Method, sr.URL)
+		}
+		if sr.Header.Get("X-Client") != "web" || sr.Header.Get("X-Gateway-Shadow") != "true" {
+			t.Errorf("shadow headers: %v", sr.Header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shadow never got the mirrored request")
+	}
+	release <- struct{}{}
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "Shadow status mismatch") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(logs.String(), "Shadow status mismatch") {
+		t.Errorf("shadow's 501 against the primary's 200 not logged: %s", logs.String())
+	}
+
+	// Writes are never mirrored
+	req = httptest.NewRequest(http.MethodPost, "/api/blog/posts", strings.NewReader(`{"title":"hi"}`))
+	if rec := serve(t, g.ProxyHandler(ServiceBlog), req, &got); rec.Code != http.StatusOK || got.Method != http.MethodPost {
+		t.Errorf("POST: status %d, primary got %s", rec.Code, got.Method)
+	}
+	select {
+	case sr := <-mirrored:
+		t.Errorf("shadow got a mirrored %s", sr.Method)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// BeginShutdown marks the gateway as draining: /readyz answers 503 from then on so load
+// balancers stop sending new traffic while in-flight requests finish
+func (g *Gateway) BeginShutdown() {
+	g.shuttingDown.Store(true)
+}
+
+// Close flushes buffered telemetry and closes the log files once the server has stopped:
+// spans still queued are exported, and the access and audit logs are synced and closed
+func (g *Gateway) Close(ctx context.Context) error {
+	var firstErr error
+	if g.tracer != nil {
+		if err := g.tracer.drain(ctx); err != nil {
+			firstErr = err
+		}
+	}
+	if g.accessLog != nil {
+		if err := closeLogWriter(g.accessLog.Writer()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if g.auditLog != nil {
+		g.auditLog.mu.Lock()
+		err := closeLogWriter(g.auditLog.out)
+		g.auditLog.mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// closeLogWriter syncs a log file, closing it unless it is stdout or stderr
+func closeLogWriter(w io.Writer) error {
+	f, ok := w.(*os.File)
+	if !ok {
+		return nil
+	}
+	if f == os.Stdout || f == os.Stderr {
+		f.Sync()
+		return nil
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
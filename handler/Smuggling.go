@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SmugglingGuard rejects requests with ambiguous message framing that front-end and
+// back-end servers might interpret differently (request smuggling): any Transfer-Encoding
+// other than a single "chunked", repeated or obfuscated Transfer-Encoding headers, and
+// Transfer-Encoding combined with Content-Length. net/http already refuses most of these
+// on its own; this is defense in depth for whatever gets through.
+func (g *Gateway) SmugglingGuard(next http.Handler) http.Handler {
+	if !g.Config.SmugglingProtection {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := ambiguousFraming(r); reason != "" {
+			g.Logger.WarnContext(r.Context(), "Potential request smuggling", "remote", g.clientAddr(r), "method", r.Method, "path", r.URL.Path, "reason", reason)
+			g.audit(r, AuditRecord{Event: "smuggling_attempt", Status: http.StatusBadRequest, Detail: reason})
+			writeJSONError(w, http.StatusBadRequest, "malformed request framing")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ambiguousFraming returns why the request's framing is ambiguous, or "" if it isn't
+func ambiguousFraming(r *http.Request) string {
+	if len(r.TransferEncoding) > 1 {
+		return "multiple transfer codings"
+	}
+	if len(r.TransferEncoding) == 1 && r.TransferEncoding[0] != "chunked" {
+		return "unsupported transfer coding " + r.TransferEncoding[0]
+	}
+
+	// The server normally consumes this header; anything left over is suspicious
+	if te, ok := r.Header["Transfer-Encoding"]; ok {
+		if len(te) != 1 || te[0] != "chunked" {
+			return "obfuscated Transfer-Encoding " + strings.Join(te, " | ")
+		}
+	}
+
+	if len(r.TransferEncoding) > 0 && len(r.Header.Values("Content-Length")) > 0 {
+		return "both Transfer-Encoding and Content-Length"
+	}
+	if cl := r.Header.Values("Content-Length"); len(cl) > 1 {
+		for _, v := range cl[1:] {
+			if v != cl[0] {
+				return "conflicting Content-Length values"
+			}
+		}
+	}
+	return ""
+}
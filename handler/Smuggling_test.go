@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSmugglingGuard(t *testing.T) {
+	g := newTestGateway(t, &Config{SmugglingProtection: true})
+	var audit bytes.Buffer
+	g.auditLog = &auditLogger{out: &audit}
+	h := g.SmugglingGuard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, tt := range []struct {
+		name             string
+		transferEncoding []string
+		header           http.Header
+		want             int
+	}{
+		{"plain", nil, nil, http.StatusNoContent},
+		{"chunked", []string{"chunked"}, nil, http.StatusNoContent},
+		{"matching Content-Lengths", nil, http.Header{"Content-Length": {"4", "4"}}, http.StatusNoContent},
+		{"stacked codings", []string{"chunked", "x"}, nil, http.StatusBadRequest},
+		{"unsupported coding", []string{"gzip"}, nil, http.StatusBadRequest},
+		{"obfuscated header", []string{"chunked"}, http.Header{"Transfer-Encoding": {"chunked", "x"}}, http.StatusBadRequest},
+		{"Transfer-Encoding with Content-Length", []string{"chunked"}, http.Header{"Content-Length": {"4"}}, http.StatusBadRequest},
+		{"conflicting Content-Lengths", nil, http.Header{"Content-Length": {"4", "40"}}, http.StatusBadRequest},
+	} {
+		audit.Reset()
+		req := httptest.NewRequest(http.MethodPost, "/api/blog/posts", nil)
+		req.TransferEncoding = tt.transferEncoding
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		rec := serve(t, h, req, nil)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+			continue
+		}
+		var record AuditRecord
+		json.Unmarshal(audit.Bytes(), &record)
+		if rejected := tt.want == http.StatusBadRequest; rejected != (record.Event == "smuggling_attempt") {
+			t.Errorf("%s: audit log %q", tt.name, audit.String())
+		}
+	}
+
+	g.Config.SmugglingProtection = false
+	req := httptest.NewRequest(http.MethodPost, "/api/blog/posts", nil)
+	req.TransferEncoding = []string{"gzip"}
+	if rec := serve(t, g.SmugglingGuard(http.NotFoundHandler()), req, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("with protection off: status %d, want the request passed through", rec.Code)
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStaleAuthMaxAge = 5 * time.Minute
+	staleAuthMaxEntries    = 10000
+)
+
+// staleAuthCache remembers the last successful validation of each token so it can stand
+// in while AuthService is unreachable. Tokens are stored hashed.
+type staleAuthCache struct {
+	mu      sync.Mutex
+	maxAge  time.Duration
+	entries map[[sha256.Size]byte]staleAuthEntry
+}
+
+type staleAuthEntry struct {
+	identity    *AuthValidateResponse
+	validatedAt time.Time
+}
+
+func newStaleAuthCache(maxAge time.Duration) *staleAuthCache {
+	if maxAge <= 0 {
+		maxAge = defaultStaleAuthMaxAge
+	}
+	return &staleAuthCache{maxAge: maxAge, entries: make(map[[sha256.Size]byte]staleAuthEntry)}
+}
+
+func (c *staleAuthCache) store(token string, identity *AuthValidateResponse) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= staleAuthMaxEntries {
+		for k, e := range c.entries {
+			if now.Sub(e.validatedAt) > c.maxAge {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= staleAuthMaxEntries {
+			for k := range c.entries {
+				delete(c.entries, k)
+				break
+			}
+		}
+	}
+	c.entries[sha256.Sum256([]byte(token))] = staleAuthEntry{identity: identity, validatedAt: now}
+}
+
+// lookup returns the last validation of token if it is younger than the max stale age
+// and the token itself hasn't expired since
+func (c *staleAuthCache) lookup(token string) (*AuthValidateResponse, time.Duration, bool) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[sha256.Sum256([]byte(token))]
+	c.mu.Unlock()
+	if !ok {
+		return nil, 0, false
+	}
+	age := now.Sub(e.validatedAt)
+	if age > c.maxAge {
+		return nil, 0, false
+	}
+	if e.identity.ExpiresAt != 0 && !now.Before(time.Unix(e.identity.ExpiresAt, 0)) {
+		return nil, 0, false
+	}
+	return e.identity, age, true
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaleAuthDuringAuthServiceOutages(t *testing.T) {
+	var down atomic.Bool
+	users := testAuthService(t, map[string]testUser{"alice-token": {UserID: "1", Role: "author"}})
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		users.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(auth.Close)
+	blog := newTestUpstream(t)
+	get := func(g *Gateway, token string) (*httptest.ResponseRecorder, testUpstreamResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := serve(t, g.AuthMiddleware(g.ProxyHandler(ServiceBlog)), req, nil)
+		var got testUpstreamResponse
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+			}
+		}
+		return rec, got
+	}
+
+	for _, tt := range []struct {
+		name   string
+		config Config
+		want   int
+	}{
+		{"opted out", Config{}, http.StatusServiceUnavailable},
+		{"opted in", Config{AllowStaleAuthOnOutage: true}, http.StatusOK},
+	} {
+		down.Store(false)
+		tt.config.AuthServiceURL, tt.config.BlogServiceURL = auth.URL, blog.URL
+		g := newTestGateway(t, &tt.config)
+		logs := captureLog(g)
+		if rec, _ := get(g, "alice-token"); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d before the outage", tt.name, rec.Code)
+		}
+
+		down.Store(true)
+		rec, got := get(g, "alice-token")
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d during the outage, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusOK {
+			if got.UserID != "1" {
+				t.Errorf("%s: stale identity forwarded user %q, want 1", tt.name, got.UserID)
+			}
+			if !strings.Contains(logs.String(), "using stale result") {
+				t.Errorf("%s: stale result not logged: %s", tt.name, logs)
+			}
+			if rec, _ := get(g, "bob-token"); rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("%s: never-validated token got %d during the outage, want 503", tt.name, rec.Code)
+			}
+		}
+	}
+
+	// An expired token stays expired, however recently it was validated
+	down.Store(true)
+	g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, BlogServiceURL: blog.URL, AllowStaleAuthOnOutage: true})
+	g.staleAuth.store("carol-token", &AuthValidateResponse{UserID: "3", ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if rec, _ := get(g, "carol-token"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expired token got %d during the outage, want 503", rec.Code)
+	}
+
+	// Results older than the max stale age are not used
+	down.Store(false)
+	g = newTestGateway(t, &Config{AuthServiceURL: auth.URL, BlogServiceURL: blog.URL, AllowStaleAuthOnOutage: true, StaleAuthMaxAge: 20 * time.Millisecond})
+	if rec, _ := get(g, "alice-token"); rec.Code != http.StatusOK {
+		t.Fatalf("status %d before the outage", rec.Code)
+	}
+	down.Store(true)
+	time.Sleep(50 * time.Millisecond)
+	if rec, _ := get(g, "alice-token"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("result past the max stale age got %d, want 503", rec.Code)
+	}
+}
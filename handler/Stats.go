@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// requestStats counts proxied requests; all fields are updated atomically
+type requestStats struct {
+	requests atomic.Int64
+	errors   atomic.Int64 // responses with a 5xx status
+	inFlight atomic.Int64
+}
+
+type serviceStats struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+	InFlight int64 `json:"inFlight"`
+	// Draining is set while the service is drained; Drained once nothing is in flight
+	Draining bool `json:"draining,omitempty"`
+	Drained  bool `json:"drained,omitempty"`
+	// Circuit is the circuit breaker state when a breaker is configured
+	Circuit string `json:"circuit,omitempty"`
+}
+
+type statsResponse struct {
+	Uptime   string                  `json:"uptime"`
+	Requests int64                   `json:"requests"`
+	InFlight int64                   `json:"inFlight"`
+	Services map[string]serviceStats `json:"services"`
+	// TokenCache is reported when the token validation cache is enabled
+	TokenCache  *tokenCacheStats `json:"tokenCache,omitempty"`
+	Revocations *revocationStats `json:"revocations"`
+}
+
+// statsHandler counts requests to a service in the gateway and service counters
+func (g *Gateway) statsHandler(up *upstream, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.requests.Add(1)
+		up.stats.requests.Add(1)
+		g.inFlight.Add(1)
+		up.stats.inFlight.Add(1)
+
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		// A response the proxy aborted midway (it panics with http.ErrAbortHandler) is
+		// recorded as a 502, whatever status it started with
+		defer func() {
+			p := recover()
+			g.inFlight.Add(-1)
+			up.stats.inFlight.Add(-1)
+			status := rec.status
+			if p != nil {
+				status = http.StatusBadGateway
+			}
+			g.recordRequest(r, up, status, time.Since(start))
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// recordRequest adds a completed request to the stats, metrics and load shedder, and logs it
+func (g *Gateway) recordRequest(r *http.Request, up *upstream, status int, elapsed time.Duration) {
+	if !g.longLived(r) {
+		g.shedder.observe(elapsed) // a stream's lifetime says nothing about load
+	}
+	g.metrics.observeRequest(up.name, r.Method, status, elapsed)
+	if status >= 500 {
+		up.stats.errors.Add(1)
+	}
+
+	level := slog.LevelInfo
+	if status >= 500 {
+		level = slog.LevelWarn
+	}
+	var userID string
+	if id := identityFrom(r); id != nil {
+		userID = id.UserID
+	}
+	g.Logger.Log(r.Context(), level, "Request completed",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+		"latency_ms", elapsed.Milliseconds(),
+		"upstream", up.name,
+		"user_id", userID,
+	)
+}
+
+// StatsHandler reports live request counters (GET /admin/stats)
+func (g *Gateway) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	upstreams := g.upstreams()
+	resp := statsResponse{
+		Uptime:      time.Since(g.started).Truncate(time.Second).String(),
+		Requests:    g.requests.Load(),
+		InFlight:    g.inFlight.Load(),
+		Services:    make(map[string]serviceStats, len(upstreams)),
+		Revocations: g.revocations.stats(),
+	}
+	for name, up := range upstreams {
+		s := serviceStats{
+			Requests: up.stats.requests.Load(),
+			Errors:   up.stats.errors.Load(),
+			InFlight: up.stats.inFlight.Load(),
+			Draining: up.draining.Load() != nil,
+		}
+		s.Drained = s.Draining && s.InFlight == 0
+		if up.breaker != nil {
+			s.Circuit = up.breaker.current()
+		}
+		resp.Services[name] = s
+	}
+	if g.tokenCache != nil {
+		resp.TokenCache = g.tokenCache.stats()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
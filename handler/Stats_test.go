@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatsCountRequestsPerService(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/blog/slow" {
+			entered <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(blog.Close)
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(users.Close)
+	g := newTestGateway(t, &Config{BlogServiceURL: blog.URL, UserServiceURL: users.URL})
+	stats := func() statsResponse {
+		var resp statsResponse
+		serve(t, http.HandlerFunc(g.StatsHandler), httptest.NewRequest(http.MethodGet, "/admin/stats", nil), &resp)
+		return resp
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.ProxyHandler(ServiceBlog).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/blog/slow", nil))
+	}()
+	<-entered
+	if s := stats(); s.InFlight != 1 || s.Services[ServiceBlog].InFlight != 1 || s.Services[ServiceUser].InFlight != 0 {
+		t.Errorf("during a slow blog request: in flight %d, per service %+v", s.InFlight, s.Services)
+	}
+	close(release)
+	<-done
+
+	serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil)
+	serve(t, g.ProxyHandler(ServiceUser), httptest.NewRequest(http.MethodGet, "/api/user/profile", nil), nil)
+
+	s := stats()
+	if s.Requests != 3 || s.InFlight != 0 || s.Uptime == "" {
+		t.Errorf("gateway stats: %d requests, %d in flight, uptime %q", s.Requests, s.InFlight, s.Uptime)
+	}
+	if blog := s.Services[ServiceBlog]; blog.Requests != 2 || blog.Errors != 0 || blog.InFlight != 0 {
+		t.Errorf("blog stats: %+v", blog)
+	}
+	if user := s.Services[ServiceUser]; user.Requests != 1 || user.Errors != 1 || user.InFlight != 0 {
+		t.Errorf("user stats: %+v", user)
+	}
+}
+
+func TestStatsCountAbortedResponsesAsErrors(t *testing.T) {
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cutOffResponse(w)
+	}))
+	t.Cleanup(blog.Close)
+	g := newTestGateway(t, &Config{BlogServiceURL: blog.URL})
+	if !serveAborting(g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)) {
+		t.Fatal("response cut off by the service wasn't aborted")
+	}
+
+	var s statsResponse
+	serve(t, http.HandlerFunc(g.StatsHandler), httptest.NewRequest(http.MethodGet, "/admin/stats", nil), &s)
+	if blog := s.Services[ServiceBlog]; blog.Requests != 1 || blog.Errors != 1 || blog.InFlight != 0 {
+		t.Errorf("blog stats after an aborted response: %+v, want it counted as an error", blog)
+	}
+}
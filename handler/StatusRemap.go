@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// statusRemapPeekBytes bounds how much of a response body is inspected for BodyContains
+const statusRemapPeekBytes = 64 << 10
+
+// StatusRemap rewrites an upstream status code, optionally only when the response body
+// contains a marker (e.g. `"type":"validation"`)
+type StatusRemap struct {
+	From         int
+	To           int
+	BodyContains string
+}
+
+// remapStatus applies the first matching remap to resp. Bodies are only read when a rule
+// needs them; the bytes read are put back in front of the rest of the body.
+func remapStatus(resp *http.Response, remaps []StatusRemap) error {
+	var peeked []byte
+	read := false
+	for _, rm := range remaps {
+		if rm.From != resp.StatusCode {
+			continue
+		}
+		if rm.BodyContains != "" {
+			if !read {
+				var err error
+				peeked, err = peekBody(resp)
+				if err != nil {
+					return err
+				}
+				read = true
+			}
+			if !bytes.Contains(peeked, []byte(rm.BodyContains)) {
+				continue
+			}
+		}
+		resp.StatusCode = rm.To
+		resp.Status = strconv.Itoa(rm.To) + " " + http.StatusText(rm.To)
+		return nil
+	}
+	return nil
+}
+
+// peekBody reads up to statusRemapPeekBytes of the body and restores it so the
+// full body is still sent to the client
+func peekBody(resp *http.Response) ([]byte, error) {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return nil, nil
+	}
+	peeked, err := io.ReadAll(io.LimitReader(resp.Body, statusRemapPeekBytes))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peeked), resp.Body), resp.Body}
+	return peeked, nil
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStatusRemapsAndGatewayVersion(t *testing.T) {
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.WriteHeader(status)
+		w.Write([]byte(r.URL.Query().Get("body")))
+	}))
+	defer blog.Close()
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		GatewayVersion: "1.4.2",
+		Services: map[string]ServiceConfig{ServiceBlog: {StatusRemaps: []StatusRemap{
+			{From: 500, To: 400, BodyContains: `"type":"validation"`},
+			{From: 503, To: 502},
+		}}},
+	})
+
+	for _, tt := range []struct {
+		status int
+		body   string
+		want   int
+	}{
+		{500, `{"type":"validation","field":"title"}`, 400},
+		{500, `{"type":"crash"}`, 500},
+		{503, "", 502},
+		{201, `{"type":"validation"}`, 201},
+	} {
+		target := "/api/blog/posts?" + url.Values{"status": {strconv.Itoa(tt.status)}, "body": {tt.body}}.Encode()
+		rec := serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodPost, target, strings.NewReader("{}")), nil)
+		if rec.Code != tt.want || rec.Body.String() != tt.body {
+			t.Errorf("upstream %d %q: client got %d %q, want %d with the body intact", tt.status, tt.body, rec.Code, rec.Body, tt.want)
+		}
+		if v := rec.Header().Get("X-Gateway-Version"); v != "1.4.2" {
+			t.Errorf("upstream %d: X-Gateway-Version %q", tt.status, v)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"mime"
+	"net/http"
+	"time"
+)
+
+// streamWriter flushes every write and lifts the server's read/write deadlines once a
+// response turns out to be a stream, so long-lived SSE connections aren't cut off by
+// the server timeouts
+type streamWriter struct {
+	http.ResponseWriter
+	rc        *http.ResponseController
+	streaming bool
+}
+
+func (s *streamWriter) start() {
+	if s.streaming {
+		return
+	}
+	s.streaming = true
+	s.rc.SetWriteDeadline(time.Time{})
+	s.rc.SetReadDeadline(time.Time{})
+}
+
+func (s *streamWriter) WriteHeader(code int) {
+	if isEventStream(s.Header()) {
+		s.start()
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *streamWriter) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	if s.streaming && err == nil {
+		err = s.rc.Flush()
+	}
+	return n, err
+}
+
+func (s *streamWriter) Flush() {
+	s.rc.Flush()
+}
+
+func (s *streamWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// streamHandler enables streaming for text/event-stream responses and for requests
+// under Config.StreamRoutes. Client disconnects cancel the request context, which the
+// reverse proxy propagates to the upstream call.
+func (g *Gateway) streamHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &streamWriter{ResponseWriter: w, rc: http.NewResponseController(w)}
+		if g.streamRoute(r.URL.Path) {
+			sw.start()
+		}
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// longLived reports whether r is expected to hold its connection open: a request under
+// Config.StreamRoutes or a WebSocket upgrade. Such requests are exempt from deadlines, and
+// their duration is kept out of latency-based load shedding and SLAs.
+func (g *Gateway) longLived(r *http.Request) bool {
+	return g.streamRoute(r.URL.Path) || isWebSocketUpgrade(r)
+}
+
+// isEventStream reports whether the header declares a Server-Sent Events body
+func isEventStream(h http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStreamsOutliveTheWriteTimeout(t *testing.T) {
+	next := make(chan struct{})
+	cancelled := make(chan struct{})
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for i := 0; ; i++ {
+			select {
+			case <-next:
+			case <-r.Context().Done():
+				close(cancelled)
+				return
+			}
+			fmt.Fprintf(w, "data: comment %d\n\n", i)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer blog.Close()
+	g := newTestGateway(t, &Config{BlogServiceURL: blog.URL})
+	gw := httptest.NewUnstartedServer(g.ProxyHandler(ServiceBlog))
+	gw.Config.WriteTimeout = 200 * time.Millisecond
+	gw.Start()
+	defer gw.Close()
+
+	resp, err := http.Get(gw.URL + "/api/blog/comments/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := bufio.NewReader(resp.Body)
+	for i := range 3 {
+		// Each event is only sent once the previous one arrived, well past the write timeout
+		time.Sleep(150 * time.Millisecond)
+		next <- struct{}{}
+		line, err := events.ReadString('\n')
+		if err != nil || strings.TrimSpace(line) != fmt.Sprintf("data: comment %d", i) {
+			t.Fatalf("event %d: read %q, %v", i, line, err)
+		}
+		events.ReadString('\n')
+	}
+
+	resp.Body.Close()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing the client connection did not cancel the upstream request")
+	}
+}
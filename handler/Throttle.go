@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	minThrottleAllowed  = 0.05
+	throttleRecoverStep = 0.05
+
+	// throttleRecoveryTime is the time constant of the recovery toward forwarding
+	// everything: without further signals, the throttled share shrinks by about two
+	// thirds every 10s, so a service with little traffic isn't throttled for good
+	throttleRecoveryTime = 10 * time.Second
+)
+
+// adaptiveThrottle forwards a fraction of requests that is halved on every
+// overload signal from the backend and recovers on healthy responses and over time
+type adaptiveThrottle struct {
+	mu      sync.Mutex
+	allowed float64
+	updated time.Time // when allowed was last brought up to date
+}
+
+func newAdaptiveThrottle() *adaptiveThrottle {
+	return &adaptiveThrottle{allowed: 1, updated: time.Now()}
+}
+
+// recover moves allowed toward 1 for the time passed since the last update
+func (t *adaptiveThrottle) recover() {
+	now := time.Now()
+	if elapsed := now.Sub(t.updated); elapsed > 0 && t.allowed < 1 {
+		t.allowed = 1 - (1-t.allowed)*math.Exp(-elapsed.Seconds()/throttleRecoveryTime.Seconds())
+	}
+	t.updated = now
+}
+
+// Allow reports whether the next request may be forwarded
+func (t *adaptiveThrottle) Allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.recover()
+	return t.allowed >= 1 || rand.Float64() < t.allowed
+}
+
+// Signal records an overload signal and returns the new allowed fraction
+func (t *adaptiveThrottle) Signal() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.recover()
+	t.allowed = max(t.allowed/2, minThrottleAllowed)
+	return t.allowed
+}
+
+// Success records a healthy response
+func (t *adaptiveThrottle) Success() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.recover()
+	t.allowed = min(t.allowed+throttleRecoverStep, 1)
+}
+
+// throttleHandler rejects the share of requests the service's throttle doesn't admit
+func (g *Gateway) throttleHandler(up *upstream, svc ServiceConfig, next http.Handler) http.Handler {
+	status := svc.ThrottleStatus
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.throttle.Allow() {
+			g.Logger.WarnContext(r.Context(), "Throttled request", "method", r.Method, "path", r.URL.Path, "service", up.name)
+			w.Header().Set("Retry-After", retryAfter(svc.ThrottleRetryAfter))
+			writeJSONError(w, status, "service overloaded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// overloaded reports whether the response carries the service's overload signal. On a
+// 503 any positive value counts, below OverloadThreshold too.
+func (s ServiceConfig) overloaded(resp *http.Response) bool {
+	v := resp.Header.Get(s.OverloadHeader)
+	if v == "" {
+		return false
+	}
+	if s.OverloadThreshold > 0 {
+		n, err := strconv.Atoi(v)
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			return err == nil && n > 0
+		}
+		return err == nil && n >= s.OverloadThreshold
+	}
+	b, err := strconv.ParseBool(v)
+	return err == nil && b
+}
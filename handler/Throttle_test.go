@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThrottleBacksOffAnOverloadedService(t *testing.T) {
+	blog := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Overloaded", "1")
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "busy"})
+	}))
+	defer blog.Close()
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		Services: map[string]ServiceConfig{ServiceBlog: {
+			OverloadHeader:     "X-Overloaded",
+			ThrottleStatus:     http.StatusTooManyRequests,
+			ThrottleRetryAfter: 5 * time.Second,
+		}},
+	})
+	h := g.ProxyHandler(ServiceBlog)
+
+	// Every overload signal halves the share forwarded, down to 5%
+	throttled := 0
+	for range 30 {
+		var resp map[string]any
+		rec := serve(t, h, httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), &resp)
+		if rec.Code != http.StatusTooManyRequests {
+			continue
+		}
+		throttled++
+		if resp["error"] != "service overloaded" || rec.Header().Get("Retry-After") != "5" {
+			t.Fatalf("throttled response %v, Retry-After %q", resp, rec.Header().Get("Retry-After"))
+		}
+	}
+	if throttled < 10 {
+		t.Errorf("%d of 30 requests throttled, want most", throttled)
+	}
+}
+
+func TestThrottleRecoversOverTime(t *testing.T) {
+	throttle := newAdaptiveThrottle()
+	for range 10 {
+		throttle.Signal()
+	}
+	if throttle.allowed != minThrottleAllowed {
+		t.Fatalf("allowed %v after repeated signals, want the %v floor", throttle.allowed, minThrottleAllowed)
+	}
+	// Without traffic, a minute without signals brings the service back
+	throttle.updated = throttle.updated.Add(-time.Minute)
+	if !throttle.Allow() || throttle.allowed < 0.99 {
+		t.Errorf("allowed %v a minute after the last signal, want almost 1", throttle.allowed)
+	}
+}
+
+func TestOverloadedParsesTheSignal(t *testing.T) {
+	for _, tt := range []struct {
+		threshold int
+		status    int
+		value     string
+		want      bool
+	}{
+		{0, http.StatusOK, "true", true},
+		{0, http.StatusOK, "false", false},
+		{0, http.StatusServiceUnavailable, "false", false},
+		{0, http.StatusServiceUnavailable, "1", true},
+		{0, http.StatusServiceUnavailable, "", false},
+		{10, http.StatusOK, "5", false},
+		{10, http.StatusOK, "12", true},
+		{10, http.StatusServiceUnavailable, "5", true},
+		{10, http.StatusServiceUnavailable, "0", false},
+	} {
+		svc := ServiceConfig{OverloadHeader: "X-Overloaded", OverloadThreshold: tt.threshold}
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		if tt.value != "" {
+			resp.Header.Set("X-Overloaded", tt.value)
+		}
+		if got := svc.overloaded(resp); got != tt.want {
+			t.Errorf("threshold %d, status %d, %q: overloaded %v, want %v", tt.threshold, tt.status, tt.value, got, tt.want)
+		}
+	}
+}
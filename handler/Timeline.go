@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timelineEvent is one step of a request's processing, relative to its arrival
+type timelineEvent struct {
+	Event string  `json:"event"`
+	Ms    float64 `json:"ms"`
+}
+
+// timeline collects processing steps for debug clients that ask for them
+type timeline struct {
+	mu      sync.Mutex
+	start   time.Time
+	events  []timelineEvent
+	trusted bool // set once the caller is authenticated with a debug role
+}
+
+type timelineKey struct{}
+
+func timelineFrom(ctx context.Context) *timeline {
+	tl, _ := ctx.Value(timelineKey{}).(*timeline)
+	return tl
+}
+
+func (t *timeline) mark(event string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// time.Since uses the monotonic clock, so offsets never go backwards
+	t.events = append(t.events, timelineEvent{Event: event, Ms: float64(time.Since(t.start).Microseconds()) / 1000})
+}
+
+func (t *timeline) setTrusted() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trusted = true
+}
+
+func (t *timeline) snapshot() ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.trusted {
+		return nil, false
+	}
+	b, err := json.Marshal(t.events)
+	return b, err == nil
+}
+
+// clientTrace marks the backend connection phases of the proxied call
+func (t *timeline) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) { t.mark("backend_conn_start") },
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.mark("backend_conn_reused")
+			} else {
+				t.mark("backend_conn_established")
+			}
+		},
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.mark("backend_tls_done") },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.mark("backend_request_written") },
+		GotFirstResponseByte: func() { t.mark("backend_first_byte") },
+	}
+}
+
+// timelineWriter adds the timeline as a response header before the headers go out,
+// and the total duration as a trailer once the body is done
+type timelineWriter struct {
+	*statusRecorder
+	tl          *timeline
+	wroteHeader bool
+}
+
+func (w *timelineWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.tl.mark("response_headers")
+		if b, ok := w.tl.snapshot(); ok {
+			w.Header().Set("X-Debug-Timeline", string(b))
+			w.Header().Add("Trailer", "X-Debug-Timeline-Total")
+		}
+	}
+	w.statusRecorder.WriteHeader(code)
+}
+
+func (w *timelineWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.statusRecorder.Write(b)
+}
+
+// TimelineMiddleware records a processing timeline for requests carrying
+// X-Debug-Timeline: true. It is only returned to authenticated callers whose role is
+// in Config.DebugTimelineRoles, so internals don't leak to everyone.
+func (g *Gateway) TimelineMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(g.Config.DebugTimelineRoles) == 0 || !strings.EqualFold(r.Header.Get("X-Debug-Timeline"), "true") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Header.Del("X-Debug-Timeline")
+
+		tl := &timeline{start: time.Now()}
+		tl.mark("received")
+		tw := &timelineWriter{statusRecorder: newStatusRecorder(w), tl: tl}
+		next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), timelineKey{}, tl)))
+
+		tl.mark("done")
+		if tl.trusted && tw.wroteHeader {
+			tw.Header().Set("X-Debug-Timeline-Total", fmt.Sprintf("%.3fms", float64(time.Since(tl.start).Microseconds())/1000))
+		}
+	})
+}
+
+// trustTimeline marks the request's timeline as viewable if the caller has a debug role
+func (g *Gateway) trustTimeline(r *http.Request, role string) {
+	if tl := timelineFrom(r.Context()); tl != nil && slices.Contains(g.Config.DebugTimelineRoles, role) {
+		tl.setTrusted()
+	}
+}
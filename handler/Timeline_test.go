@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestDebugTimelineForTrustedCallers(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{
+		"admin-token": {UserID: "root", Role: "admin"},
+		"user-token":  {UserID: "alice", Role: "user"},
+	})
+	blog := newTestUpstream(t)
+	g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, BlogServiceURL: blog.URL, DebugTimelineRoles: []string{"admin"}})
+	h := g.TimelineMiddleware(g.AuthMiddleware(g.ProxyHandler(ServiceBlog)))
+	request := func(token string, debug bool) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if debug {
+			req.Header.Set("X-Debug-Timeline", "true")
+		}
+		return serve(t, h, req, nil).Result()
+	}
+
+	resp := request("admin-token", true)
+	var events []timelineEvent
+	if err := json.Unmarshal([]byte(resp.Header.Get("X-Debug-Timeline")), &events); err != nil {
+		t.Fatalf("invalid timeline %q: %v", resp.Header.Get("X-Debug-Timeline"), err)
+	}
+	var names []string
+	for i, e := range events {
+		if i > 0 && e.Ms < events[i-1].Ms {
+			t.Errorf("timeline goes backwards at %s: %+v", e.Event, events)
+		}
+		names = append(names, e.Event)
+	}
+	for _, want := range []string{"received", "auth_start", "auth_end", "backend_conn_start", "backend_first_byte", "response_headers"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("timeline %q is missing %s", names, want)
+		}
+	}
+	if resp.Trailer.Get("X-Debug-Timeline-Total") == "" {
+		t.Errorf("no total duration trailer in %v", resp.Trailer)
+	}
+
+	for _, tt := range []struct {
+		name, token string
+		debug       bool
+	}{
+		{"caller without a debug role", "user-token", true},
+		{"debug caller not asking", "admin-token", false},
+	} {
+		if resp := request(tt.token, tt.debug); resp.Header.Get("X-Debug-Timeline") != "" || resp.Trailer.Get("X-Debug-Timeline-Total") != "" {
+			t.Errorf("%s got a timeline", tt.name)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultTokenCacheMaxEntries = 10000
+
+// tokenCache is an LRU cache of successful token validations so repeated requests with
+// the same token skip AuthService. Entries live for the configured TTL but never past
+// the token's expiry. Tokens are stored hashed.
+type tokenCache struct {
+	mu         sync.Mutex
+	entries    map[[sha256.Size]byte]*list.Element
+	lru        *list.List
+	ttl        time.Duration
+	maxEntries int
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type tokenCacheEntry struct {
+	key      [sha256.Size]byte
+	identity *AuthValidateResponse
+	expires  time.Time
+}
+
+type tokenCacheStats struct {
+	Entries int   `json:"entries"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+func newTokenCache(ttl time.Duration, maxEntries int) *tokenCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultTokenCacheMaxEntries
+	}
+	return &tokenCache{
+		entries:    make(map[[sha256.Size]byte]*list.Element),
+		lru:        list.New(),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+func (c *tokenCache) get(token string) (*AuthValidateResponse, bool) {
+	key := sha256.Sum256([]byte(token))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if ok && time.Now().After(el.Value.(*tokenCacheEntry).expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.lru.MoveToFront(el)
+	return el.Value.(*tokenCacheEntry).identity, true
+}
+
+func (c *tokenCache) set(token string, identity *AuthValidateResponse) {
+	e := &tokenCacheEntry{key: sha256.Sum256([]byte(token)), identity: identity, expires: time.Now().Add(c.ttl)}
+	if identity.ExpiresAt != 0 {
+		if exp := time.Unix(identity.ExpiresAt, 0); exp.Before(e.expires) {
+			e.expires = exp
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*tokenCacheEntry).key)
+	}
+}
+
+// flush drops the entries of userID, or every entry when userID is empty, and returns
+// how many were dropped
+func (c *tokenCache) flush(userID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if userID == "" {
+		n := c.lru.Len()
+		c.entries = make(map[[sha256.Size]byte]*list.Element)
+		c.lru.Init()
+		return n
+	}
+	n := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*tokenCacheEntry); e.identity.UserID == userID {
+			c.lru.Remove(el)
+			delete(c.entries, e.key)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+func (c *tokenCache) stats() *tokenCacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return &tokenCacheStats{Entries: entries, Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+// TokenCacheFlushHandler drops cached token validations (DELETE /admin/token-cache), e.g.
+// after a user's role changes or their tokens are revoked. ?user=<userID> limits the
+// flush to that user's tokens.
+func (g *Gateway) TokenCacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	if g.tokenCache == nil {
+		writeJSONError(w, http.StatusNotFound, "token cache is disabled")
+		return
+	}
+	userID := r.URL.Query().Get("user")
+	n := g.tokenCache.flush(userID)
+	g.Logger.InfoContext(r.Context(), "Flushed token cache", "entries", n, "user_id", userID)
+	writeJSON(w, http.StatusOK, map[string]int{"flushed": n})
+}
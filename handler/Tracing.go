@@ -0,0 +1,331 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tracing defaults
+const (
+	defaultTracingServiceName = "gateway"
+	traceBatchSize            = 512
+	traceQueueSize            = 4096
+	traceExportInterval       = 5 * time.Second
+)
+
+// OTLP span kinds
+const (
+	spanKindServer = 2
+	spanKindClient = 3
+)
+
+// TracingConfig exports a span per request, with child spans for token validation and
+// the proxied call, to an OpenTelemetry collector over OTLP/HTTP (JSON). W3C
+// traceparent headers are honoured on the way in and propagated to backends.
+type TracingConfig struct {
+	// OTLPEndpoint is the collector's base URL, e.g. http://otel-collector:4318; empty disables tracing
+	OTLPEndpoint string
+	// SampleRatio is the fraction of new traces recorded (default 1); requests arriving
+	// with a traceparent follow the caller's sampling decision
+	SampleRatio float64
+	ServiceName string
+}
+
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // zero for a trace's root span
+	sampled  bool
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    map[string]any
+	failed   bool
+}
+
+type spanKey struct{}
+
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// traceparent renders the span as a W3C traceparent header value
+func (s *span) traceparent() string {
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-" + flags
+}
+
+// parseTraceparent returns the trace ID, parent span ID and sampled flag of a traceparent header
+func parseTraceparent(h string) (traceID [16]byte, parentID [8]byte, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, parentID, false, false
+	}
+	var flags [1]byte
+	_, err1 := hex.Decode(traceID[:], []byte(parts[1]))
+	_, err2 := hex.Decode(parentID[:], []byte(parts[2]))
+	_, err3 := hex.Decode(flags[:], []byte(parts[3]))
+	if err1 != nil || err2 != nil || err3 != nil || traceID == ([16]byte{}) || parentID == ([8]byte{}) {
+		return traceID, parentID, false, false
+	}
+	return traceID, parentID, flags[0]&1 == 1, true
+}
+
+// tracer records spans and queues the sampled ones for export
+type tracer struct {
+	config TracingConfig
+	queue  chan *span
+	client *http.Client
+}
+
+func newTracer(config TracingConfig, client *http.Client) *tracer {
+	if config.SampleRatio <= 0 {
+		config.SampleRatio = 1
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = defaultTracingServiceName
+	}
+	return &tracer{config: config, queue: make(chan *span, traceQueueSize), client: client}
+}
+
+// startSpan starts a child of the span in ctx, or a new trace when there is none
+func (t *tracer) startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	s := &span{name: name, kind: kind, start: time.Now(), attrs: make(map[string]any)}
+	if parent := spanFrom(ctx); parent != nil {
+		s.traceID, s.parentID, s.sampled = parent.traceID, parent.spanID, parent.sampled
+	} else {
+		crand.Read(s.traceID[:])
+		s.sampled = rand.Float64() < t.config.SampleRatio
+	}
+	crand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// finish ends the span and queues it for export if sampled, dropping it when the queue is full
+func (t *tracer) finish(s *span) {
+	s.end = time.Now()
+	if !s.sampled {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+	}
+}
+
+// TracingMiddleware starts the server span of each request, continuing the caller's
+// trace when the request carries a valid traceparent header
+func (g *Gateway) TracingMiddleware(next http.Handler) http.Handler {
+	if g.tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = context.WithValue(ctx, spanKey{}, &span{traceID: traceID, spanID: parentID, sampled: sampled})
+		}
+		ctx, s := g.tracer.startSpan(ctx, r.Method, spanKindServer)
+		s.attrs["http.request.method"] = r.Method
+		s.attrs["url.path"] = r.URL.Path
+		if ip, ok := g.clientIP(r); ok {
+			s.attrs["client.address"] = ip.String()
+		}
+
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		s.attrs["http.response.status_code"] = rec.status
+		s.failed = rec.status >= 500
+		g.tracer.finish(s)
+	})
+}
+
+// tracingTransport records a client span around each call to a service and propagates
+// it to the backend in the traceparent header
+type tracingTransport struct {
+	tracer  *tracer
+	service string
+	next    http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, s := t.tracer.startSpan(req.Context(), req.Method+" "+t.service, spanKindClient)
+	s.attrs["http.request.method"] = req.Method
+	s.attrs["server.address"] = req.URL.Host
+	s.attrs["peer.service"] = t.service
+
+	req = req.Clone(ctx)
+	req.Header.Set("traceparent", s.traceparent())
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		s.attrs["error.type"] = err.Error()
+		s.failed = true
+	} else {
+		s.attrs["http.response.status_code"] = resp.StatusCode
+		s.failed = resp.StatusCode >= 500
+	}
+	t.tracer.finish(s)
+	return resp, err
+}
+
+// ExportTraces sends queued spans to the collector in batches until ctx is done
+func (g *Gateway) ExportTraces(ctx context.Context) {
+	if g.tracer == nil {
+		return
+	}
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+	batch := make([]*span, 0, traceBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := g.tracer.export(ctx, batch); err != nil {
+			g.Logger.Error("Failed to export spans", "spans", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-g.tracer.queue:
+			if batch = append(batch, s); len(batch) >= traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// drain exports the spans still queued, for use at shutdown
+func (t *tracer) drain(ctx context.Context) error {
+	var batch []*span
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+		default:
+			if len(batch) == 0 {
+				return nil
+			}
+			return t.export(ctx, batch)
+		}
+	}
+}
+
+// OTLP/HTTP JSON encoding of a batch of spans
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID      string          `json:"traceId"`
+		SpanID       string          `json:"spanId"`
+		ParentSpanID string          `json:"parentSpanId,omitempty"`
+		Name         string          `json:"name"`
+		Kind         int             `json:"kind"`
+		Start        string          `json:"startTimeUnixNano"`
+		End          string          `json:"endTimeUnixNano"`
+		Attributes   []otlpAttribute `json:"attributes,omitempty"`
+		Status       otlpStatus      `json:"status"`
+	}
+	otlpStatus struct {
+		Code int `json:"code,omitempty"` // 2 is error
+	}
+	otlpAttribute struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+func otlpAttr(key string, v any) otlpAttribute {
+	switch v := v.(type) {
+	case int:
+		return otlpAttribute{Key: key, Value: map[string]any{"intValue": strconv.Itoa(v)}}
+	case bool:
+		return otlpAttribute{Key: key, Value: map[string]any{"boolValue": v}}
+	default:
+		return otlpAttribute{Key: key, Value: map[string]any{"stringValue": fmt.Sprint(v)}}
+	}
+}
+
+func (t *tracer) export(ctx context.Context, batch []*span) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		o := otlpSpan{
+			TraceID: hex.EncodeToString(s.traceID[:]),
+			SpanID:  hex.EncodeToString(s.spanID[:]),
+			Name:    s.name,
+			Kind:    s.kind,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != ([8]byte{}) {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, k := range sortedKeys(s.attrs) {
+			o.Attributes = append(o.Attributes, otlpAttr(k, s.attrs[k]))
+		}
+		if s.failed {
+			o.Status.Code = 2
+		}
+		spans = append(spans, o)
+	}
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			otlpAttr("service.name", t.config.ServiceName),
+			otlpAttr("service.version", Version),
+		}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "gateway-service", Version: Version}, Spans: spans}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, traceExportInterval)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(t.config.OTLPEndpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const trace, parent = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	for header, want := range map[string]bool{
+		"00-" + trace + "-" + parent + "-01":                   true,
+		"00-" + trace + "-" + parent + "-00":                   true,
+		"01-" + trace + "-" + parent + "-01-future":            true,
+		"ff-" + trace + "-" + parent + "-01":                   false,
+		"00-" + strings.Repeat("0", 32) + "-" + parent + "-01": false,
+		"00-" + trace + "-0000000000000000-01":                 false,
+		"00-" + trace[:30] + "-" + parent + "-01":              false,
+		"00-" + trace + "-" + parent:                           false,
+		"00-" + strings.Repeat("x", 32) + "-" + parent + "-01": false,
+	} {
+		if _, _, _, ok := parseTraceparent(header); ok != want {
+			t.Errorf("parseTraceparent(%q) ok = %t, want %t", header, ok, want)
+		}
+	}
+	_, _, sampled, _ := parseTraceparent("00-" + trace + "-" + parent + "-03")
+	if !sampled {
+		t.Error("flags 03 not sampled, want the sampled bit read on its own")
+	}
+}
+
+func TestTracingExport(t *testing.T) {
+	var mu sync.Mutex
+	var exports []otlpTraces
+	received := map[string]string{} // service to the traceparent it was sent
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch otlpTraces
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" ||
+			json.NewDecoder(r.Body).Decode(&batch) != nil {
+			http.Error(w, "unexpected export", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		exports = append(exports, batch)
+		mu.Unlock()
+	}))
+	t.Cleanup(collector.Close)
+	record := func(service string, h http.HandlerFunc) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			received[service] = r.Header.Get("traceparent")
+			mu.Unlock()
+			h(w, r)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	auth := record(ServiceAuth, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, testUser{UserID: "alice", Role: "user"})
+	})
+	blog := record(ServiceBlog, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	})
+	g := newTestGateway(t, &Config{
+		AuthServiceURL: auth.URL,
+		BlogServiceURL: blog.URL,
+		Tracing:        TracingConfig{OTLPEndpoint: collector.URL + "/", ServiceName: "edge"},
+	})
+	h := g.TracingMiddleware(g.AuthMiddleware(g.ProxyHandler(ServiceBlog)))
+	request := func(traceparent string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		req.Header.Set("Authorization", "Bearer alice-token")
+		req.Header.Set("traceparent", traceparent)
+		serve(t, h, req, nil)
+	}
+
+	const trace, caller = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	// The caller decided not to sample: its trace continues to the services unrecorded
+	request("00-" + trace + "-" + caller + "-00")
+	if err := g.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(exports) != 0 || !strings.HasPrefix(received[ServiceBlog], "00-"+trace+"-") || !strings.HasSuffix(received[ServiceBlog], "-00") {
+		t.Errorf("unsampled trace: %d exports, blog sent traceparent %q", len(exports), received[ServiceBlog])
+	}
+	mu.Unlock()
+
+	request("00-" + trace + "-" + caller + "-01")
+	if err := g.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(exports) != 1 || len(exports[0].ResourceSpans) != 1 {
+		t.Fatalf("%d exports, want the sampled spans in one", len(exports))
+	}
+	rs := exports[0].ResourceSpans[0]
+	if rs.Resource.Attributes[0].Key != "service.name" || rs.Resource.Attributes[0].Value["stringValue"] != "edge" {
+		t.Errorf("resource attributes %v", rs.Resource.Attributes)
+	}
+	spans := map[string]otlpSpan{}
+	for _, s := range rs.ScopeSpans[0].Spans {
+		spans[s.Name] = s
+		if s.TraceID != trace {
+			t.Errorf("span %q in trace %s, want the caller's", s.Name, s.TraceID)
+		}
+	}
+	server, validate, proxy := spans["GET"], spans["POST auth validate"], spans["GET blog"]
+	if len(spans) != 3 || server.Kind != spanKindServer || validate.Kind != spanKindClient || proxy.Kind != spanKindClient {
+		t.Fatalf("spans %+v, want the server span with validation and proxy client spans", spans)
+	}
+	if server.ParentSpanID != caller || validate.ParentSpanID != server.SpanID || proxy.ParentSpanID != server.SpanID {
+		t.Errorf("parents: server %s, validate %s, proxy %s; want the caller's span, then the server span", server.ParentSpanID, validate.ParentSpanID, proxy.ParentSpanID)
+	}
+	// Each service is told which span called it
+	for service, s := range map[string]otlpSpan{ServiceAuth: validate, ServiceBlog: proxy} {
+		if want := "00-" + trace + "-" + s.SpanID + "-01"; received[service] != want {
+			t.Errorf("%s got traceparent %q, want %q", service, received[service], want)
+		}
+	}
+	// The backend's 500 marks the proxy and server spans as errors
+	if server.Status.Code != 2 || proxy.Status.Code != 2 || validate.Status.Code != 0 {
+		t.Errorf("status codes: server %d, proxy %d, validate %d", server.Status.Code, proxy.Status.Code, validate.Status.Code)
+	}
+	attrs := map[string]map[string]any{}
+	for _, a := range server.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if attrs["http.response.status_code"]["intValue"] != "500" || attrs["url.path"]["stringValue"] != "/api/blog/posts" {
+		t.Errorf("server span attributes %v", attrs)
+	}
+}
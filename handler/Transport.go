@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Transport defaults, all above the stdlib's (which allows only 2 idle conns per host)
+const (
+	defaultMaxIdleConns        = 256
+	defaultMaxIdleConnsPerHost = 64
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// newTransport builds a dedicated connection pool for one upstream service
+func (g *Gateway) newTransport(svc ServiceConfig) (*http.Transport, error) {
+	maxIdle := g.Config.MaxIdleConns
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdleConns
+	}
+	maxIdlePerHost := g.Config.MaxIdleConnsPerHost
+	if maxIdlePerHost <= 0 {
+		maxIdlePerHost = defaultMaxIdleConnsPerHost
+	}
+	// The pool must be able to hold the warm connections
+	maxIdlePerHost = max(maxIdlePerHost, svc.WarmPoolSize)
+	idleTimeout := g.Config.IdleConnTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleConnTimeout
+	}
+
+	proxy, err := egressProxy(svc)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := upstreamTLS(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdle,
+		MaxIdleConnsPerHost:   maxIdlePerHost,
+		MaxConnsPerHost:       g.Config.MaxConnsPerHost,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}, nil
+}
+
+// egressProxy returns the Transport.Proxy func for a service's outbound proxy
+// (http, https or socks5 URL), or nil to connect directly
+func egressProxy(svc ServiceConfig) (func(*http.Request) (*url.URL, error), error) {
+	if svc.EgressProxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(svc.EgressProxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid egress proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported egress proxy scheme %q", u.Scheme)
+	}
+	if svc.EgressProxyUser != "" {
+		u.User = url.UserPassword(svc.EgressProxyUser, svc.EgressProxyPassword)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// upstreamTLS builds the TLS config for mutual TLS with a service: a client certificate
+// to present and/or a CA to verify the service's certificate against. It returns nil
+// (standard TLS) when neither is configured.
+func upstreamTLS(svc ServiceConfig) (*tls.Config, error) {
+	if svc.TLSCertFile == "" && svc.TLSKeyFile == "" && svc.TLSCAFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if svc.TLSCertFile != "" || svc.TLSKeyFile != "" {
+		if svc.TLSCertFile == "" || svc.TLSKeyFile == "" {
+			return nil, errors.New("client certificate and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(svc.TLSCertFile, svc.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if svc.TLSCAFile != "" {
+		pem, err := os.ReadFile(svc.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upstream CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", svc.TLSCAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
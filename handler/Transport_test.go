@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpstreamTransports(t *testing.T) {
+	g := newTestGateway(t, &Config{
+		MaxConnsPerHost: 32,
+		IdleConnTimeout: time.Minute,
+		Services:        map[string]ServiceConfig{ServiceBlog: {WarmPoolSize: 100}},
+	})
+	ups := g.upstreams()
+	user, blog := ups[ServiceUser].transport, ups[ServiceBlog].transport
+	if user == blog {
+		t.Fatal("services share a transport")
+	}
+	if user.MaxIdleConns != defaultMaxIdleConns || user.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost {
+		t.Errorf("user idle limits %d/%d, want the defaults", user.MaxIdleConns, user.MaxIdleConnsPerHost)
+	}
+	if user.MaxConnsPerHost != 32 || user.IdleConnTimeout != time.Minute {
+		t.Errorf("user transport ignores the config: %d conns, %s idle", user.MaxConnsPerHost, user.IdleConnTimeout)
+	}
+	if blog.MaxIdleConnsPerHost != 100 {
+		t.Errorf("blog keeps %d idle conns per host, want room for its warm pool of 100", blog.MaxIdleConnsPerHost)
+	}
+}
+
+func TestEgressProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("gw:secret")) {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		proxied = append(proxied, r.URL.Path)
+		writeJSON(w, http.StatusOK, testUpstreamResponse{Path: r.URL.Path})
+	}))
+	defer proxy.Close()
+	users := newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: "http://blog.invalid",
+		UserServiceURL: users.URL,
+		Services: map[string]ServiceConfig{ServiceBlog: {
+			EgressProxy:         proxy.URL,
+			EgressProxyUser:     "gw",
+			EgressProxyPassword: "secret",
+		}},
+	})
+
+	if rec := serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil); rec.Code != http.StatusOK {
+		t.Fatalf("blog through the proxy: status %d", rec.Code)
+	}
+	if rec := serve(t, g.ProxyHandler(ServiceUser), httptest.NewRequest(http.MethodGet, "/api/user/profile", nil), nil); rec.Code != http.StatusOK {
+		t.Fatalf("direct user service: status %d", rec.Code)
+	}
+	if len(proxied) != 1 || proxied[0] != "/api/blog/posts" || users.calls.Load() != 1 {
+		t.Errorf("proxy saw %q and the user service %d calls, want only the blog request proxied", proxied, users.calls.Load())
+	}
+
+	config := &Config{Services: map[string]ServiceConfig{ServiceBlog: {EgressProxy: "ftp://proxy.internal"}}}
+	for _, u := range []*string{&config.AuthServiceURL, &config.BlogServiceURL, &config.UserServiceURL, &config.AspServiceURL} {
+		*u = users.URL
+	}
+	if _, err := NewGateway(config, g.Logger); err == nil {
+		t.Error("NewGateway accepted an ftp egress proxy")
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key as PEM files
+func writeClientCert(t *testing.T, dir, cn string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, cn+".crt"), filepath.Join(dir, cn+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	cert, _ = x509.ParseCertificate(der)
+	return certFile, keyFile, cert
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpstreamMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCert(t, dir, "gateway")
+	clients := x509.NewCertPool()
+	clients.AddCert(clientCert)
+	blog := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	blog.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clients}
+	blog.StartTLS()
+	defer blog.Close()
+	caFile := filepath.Join(dir, "blog-ca.crt")
+	writePEM(t, caFile, "CERTIFICATE", blog.Certificate().Raw)
+
+	for _, tt := range []struct {
+		name string
+		svc  ServiceConfig
+		want int
+	}{
+		{"client certificate and CA", ServiceConfig{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSCAFile: caFile}, http.StatusOK},
+		{"no client certificate", ServiceConfig{TLSCAFile: caFile}, http.StatusBadGateway},
+		{"service certificate not trusted", ServiceConfig{TLSCertFile: certFile, TLSKeyFile: keyFile}, http.StatusBadGateway},
+	} {
+		g := newTestGateway(t, &Config{BlogServiceURL: blog.URL, Services: map[string]ServiceConfig{ServiceBlog: tt.svc}})
+		rec := serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil)
+		if rec.Code != tt.want || (tt.want == http.StatusOK && rec.Body.String() != "gateway") {
+			t.Errorf("%s: status %d with %q, want %d", tt.name, rec.Code, rec.Body, tt.want)
+		}
+	}
+
+	config := &Config{BlogServiceURL: blog.URL, Services: map[string]ServiceConfig{ServiceBlog: {TLSCertFile: certFile}}}
+	for _, u := range []*string{&config.AuthServiceURL, &config.UserServiceURL, &config.AspServiceURL} {
+		*u = blog.URL
+	}
+	if _, err := NewGateway(config, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("NewGateway accepted a client certificate without its key")
+	}
+}
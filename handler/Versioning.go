@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// DefaultAPIVersion is served by a service's main URL
+const DefaultAPIVersion = "v1"
+
+// vendorMediaType matches versioned media types like application/vnd.myapi.v2+json
+var vendorMediaType = regexp.MustCompile(`application/vnd\.[\w.-]+?\.(v\d+)\+json`)
+
+// requestedVersion returns the API version asked for by X-API-Version ("2" or "v2")
+// or a versioned Accept media type, or "" if the request doesn't ask for one
+func requestedVersion(r *http.Request) string {
+	if v := strings.TrimSpace(r.Header.Get("X-API-Version")); v != "" {
+		v = strings.ToLower(v)
+		if !strings.HasPrefix(v, "v") {
+			v = "v" + v
+		}
+		return v
+	}
+	if m := vendorMediaType.FindStringSubmatch(r.Header.Get("Accept")); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// newVersionUpstreams builds one upstream per extra API version of a service
+func (g *Gateway) newVersionUpstreams(up *upstream) error {
+	for version, raw := range up.svc.Versions {
+		targets, err := parseTargets(raw)
+		if err != nil {
+			return fmt.Errorf("invalid %s service %s URL: %w", up.name, version, err)
+		}
+		vu, err := g.newUpstream(up.name, up.svc, targets)
+		if err != nil {
+			return err
+		}
+		if up.versions == nil {
+			up.versions = make(map[string]*upstream)
+		}
+		up.versions[strings.ToLower(version)] = vu
+	}
+	return nil
+}
+
+// selectVersion picks the upstream serving the requested API version. Services
+// without extra versions ignore version headers entirely.
+func (up *upstream) selectVersion(r *http.Request) (*upstream, bool) {
+	if len(up.versions) == 0 {
+		return up, true
+	}
+	v := requestedVersion(r)
+	if v == "" || v == DefaultAPIVersion {
+		return up, true
+	}
+	vu, ok := up.versions[v]
+	return vu, ok
+}
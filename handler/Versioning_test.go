@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIVersionRouting(t *testing.T) {
+	v1, v2 := newTestUpstream(t), newTestUpstream(t)
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: v1.URL,
+		Services:       map[string]ServiceConfig{ServiceBlog: {Versions: map[string]string{"V2": v2.URL}}},
+	})
+
+	for _, tt := range []struct {
+		name, header, value string
+		want                *testUpstream
+	}{
+		{"no version", "", "", v1},
+		{"X-API-Version v1", "X-API-Version", "v1", v1},
+		{"X-API-Version 2", "X-API-Version", "2", v2},
+		{"X-API-Version V2", "X-API-Version", "V2", v2},
+		{"vendor Accept", "Accept", "application/vnd.myapi.v2+json", v2},
+		{"unknown X-API-Version", "X-API-Version", "v3", nil},
+		{"unknown vendor Accept", "Accept", "application/vnd.myapi.v9+json", nil},
+	} {
+		before1, before2 := v1.calls.Load(), v2.calls.Load()
+		req := httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		rec := serve(t, g.ProxyHandler(ServiceBlog), req, nil)
+		served1, served2 := v1.calls.Load()-before1, v2.calls.Load()-before2
+		switch tt.want {
+		case nil:
+			if rec.Code != http.StatusBadRequest || served1+served2 != 0 {
+				t.Errorf("%s: status %d after %d upstream calls, want 400 without any", tt.name, rec.Code, served1+served2)
+			}
+		case v1:
+			if rec.Code != http.StatusOK || served1 != 1 || served2 != 0 {
+				t.Errorf("%s: status %d, v1 served %d and v2 %d, want v1", tt.name, rec.Code, served1, served2)
+			}
+		case v2:
+			if rec.Code != http.StatusOK || served1 != 0 || served2 != 1 {
+				t.Errorf("%s: status %d, v1 served %d and v2 %d, want v2", tt.name, rec.Code, served1, served2)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWarmPoolInterval = 15 * time.Second
+	warmPoolProbeTimeout    = 5 * time.Second
+)
+
+// MaintainWarmPools keeps ServiceConfig.WarmPoolSize idle keep-alive connections open
+// to every instance of a service until ctx is done. Each round sends that many
+// concurrent probe requests through the service's transport: idle connections are
+// reused and any that were closed get re-established, so real requests rarely pay the
+// TCP/TLS handshake. Config.WarmPoolInterval should stay below the backend's keep-alive timeout.
+func (g *Gateway) MaintainWarmPools(ctx context.Context) {
+	var pools []*upstream
+	for _, up := range g.upstreams() {
+		if up.svc.WarmPoolSize > 0 {
+			pools = append(pools, up)
+		}
+	}
+	if len(pools) == 0 {
+		return
+	}
+
+	interval := g.Config.WarmPoolInterval
+	if interval <= 0 {
+		interval = defaultWarmPoolInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, up := range pools {
+			g.refillWarmPool(ctx, up)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *Gateway) refillWarmPool(ctx context.Context, up *upstream) {
+	svc := up.svc
+	probe := svc.WarmPoolProbePath
+	if probe == "" {
+		probe = "/"
+	}
+	ref, err := url.Parse(probe)
+	if err != nil {
+		g.Logger.Error("Invalid warm pool probe path", "service", up.name, "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, warmPoolProbeTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, in := range up.instances() {
+		target := in.resolve(ref).String()
+		for range svc.WarmPoolSize {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+				if err != nil {
+					return
+				}
+				req.Header.Set("X-Gateway-Probe", "warm-pool")
+				resp, err := up.transport.RoundTrip(req)
+				if err != nil {
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}()
+		}
+	}
+	wg.Wait()
+}
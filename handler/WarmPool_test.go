@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmPoolKeepsConnectionsOpen(t *testing.T) {
+	var conns, probes atomic.Int64
+	blog := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Gateway-Probe") == "warm-pool" {
+			probes.Add(1)
+			// Holds the probes long enough that each needs its own connection
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	blog.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	blog.Start()
+	defer blog.Close()
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog.URL,
+		Services:       map[string]ServiceConfig{ServiceBlog: {WarmPoolSize: 3, WarmPoolProbePath: "/ping"}},
+	})
+	up := g.upstreams()[ServiceBlog]
+
+	g.refillWarmPool(context.Background(), up)
+	if conns.Load() != 3 || probes.Load() != 3 {
+		t.Fatalf("first refill opened %d connections for %d probes, want 3 for 3", conns.Load(), probes.Load())
+	}
+	g.refillWarmPool(context.Background(), up)
+	for range 3 {
+		serve(t, g.ProxyHandler(ServiceBlog), httptest.NewRequest(http.MethodGet, "/api/blog/posts", nil), nil)
+	}
+	if conns.Load() != 3 || probes.Load() != 6 {
+		t.Errorf("second refill and 3 requests opened %d connections in total for %d probes, want the pool reused", conns.Load(), probes.Load())
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWarmupTimeout = 30 * time.Second
+	warmupRetryInterval  = 500 * time.Millisecond
+)
+
+// Warmup sends each service's configured prefetch GETs to every instance so backend
+// caches are warm before real traffic arrives. Responses are discarded. Requests to a
+// backend that isn't accepting connections yet are retried until Config.WarmupTimeout.
+func (g *Gateway) Warmup(ctx context.Context) {
+	timeout := g.Config.WarmupTimeout
+	if timeout <= 0 {
+		timeout = defaultWarmupTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, up := range g.upstreams() {
+		paths := up.svc.WarmupPaths
+		if len(paths) == 0 {
+			continue
+		}
+		for _, in := range up.instances() {
+			wg.Add(1)
+			go func(up *upstream, in *instance) {
+				defer wg.Done()
+				for _, path := range paths {
+					g.warmup(ctx, up, in, path)
+				}
+			}(up, in)
+		}
+	}
+	wg.Wait()
+}
+
+// warmup performs a single prefetch, waiting for the backend to accept connections
+func (g *Gateway) warmup(ctx context.Context, up *upstream, in *instance, path string) {
+	target, err := g.upstreamURL(up, in, path)
+	if err != nil {
+		g.Logger.Warn("Warm-up skipped", "service", up.name, "error", err)
+		return
+	}
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+		if err != nil {
+			g.Logger.Warn("Warm-up skipped", "service", up.name, "error", err)
+			return
+		}
+		req.Header.Set("X-Gateway-Warmup", "true")
+
+		start := time.Now()
+		resp, err := up.client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			g.Logger.Info("Warm-up request", "target", target, "status", resp.StatusCode, "latency", time.Since(start))
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			g.Logger.Warn("Warm-up request gave up", "target", target, "error", err)
+			return
+		case <-time.After(warmupRetryInterval):
+		}
+	}
+}
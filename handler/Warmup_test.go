@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestWarmupPrefetchesEveryInstance(t *testing.T) {
+	var mu sync.Mutex
+	warmed := make(map[string][]string)
+	backend := func() *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Gateway-Warmup") != "true" {
+				http.Error(w, "not a warm-up request", http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			warmed[r.Host] = append(warmed[r.Host], r.URL.Path)
+			mu.Unlock()
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	blog1, blog2 := backend(), backend()
+	g := newTestGateway(t, &Config{
+		BlogServiceURL: blog1.URL + "," + blog2.URL,
+		Services: map[string]ServiceConfig{ServiceBlog: {
+			StripPrefix: true,
+			WarmupPaths: []string{"/api/blog/posts", "/api/blog/tags"},
+		}},
+	})
+
+	g.Warmup(context.Background())
+	for _, srv := range []*httptest.Server{blog1, blog2} {
+		host := srv.Listener.Addr().String()
+		if got := warmed[host]; len(got) != 2 || got[0] != "/posts" || got[1] != "/tags" {
+			t.Errorf("%s warmed %q, want /posts and /tags", host, got)
+		}
+	}
+}
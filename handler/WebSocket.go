@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// websocketTokenParam carries the JWT of browser WebSocket clients, which can't set
+	// an Authorization header on the upgrade request
+	websocketTokenParam = "access_token"
+	// websocketBearerProtocol marks a Sec-WebSocket-Protocol offer whose next entry is the JWT,
+	// as in "Sec-WebSocket-Protocol: bearer, <token>"
+	websocketBearerProtocol = "bearer"
+)
+
+// isWebSocketUpgrade reports whether r asks to switch the connection to WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	if r.Method != http.MethodGet || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, opt := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(opt), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// websocketToken takes the JWT of an upgrade request from the access_token query parameter
+// or from a "bearer, <token>" Sec-WebSocket-Protocol offer. The token is removed from the
+// request either way so it isn't forwarded to the backend.
+func websocketToken(r *http.Request) string {
+	if !isWebSocketUpgrade(r) {
+		return ""
+	}
+	var token string
+	if q := r.URL.Query(); q.Has(websocketTokenParam) {
+		token = q.Get(websocketTokenParam)
+		q.Del(websocketTokenParam)
+		r.URL.RawQuery = q.Encode()
+	}
+
+	var offers []string
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			offers = append(offers, strings.TrimSpace(p))
+		}
+	}
+	for i, p := range offers {
+		if strings.EqualFold(p, websocketBearerProtocol) && i+1 < len(offers) {
+			if token == "" {
+				token = offers[i+1]
+			}
+			offers = append(offers[:i+1], offers[i+2:]...)
+			r.Header.Set("Sec-WebSocket-Protocol", strings.Join(offers, ", "))
+			break
+		}
+	}
+	return token
+}
+
+// acceptBearerProtocol answers a handshake whose client offered the bearer protocol with
+// that protocol when the backend chose none, since browsers drop connections whose offered
+// protocols all go unanswered
+func acceptBearerProtocol(resp *http.Response) error {
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Protocol") != "" {
+		return nil
+	}
+	for _, p := range strings.Split(resp.Request.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		if strings.EqualFold(strings.TrimSpace(p), websocketBearerProtocol) {
+			resp.Header.Set("Sec-WebSocket-Protocol", websocketBearerProtocol)
+			break
+		}
+	}
+	return nil
+}
+
+// websocketHandler sends WebSocket upgrades to tunnel, a service chain without the layers
+// that buffer responses, bound their duration or hold a concurrency slot for the whole
+// response; other requests go to next. The reverse proxy hijacks the client connection
+// on a 101 answer and copies both directions until either side closes.
+func (g *Gateway) websocketHandler(tunnel, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tunnel.ServeHTTP(&tunnelWriter{ResponseWriter: w}, r)
+	})
+}
+
+// tunnelWriter clears the server's read and write timeouts from the hijacked connection,
+// which would otherwise cut long-lived tunnels off
+type tunnelWriter struct {
+	http.ResponseWriter
+}
+
+func (w *tunnelWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, brw, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *tunnelWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// upgradeRequest is what the fake backend saw of the handshake
+type upgradeRequest struct {
+	query, protocol, userID, authorization string
+}
+
+// newEchoBackend answers WebSocket handshakes with 101 and echoes the bytes that follow,
+// without framing since the gateway tunnels the connection as it is
+func newEchoBackend(t *testing.T, seen chan<- upgradeRequest) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- upgradeRequest{r.URL.RawQuery, r.Header.Get("Sec-WebSocket-Protocol"), r.Header.Get("X-User-ID"), r.Header.Get("Authorization")}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		buf := make([]byte, 64)
+		for {
+			n, err := brw.Read(buf)
+			if err != nil {
+				return
+			}
+			conn.Write(buf[:n])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWebSocketTunnel(t *testing.T) {
+	auth := testAuthService(t, map[string]testUser{"alice-token": {UserID: "alice", Role: "user"}})
+	seen := make(chan upgradeRequest, 1)
+	blog := newEchoBackend(t, seen)
+	g := newTestGateway(t, &Config{AuthServiceURL: auth.URL, BlogServiceURL: blog.URL})
+	gw := httptest.NewUnstartedServer(g.AuthMiddleware(g.ProxyHandler(ServiceBlog)))
+	// Tunnels outlive the server's timeouts
+	gw.Config.ReadTimeout, gw.Config.WriteTimeout = 50*time.Millisecond, 50*time.Millisecond
+	gw.Start()
+	t.Cleanup(gw.Close)
+
+	handshake := func(target, protocol string) (net.Conn, *bufio.Reader, *http.Response) {
+		t.Helper()
+		conn, err := net.Dial("tcp", gw.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		req := "GET " + target + " HTTP/1.1\r\nHost: gateway\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+		if protocol != "" {
+			req += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+		}
+		if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+			t.Fatal(err)
+		}
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, br, resp
+	}
+
+	for _, tt := range []struct {
+		name, target, offered  string
+		wantProtocol, forwards string
+	}{
+		{"query token", "/api/blog/live?access_token=alice-token&room=7", "chat", "", "chat"},
+		{"protocol token", "/api/blog/live?room=7", "bearer, alice-token, chat", "bearer", "bearer, chat"},
+	} {
+		conn, br, resp := handshake(tt.target, tt.offered)
+		if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Protocol") != tt.wantProtocol {
+			t.Fatalf("%s: status %d, protocol %q", tt.name, resp.StatusCode, resp.Header.Get("Sec-WebSocket-Protocol"))
+		}
+		// The token authenticates the upgrade and stays away from the backend
+		if got := <-seen; got != (upgradeRequest{query: "room=7", protocol: tt.forwards, userID: "alice"}) {
+			t.Errorf("%s: backend saw %+v", tt.name, got)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		for _, msg := range []string{"ping", "pong"} {
+			if _, err := io.WriteString(conn, msg); err != nil {
+				t.Fatalf("%s: write after the server timeouts: %v", tt.name, err)
+			}
+			buf := make([]byte, len(msg))
+			if _, err := io.ReadFull(br, buf); err != nil || string(buf) != msg {
+				t.Fatalf("%s: echoed %q (%v), want %q", tt.name, buf, err, msg)
+			}
+		}
+	}
+
+	// Upgrades need a valid token like any request
+	if _, _, resp := handshake("/api/blog/live?access_token=forged", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("forged token: status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestWebSocketToken(t *testing.T) {
+	upgrade := func(target string, header ...string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Upgrade", "WebSocket")
+		r.Header.Set("Connection", "Upgrade")
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Add(header[i], header[i+1])
+		}
+		return r
+	}
+	// The query token wins, but the protocol offer is cleaned up too
+	r := upgrade("/live?access_token=q-token", "Sec-WebSocket-Protocol", "bearer", "Sec-WebSocket-Protocol", "p-token, chat")
+	if token := websocketToken(r); token != "q-token" || r.Header.Get("Sec-WebSocket-Protocol") != "bearer, chat" || r.URL.RawQuery != "" {
+		t.Errorf("token %q, protocol %q, query %q", token, r.Header.Get("Sec-WebSocket-Protocol"), r.URL.RawQuery)
+	}
+	// A bearer offer with nothing after it carries no token
+	if token := websocketToken(upgrade("/live", "Sec-WebSocket-Protocol", "chat, bearer")); token != "" {
+		t.Errorf("trailing bearer offer: token %q", token)
+	}
+	// Plain requests keep their query
+	r = httptest.NewRequest(http.MethodGet, "/live?access_token=q-token", nil)
+	if token := websocketToken(r); token != "" || r.URL.RawQuery != "access_token=q-token" {
+		t.Errorf("not an upgrade: token %q, query %q", token, r.URL.RawQuery)
+	}
+}
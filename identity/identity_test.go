@@ -0,0 +1,117 @@
+package identity
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signed returns the headers the gateway sends for id, signed with secret at t
+func signed(secret string, id Identity, t time.Time) http.Header {
+	h := http.Header{}
+	h.Set(UserIDHeader, id.UserID)
+	h.Set(RoleHeader, id.Role)
+	h.Set(UsernameHeader, id.Username)
+	if id.TenantID != "" {
+		h.Set(TenantIDHeader, id.TenantID)
+	}
+	h.Set(SignatureHeader, Sign([]byte(secret), id, t))
+	return h
+}
+
+func TestVerify(t *testing.T) {
+	alice := Identity{UserID: "42", Role: "admin", Username: "alice", TenantID: "acme"}
+	now := time.Now()
+	for _, tt := range []struct {
+		name    string
+		header  http.Header
+		secrets []string
+		want    error
+	}{
+		{"valid", signed("s1", alice, now), []string{"s1"}, nil},
+		{"without a tenant", signed("s1", Identity{UserID: "7", Role: "user", Username: "bob"}, now), []string{"s1"}, nil},
+		{"old secret during rotation", signed("s1", alice, now), []string{"s2", "s1"}, nil},
+		{"retired secret", signed("s1", alice, now), []string{"s2"}, ErrInvalidSignature},
+		{"no signature", http.Header{UserIDHeader: {"42"}}, []string{"s1"}, ErrMissingSignature},
+		{"expired", signed("s1", alice, now.Add(-2*time.Minute)), []string{"s1"}, ErrExpiredSignature},
+		{"from the future", signed("s1", alice, now.Add(2*time.Minute)), []string{"s1"}, ErrExpiredSignature},
+	} {
+		var secrets [][]byte
+		for _, s := range tt.secrets {
+			secrets = append(secrets, []byte(s))
+		}
+		id, err := Verify(tt.header, time.Minute, secrets...)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.want)
+		}
+		if err == nil && id != FromHeader(tt.header) {
+			t.Errorf("%s: identity %+v, want the headers' %+v", tt.name, id, FromHeader(tt.header))
+		}
+	}
+}
+
+func TestVerifyRejectsTamperedHeaders(t *testing.T) {
+	alice := Identity{UserID: "42", Role: "user", Username: "alice", TenantID: "acme"}
+	for name, tamper := range map[string]func(http.Header){
+		"user ID":   func(h http.Header) { h.Set(UserIDHeader, "1") },
+		"role":      func(h http.Header) { h.Set(RoleHeader, "admin") },
+		"username":  func(h http.Header) { h.Set(UsernameHeader, "root") },
+		"tenant":    func(h http.Header) { h.Set(TenantIDHeader, "globex") },
+		"no tenant": func(h http.Header) { h.Del(TenantIDHeader) },
+		// Fields can't be shifted into one another
+		"shifted fields": func(h http.Header) {
+			h.Set(RoleHeader, "user\nalice")
+			h.Set(UsernameHeader, "")
+		},
+		"timestamp": func(h http.Header) {
+			h.Set(SignatureHeader, strings.Replace(h.Get(SignatureHeader), "t=", "t=1", 1))
+		},
+		"bad timestamp": func(h http.Header) {
+			h.Set(SignatureHeader, "t=soon,"+strings.Split(h.Get(SignatureHeader), ",")[1])
+		},
+		"bad v1": func(h http.Header) {
+			h.Set(SignatureHeader, strings.Split(h.Get(SignatureHeader), ",")[0]+",v1=zz")
+		},
+		"no v1": func(h http.Header) {
+			h.Set(SignatureHeader, strings.Split(h.Get(SignatureHeader), ",")[0])
+		},
+	} {
+		h := signed("s1", alice, time.Now())
+		tamper(h)
+		if _, err := Verify(h, time.Minute, []byte("s1")); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("tampered %s: error %v, want %v", name, err, ErrInvalidSignature)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var got Identity
+	var ok bool
+	h := Middleware(time.Minute, []byte("s1"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok = FromContext(r.Context())
+	}))
+
+	alice := Identity{UserID: "42", Role: "user", Username: "alice"}
+	req := httptest.NewRequest(http.MethodGet, "/posts", nil)
+	req.Header = signed("s1", alice, time.Now())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !ok || got != alice {
+		t.Errorf("signed request: status %d, identity %+v (%t), want %+v", rec.Code, got, ok, alice)
+	}
+
+	got, ok = Identity{}, false
+	req = httptest.NewRequest(http.MethodGet, "/posts", nil)
+	req.Header.Set(UserIDHeader, "42")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || ok {
+		t.Errorf("unsigned request: status %d, handler reached %t, want 401", rec.Code, ok)
+	}
+	if _, ok := FromContext(req.Context()); ok {
+		t.Error("FromContext found an identity Middleware never set")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listen opens the TCP address, or the Unix socket at socketPath when it is set. A stale
+// socket file is replaced, and mode (octal, default 0660) sets the socket's permissions.
+// Closing the returned Unix listener removes the socket file.
+func listen(addr, socketPath, mode string) (net.Listener, error) {
+	if socketPath == "" {
+		return net.Listen("tcp", addr)
+	}
+	perm := os.FileMode(0o660)
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LISTEN_SOCKET_MODE %q", mode)
+		}
+		perm = os.FileMode(m)
+	}
+
+	if fi, err := os.Lstat(socketPath); err == nil {
+		if fi.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, perm); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
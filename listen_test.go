@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MicroSOA-09/gateway-service/handler"
+)
+
+func TestListenOnAUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "gw.sock")
+	// A socket left behind by a crashed gateway is replaced
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listen(":0", socket, "600")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if fi, err := os.Stat(socket); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode %v (%v), want 0600", fi.Mode().Perm(), err)
+	}
+	// Socket peers count as local for the admin and metrics IP filter
+	server := &http.Server{Handler: testRouter(t, &handler.Config{AdminToken: "admin-secret", AllowedCIDRs: []string{"127.0.0.1"}})}
+	go server.Serve(l)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://gateway/")
+	if err != nil {
+		t.Fatalf("GET / over the socket: %v", err)
+	}
+	var banner map[string]string
+	err = json.NewDecoder(resp.Body).Decode(&banner)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || err != nil || banner["name"] != handler.GatewayName {
+		t.Errorf("GET / over the socket: status %d, banner %v (%v)", resp.StatusCode, banner, err)
+	}
+	for _, path := range []string{"/admin/stats", "/metrics"} {
+		req, _ := http.NewRequest(http.MethodGet, "http://gateway"+path, nil)
+		req.Header.Set("X-Admin-Token", "admin-secret")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s over the socket: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s over the socket: status %d, want 200", path, resp.StatusCode)
+		}
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(socket); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("socket file left after shutdown: %v", err)
+	}
+}
+
+func TestListenRefusesToReplaceOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "gateway.conf")
+	if err := os.WriteFile(file, []byte("keep me"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := listen(":0", file, ""); err == nil {
+		l.Close()
+		t.Error("listen replaced a regular file")
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "keep me" {
+		t.Errorf("file after listen: %q (%v)", data, err)
+	}
+	if l, err := listen(":0", filepath.Join(dir, "gw.sock"), "rw"); err == nil {
+		l.Close()
+		t.Error("listen accepted an invalid socket mode")
+	}
+}
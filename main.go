@@ -1,84 +1,549 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/MicroSOA-09/gateway-service/handler"
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
 )
 
 func main() {
 	// Load .env file
-	err := godotenv.Load()
+	reloader := newConfigReloader()
+	err := reloader.loadDotEnv()
 	if err != nil {
 		fmt.Println("Warning: Could not load .env file, using defaults:", err)
 	}
-	
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       envLogLevel("LOG_LEVEL"),
+		ReplaceAttr: durationsAsText,
+	}))
+
+	config, err := loadConfig()
+	if err != nil {
+		logger.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	gateway, err := handler.NewGateway(config, logger)
+	if err != nil {
+		logger.Error("Failed to initialize gateway", "error", err)
+		os.Exit(1)
+	}
+
+	// Prefetch configured paths so backend caches are warm before we take traffic
+	gateway.Warmup(context.Background())
+
+	// Load the JWT signing keys so tokens can be verified locally from the first request
+	if err := gateway.FetchJWKS(context.Background()); err != nil {
+		logger.Error("Failed to load JWKS", "error", err)
+	}
+
+	// Discover the OIDC provider up front; login retries until it succeeds
+	if err := gateway.DiscoverOIDC(context.Background()); err != nil {
+		logger.Error("OIDC discovery failed", "error", err)
+	}
+
+	// Background loops stop once the server has shut down
+	background, stopBackground := context.WithCancel(context.Background())
+	go gateway.RefreshJWKS(background)
+	go gateway.ExportTraces(background)
+	go gateway.ProbeUpstreams(background)
+	go gateway.DiscoverInstances(background)
+	go gateway.RecycleConnections(background)
+	go gateway.MonitorSLAs(background)
+	go gateway.MaintainWarmPools(background)
+	go gateway.SyncRevocations(background)
+
+	// The router is rebuilt on reload; requests already routed finish on the old one
+	var router atomic.Pointer[mux.Router]
+	router.Store(newRouter(gateway, config))
+	routes := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.Load().ServeHTTP(w, r)
+	})
+
+	// Routes, service URLs, CORS policy and rate limits are reloaded on SIGHUP, and on
+	// changes to .env or ROUTES_FILE when CONFIG_WATCH_INTERVAL is set
+	reloader.logger, reloader.gateway, reloader.router, reloader.startup = logger, gateway, &router, config
+	go reloader.run(background)
+
+	// Start server
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	timeouts, err := serverTimeoutsFromEnv()
+	if err != nil {
+		logger.Error("Invalid server timeout", "error", err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           gateway.RequestIDMiddleware(gateway.AccessLogMiddleware(gateway.TracingMiddleware(gateway.CompressionMiddleware(gateway.CORSMiddleware(gateway.SmugglingGuard(gateway.HeaderLimitGuard(routes))))))),
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+		ReadTimeout:       timeouts.Read,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+	}
+
+	listener, err := listen(server.Addr, os.Getenv("LISTEN_SOCKET"), os.Getenv("LISTEN_SOCKET_MODE"))
+	if err != nil {
+		logger.Error("Failed to listen", "error", err)
+		os.Exit(1)
+	}
+
+	// The admin API is kept off the public port when ADMIN_ADDR is set
+	var adminServer *http.Server
+	if config.AdminAddr != "" {
+		adminServer = &http.Server{
+			Addr:              config.AdminAddr,
+			Handler:           gateway.RequestIDMiddleware(gateway.AccessLogMiddleware(newAdminRouter(gateway, config))),
+			ReadTimeout:       timeouts.Read,
+			ReadHeaderTimeout: timeouts.ReadHeader,
+			WriteTimeout:      timeouts.Write,
+			IdleTimeout:       timeouts.Idle,
+		}
+		adminListener, err := listen(adminServer.Addr, "", "")
+		if err != nil {
+			logger.Error("Failed to listen for the admin API", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Starting admin API", "addr", adminListener.Addr().String())
+		go func() {
+			if err := adminServer.Serve(adminListener); err != nil && err != http.ErrServerClosed {
+				logger.Error("Admin server failed", "error", err)
+			}
+		}()
+	}
+
+	// Shut down gracefully on SIGINT/SIGTERM: /readyz starts failing and keep-alives stop,
+	// SHUTDOWN_DELAY gives load balancers time to notice, then the listener closes and
+	// in-flight requests get SHUTDOWN_DRAIN_TIMEOUT to finish. A second signal exits at once.
+	// Closing a Unix listener removes its socket file.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownDelay := envDuration("SHUTDOWN_DELAY", 0)
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		stop()
+		logger.Info("Shutting down", "delay", shutdownDelay, "drain_timeout", timeouts.Drain)
+		gateway.BeginShutdown()
+		server.SetKeepAlivesEnabled(false)
+		time.Sleep(shutdownDelay)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeouts.Drain)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Drain timed out, closing remaining connections", "error", err)
+			server.Close()
+		}
+		if adminServer != nil {
+			adminServer.Close()
+		}
+		stopBackground()
+
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelFlush()
+		if err := gateway.Close(flushCtx); err != nil {
+			logger.Error("Failed to flush logs and traces", "error", err)
+		}
+	}()
+
+	logger.Info("Starting gateway", "addr", listener.Addr().String())
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		logger.Error("Server failed", "error", err)
+		os.Exit(1)
+	}
+	<-drained
+	logger.Info("Shutdown complete")
+}
+
+// loadConfig reads the configuration from the environment and the ROUTES_FILE
+func loadConfig() (*handler.Config, error) {
 	config := &handler.Config{
 		AuthServiceURL: os.Getenv("AUTH_SERVICE_URL"),
 		BlogServiceURL: os.Getenv("BLOG_SERVICE_URL"),
 		UserServiceURL: os.Getenv("USER_SERVICE_URL"),
 		AspServiceURL:  os.Getenv("ASP_SERVICE_URL"),
+		Services: map[string]handler.ServiceConfig{
+			handler.ServiceAuth: serviceConfigFromEnv("AUTH"),
+			handler.ServiceBlog: serviceConfigFromEnv("BLOG"),
+			handler.ServiceUser: serviceConfigFromEnv("USER"),
+			handler.ServiceAsp:  serviceConfigFromEnv("ASP"),
+		},
+		MaxIdleConns:        envInt("MAX_IDLE_CONNS", 0),
+		MaxIdleConnsPerHost: envInt("MAX_IDLE_CONNS_PER_HOST", 0),
+		MaxConnsPerHost:     envInt("MAX_CONNS_PER_HOST", 0),
+		IdleConnTimeout:     envDuration("IDLE_CONN_TIMEOUT", 0),
+		DNSRefreshInterval:  envDuration("DNS_REFRESH_INTERVAL", 0),
+		MaxConnAge:          envDuration("MAX_CONN_AGE", 0),
+		BodyLog: handler.BodyLogConfig{
+			Prefixes:     envList("BODY_LOG_ROUTES"),
+			MaxBytes:     envInt("BODY_LOG_MAX_BYTES", 0),
+			RedactFields: envList("BODY_LOG_REDACT"),
+			Statuses:     envIntList("BODY_LOG_STATUSES"),
+		},
+		Compression: handler.CompressionConfig{
+			Enabled:      envBool("COMPRESSION", false),
+			MinSize:      envInt("COMPRESSION_MIN_SIZE", 0),
+			ContentTypes: envList("COMPRESSION_TYPES"),
+			Level:        envInt("COMPRESSION_LEVEL", 0),
+		},
+		APIKeys: handler.APIKeyConfig{
+			Header:       os.Getenv("API_KEY_HEADER"),
+			Keys:         envAPIKeys("API_KEYS"),
+			ValidatePath: os.Getenv("API_KEY_VALIDATE_PATH"),
+			RateLimit:    envRateLimit("API_KEY_RATE_LIMIT"),
+		},
+		CookieAuth: handler.CookieAuthConfig{
+			Enabled:            envBool("COOKIE_AUTH", false),
+			LoginPaths:         envList("COOKIE_AUTH_LOGIN_PATHS"),
+			RefreshPaths:       envList("COOKIE_AUTH_REFRESH_PATHS"),
+			AccessTokenField:   os.Getenv("COOKIE_AUTH_ACCESS_FIELD"),
+			RefreshTokenField:  os.Getenv("COOKIE_AUTH_REFRESH_FIELD"),
+			AccessTokenCookie:  os.Getenv("COOKIE_AUTH_ACCESS_COOKIE"),
+			RefreshTokenCookie: os.Getenv("COOKIE_AUTH_REFRESH_COOKIE"),
+			RefreshCookiePath:  os.Getenv("COOKIE_AUTH_REFRESH_COOKIE_PATH"),
+			Secure:             envBool("COOKIE_SECURE", true),
+			SameSite:           os.Getenv("COOKIE_SAMESITE"),
+			Domain:             os.Getenv("COOKIE_DOMAIN"),
+		},
+		LoadShed: handler.LoadShedConfig{
+			MaxInFlight:   envInt("SHED_MAX_IN_FLIGHT", 0),
+			LatencyTarget: envDuration("SHED_LATENCY_TARGET", 0),
+			MaxFraction:   envFloat("SHED_MAX_FRACTION", 0),
+			RetryAfter:    envDuration("SHED_RETRY_AFTER", 0),
+		},
+		SchemaRules: envSchemaRules("SCHEMA_RULES"),
+		ContentType: handler.ContentTypeConfig{
+			Prefixes: envList("CONTENT_TYPE_ROUTES"),
+			Methods:  envList("CONTENT_TYPE_METHODS"),
+			Types:    envList("CONTENT_TYPES"),
+		},
+		Consul: handler.ConsulConfig{
+			Address:    os.Getenv("CONSUL_HTTP_ADDR"),
+			Token:      os.Getenv("CONSUL_HTTP_TOKEN"),
+			Datacenter: os.Getenv("CONSUL_DATACENTER"),
+		},
+		Kubernetes: handler.KubernetesConfig{
+			Enabled:   envBool("KUBERNETES_DISCOVERY", false),
+			Namespace: os.Getenv("KUBERNETES_NAMESPACE"),
+			APIServer: os.Getenv("KUBERNETES_API_SERVER"),
+		},
+		HealthScore: handler.HealthScoreConfig{
+			ErrorWeight:        envFloat("HEALTH_ERROR_WEIGHT", 0),
+			LatencyWeight:      envFloat("HEALTH_LATENCY_WEIGHT", 0),
+			ConnectionWeight:   envFloat("HEALTH_CONNECTION_WEIGHT", 0),
+			LatencyTarget:      envDuration("HEALTH_LATENCY_TARGET", 0),
+			ConnectionTarget:   envInt("HEALTH_CONNECTION_TARGET", 0),
+			EjectBelow:         envFloat("HEALTH_EJECT_BELOW", 0),
+			EjectAfterFailures: envInt("HEALTH_EJECT_AFTER_FAILURES", 0),
+			EjectDuration:      envDuration("HEALTH_EJECT_DURATION", 0),
+		},
+		LoadBalancing:           os.Getenv("LOAD_BALANCING"),
+		AllowedCIDRs:            envList("ALLOWED_CIDRS"),
+		DeniedCIDRs:             envList("DENIED_CIDRS"),
+		TrustedProxyCIDRs:       envList("TRUSTED_PROXY_CIDRS"),
+		TrustedProxyHops:        envInt("TRUSTED_PROXY_HOPS", 0),
+		MaxConcurrentPerService: envInt("MAX_CONCURRENT_PER_SERVICE", 0),
+		BulkheadQueueSize:       envInt("BULKHEAD_QUEUE_SIZE", 0),
+		CircuitBreaker:          envBreaker("CIRCUIT"),
+		Retry:                   envRetry("RETRY"),
+		TenantRateLimits:        envRateLimits("TENANT_RATE_LIMITS"),
+		DefaultTenantRateLimit:  envRateLimit("DEFAULT_TENANT_RATE_LIMIT"),
+		RouteRateLimits:         envRateLimits("ROUTE_RATE_LIMITS"),
+		RateLimitRedisURL:       os.Getenv("RATE_LIMIT_REDIS_URL"),
+		RateLimitDetails:        envBool("RATE_LIMIT_DETAILS", false),
+		CacheRedisURL:           os.Getenv("CACHE_REDIS_URL"),
+		CacheRedisPrefix:        os.Getenv("CACHE_REDIS_PREFIX"),
+		MaxHeaderBytes:          envInt("MAX_HEADER_BYTES", handler.DefaultMaxHeaderBytes),
+		MaxHeaderCount:          envInt("MAX_HEADER_COUNT", 0),
+		MaxHeaderValueBytes:     envInt("MAX_HEADER_VALUE_BYTES", 0),
+		RequestDeadline:         envDuration("REQUEST_DEADLINE", 0),
+		RouteTimeouts:           envDurations("ROUTE_TIMEOUTS"),
+		MaxBodyBytes:            int64(envInt("MAX_BODY_BYTES", 0)),
+		RouteBodyLimits:         envSizes("ROUTE_BODY_LIMITS"),
+		StreamRoutes:            envList("STREAM_ROUTES"),
+		ResponseBufferLimit:     envInt("RESPONSE_BUFFER_LIMIT", 0),
+		FlushInterval:           envDuration("FLUSH_INTERVAL", 0),
+		PublicPaths:             envList("PUBLIC_PATHS"),
+		OptionalAuthPaths:       envList("OPTIONAL_AUTH_PATHS"),
+		AspPrefixes:             envList("ASP_PREFIXES"),
+		SmugglingProtection:     envBool("SMUGGLING_PROTECTION", true),
+		SLAs:                    envSLAs("SLA_ROUTES"),
+		SLAWebhook:              os.Getenv("SLA_WEBHOOK"),
+		SLAEvalInterval:         envDuration("SLA_EVAL_INTERVAL", 0),
+		SLANotifyInterval:       envDuration("SLA_NOTIFY_INTERVAL", 0),
+		IdentitySecret:          os.Getenv("IDENTITY_SIGNING_SECRET"),
+		AdminToken:              os.Getenv("ADMIN_TOKEN"),
+		AdminAddr:               os.Getenv("ADMIN_ADDR"),
+		CaptureSize:             envInt("CAPTURE_SIZE", 0),
+		ReplayTargets:           envList("REPLAY_TARGETS"),
+		AuditEnabled:            envBool("AUDIT_ENABLED", false),
+		AuditLogPath:            os.Getenv("AUDIT_LOG_PATH"),
+		WarmPoolInterval:        envDuration("WARM_POOL_INTERVAL", 0),
+		DebugTimelineRoles:      envList("DEBUG_TIMELINE_ROLES"),
+		Redirects:               envMap("REDIRECTS"),
+		AllowStaleAuthOnOutage:  envBool("ALLOW_STALE_AUTH_ON_OUTAGE", false),
+		StaleAuthMaxAge:         envDuration("STALE_AUTH_MAX_AGE", 0),
+		TokenRefreshThreshold:   envDuration("TOKEN_REFRESH_THRESHOLD", 0),
+		AccessLogFormat:         os.Getenv("ACCESS_LOG_FORMAT"),
+		AccessLogPath:           os.Getenv("ACCESS_LOG_PATH"),
+		AccessLogExcludePaths:   envList("ACCESS_LOG_EXCLUDE_PATHS"),
+		AccessLogRedactHeaders:  envList("ACCESS_LOG_REDACT_HEADERS"),
+		ReadinessInterval:       envDuration("READINESS_INTERVAL", 0),
+		CriticalServices:        envList("CRITICAL_SERVICES"),
+		EnablePprof:             envBool("ENABLE_PPROF", false),
+		AspGRPC:                 envBool("ASP_GRPC", false),
+		GatewayVersion:          os.Getenv("GATEWAY_VERSION"),
+		ProblemUpstreamErrors:   envBool("PROBLEM_UPSTREAM_ERRORS", false),
+		WarmupTimeout:           envDuration("WARMUP_TIMEOUT", 0),
+		AggregateTimeout:        envDuration("AGGREGATE_TIMEOUT", 3*time.Second),
+		TokenCacheTTL:           envDuration("TOKEN_CACHE_TTL", 0),
+		TokenCacheMaxEntries:    envInt("TOKEN_CACHE_MAX_ENTRIES", 0),
+		Revocation: handler.RevocationConfig{
+			SyncPath:     os.Getenv("REVOCATION_SYNC_PATH"),
+			SyncInterval: envDuration("REVOCATION_SYNC_INTERVAL", 0),
+			MaxTokenAge:  envDuration("REVOCATION_MAX_TOKEN_AGE", 0),
+		},
+		LocalJWT: handler.LocalJWTConfig{
+			Enabled:         envBool("LOCAL_JWT", false),
+			JWKSPath:        os.Getenv("JWKS_PATH"),
+			Secret:          os.Getenv("JWT_SECRET"),
+			RefreshInterval: envDuration("JWKS_REFRESH_INTERVAL", 0),
+			Issuer:          os.Getenv("JWT_ISSUER"),
+			Audience:        os.Getenv("JWT_AUDIENCE"),
+			RemoteFallback:  envBool("JWT_REMOTE_FALLBACK", false),
+		},
+		OIDC: handler.OIDCConfig{
+			Issuer:        os.Getenv("OIDC_ISSUER"),
+			ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:        envList("OIDC_SCOPES"),
+			RoleClaim:     os.Getenv("OIDC_ROLE_CLAIM"),
+			DefaultRole:   os.Getenv("OIDC_DEFAULT_ROLE"),
+			SessionSecret: os.Getenv("OIDC_SESSION_SECRET"),
+			SessionCookie: os.Getenv("OIDC_SESSION_COOKIE"),
+			SessionTTL:    envDuration("OIDC_SESSION_TTL", 0),
+			Secure:        envBool("COOKIE_SECURE", true),
+			LoginPath:     os.Getenv("OIDC_LOGIN_PATH"),
+			LogoutPath:    os.Getenv("OIDC_LOGOUT_PATH"),
+		},
+		GraphQL: handler.GraphQLConfig{
+			Enabled:        envBool("GRAPHQL", false),
+			MaxDepth:       envInt("GRAPHQL_MAX_DEPTH", 0),
+			MaxConcurrency: envInt("GRAPHQL_MAX_CONCURRENCY", 0),
+		},
+		Batch: handler.BatchConfig{
+			Enabled:          envBool("BATCH", false),
+			MaxRequests:      envInt("BATCH_MAX_REQUESTS", 0),
+			MaxConcurrency:   envInt("BATCH_MAX_CONCURRENCY", 0),
+			MaxResponseBytes: int64(envInt("BATCH_MAX_RESPONSE_BYTES", 0)),
+		},
+		CORS: handler.CORSConfig{
+			Origins:        envList("CORS_ORIGINS"),
+			Methods:        envList("CORS_METHODS"),
+			Headers:        envList("CORS_HEADERS"),
+			ExposedHeaders: envList("CORS_EXPOSED_HEADERS"),
+			MaxAge:         envDuration("CORS_MAX_AGE", 0),
+			NoCredentials:  !envBool("CORS_ALLOW_CREDENTIALS", true),
+		},
+		RouteCORS: envRouteCORS(),
+		Tracing: handler.TracingConfig{
+			OTLPEndpoint: os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+			SampleRatio:  envFloat("OTEL_TRACES_SAMPLER_ARG", 0),
+			ServiceName:  os.Getenv("OTEL_SERVICE_NAME"),
+		},
 	}
 
-	if config.AuthServiceURL == "" || config.BlogServiceURL == "" || config.UserServiceURL == "" || config.AspServiceURL == "" {
-		log.Fatal("Missing required environment variables")
+	var err error
+	if config.AccessRules, err = envAccessRules("ACCESS_RULES"); err != nil {
+		return nil, err
 	}
 
-	logger := log.New(os.Stdout, "[gateway] ", log.LstdFlags)
+	if path := os.Getenv("ROUTES_FILE"); path != "" {
+		if config.Routes, err = handler.LoadRoutes(path); err != nil {
+			return nil, err
+		}
+		for _, rt := range config.Routes {
+			config.Services[rt.Name] = serviceConfigFromEnv(envPrefix(rt.Name))
+		}
+	}
 
-	gateway, err := handler.NewGateway(config, logger)
-	if err != nil {
-		logger.Fatal("Failed to initialize gateway:", err)
+	if path := os.Getenv("COMPOSITIONS_FILE"); path != "" {
+		if config.Compositions, err = handler.LoadCompositions(path); err != nil {
+			return nil, err
+		}
 	}
 
+	if path := os.Getenv("GRAPHQL_SCHEMA_FILE"); path != "" {
+		if config.GraphQL.Schema, err = handler.LoadGraphQLSchema(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		keys, err := handler.LoadAPIKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		config.APIKeys.Keys = append(config.APIKeys.Keys, keys...)
+	}
+
+	if config.AuthServiceURL == "" || config.BlogServiceURL == "" || config.UserServiceURL == "" || config.AspServiceURL == "" {
+		return nil, errors.New("missing required environment variables")
+	}
+	return config, nil
+}
+
+// newRouter registers the gateway's routes, including the currently declared ones
+func newRouter(gateway *handler.Gateway, config *handler.Config) *mux.Router {
 	router := mux.NewRouter()
-	router.Use(gateway.AuthMiddleware)
 
-	// Routes with authentication middleware
-	authRouter := router.PathPrefix("/api/auth").Subrouter()
-	authRouter.HandleFunc("/{path:.*}", gateway.ProxyHandler(gateway.AuthProxy, config.AuthServiceURL))
+	// Unmatched paths and methods get JSON 404/405 instead of mux's plain text
+	router.NotFoundHandler = gateway.NotFoundHandler()
+	router.MethodNotAllowedHandler = gateway.MethodNotAllowedHandler()
 
-	blogRouter := router.PathPrefix("/api/blog").Subrouter()
-	blogRouter.HandleFunc("/{path:.*}", gateway.ProxyHandler(gateway.BlogProxy, config.BlogServiceURL))
+	// With ADMIN_ADDR the internal endpoints get their own listener; see newAdminRouter
+	if config.AdminAddr == "" {
+		adminRoutes(router, gateway, config)
+	}
 
-	userRouter := router.PathPrefix("/api/user").Subrouter()
-	userRouter.HandleFunc("/{path:.*}", gateway.ProxyHandler(gateway.UserProxy, config.UserServiceURL))
+	// Liveness and readiness probes; no auth
+	router.HandleFunc("/healthz", gateway.HealthzHandler).Methods("GET", "HEAD")
+	router.HandleFunc("/readyz", gateway.ReadyzHandler).Methods("GET", "HEAD")
 
-	aspRouter := router.PathPrefix("/api/").Subrouter()
-	aspRouter.HandleFunc("/{path:.*}", gateway.ProxyHandler(gateway.AspProxy, config.AspServiceURL))
-	// Apply auth middleware to all routes
+	// OIDC login, callback and logout; no auth. The handler checks each path's method
+	for _, path := range gateway.OIDCPaths() {
+		router.Handle(path, gateway.OIDCHandler()).Methods("GET", "POST")
+	}
 
-	// Definiši CORS opcije
-	cors := handlers.CORS(
-		handlers.AllowedOrigins([]string{"http://localhost:4200"}), // Specifično za Angular frontend
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
-		handlers.AllowCredentials(),
-	)
+	// Landing banner for health checkers; no auth
+	router.HandleFunc("/", gateway.RootHandler).Methods("GET", "HEAD")
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Batches and GraphQL resolvers go back through this router, so each call they make
+	// gets the API middleware chain with the client's credentials
+	if config.Batch.Enabled {
+		router.Handle(handler.BatchPath, gateway.LoadShedMiddleware(gateway.BodyLimitMiddleware(gateway.BatchHandler(router)))).Methods("POST")
+	}
+	if config.GraphQL.Enabled {
+		router.Handle(handler.GraphQLPath, gateway.LoadShedMiddleware(gateway.BodyLimitMiddleware(gateway.GraphQLHandler(router)))).Methods("GET", "POST")
 	}
 
-	server := &http.Server{
-		Addr:         ":" + port,
-		Handler:      cors(router),
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  15 * time.Second,
+	// Everything else goes through the API middleware chain
+	apiRouter := router.NewRoute().Subrouter()
+	apiRouter.Use(gateway.LoadShedMiddleware)
+	apiRouter.Use(gateway.BodyLimitMiddleware)
+	apiRouter.Use(gateway.DeadlineMiddleware)
+	apiRouter.Use(gateway.TimelineMiddleware)
+	apiRouter.Use(gateway.SLAMiddleware)
+	apiRouter.Use(gateway.AuthMiddleware)
+	apiRouter.Use(gateway.AuthorizationMiddleware)
+	apiRouter.Use(gateway.TenantRateLimitMiddleware)
+	apiRouter.Use(gateway.RouteRateLimitMiddleware)
+	apiRouter.Use(gateway.ContentTypeMiddleware)
+	apiRouter.Use(gateway.SchemaMiddleware)
+	apiRouter.Use(gateway.BodyLogMiddleware)
+
+	// mux upper-cases route methods in place, and reloads build routers while requests
+	// read ProxyMethods, so routes get a copy
+	proxyMethods := slices.Clone(handler.ProxyMethods)
+
+	// Declared compositions are exact paths, registered ahead of any prefix they fall under
+	for _, c := range config.Compositions {
+		apiRouter.HandleFunc(c.Path, gateway.AggregateHandler(c.Sections)).Methods("GET")
+	}
+
+	// Services declared in the routes file, registered first so their prefixes win over /api/
+	for _, rt := range gateway.Routes() {
+		apiRouter.PathPrefix(rt.Prefix + "/").Handler(gateway.ProxyHandler(rt.Name)).Methods(rt.Methods...)
+	}
+
+	// Routes with authentication middleware
+	authRouter := apiRouter.PathPrefix("/api/auth").Subrouter()
+	authRouter.HandleFunc("/{path:.*}", gateway.ProxyHandler(handler.ServiceAuth)).Methods(proxyMethods...)
+
+	blogRouter := apiRouter.PathPrefix("/api/blog").Subrouter()
+	blogRouter.HandleFunc("/{path:.*}", gateway.ProxyHandler(handler.ServiceBlog)).Methods(proxyMethods...)
+
+	userRouter := apiRouter.PathPrefix("/api/user").Subrouter()
+	userRouter.HandleFunc("/{path:.*}", gateway.ProxyHandler(handler.ServiceUser)).Methods(proxyMethods...)
+
+	// Aggregation endpoints must be registered before the catch-all ASP router
+	apiRouter.HandleFunc("/api/aggregate/dashboard", gateway.AggregateHandler(handler.DashboardSections)).Methods("GET")
+
+	// gRPC-Web calls are translated to gRPC for the ASP service
+	if config.AspGRPC {
+		apiRouter.PathPrefix(handler.GRPCWebPrefix + "/").Handler(gateway.GRPCWebHandler()).Methods("POST")
 	}
 
-	logger.Printf("Starting gateway on :%s", port)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		logger.Fatal("Server failed:", err)
+	// ASP takes everything else under /api/, unless ASP_PREFIXES narrows it down
+	// so that unknown /api paths return 404
+	if len(config.AspPrefixes) == 0 {
+		aspRouter := apiRouter.PathPrefix("/api/").Subrouter()
+		aspRouter.HandleFunc("/{path:.*}", gateway.ProxyHandler(handler.ServiceAsp)).Methods(proxyMethods...)
 	}
+	for _, prefix := range config.AspPrefixes {
+		apiRouter.PathPrefix(prefix).HandlerFunc(gateway.ProxyHandler(handler.ServiceAsp)).Methods(proxyMethods...)
+	}
+
+	return router
+}
+
+// adminRoutes registers the metrics, admin and profiling endpoints
+func adminRoutes(router *mux.Router, gateway *handler.Gateway, config *handler.Config) {
+	// Prometheus scrape endpoint; no auth, but restricted by the IP filter like the admin API
+	router.Handle("/metrics", gateway.IPFilterMiddleware(http.HandlerFunc(gateway.MetricsHandler))).Methods("GET")
+
+	// Admin endpoints bypass JWT auth and are guarded by the admin token and IP filter
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(gateway.IPFilterMiddleware)
+	adminRouter.Use(gateway.AdminMiddleware)
+	adminRouter.HandleFunc("/captures", gateway.CapturesHandler).Methods("GET")
+	adminRouter.HandleFunc("/replay", gateway.ReplayHandler).Methods("POST")
+	adminRouter.HandleFunc("/maintenance", gateway.MaintenanceHandler).Methods("POST")
+	adminRouter.HandleFunc("/drain", gateway.DrainHandler).Methods("POST")
+	adminRouter.HandleFunc("/stats", gateway.StatsHandler).Methods("GET")
+	adminRouter.HandleFunc("/token-cache", gateway.TokenCacheFlushHandler).Methods("DELETE")
+	adminRouter.HandleFunc("/revocations", gateway.RevocationHandler).Methods("POST")
+	adminRouter.HandleFunc("/routes", gateway.RoutesHandler).Methods("GET")
+	adminRouter.HandleFunc("/upstreams", gateway.UpstreamsHandler).Methods("GET")
+	adminRouter.HandleFunc("/instances/drain", gateway.InstanceDrainHandler).Methods("POST")
+	adminRouter.HandleFunc("/rate-limits", gateway.RateLimitsHandler).Methods("GET")
+	adminRouter.HandleFunc("/cache", gateway.CachePurgeHandler).Methods("DELETE")
+
+	// Profiling is off by default and, like the admin API, bypasses JWT auth
+	if config.EnablePprof {
+		debugRouter := router.PathPrefix("/debug/pprof/").Subrouter()
+		debugRouter.Use(gateway.IPFilterMiddleware)
+		debugRouter.Use(gateway.AdminMiddleware)
+		debugRouter.NewRoute().Handler(gateway.PprofHandler()).Methods("GET")
+	}
+}
+
+// newAdminRouter serves only the internal endpoints, for the ADMIN_ADDR listener
+func newAdminRouter(gateway *handler.Gateway, config *handler.Config) *mux.Router {
+	router := mux.NewRouter()
+	router.NotFoundHandler = gateway.NotFoundHandler()
+	router.MethodNotAllowedHandler = gateway.MethodNotAllowedHandler()
+	router.HandleFunc("/healthz", gateway.HealthzHandler).Methods("GET", "HEAD")
+	adminRoutes(router, gateway, config)
+	return router
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MicroSOA-09/gateway-service/handler"
+)
+
+// testRouter builds the gateway's router from config, with every service pointing at a
+// backend that fails the test when reached
+func testRouter(t *testing.T, config *handler.Config) http.Handler {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("%s %s reached a backend", r.Method, r.URL.Path)
+	}))
+	t.Cleanup(backend.Close)
+	config.AuthServiceURL, config.BlogServiceURL, config.UserServiceURL, config.AspServiceURL = backend.URL, backend.URL, backend.URL, backend.URL
+	gateway, err := handler.NewGateway(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newRouter(gateway, config)
+}
+
+func TestUnmatchedRoutesGetJSONErrors(t *testing.T) {
+	router := testRouter(t, &handler.Config{AspPrefixes: []string{"/api/asp/"}})
+
+	for _, tt := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/nonexistent", http.StatusNotFound},
+		{http.MethodPost, "/healthz", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/aggregate/dashboard", http.StatusMethodNotAllowed},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		var body struct{ Error string }
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); rec.Code != tt.want || err != nil || body.Error == "" {
+			t.Errorf("%s %s: status %d with body %q, want %d with a JSON error", tt.method, tt.path, rec.Code, rec.Body, tt.want)
+		}
+	}
+}
+
+func TestPprofNeedsTheAdminToken(t *testing.T) {
+	for _, tt := range []struct {
+		name, path, token string
+		enabled           bool
+		want              int
+	}{
+		{"disabled", "/debug/pprof/", "admin-secret", false, http.StatusNotFound},
+		{"without a token", "/debug/pprof/", "", true, http.StatusForbidden},
+		{"with a wrong token", "/debug/pprof/", "guess", true, http.StatusForbidden},
+		{"index", "/debug/pprof/", "admin-secret", true, http.StatusOK},
+		{"cmdline", "/debug/pprof/cmdline", "admin-secret", true, http.StatusOK},
+	} {
+		router := testRouter(t, &handler.Config{EnablePprof: tt.enabled, AdminToken: "admin-secret"})
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("X-Admin-Token", tt.token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestRootBannerAndRedirects(t *testing.T) {
+	router := testRouter(t, &handler.Config{
+		AspPrefixes: []string{"/api/asp/"},
+		Redirects:   map[string]string{"/docs": "https://docs.example/gateway", "/api/docs": "https://docs.example/api"},
+	})
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	var banner map[string]string
+	if rec := serve("/"); rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &banner) != nil ||
+		banner["name"] != handler.GatewayName || banner["version"] != handler.Version || banner["uptime"] == "" {
+		t.Errorf("GET /: status %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := serve("/docs"); rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://docs.example/gateway" {
+		t.Errorf("GET /docs: status %d to %q, want a redirect to the docs", rec.Code, rec.Header().Get("Location"))
+	}
+	for _, path := range []string{"/unknown", "/api/docs"} {
+		if rec := serve(path); rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != handler.ProblemContentType {
+			t.Errorf("GET %s: status %d (%s), want a problem details 404", path, rec.Code, rec.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestAdminDrainNeedsTheAdminToken(t *testing.T) {
+	router := testRouter(t, &handler.Config{AdminToken: "admin-secret"})
+	for _, tt := range []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusForbidden},
+		{"guess", http.StatusForbidden},
+		{"admin-secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/drain", strings.NewReader(`{"service":"user","enabled":true}`))
+		if tt.token != "" {
+			req.Header.Set("X-Admin-Token", tt.token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("token %q: status %d, want %d", tt.token, rec.Code, tt.want)
+		}
+	}
+}
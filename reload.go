@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/MicroSOA-09/gateway-service/handler"
+	"github.com/gorilla/mux"
+	"github.com/joho/godotenv"
+)
+
+const dotEnvFile = ".env"
+
+// configReloader re-reads the configuration on SIGHUP, and when CONFIG_WATCH_INTERVAL is
+// set, whenever .env, the ROUTES_FILE or the API_KEYS_FILE changes. Variables set in the
+// process environment keep precedence over .env, as at startup.
+type configReloader struct {
+	logger  *slog.Logger
+	gateway *handler.Gateway
+	router  *atomic.Pointer[mux.Router]
+	startup *handler.Config // for the router's settings that only apply on restart
+
+	processEnv map[string]bool // variables set before .env was loaded
+	dotEnv     map[string]bool // variables last loaded from .env
+}
+
+// newConfigReloader must be called before .env is loaded
+func newConfigReloader() *configReloader {
+	cr := &configReloader{processEnv: make(map[string]bool), dotEnv: make(map[string]bool)}
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		cr.processEnv[k] = true
+	}
+	return cr
+}
+
+// loadDotEnv applies .env to the environment, unsetting variables it no longer sets.
+// A missing .env unsets them all and still returns its error.
+func (cr *configReloader) loadDotEnv() error {
+	env, err := godotenv.Read(dotEnvFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for k := range cr.dotEnv {
+		if _, ok := env[k]; !ok {
+			os.Unsetenv(k)
+		}
+	}
+	cr.dotEnv = make(map[string]bool, len(env))
+	for k, v := range env {
+		if !cr.processEnv[k] {
+			os.Setenv(k, v)
+			cr.dotEnv[k] = true
+		}
+	}
+	return err
+}
+
+// run reloads on SIGHUP or file changes until ctx is done
+func (cr *configReloader) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval := envDuration("CONFIG_WATCH_INTERVAL", 0); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	files := []string{dotEnvFile, os.Getenv("ROUTES_FILE"), os.Getenv("API_KEYS_FILE")}
+	modified := modTimes(files)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			cr.logger.Info("Reloading configuration", "trigger", "SIGHUP")
+		case <-tick:
+			if modTimes(files) == modified {
+				continue
+			}
+			cr.logger.Info("Reloading configuration", "trigger", "file change")
+		}
+		modified = modTimes(files)
+		cr.reload()
+	}
+}
+
+func (cr *configReloader) reload() {
+	if err := cr.loadDotEnv(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		cr.logger.Error("Failed to read .env, keeping current configuration", "error", err)
+		return
+	}
+	config, err := loadConfig()
+	if err != nil {
+		cr.logger.Error("Invalid configuration, keeping current configuration", "error", err)
+		return
+	}
+	if err := cr.gateway.Reload(config); err != nil {
+		cr.logger.Error("Invalid configuration, keeping current configuration", "error", err)
+		return
+	}
+	cr.router.Store(newRouter(cr.gateway, cr.startup))
+}
+
+// modTimes joins the modification times of files, missing ones included, into a
+// value that changes whenever any of them does
+func modTimes(files []string) string {
+	var b strings.Builder
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		if fi, err := os.Stat(f); err == nil {
+			b.WriteString(fi.ModTime().String())
+		}
+		b.WriteByte(';')
+	}
+	return b.String()
+}